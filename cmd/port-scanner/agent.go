@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/pflag"
+	"go.coder.com/cli"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+// agentTokenEnv is where both sides pick up the shared token when the flags aren't set.
+const agentTokenEnv = "PORT_SCANNER_AGENT_TOKEN"
+
+// agent runs a worker that scans the ports handed to it by scan --agents,
+// which lets a sweep run from several vantage points and machines at once.
+type agentCmd struct {
+	addr    string
+	token   string
+	tlsCert string
+	tlsKey  string
+}
+
+func (cmd *agentCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "agent",
+		Usage: "[flags]",
+		Desc:  "Run a worker that scans the ports handed to it by scan --agents over gRPC.",
+	}
+}
+
+func (cmd *agentCmd) RegisterFlags(fl *pflag.FlagSet) {
+	fl.StringVar(&cmd.addr, "addr", ":50051", "address to listen on")
+	fl.StringVar(&cmd.token, "token", os.Getenv(agentTokenEnv), "only take scans from clients presenting this token(defaults to $"+agentTokenEnv+", open to anyone if not set)")
+	_ = fl.SetAnnotation("token", secretAnnotation, []string{"true"})
+	fl.StringVar(&cmd.tlsCert, "tls-cert", "", "serve over tls with this certificate(plaintext if not set)")
+	fl.StringVar(&cmd.tlsKey, "tls-key", "", "private key of --tls-cert")
+}
+
+func (cmd *agentCmd) Run(fl *pflag.FlagSet) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if (cmd.tlsCert == "") != (cmd.tlsKey == "") {
+		fl.Usage()
+		log.Fatal("--tls-cert and --tls-key have to be set together")
+	}
+
+	var opts []grpc.ServerOption
+	if cmd.tlsCert != "" {
+		creds, err := credentials.NewServerTLSFromFile(cmd.tlsCert, cmd.tlsKey)
+		if err != nil {
+			log.Fatalf("failed to load tls certificate: %s", err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+
+	if cmd.token == "" {
+		log.Print("warning: no --token set, anyone who can reach the agent can scan through it")
+	}
+
+	lis, err := net.Listen("tcp", cmd.addr)
+	if err != nil {
+		log.Fatalf("failed to listen: %s", err)
+	}
+
+	srv := grpc.NewServer(opts...)
+	srv.RegisterService(&agentServiceDesc, &agentServer{token: cmd.token})
+	go func() {
+		<-ctx.Done()
+		// Stopping cancels the scans still running, their clients see the scan fail.
+		srv.Stop()
+	}()
+
+	log.Printf("listening on %s...", lis.Addr())
+	if err := srv.Serve(lis); err != nil {
+		log.Fatalf("failed to serve: %s", err)
+	}
+	log.Print("agent stopped")
+}
+
+// The agent service is described by hand below. Its messages are the plain
+// structs that follow rather than generated protobufs, they're sent as json
+// with jsonCodec, so there's no protoc step to build the agent:
+//
+//	service Agent {
+//	  rpc Scan(agentScanRequest) returns (stream agentScanEvent);
+//	}
+const agentScanMethod = "/portscanner.Agent/Scan"
+
+var agentServiceDesc = grpc.ServiceDesc{
+	ServiceName: "portscanner.Agent",
+	HandlerType: (*agentService)(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Scan",
+		ServerStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			var req agentScanRequest
+			if err := stream.RecvMsg(&req); err != nil {
+				return err
+			}
+			return srv.(agentService).Scan(&req, stream)
+		},
+	}},
+}
+
+type agentService interface {
+	Scan(req *agentScanRequest, stream grpc.ServerStream) error
+}
+
+// agentScanRequest asks an agent to scan some of the ports of a single address.
+type agentScanRequest struct {
+	IP            string                       `json:"ip"`
+	Network       string                       `json:"network"`
+	Ports         []int                        `json:"ports"`
+	Timeout       time.Duration                `json:"timeout"`
+	Concurrency   int                          `json:"concurrency"`
+	Retry         scanner.RetryPolicy          `json:"retry"`
+	ConfirmLevels map[int]scanner.ConfirmLevel `json:"confirm_levels,omitempty"`
+}
+
+// agentScanEvent is streamed back to the client as the scan goes.
+type agentScanEvent struct {
+	// Port is set for every port as soon as it turns out to be reachable.
+	Port *scanner.PortResult `json:"port,omitempty"`
+	// Scanned is how many of the requested ports are done so far.
+	Scanned int `json:"scanned"`
+	// Done is set on the last event, along with how the scan went.
+	Done      bool           `json:"done,omitempty"`
+	Failures  map[string]int `json:"failures,omitempty"`
+	Unscanned []int          `json:"unscanned,omitempty"`
+	Probes    int            `json:"probes,omitempty"`
+	Retries   int            `json:"retries,omitempty"`
+}
+
+// jsonCodec marshals the agent service's messages. It's picked by
+// the "json" content-subtype the client sets on its calls.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                               { return "json" }
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+type agentServer struct {
+	token string
+}
+
+func (a *agentServer) Scan(req *agentScanRequest, stream grpc.ServerStream) error {
+	ctx := stream.Context()
+	if err := a.authenticate(ctx); err != nil {
+		return err
+	}
+
+	found := make(chan scanner.PortResult)
+	s, err := scanner.New(req.IP, scanner.Options{
+		Network:       req.Network,
+		Ports:         req.Ports,
+		Timeout:       req.Timeout,
+		Concurrency:   req.Concurrency,
+		Retry:         req.Retry,
+		ConfirmLevels: req.ConfirmLevels,
+		Found:         found,
+	})
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	client := "unknown client"
+	if p, ok := peer.FromContext(ctx); ok {
+		client = p.Addr.String()
+	}
+	log.Printf("scanning %d ports of %s for %s...", len(req.Ports), req.IP, client)
+
+	type scanned struct {
+		res scanner.Result
+		err error
+	}
+	done := make(chan scanned, 1)
+	go func() {
+		res, err := s.Scan(ctx)
+		close(found)
+		done <- scanned{res, err}
+	}()
+
+	// If the client goes away the scan notices through ctx, until then
+	// lets keep draining found so it never blocks on a send.
+	abandon := func(err error) error {
+		go func() {
+			for range found {
+			}
+		}()
+		<-done
+		return err
+	}
+
+	// Streams aren't safe to send on from several goroutines,
+	// so every event goes out from this one.
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		var ev agentScanEvent
+		select {
+		case p, ok := <-found:
+			if !ok {
+				result := <-done
+				if result.err != nil {
+					log.Printf("scan of %s for %s stopped early: %s", req.IP, client, result.err)
+					return status.FromContextError(result.err).Err()
+				}
+				ev.Done, ev.Failures, ev.Unscanned = true, result.res.Failures, result.res.Unscanned
+				ev.Probes, ev.Retries = result.res.Probes, result.res.Retries
+			} else {
+				ev.Port = &p
+			}
+		case <-ticker.C:
+		}
+
+		ev.Scanned, _ = s.Progress()
+		err := stream.SendMsg(&ev)
+		if ev.Done {
+			return err
+		}
+		if err != nil {
+			return abandon(err)
+		}
+	}
+}
+
+// authenticate checks the client presented our token, if we have one.
+func (a *agentServer) authenticate(ctx context.Context) error {
+	if a.token == "" {
+		return nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	if validToken(md.Get("authorization"), a.token) {
+		return nil
+	}
+	return status.Error(codes.Unauthenticated, "missing or invalid agent token")
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/xerrors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+const agentDialTimeout = 10 * time.Second
+
+// agentFlags are the scan flags for handing the scan out to agents.
+type agentFlags struct {
+	addrs []string
+	token string
+	ca    string
+}
+
+func registerAgentFlags(fl *pflag.FlagSet, a *agentFlags) {
+	fl.StringSliceVar(&a.addrs, "agents", nil, "shard the ports of every target across these agents and merge what they find(e.g. host1:50051,host2:50051)")
+	fl.StringVar(&a.token, "agent-token", os.Getenv(agentTokenEnv), "token the agents were started with(defaults to $"+agentTokenEnv+")")
+	_ = fl.SetAnnotation("agent-token", secretAnnotation, []string{"true"})
+	fl.StringVar(&a.ca, "agent-ca", "", "talk tls to the agents, trusting certificates signed by this ca(plaintext if not set)")
+}
+
+func (a *agentFlags) enabled() bool { return len(a.addrs) > 0 }
+
+// agentIncompatibleFlags only make sense when we make the connections ourselves,
+// the agents only take over the port scan itself.
+var agentIncompatibleFlags = []string{
+	"syn", "stateless", "fin", "null", "xmas", "ack", "zombie", "proxy", "ssh-jump", "source-ips", "source-ip", "interface", "fwmark",
+	"auto-timeout", "host-timeout", "verify", "verify-sample", "skip-dead", "max-connections", "max-rate", "min-rate", "jitter", "audit-log", "checkpoint", "resume",
+	"check-auth", "tls-probe", "http-probe", "probes", "probe-file", "script", "os-detect", "guess-protocol", "service-version", "vulns", "vuln-db", "banners", "all-states", "adaptive",
+}
+
+// checkAgentFlags returns an error naming the flags set on fl that can't be used with --agents.
+func checkAgentFlags(fl *pflag.FlagSet) error {
+	var set []string
+	for _, name := range agentIncompatibleFlags {
+		if fl.Changed(name) {
+			set = append(set, "--"+name)
+		}
+	}
+
+	if len(set) > 0 {
+		return xerrors.Errorf("%s can't be used with --agents", strings.Join(set, ", "))
+	}
+	return nil
+}
+
+// agentPool holds a connection to every agent of --agents.
+type agentPool struct {
+	addrs []string
+	conns []*grpc.ClientConn
+}
+
+// token authenticates us to the agents on every call.
+type agentToken string
+
+func (t agentToken) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+// RequireTransportSecurity is false since agents run without tls unless told otherwise.
+func (t agentToken) RequireTransportSecurity() bool { return false }
+
+// dial connects to every agent up front so one that's down fails the scan before it starts.
+func (a *agentFlags) dial(ctx context.Context) (*agentPool, error) {
+	opts := []grpc.DialOption{
+		grpc.WithBlock(),
+		grpc.FailOnNonTempDialError(true),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(jsonCodec{}.Name())),
+	}
+
+	if a.ca != "" {
+		creds, err := credentials.NewClientTLSFromFile(a.ca, "")
+		if err != nil {
+			return nil, xerrors.Errorf("failed to load --agent-ca: %w", err)
+		}
+		opts = append(opts, grpc.WithTransportCredentials(creds))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+
+	if a.token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(agentToken(a.token)))
+	}
+
+	pool := &agentPool{addrs: a.addrs}
+	for _, addr := range a.addrs {
+		dialCtx, cancel := context.WithTimeout(ctx, agentDialTimeout)
+		conn, err := grpc.DialContext(dialCtx, addr, opts...)
+		cancel()
+		if err != nil {
+			pool.Close()
+			return nil, xerrors.Errorf("failed to connect to agent %s: %w", addr, err)
+		}
+		pool.conns = append(pool.conns, conn)
+	}
+	return pool, nil
+}
+
+func (p *agentPool) String() string { return strings.Join(p.addrs, ", ") }
+
+func (p *agentPool) Close() error {
+	for _, conn := range p.conns {
+		_ = conn.Close()
+	}
+	return nil
+}
+
+// agentScan is a single target's scan sharded across the pool.
+// It reports its progress the same way a scanner.Scanner does.
+type agentScan struct {
+	pool *agentPool
+	ip   string
+	opts scanner.Options
+	// scanned holds how many ports each agent is done with,
+	// it's only touched through sync/atomic.
+	scanned []int64
+}
+
+func (p *agentPool) newScan(ip string, opts scanner.Options) *agentScan {
+	return &agentScan{pool: p, ip: ip, opts: opts, scanned: make([]int64, len(p.conns))}
+}
+
+// Progress reports how many of the target's ports the agents are done with.
+func (a *agentScan) Progress() (scanned, total int) {
+	for i := range a.scanned {
+		scanned += int(atomic.LoadInt64(&a.scanned[i]))
+	}
+	return scanned, len(a.opts.Ports)
+}
+
+// Scan deals the ports out to the agents like cards, so each of them gets a share
+// of every range, and merges what they find into a single result. Ports are sent
+// on opts.Found as the agents report them. An agent failing part way through doesn't
+// stop the others, the ports it did find are kept and its error is returned.
+func (a *agentScan) Scan(ctx context.Context) (scanner.Result, error) {
+	shards := make([][]int, len(a.pool.conns))
+	for i, port := range a.opts.Ports {
+		shards[i%len(shards)] = append(shards[i%len(shards)], port)
+	}
+
+	res := scanner.Result{
+		Host:     a.ip,
+		Network:  a.opts.Network,
+		Start:    time.Now(),
+		Failures: make(map[string]int),
+	}
+
+	var mu sync.Mutex
+	var errs []string
+	var wg sync.WaitGroup
+	for i, ports := range shards {
+		if len(ports) == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, ports []int) {
+			defer wg.Done()
+			err := a.scanShard(ctx, i, ports, func(p scanner.PortResult) {
+				mu.Lock()
+				res.Ports = append(res.Ports, p)
+				mu.Unlock()
+				if a.opts.Found != nil {
+					a.opts.Found <- p
+				}
+			}, func(done *agentScanEvent) {
+				mu.Lock()
+				defer mu.Unlock()
+				for outcome, n := range done.Failures {
+					res.Failures[outcome] += n
+				}
+				res.Unscanned = append(res.Unscanned, done.Unscanned...)
+				res.Probes += done.Probes
+				res.Retries += done.Retries
+			})
+
+			if err != nil && ctx.Err() == nil {
+				mu.Lock()
+				errs = append(errs, "agent "+a.pool.addrs[i]+": "+err.Error())
+				mu.Unlock()
+			}
+		}(i, ports)
+	}
+	wg.Wait()
+
+	res.Duration = time.Since(res.Start)
+	sort.Slice(res.Ports, func(i, j int) bool { return res.Ports[i].Port < res.Ports[j].Port })
+	sort.Ints(res.Unscanned)
+
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+	if len(errs) > 0 {
+		return res, xerrors.New(strings.Join(errs, "; "))
+	}
+	return res, nil
+}
+
+// scanShard has the i'th agent scan ports, calling found for every port it reports
+// and done with its last event once it's through.
+func (a *agentScan) scanShard(ctx context.Context, i int, ports []int, found func(scanner.PortResult), done func(*agentScanEvent)) error {
+	stream, err := a.pool.conns[i].NewStream(ctx, &agentServiceDesc.Streams[0], agentScanMethod)
+	if err != nil {
+		return err
+	}
+
+	req := &agentScanRequest{
+		IP:            a.ip,
+		Network:       a.opts.Network,
+		Ports:         ports,
+		Timeout:       a.opts.Timeout,
+		Concurrency:   a.opts.Concurrency,
+		Retry:         a.opts.Retry,
+		ConfirmLevels: a.opts.ConfirmLevels,
+	}
+	if err := stream.SendMsg(req); err != nil {
+		return err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+
+	for {
+		var ev agentScanEvent
+		if err := stream.RecvMsg(&ev); err != nil {
+			if err == io.EOF {
+				return xerrors.New("agent ended the scan without finishing it")
+			}
+			return err
+		}
+
+		atomic.StoreInt64(&a.scanned[i], int64(ev.Scanned))
+		if ev.Port != nil {
+			found(*ev.Port)
+		}
+		if ev.Done {
+			done(&ev)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	awsTimeout = 30 * time.Second
+	// ec2APIVersion is the version of the ec2 query api we speak, it hasn't
+	// changed in years.
+	ec2APIVersion = "2016-11-15"
+	// imdsTimeout is short, off of ec2 there's nothing there to wait for.
+	imdsTimeout = time.Second
+	imdsBase    = "http://169.254.169.254"
+)
+
+// awsSource is an aws://region target source, e.g.
+// aws://eu-west-1?tag:Env=prod&tag:Role=web,api&ips=public
+type awsSource struct {
+	region string
+	// tags are the tag filters, an instance has to match every one of them
+	// with any of its values.
+	tags map[string][]string
+	// ips is which addresses of an instance to scan, public, private or all.
+	ips     string
+	profile string
+}
+
+func parseAWSSource(u *url.URL) (*awsSource, error) {
+	src := &awsSource{region: u.Host, tags: make(map[string][]string), ips: "all", profile: os.Getenv("AWS_PROFILE")}
+	if src.region == "" {
+		return nil, xerrors.Errorf("%q has no region(e.g. aws://us-east-1)", u.String())
+	}
+
+	for key, values := range u.Query() {
+		value := values[len(values)-1]
+		switch {
+		case strings.HasPrefix(key, "tag:"):
+			src.tags[strings.TrimPrefix(key, "tag:")] = strings.Split(value, ",")
+		case key == "ips":
+			if value != "public" && value != "private" && value != "all" {
+				return nil, xerrors.Errorf("ips=%s should be public, private or all", value)
+			}
+			src.ips = value
+		case key == "profile":
+			src.profile = value
+		default:
+			return nil, xerrors.Errorf("%q isn't an aws:// option(tag:<key>, ips or profile)", key)
+		}
+	}
+	if src.profile == "" {
+		src.profile = "default"
+	}
+	return src, nil
+}
+
+// ec2Instance is a running instance with the addresses it has.
+type ec2Instance struct {
+	ID      string
+	Name    string
+	Public  []string
+	Private []string
+}
+
+func (i ec2Instance) String() string {
+	if i.Name != "" {
+		return "instance/" + i.ID + "(" + i.Name + ")"
+	}
+	return "instance/" + i.ID
+}
+
+// awsCredentials sign requests to aws.
+type awsCredentials struct {
+	accessKey, secretKey, sessionToken string
+}
+
+// credentials finds credentials the way the aws sdks do, from the
+// environment, then the shared credentials file and last the instance
+// metadata service when we're running on ec2 ourselves.
+func (src *awsSource) credentials(ctx context.Context) (*awsCredentials, error) {
+	if key := os.Getenv("AWS_ACCESS_KEY_ID"); key != "" {
+		return &awsCredentials{accessKey: key, secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"), sessionToken: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, ".aws", "credentials")
+		}
+	}
+	creds, err := readAWSCredentialsFile(path, src.profile)
+	if err != nil {
+		return nil, err
+	}
+	if creds != nil {
+		return creds, nil
+	}
+
+	creds, err = imdsCredentials(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("no aws credentials in the environment, in profile %q of %s or from the instance metadata service: %w", src.profile, path, err)
+	}
+	return creds, nil
+}
+
+// readAWSCredentialsFile returns the keys of profile in the ini file at
+// path, nil when there's no such file or profile.
+func readAWSCredentialsFile(path, profile string) (*awsCredentials, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("failed to open %q: %w", path, err)
+	}
+	defer f.Close()
+
+	var creds *awsCredentials
+	in := false
+	lines := bufio.NewScanner(f)
+	for lines.Scan() {
+		line := strings.TrimSpace(lines.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			in = strings.TrimSpace(line[1:len(line)-1]) == profile
+			if in && creds == nil {
+				creds = new(awsCredentials)
+			}
+			continue
+		}
+		i := strings.IndexByte(line, '=')
+		if !in || i < 0 {
+			continue
+		}
+		value := strings.TrimSpace(line[i+1:])
+		switch strings.TrimSpace(line[:i]) {
+		case "aws_access_key_id":
+			creds.accessKey = value
+		case "aws_secret_access_key":
+			creds.secretKey = value
+		case "aws_session_token":
+			creds.sessionToken = value
+		}
+	}
+	if err := lines.Err(); err != nil {
+		return nil, xerrors.Errorf("failed to read %q: %w", path, err)
+	}
+	if creds != nil && creds.accessKey == "" {
+		return nil, xerrors.Errorf("profile %q of %q has no aws_access_key_id(sso and assume role profiles aren't supported, export the keys instead)", profile, path)
+	}
+	return creds, nil
+}
+
+// imdsCredentials fetches the credentials of the instance's role over
+// IMDSv2.
+func imdsCredentials(ctx context.Context) (*awsCredentials, error) {
+	client := &http.Client{Timeout: imdsTimeout}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, imdsBase+"/latest/api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	token, err := doAWS(client, req)
+	if err != nil {
+		return nil, err
+	}
+
+	get := func(path string) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, imdsBase+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-aws-ec2-metadata-token", string(token))
+		return doAWS(client, req)
+	}
+	role, err := get("/latest/meta-data/iam/security-credentials/")
+	if err != nil {
+		return nil, xerrors.Errorf("instance has no role: %w", err)
+	}
+	b, err := get("/latest/meta-data/iam/security-credentials/" + strings.TrimSpace(strings.SplitN(string(role), "\n", 2)[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	var creds struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string `json:"SecretAccessKey"`
+		Token           string `json:"Token"`
+	}
+	if err := json.Unmarshal(b, &creds); err != nil {
+		return nil, xerrors.Errorf("failed to decode role credentials: %w", err)
+	}
+	return &awsCredentials{accessKey: creds.AccessKeyID, secretKey: creds.SecretAccessKey, sessionToken: creds.Token}, nil
+}
+
+// describeInstancesResponse is the part of a DescribeInstances response we read.
+type describeInstancesResponse struct {
+	Reservations []struct {
+		Instances []struct {
+			ID        string `xml:"instanceId"`
+			PrivateIP string `xml:"privateIpAddress"`
+			PublicIP  string `xml:"ipAddress"`
+			Tags      []struct {
+				Key   string `xml:"key"`
+				Value string `xml:"value"`
+			} `xml:"tagSet>item"`
+			Interfaces []struct {
+				IPv6 []string `xml:"ipv6AddressesSet>item>ipv6Address"`
+			} `xml:"networkInterfaceSet>item"`
+		} `xml:"instancesSet>item"`
+	} `xml:"reservationSet>item"`
+	NextToken string `xml:"nextToken"`
+}
+
+// instances lists the running instances matching the source's tags.
+func (src *awsSource) instances(ctx context.Context) ([]ec2Instance, error) {
+	creds, err := src.credentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := "https://ec2." + src.region + ".amazonaws.com"
+	for _, env := range []string{"AWS_ENDPOINT_URL_EC2", "AWS_ENDPOINT_URL"} {
+		if e := os.Getenv(env); e != "" {
+			endpoint = strings.TrimSuffix(e, "/")
+			break
+		}
+	}
+
+	query := url.Values{
+		"Action":           {"DescribeInstances"},
+		"Version":          {ec2APIVersion},
+		"MaxResults":       {"1000"},
+		"Filter.1.Name":    {"instance-state-name"},
+		"Filter.1.Value.1": {"running"},
+	}
+	// Lets add the tag filters in a stable order, it keeps requests
+	// comparable when debugging.
+	keys := make([]string, 0, len(src.tags))
+	for k := range src.tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		prefix := "Filter." + strconv.Itoa(i+2)
+		query.Set(prefix+".Name", "tag:"+k)
+		for j, v := range src.tags[k] {
+			query.Set(prefix+".Value."+strconv.Itoa(j+1), v)
+		}
+	}
+
+	client := &http.Client{Timeout: awsTimeout}
+	var instances []ec2Instance
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/", strings.NewReader(query.Encode()))
+		if err != nil {
+			return nil, xerrors.Errorf("failed to build request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+		signAWS(req, []byte(query.Encode()), creds, src.region, "ec2", time.Now())
+
+		b, err := doAWS(client, req)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to describe instances: %w", err)
+		}
+		var resp describeInstancesResponse
+		if err := xml.Unmarshal(b, &resp); err != nil {
+			return nil, xerrors.Errorf("failed to decode instances: %w", err)
+		}
+
+		for _, r := range resp.Reservations {
+			for _, inst := range r.Instances {
+				i := ec2Instance{ID: inst.ID}
+				for _, t := range inst.Tags {
+					if t.Key == "Name" {
+						i.Name = t.Value
+					}
+				}
+				if inst.PublicIP != "" {
+					i.Public = append(i.Public, inst.PublicIP)
+				}
+				if inst.PrivateIP != "" {
+					i.Private = append(i.Private, inst.PrivateIP)
+				}
+				// Instance ipv6 addresses are globally routable, they're public and private at once.
+				for _, iface := range inst.Interfaces {
+					i.Public = append(i.Public, iface.IPv6...)
+					i.Private = append(i.Private, iface.IPv6...)
+				}
+				instances = append(instances, i)
+			}
+		}
+
+		if resp.NextToken == "" {
+			return instances, nil
+		}
+		query.Set("NextToken", resp.NextToken)
+	}
+}
+
+// addInstances adds the addresses of instances src asks for to d.
+func (src *awsSource) addInstances(d discovered, instances []ec2Instance) {
+	for _, i := range instances {
+		var ips []string
+		if src.ips != "private" {
+			ips = append(ips, i.Public...)
+		}
+		if src.ips != "public" {
+			ips = append(ips, i.Private...)
+		}
+		for _, ip := range ips {
+			d.addHost(ip, i.String())
+		}
+	}
+}
+
+// doAWS sends req and returns the body of a successful response, or the
+// error aws explained itself with.
+func doAWS(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var awsErr struct {
+			Code    string `xml:"Errors>Error>Code"`
+			Message string `xml:"Errors>Error>Message"`
+		}
+		if xml.Unmarshal(b, &awsErr) == nil && awsErr.Code != "" {
+			return nil, xerrors.Errorf("%s: %s", awsErr.Code, awsErr.Message)
+		}
+		return nil, xerrors.Errorf("%s answered %s", req.URL.Host, resp.Status)
+	}
+	return b, nil
+}
+
+// signAWS signs req with signature version 4.
+func signAWS(req *http.Request, body []byte, creds *awsCredentials, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+
+	signed := []string{"content-type", "host", "x-amz-date"}
+	if creds.sessionToken != "" {
+		signed = append(signed, "x-amz-security-token")
+	}
+	var headers strings.Builder
+	for _, h := range signed {
+		value := req.Header.Get(h)
+		if h == "host" {
+			value = req.URL.Host
+		}
+		headers.WriteString(h + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonical := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		headers.String(),
+		strings.Join(signed, ";"),
+		hexSHA256(body),
+	}, "\n")
+
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hexSHA256([]byte(canonical))
+
+	key := hmacSHA256([]byte("AWS4"+creds.secretKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+creds.accessKey+"/"+scope+", SignedHeaders="+strings.Join(signed, ";")+", Signature="+signature)
+}
+
+func hexSHA256(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/xerrors"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+// A baseline is a saved scan that later ones are held against. The first run with
+// --baseline writes it and every run after that only reports how it deviates,
+// so an unchanged network stays quiet. It's a regular --save file, which makes
+// accepting a deviation as simple as deleting the file or saving over it.
+
+func registerBaselineFlag(fl *pflag.FlagSet, path *string, what string) {
+	fl.StringVar(path, "baseline", "", "save the first run's results to this file and from then on only report "+what+" that deviate from it")
+}
+
+// loadBaseline reads the baseline at path, nil when there isn't one yet.
+func loadBaseline(path string) (*report, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+	return readReport(path)
+}
+
+// deviations lists every port of rep that differs from baseline in the format of diff.
+// Hosts whose scan stopped early only got through some of their ports, and the ones
+// an interrupted scan never got to none at all, so they're left out rather than
+// reporting what wasn't scanned as gone.
+func deviations(baseline, rep *report) []string {
+	scanned := make(map[string]bool)
+	complete := &report{}
+	for _, h := range rep.Hosts {
+		key := hostKey(h)
+		scanned[key] = h.Error == "" && !h.Interrupted
+		if scanned[key] {
+			complete.Hosts = append(complete.Hosts, h)
+		}
+	}
+
+	expected := &report{}
+	for _, h := range baseline.Hosts {
+		key := hostKey(h)
+		if done, ok := scanned[key]; done || (!ok && !rep.Interrupted) {
+			expected.Hosts = append(expected.Hosts, h)
+		}
+	}
+	return diffReports(expected, complete)
+}
+
+// baselineOpenPorts returns the open ports the baseline holds for host, whatever address it was at.
+func baselineOpenPorts(baseline *report, host string) (map[int]bool, error) {
+	for _, h := range baseline.Hosts {
+		if h.Host != host {
+			continue
+		}
+		open := make(map[int]bool)
+		for _, p := range h.Ports {
+			if p.State == scanner.StateOpen {
+				open[p.Port] = true
+			}
+		}
+		return open, nil
+	}
+	return nil, xerrors.Errorf("the baseline has no results for %s", host)
+}
+
+// watchReport is a scan of a watch as a report, for saving as its baseline and
+// recording with --record. open are the ports of host found open at ip out of the scanned ones.
+func watchReport(invocation, host, ip string, open map[int]bool, scanned int) *report {
+	now := time.Now().UTC()
+	h := &hostResult{
+		Host:         host,
+		IP:           ip,
+		Protocol:     "tcp",
+		Timestamp:    now,
+		Found:        len(open),
+		Ports:        []portResult{},
+		ScannedPorts: scanned,
+		TotalPorts:   scanned,
+	}
+	for _, port := range sortedPorts(open) {
+		h.Ports = append(h.Ports, portResult{Port: port, State: scanner.StateOpen, Service: scanner.ServiceName(port, "tcp")})
+	}
+	return &report{Version: reportVersion, Invocation: invocation, Timestamp: now, Hosts: []*hostResult{h}}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/pflag"
+	"go.coder.com/cli"
+	"golang.org/x/xerrors"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+const (
+	// calibrateRTTSamples is how many ports get dialed one at a time to measure the round trip.
+	calibrateRTTSamples = 20
+	// minCalibratedTimeout keeps the recommended timeout sane on paths that answer in microseconds.
+	minCalibratedTimeout = 100 * time.Millisecond
+)
+
+// calibrate finds the settings that scan a host fastest without losing
+// answers on the way. It measures the round trip to pick a timeout, then scans
+// the same ports in bursts of doubling concurrency until the host, or whatever
+// sits in between, starts dropping probes, and saves the fastest concurrency
+// that didn't as a profile.
+//
+//	port-scanner calibrate --host 10.0.0.5
+//	port-scanner scan --host 10.0.0.5 --profile calibrated
+type calibrateCmd struct {
+	host           string
+	ports          string
+	maxConcurrency int
+	timeout        time.Duration
+	profile        string
+	config         string
+	dryRun         bool
+}
+
+func (cmd *calibrateCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "calibrate",
+		Usage: "--host HOST [flags]",
+		Desc:  "Find the fastest --concurrency and --timeout a host can be scanned with and save them as a profile.",
+	}
+}
+
+func (cmd *calibrateCmd) RegisterFlags(fl *pflag.FlagSet) {
+	fl.StringVar(&cmd.host, "host", "", "host to calibrate against")
+	fl.StringVarP(&cmd.ports, "ports", "p", "", "ports every burst scans(defaults to the top 1000 ports)")
+	fl.IntVar(&cmd.maxConcurrency, "max-concurrency", 4096, "highest concurrency to try")
+	fl.DurationVar(&cmd.timeout, "timeout", scanner.DefaultTimeout, "how long the bursts wait on each connection attempt, and the most the recommended timeout can be")
+	fl.StringVar(&cmd.profile, "profile", "calibrated", "name of the config file profile to save the settings as")
+	registerConfigFlag(fl, &cmd.config)
+	fl.BoolVar(&cmd.dryRun, "dry-run", false, "only print the recommended settings instead of saving them")
+}
+
+// burstResult is how a burst at one concurrency went.
+type burstResult struct {
+	concurrency int
+	duration    time.Duration
+	probes      int
+	open        int
+	refused     int
+	exhausted   int
+	// degraded is set when the burst got fewer answers than the first one.
+	degraded bool
+}
+
+// answered counts the ports that gave us an answer either way.
+func (b burstResult) answered() int { return b.open + b.refused }
+
+func (b burstResult) rate() float64 {
+	if b.duration <= 0 {
+		return 0
+	}
+	return float64(b.probes) / b.duration.Seconds()
+}
+
+func (cmd *calibrateCmd) Run(fl *pflag.FlagSet) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if cmd.host == "" {
+		fl.Usage()
+		log.Fatal("host not provided")
+	}
+
+	if cmd.timeout <= 0 {
+		fl.Usage()
+		log.Fatalf("--timeout must be positive, got %s", cmd.timeout)
+	}
+
+	if cmd.maxConcurrency < 1 {
+		fl.Usage()
+		log.Fatalf("--max-concurrency must be at least 1, got %d", cmd.maxConcurrency)
+	}
+
+	if cmd.profile == "" && !cmd.dryRun {
+		fl.Usage()
+		log.Fatal("--profile can't be empty")
+	}
+
+	ports, err := scanner.TopPorts(1000)
+	if cmd.ports != "" {
+		ports, err = scanner.ParsePorts(cmd.ports)
+	}
+	if err != nil {
+		fl.Usage()
+		log.Fatalf("invalid ports: %s", err)
+	}
+
+	ips, err := scanner.Resolve(ctx, cmd.host, "tcp", scanner.DefaultResolveTimeout)
+	if err != nil {
+		log.Fatalf("failed to resolve host: %s", err)
+	}
+	addr := scanner.ZonedAddr(cmd.host, ips[0])
+
+	// Concurrency past the open file limit says more about us than the path.
+	cmd.maxConcurrency = fitFileLimit(cmd.maxConcurrency, false)
+
+	log.Printf("measuring the round trip to %s...", addr)
+	rtts := sampleRTT(ctx, addr, ports, cmd.timeout)
+	timeout := cmd.timeout
+	if len(rtts) == 0 {
+		log.Printf("no port answered in time, keeping the timeout at %s", timeout)
+	} else {
+		sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+		timeout = recommendTimeout(rtts[len(rtts)-1], cmd.timeout)
+		log.Printf("round trip: min %s, max %s over %d ports", rtts[0], rtts[len(rtts)-1], len(rtts))
+	}
+
+	var bursts []burstResult
+	for concurrency := 32; ; concurrency *= 2 {
+		if concurrency > cmd.maxConcurrency {
+			concurrency = cmd.maxConcurrency
+		}
+		if len(bursts) > 0 && concurrency <= bursts[len(bursts)-1].concurrency {
+			break
+		}
+
+		log.Printf("scanning %d ports, %d at once...", len(ports), concurrency)
+		b, err := burst(ctx, addr, ports, concurrency, cmd.timeout)
+		if err != nil {
+			if ctx.Err() != nil {
+				os.Exit(exitInterrupted)
+			}
+			log.Fatalf("failed to scan: %s", err)
+		}
+
+		if len(bursts) == 0 && b.answered() == 0 {
+			log.Fatalf("none of the ports of %s answered, there's nothing to calibrate against(try other --ports)", cmd.host)
+		}
+		if len(bursts) > 0 {
+			first := bursts[0]
+			// A couple of answers can go missing for reasons of their own,
+			// losing more than 2% of them means probes are being dropped.
+			b.degraded = b.open < first.open || float64(b.answered()) < 0.98*float64(first.answered()) || b.exhausted > 0
+		}
+		bursts = append(bursts, b)
+
+		// Every port has a worker of its own by now, more can't go any faster.
+		if b.degraded || concurrency >= len(ports) {
+			break
+		}
+	}
+
+	concurrency := recommendConcurrency(bursts)
+	printBursts(bursts, concurrency)
+	fmt.Printf("\nrecommended: --concurrency %d --timeout %s\n", concurrency, timeout)
+
+	if cmd.dryRun {
+		return
+	}
+
+	settings := map[string]interface{}{
+		"concurrency": concurrency,
+		"timeout":     timeout.String(),
+	}
+	if err := saveProfile(cmd.config, cmd.profile, settings); err != nil {
+		log.Fatalf("failed to save profile: %s", err)
+	}
+	log.Printf("saved as profile %q in %s, scan with --profile %s", cmd.profile, cmd.config, cmd.profile)
+}
+
+// sampleRTT dials up to calibrateRTTSamples of ports one after the other and
+// returns how long the ones that connected or refused us took to answer.
+func sampleRTT(ctx context.Context, addr string, ports []int, timeout time.Duration) []time.Duration {
+	d := net.Dialer{Timeout: timeout}
+	var rtts []time.Duration
+	for i := 0; i < len(ports) && len(rtts) < calibrateRTTSamples && ctx.Err() == nil; i++ {
+		start := time.Now()
+		conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(addr, strconv.Itoa(ports[i])))
+		rtt := time.Since(start)
+		if err == nil {
+			conn.Close()
+		} else if !xerrors.Is(err, syscall.ECONNREFUSED) {
+			continue
+		}
+		rtts = append(rtts, rtt)
+	}
+	return rtts
+}
+
+// recommendTimeout leaves plenty of room over the slowest round trip we saw
+// for the path having a bad moment, without going over max.
+func recommendTimeout(slowest, max time.Duration) time.Duration {
+	timeout := 4 * slowest
+	if timeout < minCalibratedTimeout {
+		timeout = minCalibratedTimeout
+	}
+	timeout = timeout.Round(10 * time.Millisecond)
+	if timeout > max {
+		timeout = max
+	}
+	return timeout
+}
+
+// burst scans ports on addr concurrency at a time.
+func burst(ctx context.Context, addr string, ports []int, concurrency int, timeout time.Duration) (burstResult, error) {
+	s, err := scanner.New(addr, scanner.Options{
+		Network:     "tcp",
+		Ports:       ports,
+		Timeout:     timeout,
+		Concurrency: concurrency,
+	})
+	if err != nil {
+		return burstResult{}, xerrors.Errorf("failed to initialize port scanner: %w", err)
+	}
+
+	res, err := s.Scan(ctx)
+	if err != nil {
+		return burstResult{}, err
+	}
+	return burstResult{
+		concurrency: concurrency,
+		duration:    res.Duration,
+		probes:      res.Probes,
+		open:        len(res.Open()),
+		refused:     res.Failures["refused"],
+		exhausted:   res.Failures["exhausted"],
+	}, nil
+}
+
+// recommendConcurrency picks the lowest concurrency that got within 10% of the
+// fastest burst that didn't lose answers, going any higher only adds load.
+func recommendConcurrency(bursts []burstResult) int {
+	var fastest float64
+	for _, b := range bursts {
+		if !b.degraded && b.rate() > fastest {
+			fastest = b.rate()
+		}
+	}
+	for _, b := range bursts {
+		if !b.degraded && b.rate() >= 0.9*fastest {
+			return b.concurrency
+		}
+	}
+	return bursts[0].concurrency
+}
+
+func printBursts(bursts []burstResult, recommended int) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CONCURRENCY\tDURATION\tRATE\tOPEN\tCLOSED\tVERDICT")
+	for _, b := range bursts {
+		verdict := "ok"
+		switch {
+		case b.exhausted > 0:
+			verdict = fmt.Sprintf("ran out of sockets on %d ports", b.exhausted)
+		case b.degraded:
+			verdict = fmt.Sprintf("lost %d answers", bursts[0].answered()-b.answered())
+		case b.concurrency == recommended:
+			verdict = "recommended"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%.0f/s\t%d\t%d\t%s\n", b.concurrency, b.duration.Round(time.Millisecond), b.rate(), b.open, b.refused, verdict)
+	}
+	w.Flush()
+}
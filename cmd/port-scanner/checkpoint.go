@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+const checkpointInterval = 10 * time.Second
+
+// checkpoint is the progress of a scan as written to --checkpoint,
+// it's enough to pick a killed scan back up with --resume.
+type checkpoint struct {
+	Invocation string `json:"invocation"`
+	Protocol   string `json:"protocol"`
+	// Ports are the ports every target gets scanned on, sorted.
+	Ports   []int             `json:"ports"`
+	Targets []*targetProgress `json:"targets"`
+}
+
+// targetProgress is how far we got with a single target.
+type targetProgress struct {
+	Host string `json:"host"`
+	IP   string `json:"ip"`
+	// Result is set once the target has been scanned in full.
+	Result *hostResult `json:"result,omitempty"`
+	// Started is set once the target's scan is under way, from then on
+	// Remaining holds the ports still to be scanned and Found what turned up so far.
+	Started   bool                 `json:"started,omitempty"`
+	Remaining []int                `json:"remaining,omitempty"`
+	Found     []scanner.PortResult `json:"found,omitempty"`
+}
+
+// checkpointFile keeps a checkpoint written to path as the scan goes.
+// A nil checkpointFile does nothing, which keeps callers free of --checkpoint checks.
+type checkpointFile struct {
+	path string
+	mu   sync.Mutex
+	cp   *checkpoint
+	// saveMu serializes saves, hosts scanned in parallel each save as they go.
+	saveMu sync.Mutex
+}
+
+// newCheckpointFile starts a checkpoint of scanning targets on ports at path.
+// When resume is set the progress saved there by an earlier run of the same scan is carried over.
+func newCheckpointFile(path string, resume bool, invocation, protocol string, targets []target, ports []int) (*checkpointFile, error) {
+	cp := &checkpoint{
+		Invocation: invocation,
+		Protocol:   protocol,
+		Ports:      append([]int(nil), ports...),
+	}
+	sort.Ints(cp.Ports)
+
+	// Lets key the saved progress by target so it still lines up
+	// with the targets we just resolved.
+	saved := make(map[string]*targetProgress)
+	if resume {
+		prev, err := readCheckpoint(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if prev.Protocol != cp.Protocol || !sameInts(prev.Ports, cp.Ports) {
+			// A --sample without its --seed picks other ports every run.
+			return nil, xerrors.Errorf("%q was saved by a scan of other ports or another protocol(resume with the same port flags, and the same --seed for --sample)", path)
+		}
+
+		for _, p := range prev.Targets {
+			saved[p.Host+" "+p.IP] = p
+		}
+	}
+
+	for _, t := range targets {
+		p, ok := saved[t.host+" "+t.addr()]
+		if !ok {
+			p = &targetProgress{Host: t.host, IP: t.addr()}
+		}
+		cp.Targets = append(cp.Targets, p)
+	}
+
+	f := &checkpointFile{path: path, cp: cp}
+	return f, f.save()
+}
+
+func readCheckpoint(path string) (*checkpoint, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read %q: %w", path, err)
+	}
+
+	var cp checkpoint
+	if err := json.Unmarshal(b, &cp); err != nil {
+		return nil, xerrors.Errorf("failed to parse %q: %w", path, err)
+	}
+	return &cp, nil
+}
+
+// progress returns the progress of the i'th target.
+func (f *checkpointFile) progress(i int) *targetProgress {
+	if f == nil {
+		return nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.cp.Targets[i]
+}
+
+// update records the ports s found and has still to scan on p so far and saves the checkpoint.
+// Ports found by the run we resumed from are kept.
+func (f *checkpointFile) update(p *targetProgress, s *scanner.Scanner) {
+	if f == nil {
+		return
+	}
+
+	found, remaining := s.Snapshot()
+	f.mu.Lock()
+	p.Started = true
+	p.Found = mergePorts(p.Found, found)
+	p.Remaining = remaining
+	f.mu.Unlock()
+
+	if err := f.save(); err != nil {
+		log.Printf("failed to save checkpoint: %s", err)
+	}
+}
+
+// every saves the progress of s on p every checkpointInterval until the returned func is called,
+// so even a scan killed outright only loses the ports of the last interval.
+func (f *checkpointFile) every(s *scanner.Scanner, p *targetProgress) (stop func()) {
+	if f == nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(checkpointInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				f.update(p, s)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+	}
+}
+
+// finish records the result of a target that has been scanned in full and saves the checkpoint.
+func (f *checkpointFile) finish(p *targetProgress, result *hostResult) {
+	if f == nil {
+		return
+	}
+
+	f.mu.Lock()
+	p.Result = result
+	p.Started, p.Remaining, p.Found = false, nil, nil
+	f.mu.Unlock()
+
+	if err := f.save(); err != nil {
+		log.Printf("failed to save checkpoint: %s", err)
+	}
+}
+
+// done reports whether every target has been scanned in full.
+func (f *checkpointFile) done() bool {
+	if f == nil {
+		return false
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, p := range f.cp.Targets {
+		if p.Result == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// save writes the checkpoint next to path before moving it into place,
+// so getting killed half way through a write never leaves a corrupt one behind.
+func (f *checkpointFile) save() error {
+	// Saves sharing the tmp file would mix their writes, and an older
+	// snapshot could replace a newer one.
+	f.saveMu.Lock()
+	defer f.saveMu.Unlock()
+
+	f.mu.Lock()
+	b, err := json.MarshalIndent(f.cp, "", "  ")
+	f.mu.Unlock()
+	if err != nil {
+		return xerrors.Errorf("failed to encode checkpoint: %w", err)
+	}
+
+	tmp := f.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return xerrors.Errorf("failed to write %q: %w", tmp, err)
+	}
+
+	if err := os.Rename(tmp, f.path); err != nil {
+		return xerrors.Errorf("failed to replace %q: %w", f.path, err)
+	}
+	return nil
+}
+
+// remove deletes the checkpoint once there's nothing left to resume.
+func (f *checkpointFile) remove() error {
+	if f == nil {
+		return nil
+	}
+	return os.Remove(f.path)
+}
+
+// mergePorts returns the ports of both a and b sorted by port number,
+// where a port is in both the one from b wins.
+func mergePorts(a, b []scanner.PortResult) []scanner.PortResult {
+	byPort := make(map[int]scanner.PortResult, len(a)+len(b))
+	for _, p := range a {
+		byPort[p.Port] = p
+	}
+	for _, p := range b {
+		byPort[p.Port] = p
+	}
+
+	merged := make([]scanner.PortResult, 0, len(byPort))
+	for _, p := range byPort {
+		merged = append(merged, p)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Port < merged[j].Port })
+	return merged
+}
+
+// sameInts reports whether a and b hold the same ints in the same order.
+func sameInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
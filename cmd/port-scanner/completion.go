@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"go.coder.com/cli"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+// completion prints shell completion scripts. They're generated from the
+// command tree itself, so new subcommands and flags are picked up for free.
+type completionCmd struct{}
+
+func (cmd *completionCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "completion",
+		Usage: "bash|zsh|fish",
+		Desc: "Print a shell completion script.\n" +
+			"e.g. source <(port-scanner completion bash) or port-scanner completion fish | source",
+	}
+}
+
+func (cmd *completionCmd) Run(fl *pflag.FlagSet) {
+	if fl.NArg() != 1 {
+		fl.Usage()
+		log.Fatal("expected a shell(bash, zsh or fish)")
+	}
+
+	tree := newCompletionNode(nil, new(root))
+	var err error
+	switch fl.Arg(0) {
+	case "bash":
+		err = writeBashCompletion(os.Stdout, tree)
+	case "zsh":
+		err = writeZshCompletion(os.Stdout, tree)
+	case "fish":
+		err = writeFishCompletion(os.Stdout, tree)
+	default:
+		fl.Usage()
+		log.Fatalf("%q is an unsupported shell", fl.Arg(0))
+	}
+
+	if err != nil {
+		log.Fatalf("failed to write completion script: %s", err)
+	}
+}
+
+// completionNode is a command along with everything there is to complete after it.
+type completionNode struct {
+	path     []string
+	spec     cli.CommandSpec
+	flags    []*pflag.Flag
+	children []*completionNode
+}
+
+func newCompletionNode(parent []string, c cli.Command) *completionNode {
+	n := &completionNode{spec: c.Spec()}
+	n.path = append(append([]string(nil), parent...), n.spec.Name)
+
+	if f, ok := c.(cli.FlaggedCommand); ok {
+		fl := pflag.NewFlagSet(n.spec.Name, pflag.ContinueOnError)
+		f.RegisterFlags(fl)
+		fl.VisitAll(func(f *pflag.Flag) {
+			if !f.Hidden {
+				n.flags = append(n.flags, f)
+			}
+		})
+	}
+
+	if p, ok := c.(cli.ParentCommand); ok {
+		for _, child := range p.Subcommands() {
+			if !child.Spec().Hidden {
+				n.children = append(n.children, newCompletionNode(n.path, child))
+			}
+		}
+	}
+	return n
+}
+
+// walk calls fn on n and every command below it.
+func (n *completionNode) walk(fn func(*completionNode)) {
+	fn(n)
+	for _, c := range n.children {
+		c.walk(fn)
+	}
+}
+
+func (n *completionNode) childNames() []string {
+	var names []string
+	for _, c := range n.children {
+		names = append(names, c.spec.Name)
+	}
+	return names
+}
+
+// takesValue reports whether f is followed by a value rather than standing on its own.
+func takesValue(f *pflag.Flag) bool {
+	return f.NoOptDefVal == ""
+}
+
+// flagValues returns the values worth suggesting for flag of the command at path.
+// Flags that take free-form values like ports or file paths have none.
+func flagValues(path []string, flag string) []string {
+	command := path[len(path)-1]
+	switch flag {
+	case "output":
+		if command != "scan" {
+			return []string{"text", "json"}
+		}
+		return strings.Split(outputFormats(), ", ")
+	case "protocol":
+		return []string{"tcp", "udp"}
+	case "log-format":
+		return []string{"text", "json"}
+	case "addresses":
+		return []string{"first", "dual", "all"}
+	case "methods":
+		return []string{"arp", "icmp", "tcp"}
+	case "probes":
+		return append(scanner.ProbeNames(), "all")
+	case "profile":
+		return profileNames(nil)
+	case "timing":
+		var names []string
+		for i, t := range timingTemplates {
+			names = append(names, fmt.Sprint(i), t.name)
+		}
+		return names
+	}
+	return nil
+}
+
+// shellFunc turns a command path into something usable as a shell function name.
+func shellFunc(path []string) string {
+	return "_" + strings.Replace(strings.Join(path, "_"), "-", "_", -1)
+}
+
+func writeBashCompletion(w io.Writer, tree *completionNode) error {
+	var b strings.Builder
+	name := tree.spec.Name
+	fmt.Fprintf(&b, "# bash completion for %s, load it with: source <(%s completion bash)\n", name, name)
+	fmt.Fprintf(&b, "%s() {\n", shellFunc(tree.path))
+	b.WriteString("    local cur=\"${COMP_WORDS[COMP_CWORD]}\" prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+	fmt.Fprintf(&b, "    local path=%q i\n", name)
+
+	// Lets figure out which subcommand we're in from the words typed so far.
+	b.WriteString("    for ((i = 1; i < COMP_CWORD; i++)); do\n")
+	b.WriteString("        case \"$path ${COMP_WORDS[i]}\" in\n")
+	tree.walk(func(n *completionNode) {
+		if len(n.path) == 1 {
+			return
+		}
+		parent := strings.Join(n.path[:len(n.path)-1], " ")
+		patterns := []string{fmt.Sprintf("%q", parent+" "+n.spec.Name)}
+		for _, alias := range n.spec.Aliases {
+			patterns = append(patterns, fmt.Sprintf("%q", parent+" "+alias))
+		}
+		fmt.Fprintf(&b, "            %s) path=%q ;;\n", strings.Join(patterns, "|"), strings.Join(n.path, " "))
+	})
+	b.WriteString("        esac\n")
+	b.WriteString("    done\n\n")
+
+	b.WriteString("    case \"$path\" in\n")
+	tree.walk(func(n *completionNode) {
+		fmt.Fprintf(&b, "        %q)\n", strings.Join(n.path, " "))
+
+		var words []string
+		var valueFlags []string
+		b.WriteString("            case \"$prev\" in\n")
+		for _, f := range n.flags {
+			words = append(words, "--"+f.Name)
+			names := "--" + f.Name
+			if f.Shorthand != "" {
+				words = append(words, "-"+f.Shorthand)
+				names += "|-" + f.Shorthand
+			}
+			if !takesValue(f) {
+				continue
+			}
+
+			if values := flagValues(n.path, f.Name); len(values) > 0 {
+				fmt.Fprintf(&b, "                %s) COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n", names, strings.Join(values, " "))
+			} else {
+				valueFlags = append(valueFlags, names)
+			}
+		}
+		// Free-form values fall back to completing file names.
+		if len(valueFlags) > 0 {
+			fmt.Fprintf(&b, "                %s) return ;;\n", strings.Join(valueFlags, "|"))
+		}
+		b.WriteString("            esac\n")
+
+		words = append(n.childNames(), words...)
+		fmt.Fprintf(&b, "            COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n", strings.Join(words, " "))
+	})
+	b.WriteString("    esac\n")
+	b.WriteString("}\n")
+	fmt.Fprintf(&b, "complete -o default -F %s %s\n", shellFunc(tree.path), name)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeZshCompletion reuses the bash script through zsh's bash compatibility,
+// it understands the same compgen and complete builtins once bashcompinit is loaded.
+func writeZshCompletion(w io.Writer, tree *completionNode) error {
+	name := tree.spec.Name
+	header := fmt.Sprintf("#compdef %s\n# zsh completion for %s, load it with: source <(%s completion zsh)\n", name, name, name) +
+		"autoload -U +X compinit && compinit\n" +
+		"autoload -U +X bashcompinit && bashcompinit\n"
+	if _, err := io.WriteString(w, header); err != nil {
+		return err
+	}
+	return writeBashCompletion(w, tree)
+}
+
+func writeFishCompletion(w io.Writer, tree *completionNode) error {
+	var b strings.Builder
+	name := tree.spec.Name
+	fmt.Fprintf(&b, "# fish completion for %s, load it with: %s completion fish | source\n", name, name)
+	fmt.Fprintf(&b, "complete -c %s -f\n", name)
+
+	tree.walk(func(n *completionNode) {
+		// A command is active once its whole path has been typed, and its
+		// subcommands are only offered until one of them has been.
+		var conditions []string
+		if len(n.path) == 1 {
+			conditions = append(conditions, "__fish_use_subcommand")
+		}
+		for _, word := range n.path[1:] {
+			conditions = append(conditions, "__fish_seen_subcommand_from "+word)
+		}
+		active := strings.Join(conditions, "; and ")
+
+		if children := n.childNames(); len(children) > 0 {
+			offer := active
+			if len(n.path) > 1 {
+				offer += "; and not __fish_seen_subcommand_from " + strings.Join(children, " ")
+			}
+			for _, c := range n.children {
+				fmt.Fprintf(&b, "complete -c %s -n %s -a %s -d %s\n", name, fishQuote(offer), c.spec.Name, fishQuote(c.spec.ShortDesc()))
+			}
+		}
+
+		if len(n.path) == 1 {
+			return
+		}
+
+		for _, f := range n.flags {
+			line := fmt.Sprintf("complete -c %s -n %s -l %s", name, fishQuote(active), f.Name)
+			if f.Shorthand != "" {
+				line += " -s " + f.Shorthand
+			}
+			if takesValue(f) {
+				if values := flagValues(n.path, f.Name); len(values) > 0 {
+					line += " -x -a " + fishQuote(strings.Join(values, " "))
+				} else {
+					line += " -r -F"
+				}
+			}
+			line += " -d " + fishQuote(f.Usage)
+			b.WriteString(line + "\n")
+		}
+	})
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// fishQuote single quotes s for fish, which only treats \\ and \' specially inside them.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
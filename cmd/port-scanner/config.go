@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+// A config file spares retyping the same flags for every scan. Its keys are flag names
+// without the dashes, under a section named after the subcommand they apply to.
+// The defaults section applies to every subcommand with a flag of that name.
+// Flags given on the command line always win over the file.
+//
+//	defaults:
+//	  timeout: 1s
+//	scan:
+//	  hosts: [10.0.0.1, example.com]
+//	  ports: [22, 80, 443, 8000-9000]
+//	  concurrency: 256
+//	  output: json
+//	watch:
+//	  every: 1m
+//	  smtp-server: smtp.example.com:587
+//	  smtp-user: alerts@example.com
+//	  email-to: [oncall@example.com]
+//
+// hosts is the one key that isn't a flag, scan falls back to it when
+// neither --host nor --targets-file is given.
+//
+// A profiles section defines named bundles of settings picked with --profile,
+// on top of the built in ones(see profiles.go).
+//
+//	profiles:
+//	  lan:
+//	    top-ports: 1000
+//	    timeout: 200ms
+//	    concurrency: 2048
+//
+// A port-groups section adds to the groups --ports takes as @name, or
+// replaces the built in ones(@web, @db, @mail, @remote-access and @windows).
+//
+//	port-groups:
+//	  k8s: [6443, 2379-2380, 10250]
+//	  internal: "@web,@db,9090"
+type config map[string]map[string]interface{}
+
+func defaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "port-scanner", "config.yaml")
+}
+
+func registerConfigFlag(fl *pflag.FlagSet, path *string) {
+	fl.StringVar(path, "config", defaultConfigPath(), "yaml file of flag defaults(see config.go for the format)")
+}
+
+// readConfig reads the config file at path. Not having one at the default
+// path is fine, but a file that was asked for with --config has to exist.
+func readConfig(fl *pflag.FlagSet, path string) (config, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !fl.Changed("config") {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read %q: %w", path, err)
+	}
+
+	var c config
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return nil, xerrors.Errorf("failed to parse %q: %w", path, err)
+	}
+	return c, nil
+}
+
+// applyConfig sets every flag of fl the command line didn't from the config file
+// at path. The command's own section is applied over the defaults, and the
+// profile picked with --profile over both. It returns the hosts the command's
+// section lists, which only scan makes use of.
+func applyConfig(fl *pflag.FlagSet, path, command string) ([]string, error) {
+	c, err := readConfig(fl, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := definePortGroups(c["port-groups"]); err != nil {
+		return nil, xerrors.Errorf("in %q: %w", path, err)
+	}
+
+	// Lets note what came from the command line before we start setting flags ourselves.
+	given := make(map[string]bool)
+	fl.Visit(func(f *pflag.Flag) { given[f.Name] = true })
+
+	// Picking ports on the command line replaces however the file picked them,
+	// rather than clashing with it.
+	for _, group := range exclusiveFlags {
+		for _, name := range group {
+			if !given[name] {
+				continue
+			}
+			for _, other := range group {
+				given[other] = true
+			}
+		}
+	}
+
+	if err := applySettings(fl, given, c["defaults"], "defaults", true); err != nil {
+		return nil, xerrors.Errorf("in %q: %w", path, err)
+	}
+
+	settings := c[command]
+	var hosts []string
+	if value, ok := settings["hosts"]; ok {
+		hosts, err = configList(value)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid %s.hosts in %q: %w", command, path, err)
+		}
+		settings = withoutKey(settings, "hosts")
+	}
+	if err := applySettings(fl, given, settings, command, false); err != nil {
+		return nil, xerrors.Errorf("in %q: %w", path, err)
+	}
+
+	if f := fl.Lookup("profile"); f != nil && f.Value.String() != "" {
+		name := f.Value.String()
+		profile, err := findProfile(c, name)
+		if err != nil {
+			return nil, err
+		}
+		// Profiles are shared between subcommands too.
+		if err := applySettings(fl, given, profile, "profiles."+name, true); err != nil {
+			return nil, xerrors.Errorf("in %q: %w", path, err)
+		}
+	}
+	return hosts, nil
+}
+
+// exclusiveFlags are groups of flags that can't be set together.
+var exclusiveFlags = [][]string{
+	{"ports", "all", "top-ports"},
+}
+
+// applySettings sets the flags named in settings, skipping the ones in given.
+// A shared section may name flags fl doesn't have, those are ignored.
+func applySettings(fl *pflag.FlagSet, given map[string]bool, settings map[string]interface{}, section string, shared bool) error {
+	for _, name := range sortedKeys(settings) {
+		if fl.Lookup(name) == nil {
+			if shared {
+				continue
+			}
+			return xerrors.Errorf("unknown setting %s.%s", section, name)
+		}
+
+		if given[name] || name == "config" {
+			continue
+		}
+
+		if err := fl.Set(name, configValue(settings[name])); err != nil {
+			return xerrors.Errorf("invalid %s.%s: %w", section, name, err)
+		}
+	}
+	return nil
+}
+
+func withoutKey(m map[string]interface{}, key string) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if k != key {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// configValue renders a yaml value the way the flag would have been given on the command line,
+// lists become comma separated and maps become key=value pairs(e.g. for --confirm).
+func configValue(value interface{}) string {
+	switch v := value.(type) {
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, elem := range v {
+			parts[i] = configValue(elem)
+		}
+		return strings.Join(parts, ",")
+	case map[string]interface{}:
+		var parts []string
+		for _, k := range sortedKeys(v) {
+			parts = append(parts, k+"="+configValue(v[k]))
+		}
+		return strings.Join(parts, ",")
+	case map[interface{}]interface{}:
+		// Maps keyed by ports(e.g. 443: tls) don't decode with string keys.
+		m := make(map[string]interface{}, len(v))
+		for k, elem := range v {
+			m[fmt.Sprint(k)] = elem
+		}
+		return configValue(m)
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// configList accepts either a list or a single value.
+func configList(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case []interface{}:
+		list := make([]string, len(v))
+		for i, elem := range v {
+			if _, ok := elem.([]interface{}); ok {
+				return nil, xerrors.New("expected a list of values, not a list of lists")
+			}
+			list[i] = configValue(elem)
+		}
+		return list, nil
+	case map[string]interface{}, map[interface{}]interface{}:
+		return nil, xerrors.New("expected a list of values")
+	}
+	return []string{configValue(value)}, nil
+}
+
+// definePortGroups defines the groups of the port-groups section. Groups can
+// refer to each other, so the ones referring to a group that isn't defined
+// yet are retried until all of them are or there's no more progress.
+func definePortGroups(groups map[string]interface{}) error {
+	pending := make(map[string]string, len(groups))
+	for name, value := range groups {
+		list, err := configList(value)
+		if err != nil {
+			return xerrors.Errorf("invalid port-groups.%s: %w", name, err)
+		}
+		pending[name] = strings.Join(list, ",")
+	}
+
+	for len(pending) > 0 {
+		names := make([]string, 0, len(pending))
+		for name := range pending {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var lastErr error
+		for _, name := range names {
+			if err := scanner.DefinePortGroup(name, pending[name]); err != nil {
+				lastErr = err
+				continue
+			}
+			delete(pending, name)
+		}
+		if len(pending) == len(names) {
+			return lastErr
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
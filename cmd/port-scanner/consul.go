@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	defaultConsulAddr = "127.0.0.1:8500"
+	consulTimeout     = 10 * time.Second
+)
+
+// consulSource is a consul://agent target source, e.g.
+// consul://10.0.0.5:8500?dc=eu1&service=web,api&tag=prod
+type consulSource struct {
+	// base is the agent's http api, e.g. http://127.0.0.1:8500.
+	base string
+	dc   string
+	// services limits the catalog to these services, all of them when empty.
+	services []string
+	tag      string
+	token    string
+	client   *http.Client
+}
+
+func parseConsulSource(u *url.URL) (*consulSource, error) {
+	src := &consulSource{
+		token:  os.Getenv("CONSUL_HTTP_TOKEN"),
+		client: &http.Client{Timeout: consulTimeout},
+	}
+
+	// An empty host means the agent the consul cli would talk to.
+	addr, scheme := u.Host, "http"
+	if addr == "" {
+		addr = os.Getenv("CONSUL_HTTP_ADDR")
+		if strings.HasPrefix(addr, "https://") || os.Getenv("CONSUL_HTTP_SSL") == "true" {
+			scheme = "https"
+		}
+		addr = strings.TrimPrefix(strings.TrimPrefix(addr, "https://"), "http://")
+	}
+	if addr == "" {
+		addr = defaultConsulAddr
+	}
+
+	for key, values := range u.Query() {
+		value := values[len(values)-1]
+		switch key {
+		case "dc":
+			src.dc = value
+		case "service":
+			src.services = strings.Split(value, ",")
+		case "tag":
+			src.tag = value
+		case "scheme":
+			if value != "http" && value != "https" {
+				return nil, xerrors.Errorf("scheme=%s should be http or https", value)
+			}
+			scheme = value
+		default:
+			return nil, xerrors.Errorf("%q isn't a consul:// option(dc, service, tag or scheme)", key)
+		}
+	}
+	src.base = scheme + "://" + addr
+	return src, nil
+}
+
+// consulInstance is a registration of a service in the catalog.
+type consulInstance struct {
+	Node           string `json:"Node"`
+	Address        string `json:"Address"`
+	ServiceID      string `json:"ServiceID"`
+	ServiceName    string `json:"ServiceName"`
+	ServiceAddress string `json:"ServiceAddress"`
+	ServicePort    int    `json:"ServicePort"`
+}
+
+// addr is where the service listens, the node's address unless it registered one of its own.
+func (i consulInstance) addr() string {
+	if i.ServiceAddress != "" {
+		return i.ServiceAddress
+	}
+	return i.Address
+}
+
+func (i consulInstance) String() string {
+	return "consul/" + i.Node + "/" + i.ServiceID
+}
+
+// instances returns every registration of the services the source asks for.
+func (src *consulSource) instances(ctx context.Context) ([]consulInstance, error) {
+	services := src.services
+	if len(services) == 0 {
+		var catalog map[string][]string
+		if err := src.get(ctx, "/v1/catalog/services", nil, &catalog); err != nil {
+			return nil, xerrors.Errorf("failed to list services: %w", err)
+		}
+		for name := range catalog {
+			services = append(services, name)
+		}
+		sort.Strings(services)
+	}
+
+	found := make([][]consulInstance, len(services))
+	errs := make([]error, len(services))
+	var wg sync.WaitGroup
+	for i, name := range services {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			query := url.Values{}
+			if src.tag != "" {
+				query.Set("tag", src.tag)
+			}
+			if err := src.get(ctx, "/v1/catalog/service/"+url.PathEscape(name), query, &found[i]); err != nil {
+				errs[i] = xerrors.Errorf("failed to look up service %q: %w", name, err)
+			}
+		}(i, name)
+	}
+	wg.Wait()
+
+	var instances []consulInstance
+	for i := range services {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		instances = append(instances, found[i]...)
+	}
+	return instances, nil
+}
+
+func (src *consulSource) get(ctx context.Context, path string, query url.Values, v interface{}) error {
+	if query == nil {
+		query = url.Values{}
+	}
+	if src.dc != "" {
+		query.Set("dc", src.dc)
+	}
+	target := src.base + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return xerrors.Errorf("failed to build request: %w", err)
+	}
+	if src.token != "" {
+		req.Header.Set("X-Consul-Token", src.token)
+	}
+
+	resp, err := src.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return xerrors.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		// Consul explains itself in plain text.
+		if msg := strings.TrimSpace(string(b)); msg != "" && len(msg) < 200 {
+			return xerrors.Errorf("%s: %s", resp.Status, msg)
+		}
+		return xerrors.Errorf("consul answered %s", resp.Status)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return xerrors.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// addConsulInstances adds the addresses of instances to d, each declaring
+// the port it registered. The catalog doesn't know the protocol a service
+// speaks, so its ports are taken to be tcp ones.
+func addConsulInstances(d discovered, instances []consulInstance, protocol string) {
+	for _, i := range instances {
+		if protocol != "tcp" || i.ServicePort == 0 {
+			d.addHost(i.addr(), i.String())
+			continue
+		}
+		d.add(i.addr(), i.String(), []int{i.ServicePort}, true)
+	}
+}
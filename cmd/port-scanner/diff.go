@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spf13/pflag"
+	"go.coder.com/cli"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+// diff compares two saved scans, which is how you spot what changed
+// on a network between two points in a pentest or an audit.
+type diffCmd struct{}
+
+func (cmd *diffCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:    "diff",
+		Usage:   "<old.json|nmap.xml> <new.json|nmap.xml>",
+		Aliases: []string{"d"},
+		Desc:    "Compare two scans saved with --save, or nmap's -oX output, and print the ports that appeared, disappeared or changed state.",
+	}
+}
+
+func (cmd *diffCmd) Run(fl *pflag.FlagSet) {
+	if fl.NArg() != 2 {
+		fl.Usage()
+		log.Fatal("expected exactly two result files")
+	}
+
+	before, err := readReport(fl.Arg(0))
+	if err != nil {
+		log.Fatalf("failed to read old results: %s", err)
+	}
+
+	after, err := readReport(fl.Arg(1))
+	if err != nil {
+		log.Fatalf("failed to read new results: %s", err)
+	}
+
+	changes := diffReports(before, after)
+	if len(changes) == 0 {
+		log.Print("no changes")
+		return
+	}
+
+	for _, c := range changes {
+		fmt.Println(c)
+	}
+}
+
+// hostKey identifies a host across scans. A hostname that resolved to
+// a different address is treated as a different host since those are
+// usually different machines.
+func hostKey(r *hostResult) string {
+	if r.Host == r.IP {
+		return r.Host
+	}
+	return fmt.Sprintf("%s(%s)", r.Host, r.IP)
+}
+
+// diffReports lists every port that appeared, disappeared or changed state
+// between before and after, grouped by host in the order after lists them.
+// Each port is marked with a +, - or ~ respectively.
+func diffReports(before, after *report) []string {
+	old := make(map[string]*hostResult)
+	for _, h := range before.Hosts {
+		old[hostKey(h)] = h
+	}
+
+	var keys []string
+	seen := make(map[string]bool)
+	current := make(map[string]*hostResult)
+	for _, h := range after.Hosts {
+		key := hostKey(h)
+		current[key] = h
+		keys = append(keys, key)
+		seen[key] = true
+	}
+	// Hosts that were only in the old scan go last.
+	for _, h := range before.Hosts {
+		if key := hostKey(h); !seen[key] {
+			keys = append(keys, key)
+			seen[key] = true
+		}
+	}
+
+	var changes []string
+	for _, key := range keys {
+		changes = append(changes, diffHost(key, old[key], current[key])...)
+	}
+	return changes
+}
+
+func diffHost(key string, before, after *hostResult) []string {
+	was, is := portStates(before), portStates(after)
+
+	ports := make(map[int]bool)
+	for port := range was {
+		ports[port] = true
+	}
+	for port := range is {
+		ports[port] = true
+	}
+
+	var changes []string
+	for _, port := range sortedPorts(ports) {
+		name := portResult{Port: port, Service: scanner.ServiceName(port, protocolOf(before, after))}.name()
+		oldState, wasThere := was[port]
+		newState, isThere := is[port]
+		switch {
+		case !wasThere:
+			changes = append(changes, fmt.Sprintf("%s: +%s %s", key, name, newState))
+		case !isThere:
+			changes = append(changes, fmt.Sprintf("%s: -%s %s", key, name, oldState))
+		case oldState != newState:
+			changes = append(changes, fmt.Sprintf("%s: ~%s %s -> %s", key, name, oldState, newState))
+		}
+	}
+	return changes
+}
+
+// portStates maps each reported port of r to its state, a nil r has none.
+func portStates(r *hostResult) map[int]scanner.State {
+	states := make(map[int]scanner.State)
+	if r == nil {
+		return states
+	}
+
+	for _, p := range r.Ports {
+		states[p.Port] = p.State
+	}
+	return states
+}
+
+func protocolOf(results ...*hostResult) string {
+	for _, r := range results {
+		if r != nil {
+			return r.Protocol
+		}
+	}
+	return "tcp"
+}
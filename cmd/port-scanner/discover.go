@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/pflag"
+	"go.coder.com/cli"
+	"golang.org/x/xerrors"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+// discover finds the live hosts of a range before we spend time port scanning
+// every address in it. Live hosts are printed one per line on stdout so the
+// list can be piped straight into scan, everything else is logged to stderr.
+//
+//	port-scanner discover --host 10.0.0.0/24 | port-scanner scan --targets-file -
+type discoverCmd struct {
+	host        string
+	methods     []string
+	ports       string
+	timeout     time.Duration
+	concurrency int
+	output      string
+	noPrivDrop  bool
+}
+
+func (cmd *discoverCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:    "discover",
+		Usage:   "[flags]",
+		Aliases: []string{"disc"},
+		Desc:    "Find the live hosts of a cidr range and print them as a target list.",
+	}
+}
+
+func (cmd *discoverCmd) RegisterFlags(fl *pflag.FlagSet) {
+	fl.StringVar(&cmd.host, "host", "", "host or cidr range to sweep(e.g. 192.168.1.0/24)")
+	fl.StringSliceVar(&cmd.methods, "methods", []string{"arp", "icmp", "tcp"}, "how to probe for live hosts(arp, icmp and/or tcp)")
+	fl.StringVarP(&cmd.ports, "ports", "p", "", "ports tcp discovery connects to(defaults to 22,80,443,445,3389,8080)")
+	fl.DurationVar(&cmd.timeout, "timeout", scanner.DefaultDiscoverTimeout, "how long to wait on each probe")
+	fl.IntVar(&cmd.concurrency, "concurrency", scanner.DefaultConcurrency, "how many tcp probes to have in flight at once")
+	fl.StringVarP(&cmd.output, "output", "o", "text", "output format(text or json)")
+	registerNoPrivDropFlag(fl, &cmd.noPrivDrop)
+}
+
+// liveHost is a host discovery found to be up, as it's written in json.
+type liveHost struct {
+	IP      string   `json:"ip"`
+	Method  string   `json:"method"`
+	Latency duration `json:"latency,omitempty"`
+	MAC     string   `json:"mac,omitempty"`
+	Vendor  string   `json:"vendor,omitempty"`
+}
+
+func (cmd *discoverCmd) Run(fl *pflag.FlagSet) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if cmd.host == "" {
+		fl.Usage()
+		log.Fatal("host not provided")
+	}
+
+	switch cmd.output {
+	case "text", "json":
+	default:
+		fl.Usage()
+		log.Fatalf("%q is an unsupported output format", cmd.output)
+	}
+
+	if cmd.timeout <= 0 {
+		fl.Usage()
+		log.Fatalf("--timeout must be positive, got %s", cmd.timeout)
+	}
+
+	var opts scanner.DiscoverOptions
+	for _, method := range cmd.methods {
+		switch method {
+		case "icmp":
+			opts.ICMP = true
+		case "tcp":
+			opts.TCP = true
+		case "arp":
+			opts.ARP = true
+		default:
+			fl.Usage()
+			log.Fatalf("%q is an unsupported discovery method", method)
+		}
+	}
+
+	if opts.ICMP || opts.ARP {
+		dropPrivileges(cmd.noPrivDrop, false)
+	}
+
+	// Not everyone can open raw sockets, so unless icmp was asked for
+	// explicitly lets make do with the other methods.
+	if opts.ICMP {
+		if err := scanner.CheckICMP(); err != nil {
+			if fl.Changed("methods") {
+				log.Fatal(err)
+			}
+			log.Printf("skipping icmp: %s", err)
+			opts.ICMP = false
+		}
+	}
+
+	// Without packet sockets we still have the arp cache, unless the platform has neither.
+	if opts.ARP {
+		err := scanner.CheckARP()
+		switch {
+		case xerrors.Is(err, scanner.ErrUnsupported):
+			if fl.Changed("methods") {
+				log.Fatal(err)
+			}
+			log.Printf("skipping arp: %s", err)
+			opts.ARP = false
+		case err != nil:
+			log.Printf("only checking the kernel's arp cache: %s", err)
+		}
+	}
+
+	if cmd.ports != "" {
+		ports, err := scanner.ParsePorts(cmd.ports)
+		if err != nil {
+			fl.Usage()
+			log.Fatalf("invalid ports: %s", err)
+		}
+		opts.Ports = ports
+	}
+	opts.Timeout = cmd.timeout
+	opts.Concurrency = cmd.concurrency
+
+	hosts, err := scanner.ExpandHost(cmd.host)
+	if err != nil {
+		fl.Usage()
+		log.Fatalf("invalid host: %s", err)
+	}
+
+	var ips []net.IP
+	for _, host := range hosts {
+		resolved, err := scanner.Resolve(ctx, host, "tcp", scanner.DefaultResolveTimeout)
+		if err != nil {
+			log.Printf("failed to resolve host: %s", err)
+			continue
+		}
+		ips = append(ips, resolved[0])
+	}
+
+	log.Printf("sweeping %d hosts with %s...", len(ips), strings.Join(cmd.enabled(opts), ", "))
+	start := time.Now()
+	live, err := scanner.Discover(ctx, ips, opts)
+	if err != nil && ctx.Err() == nil {
+		log.Fatalf("failed to discover hosts: %s", err)
+	}
+	if ctx.Err() != nil {
+		log.Print("interrupted, showing the hosts found so far")
+	}
+	log.Printf("found %d/%d hosts up in %s", len(live), len(ips), time.Since(start).Round(time.Millisecond))
+
+	results := make([]liveHost, len(live))
+	for i, host := range live {
+		results[i] = liveHost{IP: host.IP.String(), Method: host.Method, Latency: duration(host.Latency)}
+		if host.MAC != nil {
+			results[i].MAC = host.MAC.String()
+			results[i].Vendor = scanner.Vendor(host.MAC)
+		}
+	}
+
+	if cmd.output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			log.Fatalf("failed to write json output: %s", err)
+		}
+	} else {
+		for _, host := range results {
+			detail := host.Method
+			if host.Latency > 0 {
+				detail += " " + host.Latency.String()
+			}
+			line := fmt.Sprintf("%s is up(%s)", host.IP, detail)
+			if host.MAC != "" {
+				line += " " + host.MAC
+			}
+			if host.Vendor != "" {
+				line += " → " + host.Vendor
+			}
+			log.Print(line)
+			fmt.Println(host.IP)
+		}
+	}
+
+	if ctx.Err() != nil {
+		os.Exit(exitInterrupted)
+	}
+}
+
+// enabled lists the discovery methods opts will use.
+func (cmd *discoverCmd) enabled(opts scanner.DiscoverOptions) []string {
+	var methods []string
+	for _, m := range []struct {
+		name string
+		on   bool
+	}{{"arp", opts.ARP}, {"icmp", opts.ICMP}, {"tcp", opts.TCP}} {
+		if m.on {
+			methods = append(methods, m.name)
+		}
+	}
+	return methods
+}
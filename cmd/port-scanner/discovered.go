@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// discovered are the addresses target sources like --k8s-namespace,
+// --docker and --targets found, keyed by the address as it's reported, each with what was
+// found there and the ports those are meant to have open. A nil discovered
+// holds nothing.
+type discovered map[string]*discoveredAddress
+
+type discoveredAddress struct {
+	// objects are what was found at the address, e.g. "pod/shop/web-1".
+	objects []string
+	// ports are the ports the objects declare, sorted.
+	ports []int
+	// full is set when the address should be scanned on every port rather
+	// than only the declared ones, which is what tells us about the ports
+	// that are open without being declared.
+	full bool
+	// declares is set once an object that declares its ports was found at
+	// the address, without one there's nothing to compare the scan with.
+	declares bool
+}
+
+// add records that object lives at ip and declares ports there.
+func (d discovered) add(ip, object string, ports []int, full bool) {
+	if a := d.address(ip, object); a != nil {
+		a.ports = mergeInts(a.ports, ports)
+		sort.Ints(a.ports)
+		a.full = a.full || full
+		a.declares = true
+	}
+}
+
+// addHost records that object, which says nothing about its ports, lives at
+// ip, e.g. a cloud instance. Its address gets scanned in full.
+func (d discovered) addHost(ip, object string) {
+	if a := d.address(ip, object); a != nil {
+		a.full = true
+	}
+}
+
+func (d discovered) address(ip, object string) *discoveredAddress {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return nil
+	}
+	key := parsed.String()
+	a, ok := d[key]
+	if !ok {
+		a = new(discoveredAddress)
+		d[key] = a
+	}
+	if !containsString(a.objects, object) {
+		a.objects = append(a.objects, object)
+	}
+	return a
+}
+
+// specs returns the addresses as targets. One scanned in full is listed
+// bare to be scanned on every port, and again with its declared ports so
+// those get scanned too when they're outside of --ports.
+func (d discovered) specs() []string {
+	var specs []string
+	for ip, a := range d {
+		if a.full {
+			specs = append(specs, ip)
+		}
+		if len(a.ports) > 0 {
+			ports := make([]string, len(a.ports))
+			for i, p := range a.ports {
+				ports[i] = strconv.Itoa(p)
+			}
+			specs = append(specs, net.JoinHostPort(ip, strings.Join(ports, ",")))
+		}
+	}
+	sort.Strings(specs)
+	return specs
+}
+
+// compare returns the ports of open none of the objects at a declare, and
+// the declared ports that aren't open. Ports open without being declared
+// are only told apart on addresses scanned in full.
+func (a *discoveredAddress) compare(open []int) (undeclared, notListening []int) {
+	if !a.declares {
+		return nil, nil
+	}
+	for _, p := range open {
+		if a.full && !containsInt(a.ports, p) {
+			undeclared = append(undeclared, p)
+		}
+	}
+	for _, p := range a.ports {
+		if !containsInt(open, p) {
+			notListening = append(notListening, p)
+		}
+	}
+	return undeclared, notListening
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/xerrors"
+)
+
+const (
+	defaultDockerHost = "unix:///var/run/docker.sock"
+	dockerTimeout     = 10 * time.Second
+)
+
+// dockerDiscovery is the docker daemon whose running containers get scanned.
+type dockerDiscovery struct {
+	enabled bool
+	host    string
+}
+
+func registerDockerFlags(fl *pflag.FlagSet, d *dockerDiscovery) {
+	fl.BoolVar(&d.enabled, "docker", false, "scan the running containers of the docker daemon on their bridge addresses and the ports they publish on this host, flagging published ports nothing listens on and listening ones that aren't published")
+	fl.StringVar(&d.host, "docker-host", dockerHost(), "docker daemon to list containers from(unix:///path or tcp://host:port without tls)")
+}
+
+// dockerHost is the daemon the docker cli talks to.
+func dockerHost() string {
+	if env := os.Getenv("DOCKER_HOST"); env != "" {
+		return env
+	}
+	return defaultDockerHost
+}
+
+// container is a running container, the part of the daemon's listing we read.
+type container struct {
+	ID    string   `json:"Id"`
+	Names []string `json:"Names"`
+	Ports []struct {
+		// IP is the host address a published port is bound to.
+		IP          string `json:"IP"`
+		PrivatePort int    `json:"PrivatePort"`
+		PublicPort  int    `json:"PublicPort"`
+		Type        string `json:"Type"`
+	} `json:"Ports"`
+	NetworkSettings struct {
+		Networks map[string]struct {
+			IPAddress         string `json:"IPAddress"`
+			GlobalIPv6Address string `json:"GlobalIPv6Address"`
+		} `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+// name is what the container is reported as, e.g. "container/web".
+func (c container) name() string {
+	if len(c.Names) > 0 {
+		return "container/" + strings.TrimPrefix(c.Names[0], "/")
+	}
+	id := c.ID
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return "container/" + id
+}
+
+// discover lists the daemon's running containers.
+func (d *dockerDiscovery) discover(ctx context.Context) ([]container, error) {
+	u, err := url.Parse(d.host)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid --docker-host: %w", err)
+	}
+
+	transport := &http.Transport{}
+	base := "http://docker"
+	switch u.Scheme {
+	case "unix":
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", u.Path)
+		}
+	case "tcp", "http":
+		base = "http://" + u.Host
+	default:
+		return nil, xerrors.Errorf("invalid --docker-host: %q isn't a unix:// or tcp:// address", d.host)
+	}
+	client := &http.Client{Timeout: dockerTimeout, Transport: transport}
+
+	// Leaving the api version out gets us the daemon's own, the listing has
+	// looked the same for long enough.
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/containers/json", nil)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to build request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var daemonErr struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(b, &daemonErr) == nil && daemonErr.Message != "" {
+			return nil, xerrors.Errorf("%s: %s", resp.Status, daemonErr.Message)
+		}
+		return nil, xerrors.Errorf("docker daemon answered %s", resp.Status)
+	}
+
+	var containers []container
+	if err := json.Unmarshal(b, &containers); err != nil {
+		return nil, xerrors.Errorf("failed to decode containers: %w", err)
+	}
+	return containers, nil
+}
+
+// addContainers adds the addresses of containers to d. A container's bridge
+// addresses get scanned in full and declare the ports it publishes over
+// protocol, the host addresses it publishes them on only get scanned on
+// those, everything else listening there isn't the container's. Containers
+// on the host network have neither, their ports are wherever the host's are.
+func addContainers(d discovered, containers []container, protocol string) {
+	for _, c := range containers {
+		var private []int
+		for _, p := range c.Ports {
+			if p.Type != protocol || p.PublicPort == 0 {
+				continue
+			}
+			private = mergeInts(private, []int{p.PrivatePort})
+
+			host := p.IP
+			switch host {
+			case "", "0.0.0.0":
+				host = "127.0.0.1"
+			case "::":
+				host = "::1"
+			}
+			d.add(host, c.name(), []int{p.PublicPort}, false)
+		}
+
+		for _, network := range c.NetworkSettings.Networks {
+			for _, ip := range []string{network.IPAddress, network.GlobalIPv6Address} {
+				if ip != "" {
+					d.add(ip, c.name(), private, true)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/spf13/pflag"
+	"go.coder.com/cli"
+	"golang.org/x/xerrors"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+// A lot of "the scanner says everything is closed" reports come down to the
+// environment rather than the target: a low file-descriptor limit or a broken
+// resolver look exactly like closed ports. doctor checks for those up front.
+type doctorCmd struct {
+	lookupName string
+}
+
+func (cmd *doctorCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:    "doctor",
+		Usage:   "[flags]",
+		Aliases: []string{"selftest"},
+		Desc:    "Check the local environment for common scanning misconfigurations.",
+	}
+}
+
+func (cmd *doctorCmd) RegisterFlags(fl *pflag.FlagSet) {
+	fl.StringVar(&cmd.lookupName, "lookup", "example.com", "hostname to resolve when checking dns")
+}
+
+// A check returns a short description of what it found or an error,
+// in which case the hint is shown to help fix it.
+type check struct {
+	name string
+	hint string
+	run  func(ctx context.Context) (string, error)
+}
+
+func (cmd *doctorCmd) Run(fl *pflag.FlagSet) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	checks := []check{
+		{
+			name: "file descriptors",
+			hint: "raise the limit with ulimit -n or scan with a lower --concurrency",
+			run:  checkFileDescriptors,
+		},
+		{
+			name: "raw sockets",
+			hint: "run as root or grant CAP_NET_RAW",
+			run:  checkRawSockets,
+		},
+		{
+			name: "dns",
+			hint: "check /etc/resolv.conf or try --lookup with a name your resolver should know",
+			run:  cmd.checkDNS,
+		},
+		{
+			name: "loopback scan",
+			hint: "make sure the loopback interface is up and no local firewall drops its traffic",
+			run:  checkLoopbackScan,
+		},
+	}
+
+	var failed int
+	for _, c := range checks {
+		result, err := c.run(ctx)
+		if err != nil {
+			failed++
+			log.Printf("[FAIL] %s: %s(hint: %s)", c.name, err, c.hint)
+			continue
+		}
+		log.Printf("[PASS] %s: %s", c.name, result)
+	}
+
+	if failed > 0 {
+		log.Printf("%d/%d checks failed", failed, len(checks))
+		os.Exit(exitError)
+	}
+	log.Printf("all %d checks passed", len(checks))
+}
+
+func (cmd *doctorCmd) checkDNS(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, scanner.DefaultResolveTimeout)
+	defer cancel()
+
+	start := time.Now()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, cmd.lookupName)
+	if err != nil {
+		return "", xerrors.Errorf("failed to resolve %q: %w", cmd.lookupName, err)
+	}
+	return fmt.Sprintf("resolved %q to %d addresses in %s", cmd.lookupName, len(addrs), time.Since(start)), nil
+}
+
+// checkLoopbackScan starts a listener of our own and makes sure the scanner finds it.
+func checkLoopbackScan(ctx context.Context) (string, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", xerrors.Errorf("failed to start a loopback listener: %w", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	port := ln.Addr().(*net.TCPAddr).Port
+	s, err := scanner.New("127.0.0.1", scanner.Options{Ports: []int{port}})
+	if err != nil {
+		return "", xerrors.Errorf("failed to initialize port scanner: %w", err)
+	}
+
+	res, err := s.Scan(ctx)
+	if err != nil {
+		return "", xerrors.Errorf("failed to scan: %w", err)
+	}
+
+	if len(res.Open()) != 1 {
+		return "", xerrors.Errorf("listener on port %d was not detected", port)
+	}
+	return fmt.Sprintf("detected our own listener on port %d", port), nil
+}
@@ -0,0 +1,39 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+
+	"golang.org/x/xerrors"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+func checkFileDescriptors(context.Context) (string, error) {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return "", xerrors.Errorf("failed to read RLIMIT_NOFILE: %w", err)
+	}
+
+	// Every in-flight dial holds a descriptor, so a limit below the number
+	// of workers turns perfectly good ports into failed dials.
+	if limit.Cur < scanner.DefaultConcurrency {
+		return "", xerrors.Errorf("soft limit is %d, below the %d concurrent dials of a default scan", limit.Cur, scanner.DefaultConcurrency)
+	}
+
+	result := fmt.Sprintf("soft limit is %d(hard limit %d)", limit.Cur, limit.Max)
+	return result, nil
+}
+
+func checkRawSockets(context.Context) (string, error) {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, syscall.IPPROTO_TCP)
+	if err != nil {
+		return "", xerrors.Errorf("failed to open a raw socket: %w", err)
+	}
+	_ = syscall.Close(fd)
+	return "raw sockets are available", nil
+}
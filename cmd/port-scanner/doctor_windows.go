@@ -0,0 +1,12 @@
+package main
+
+import "context"
+
+// Windows doesn't have a per-process descriptor limit worth checking.
+func checkFileDescriptors(context.Context) (string, error) {
+	return "not applicable on windows", nil
+}
+
+func checkRawSockets(context.Context) (string, error) {
+	return "not checked on windows", nil
+}
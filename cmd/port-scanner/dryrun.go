@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+// scanPlan is everything a --dry-run reports about the scan it didn't run.
+type scanPlan struct {
+	targets  []target
+	excluded []string
+	ports    []int
+	// total is how many ports there were before --sample.
+	total   int
+	perHost int
+	proxy   *scanner.Proxy
+}
+
+// printPlan writes out what the scan would do, so the scope can be checked
+// before anything is sent to the targets.
+func (cmd *scanCmd) printPlan(w io.Writer, plan scanPlan) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "dry run, nothing was sent to the targets\n\n")
+
+	fmt.Fprintf(&b, "targets(%d):\n", len(plan.targets))
+	for _, t := range plan.targets {
+		line := "  " + t.host
+		if t.addr() != t.host {
+			line += "(" + t.addr() + ")"
+		}
+		if len(t.aliases) > 0 {
+			line += " also listed as " + strings.Join(t.aliases, ", ")
+		}
+		if t.ports != nil {
+			line += fmt.Sprintf(" on ports(%d): %s", len(t.ports), portRanges(t.ports))
+		}
+		fmt.Fprintln(&b, line)
+	}
+	if len(plan.excluded) > 0 {
+		fmt.Fprintf(&b, "excluded(%d):\n", len(plan.excluded))
+		for _, name := range plan.excluded {
+			fmt.Fprintf(&b, "  %s\n", name)
+		}
+	}
+
+	fmt.Fprintf(&b, "protocol: %s, %s\n", cmd.protocol, cmd.scanType())
+	if len(cmd.decoys) > 0 {
+		fmt.Fprintf(&b, "decoys: %s\n", cmd.decoys)
+	}
+	var header []string
+	if cmd.ipHeader.TTL > 0 {
+		header = append(header, fmt.Sprintf("ttl %d", cmd.ipHeader.TTL))
+	}
+	if cmd.ipHeader.TOS > 0 {
+		header = append(header, fmt.Sprintf("tos %#02x", cmd.ipHeader.TOS))
+	}
+	if cmd.ipHeader.FragmentSize > 0 {
+		header = append(header, fmt.Sprintf("%d byte fragments", cmd.ipHeader.FragmentSize))
+	}
+	if len(header) > 0 {
+		fmt.Fprintf(&b, "ip header: %s\n", strings.Join(header, ", "))
+	}
+	fmt.Fprintf(&b, "ports(%d): %s\n", len(plan.ports), portRanges(plan.ports))
+	if cmd.sample > 0 {
+		fmt.Fprintf(&b, "sample: %d of %d ports(seed %d)\n", len(plan.ports), plan.total, cmd.seed)
+	}
+	if cmd.randomize {
+		fmt.Fprintf(&b, "order: random(seed %d)\n", cmd.seed)
+	}
+
+	timeout := cmd.timeout.String()
+	if cmd.autoTimeout {
+		timeout = fmt.Sprintf("%d round trips to each host, %s to %s", scanner.AutoTimeoutFactor, scanner.MinAutoTimeout, cmd.timeout)
+	}
+	fmt.Fprintf(&b, "timeout: %s, %d retries\n", timeout, cmd.retries)
+	concurrency := fmt.Sprintf("concurrency: %d ports at once", plan.perHost)
+	if cmd.parallelHosts > 1 {
+		concurrency += fmt.Sprintf(" on each of %d hosts at a time, %d in total", cmd.parallelHosts, cmd.concurrency)
+	}
+	if cmd.adaptive {
+		concurrency += "(adaptive, starting lower)"
+	}
+	fmt.Fprintln(&b, concurrency)
+
+	rate := "unlimited"
+	switch {
+	case cmd.maxRate > 0 && cmd.minRate > 0:
+		rate = strconv.FormatFloat(cmd.minRate, 'f', -1, 64) + "-" + strconv.FormatFloat(cmd.maxRate, 'f', -1, 64) + " probes per second across all hosts"
+	case cmd.maxRate > 0:
+		rate = strconv.FormatFloat(cmd.maxRate, 'f', -1, 64) + " probes per second across all hosts"
+	case cmd.minRate > 0:
+		rate = "at least " + strconv.FormatFloat(cmd.minRate, 'f', -1, 64) + " ports per second across all hosts"
+	}
+	fmt.Fprintf(&b, "rate: %s\n", rate)
+	if cmd.jitter != nil {
+		fmt.Fprintf(&b, "jitter: %s-%s between probes across all hosts\n", cmd.jitter.Min, cmd.jitter.Max)
+	}
+	if cmd.maxConnections > 0 {
+		fmt.Fprintf(&b, "connection budget: %d\n", cmd.maxConnections)
+	}
+	if cmd.skipDead {
+		var methods []string
+		if cmd.ping.ICMP {
+			methods = append(methods, "icmp")
+		}
+		if cmd.ping.TCP {
+			methods = append(methods, "tcp")
+		}
+		fmt.Fprintf(&b, "skip dead: ping with %s first\n", strings.Join(methods, " and "))
+	}
+	if cmd.verify {
+		fmt.Fprintf(&b, "verify: open ports and %d closed ones again\n", cmd.verifySample)
+	}
+	if cmd.hostTimeout > 0 {
+		fmt.Fprintf(&b, "host timeout: %s\n", cmd.hostTimeout)
+	}
+	if cmd.maxDuration > 0 {
+		fmt.Fprintf(&b, "max duration: %s\n", cmd.maxDuration)
+	}
+
+	switch {
+	case plan.proxy != nil:
+		fmt.Fprintf(&b, "route: through %s\n", plan.proxy)
+	case cmd.sshJump.enabled():
+		fmt.Fprintf(&b, "route: through ssh bastion %s\n", cmd.sshJump.target)
+	case cmd.agentFlags.enabled():
+		fmt.Fprintf(&b, "route: sharded across agents %s\n", strings.Join(cmd.agentFlags.addrs, ", "))
+	case len(cmd.sourceIPs) > 0:
+		fmt.Fprintf(&b, "route: from %s\n", strings.Join(cmd.sourceIPs, ", "))
+	case cmd.iface != "":
+		fmt.Fprintf(&b, "route: out of %s\n", cmd.iface)
+	}
+	if cmd.fwmark != 0 {
+		fmt.Fprintf(&b, "fwmark: %#x\n", cmd.fwmark)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// scanType describes how the ports would be probed.
+func (cmd *scanCmd) scanType() string {
+	switch {
+	case cmd.stateless:
+		return "stateless syn scan"
+	case cmd.syn:
+		return "syn scan"
+	case cmd.zombie != nil:
+		return "idle scan through " + cmd.zombie.String()
+	case cmd.flagScan != "":
+		return string(cmd.flagScan) + " scan"
+	case cmd.protocol == "sctp":
+		return "sctp init scan"
+	case cmd.protocol == "udp":
+		return "udp probes"
+	}
+	return "connect scan"
+}
+
+// portRanges renders ports the way --ports takes them, e.g. "1-1024,3306,8000-8080".
+func portRanges(ports []int) string {
+	sorted := append([]int(nil), ports...)
+	sort.Ints(sorted)
+
+	var ranges []string
+	for i := 0; i < len(sorted); {
+		j := i
+		for j+1 < len(sorted) && sorted[j+1] == sorted[j]+1 {
+			j++
+		}
+		if j > i {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", sorted[i], sorted[j]))
+		} else {
+			ranges = append(ranges, strconv.Itoa(sorted[i]))
+		}
+		i = j + 1
+	}
+	return strings.Join(ranges, ",")
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/xerrors"
+)
+
+// email sends watch's changes over smtp, for teams that don't run a webhook receiver.
+// Like every other flag its settings can live in the watch section of the config file.
+type email struct {
+	server   string
+	username string
+	password string
+	from     string
+	to       []string
+	timeout  time.Duration
+}
+
+func registerEmailFlags(fl *pflag.FlagSet, e *email) {
+	fl.StringVar(&e.server, "smtp-server", "", "email the changes through this smtp server(e.g. smtp.example.com:587)")
+	fl.StringVar(&e.username, "smtp-user", "", "user to authenticate to the smtp server as(no authentication if not set)")
+	fl.StringVar(&e.password, "smtp-password", os.Getenv("PORT_SCANNER_SMTP_PASSWORD"), "password of --smtp-user(defaults to $PORT_SCANNER_SMTP_PASSWORD)")
+	_ = fl.SetAnnotation("smtp-password", secretAnnotation, []string{"true"})
+	fl.StringVar(&e.from, "email-from", "", "address the emails are sent from(defaults to --smtp-user)")
+	fl.StringSliceVar(&e.to, "email-to", nil, "addresses to email the changes to")
+	fl.DurationVar(&e.timeout, "smtp-timeout", 30*time.Second, "how long to wait on the smtp server for each email")
+}
+
+// enabled reports whether an smtp server was set.
+func (e *email) enabled() bool {
+	return e.server != ""
+}
+
+// validate makes sure there's everything needed to send an email, when one is to be sent.
+func (e *email) validate() error {
+	if !e.enabled() {
+		if len(e.to) > 0 {
+			return xerrors.New("--email-to needs --smtp-server")
+		}
+		return nil
+	}
+
+	if _, _, err := net.SplitHostPort(e.server); err != nil {
+		return xerrors.Errorf("--smtp-server must be host:port: %w", err)
+	}
+	if len(e.to) == 0 {
+		return xerrors.New("--smtp-server needs --email-to")
+	}
+	if e.from == "" {
+		e.from = e.username
+	}
+	if e.from == "" || !strings.Contains(e.from, "@") {
+		return xerrors.New("--email-from must be an email address when --smtp-user isn't one")
+	}
+	return nil
+}
+
+// sendChange emails the ports that opened or closed, open is how many are open now.
+func (e *email) sendChange(ctx context.Context, change portChange, open int) error {
+	key := hostKey(&hostResult{Host: change.Host, IP: change.IP})
+	subject := fmt.Sprintf("%d ports opened and %d closed on %s", len(change.Opened), len(change.Closed), change.Host)
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "The open ports of %s changed at %s:\n\n", key, change.Timestamp.Format(time.RFC1123))
+	for _, name := range portNames(change.Opened) {
+		fmt.Fprintf(&body, "%s: +%s open\n", key, name)
+	}
+	for _, name := range portNames(change.Closed) {
+		fmt.Fprintf(&body, "%s: -%s open\n", key, name)
+	}
+	fmt.Fprintf(&body, "\n%d ports are open now.\n", open)
+	return e.send(ctx, subject, body.String())
+}
+
+// send delivers a plain text email to every --email-to address. It upgrades to tls
+// whenever the server offers STARTTLS, and won't send the password without it.
+func (e *email) send(ctx context.Context, subject, body string) error {
+	host, _, _ := net.SplitHostPort(e.server)
+
+	d := net.Dialer{Timeout: e.timeout}
+	conn, err := d.DialContext(ctx, "tcp", e.server)
+	if err != nil {
+		return xerrors.Errorf("failed to connect to smtp server: %w", err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(e.timeout)); err != nil {
+		return xerrors.Errorf("failed to set deadline: %w", err)
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return xerrors.Errorf("failed to greet smtp server: %w", err)
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return xerrors.Errorf("failed to start tls: %w", err)
+		}
+	}
+	if e.username != "" {
+		// PlainAuth refuses to send the password in the clear, unless the server's on localhost.
+		if err := c.Auth(smtp.PlainAuth("", e.username, e.password, host)); err != nil {
+			return xerrors.Errorf("failed to authenticate: %w", err)
+		}
+	}
+
+	if err := c.Mail(e.from); err != nil {
+		return xerrors.Errorf("server refused sender %s: %w", e.from, err)
+	}
+	for _, to := range e.to {
+		if err := c.Rcpt(to); err != nil {
+			return xerrors.Errorf("server refused recipient %s: %w", to, err)
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return xerrors.Errorf("failed to start the email: %w", err)
+	}
+	if _, err := w.Write(e.message(subject, body)); err != nil {
+		return xerrors.Errorf("failed to send the email: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return xerrors.Errorf("failed to send the email: %w", err)
+	}
+	return c.Quit()
+}
+
+func (e *email) message(subject, body string) []byte {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\n", e.from)
+	fmt.Fprintf(&msg, "To: %s\n", strings.Join(e.to, ", "))
+	fmt.Fprintf(&msg, "Subject: [port-scanner] %s\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\n\n")
+	msg.WriteString(body)
+	return msg.Bytes()
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"log"
+	"os"
+)
+
+// Exit codes let scripts tell apart the different ways a run can end.
+const (
+	// exitError is what log.Fatal exits with for everything not covered below,
+	// a scan that stopped early because of an error exits with it too.
+	exitError = 1
+	// Exit code 2 is taken by the cli package for flags that failed to parse.
+
+	// exitNoResolvableTargets means not a single target resolved to an address,
+	// which is a different problem from targets that resolved and had nothing open.
+	exitNoResolvableTargets = 3
+	// exitOpenPorts means open ports were found and --fail-on-open asked to hear about it,
+	// which turns a scan into a ci check that nothing unexpected is listening.
+	exitOpenPorts = 4
+	// exitPolicyViolations means audit found ports open that its policy doesn't allow.
+	exitPolicyViolations = 5
+	// exitDeadlineExceeded means --max-duration ran out before the scan was done,
+	// so the results are partial the same way they are when interrupted.
+	exitDeadlineExceeded = 6
+	// exitBaselineDrift means the scan found ports that deviate from its --baseline.
+	exitBaselineDrift = 7
+	// exitInterrupted means the run was cut short by SIGINT or SIGTERM,
+	// following the shell convention of 128 plus the signal number of SIGINT.
+	exitInterrupted = 130
+)
+
+// exitf logs the formatted message and exits with code.
+func exitf(code int, format string, v ...interface{}) {
+	log.Printf(format, v...)
+	os.Exit(code)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// runMainEnv has the test binary run main instead of the tests, so a test can
+// run port-scanner in a process of its own and see how it exits.
+const runMainEnv = "PORT_SCANNER_TEST_RUN_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) != "" {
+		// The cli package reads its arguments from os.Args.
+		os.Args = append([]string{"port-scanner"}, strings.Fields(os.Getenv(runMainEnv))...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runPortScanner runs port-scanner with args, away from the user's config
+// and history, and returns its exit code along with what it logged.
+func runPortScanner(t *testing.T, args ...string) (int, string) {
+	t.Helper()
+
+	home := t.TempDir()
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(),
+		runMainEnv+"="+strings.Join(args, " "),
+		"HOME="+home,
+		"XDG_CONFIG_HOME="+home,
+		"XDG_DATA_HOME="+home,
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), stderr.String()
+	}
+	if err != nil {
+		t.Fatalf("failed to run port-scanner: %s", err)
+	}
+	return 0, stderr.String()
+}
+
+func TestScanNoResolvableTargets(t *testing.T) {
+	// .invalid is reserved to never resolve.
+	targets := t.TempDir() + "/targets"
+	if err := ioutil.WriteFile(targets, []byte("nothing-here.invalid\nnor-here.invalid\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	code, logged := runPortScanner(t, "scan", "--targets-file", targets, "--ports", "80", "--no-progress")
+	if code != exitNoResolvableTargets {
+		t.Fatalf("exited with %d, want %d:\n%s", code, exitNoResolvableTargets, logged)
+	}
+	if !strings.Contains(logged, "no resolvable targets out of 2 hosts") {
+		t.Fatalf("didn't log that there were no resolvable targets:\n%s", logged)
+	}
+}
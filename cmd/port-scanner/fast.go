@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+const (
+	fastTimeout     = 500 * time.Millisecond
+	fastTopPorts    = 1000
+	fastConcurrency = 2048
+)
+
+// applyFast tunes the scan for the "I just want quick results" case.
+// Anything set explicitly, on the command line, by a profile or in the
+// config file, wins over the bundle.
+//
+// --fast enables:
+//   - the top 1000 ports instead of the first 1024
+//   - --auto-timeout, waiting a few round trips to each host on its probes, at most 500ms instead of 3s
+//   - up to 2048 ports at once instead of 512, scaled back by --adaptive when the network can't keep up
+//   - no retries
+//   - --skip-dead, leaving out the targets that don't answer pings instead of waiting out their timeouts
+//
+// The parts a scan can't use, like --adaptive for raw scans or pinging
+// through a proxy, are left out of the bundle rather than failing it.
+func (cmd *scanCmd) applyFast(fl *pflag.FlagSet) error {
+	if !cmd.fast {
+		return nil
+	}
+
+	// Targets given with their own ports keep them, the top ports are for the rest.
+	if cmd.protocol == "tcp" && cmd.rescanFrom == "" && cmd.ports == "" && !cmd.shouldScanAll && !fl.Changed("top-ports") {
+		if err := fl.Set("top-ports", strconv.Itoa(fastTopPorts)); err != nil {
+			return err
+		}
+	}
+	if !fl.Changed("timeout") {
+		cmd.timeout = fastTimeout
+	}
+	if !fl.Changed("retries") {
+		cmd.retries = 0
+	}
+	if !fl.Changed("concurrency") {
+		cmd.concurrency = fastConcurrency
+	}
+
+	// The agents scan on their own, and the proxy, bastion or zombie are
+	// in the way of both timing the round trips and pinging.
+	indirect := cmd.proxy != "" || cmd.sshJump.enabled() || cmd.zombieSpec != "" || cmd.agentFlags.enabled()
+	if !fl.Changed("auto-timeout") && !indirect {
+		cmd.autoTimeout = true
+	}
+	if !fl.Changed("skip-dead") && !indirect {
+		cmd.skipDead = true
+	}
+	if !fl.Changed("adaptive") && !cmd.raw() && cmd.protocol != "sctp" && !cmd.agentFlags.enabled() {
+		cmd.adaptive = true
+	}
+	return nil
+}
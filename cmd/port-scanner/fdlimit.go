@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// fdHeadroom is how many descriptors are kept back from the dials for
+// everything else we have open, like output files and the history db.
+const fdHeadroom = 64
+
+// fitFileLimit makes sure the open file limit leaves room for concurrency
+// dials at once, since every one of them holds a descriptor and running out
+// turns perfectly good ports into failed dials. With raise set it tries to
+// lift the soft limit first. It returns how many dials fit, warning when
+// that's fewer than asked for.
+func fitFileLimit(concurrency int, raise bool) int {
+	soft, hard, ok, err := fileLimit()
+	if err != nil {
+		log.Printf("warning: %s, scanning %d ports at once regardless", err, concurrency)
+		return concurrency
+	}
+	want := uint64(concurrency + fdHeadroom)
+	if !ok || soft >= want {
+		return concurrency
+	}
+
+	if raise {
+		if err := raiseFileLimit(want); err != nil {
+			log.Printf("warning: %s", err)
+		}
+		if soft, _, _, err = fileLimit(); err == nil && soft >= want {
+			return concurrency
+		}
+	}
+
+	fit := 1
+	if soft > fdHeadroom+1 {
+		fit = int(soft - fdHeadroom)
+	}
+	hint := "raise it with ulimit -n or --raise-fd-limit"
+	if raise {
+		hint = fmt.Sprintf("the hard limit is %d, raise it as root", hard)
+	}
+	log.Printf("warning: the open file limit of %d only leaves room for %d dials at once, scanning %d ports at once instead of %d(%s)", soft, fit, fit, concurrency, hint)
+	return fit
+}
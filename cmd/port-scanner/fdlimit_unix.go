@@ -0,0 +1,41 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package main
+
+import (
+	"syscall"
+
+	"golang.org/x/xerrors"
+)
+
+// fileLimit returns the soft and hard limits on the descriptors we may have open.
+// ok is false on platforms without such a limit.
+func fileLimit() (soft, hard uint64, ok bool, err error) {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return 0, 0, false, xerrors.Errorf("failed to read RLIMIT_NOFILE: %w", err)
+	}
+	return uint64(limit.Cur), uint64(limit.Max), true, nil
+}
+
+// raiseFileLimit raises the soft limit to want, or as close to it as the hard limit allows.
+func raiseFileLimit(want uint64) error {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return xerrors.Errorf("failed to read RLIMIT_NOFILE: %w", err)
+	}
+
+	if want > uint64(limit.Max) {
+		want = uint64(limit.Max)
+	}
+	if want <= uint64(limit.Cur) {
+		return nil
+	}
+
+	limit.Cur = want
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return xerrors.Errorf("failed to raise RLIMIT_NOFILE: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+	"golang.org/x/xerrors"
+)
+
+// geoRecord holds the fields we read from MaxMind style databases.
+// Country and City databases fill in the country, ASN databases the rest,
+// so lets decode whichever of them a database has into the same record.
+type geoRecord struct {
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	ASN uint   `maxminddb:"autonomous_system_number"`
+	Org string `maxminddb:"autonomous_system_organization"`
+}
+
+// geoResult is where an address is and who announces it.
+type geoResult struct {
+	Country     string `json:"country,omitempty"`
+	CountryName string `json:"country_name,omitempty"`
+	ASN         uint   `json:"asn,omitempty"`
+	Org         string `json:"org,omitempty"`
+}
+
+// geoDBs looks addresses up across every database given with --geoip-db.
+type geoDBs []*maxminddb.Reader
+
+func openGeoDBs(paths []string) (geoDBs, error) {
+	var dbs geoDBs
+	for _, path := range paths {
+		db, err := maxminddb.Open(path)
+		if err != nil {
+			dbs.Close()
+			return nil, xerrors.Errorf("failed to open %q: %w", path, err)
+		}
+		dbs = append(dbs, db)
+	}
+	return dbs, nil
+}
+
+// lookup merges what every database knows about ip, nil when none of them know anything.
+func (dbs geoDBs) lookup(ip net.IP) (*geoResult, error) {
+	var geo geoResult
+	for _, db := range dbs {
+		var rec geoRecord
+		if err := db.Lookup(ip, &rec); err != nil {
+			return nil, xerrors.Errorf("failed to look up %s: %w", ip, err)
+		}
+
+		if rec.Country.ISOCode != "" {
+			geo.Country = rec.Country.ISOCode
+			geo.CountryName = rec.Country.Names["en"]
+		}
+		if rec.ASN != 0 {
+			geo.ASN = rec.ASN
+			geo.Org = rec.Org
+		}
+	}
+
+	if geo == (geoResult{}) {
+		return nil, nil
+	}
+	return &geo, nil
+}
+
+func (dbs geoDBs) Close() {
+	for _, db := range dbs {
+		_ = db.Close()
+	}
+}
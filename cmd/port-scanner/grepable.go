@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// writeGrepable renders rep like nmap -oG, one line per host with its ports as
+// port/state/protocol/owner/service/rpc/version/ so awk and grep pipelines
+// written against nmap keep working. Hosts without a reported port get
+// a status line instead, and comments frame the run like nmap's do.
+func writeGrepable(w io.Writer, rep *report) error {
+	if _, err := fmt.Fprintf(w, "# port-scanner scan initiated %s as: %s\n", rep.Timestamp.Format(time.ANSIC), rep.Invocation); err != nil {
+		return err
+	}
+
+	for _, h := range rep.Hosts {
+		host := fmt.Sprintf("Host: %s (%s)", h.IP, grepableName(h))
+		if len(h.Ports) == 0 {
+			if _, err := fmt.Fprintf(w, "%s\tStatus: Up\n", host); err != nil {
+				return err
+			}
+			continue
+		}
+
+		ports := make([]string, 0, len(h.Ports))
+		for _, p := range h.Ports {
+			service := p.Service
+			if p.GuessedProtocol != "" {
+				service = p.GuessedProtocol
+			}
+			// Slashes and commas would break the fields apart.
+			service = grepableEscaper.Replace(service)
+			var version string
+			if p.Version != nil {
+				version = grepableEscaper.Replace(p.Version.String())
+			}
+			ports = append(ports, fmt.Sprintf("%d/%s/%s//%s//%s/", p.Port, p.State, h.Protocol, service, version))
+		}
+
+		line := fmt.Sprintf("%s\tPorts: %s", host, strings.Join(ports, ", "))
+		// nmap only names one ignored state, the one most of the ports were in.
+		if closed, filtered := h.hidden(); closed >= filtered && closed > 0 {
+			line += fmt.Sprintf("\tIgnored State: closed (%d)", closed+filtered)
+		} else if filtered > 0 {
+			line += fmt.Sprintf("\tIgnored State: filtered (%d)", closed+filtered)
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+
+	end := rep.Timestamp.Add(time.Duration(rep.Duration))
+	_, err := fmt.Fprintf(w, "# port-scanner done at %s -- %d IP addresses (%d hosts up) scanned in %.2f seconds\n",
+		end.Format(time.ANSIC), len(rep.Hosts), len(rep.Hosts), time.Duration(rep.Duration).Seconds())
+	return err
+}
+
+var grepableEscaper = strings.NewReplacer("/", "|", ",", "")
+
+// grepableName is the name nmap puts in parentheses after the address, empty when there is none.
+func grepableName(h *hostResult) string {
+	if h.PTR != "" {
+		return h.PTR
+	}
+	if h.Host != h.IP {
+		return h.Host
+	}
+	return ""
+}
@@ -0,0 +1,517 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	// Registers the sqlite3 driver with database/sql.
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/spf13/pflag"
+	"go.coder.com/cli"
+	"golang.org/x/xerrors"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+// Every recorded scan is kept in a local sqlite database so exposure can be
+// tracked over weeks. Each host of a run gets its own row, with the reported
+// ports stored alongside so they can be queried without parsing the result.
+// Results are stored as gzipped json, which is a fraction of the size for the
+// repetitive results of a monitor. Rows recorded before that hold plain json.
+const historySchema = `
+CREATE TABLE IF NOT EXISTS scans (
+	id            INTEGER PRIMARY KEY AUTOINCREMENT,
+	started_at    TIMESTAMP NOT NULL,
+	duration_ns   INTEGER NOT NULL,
+	invocation    TEXT NOT NULL,
+	host          TEXT NOT NULL,
+	ip            TEXT NOT NULL,
+	protocol      TEXT NOT NULL,
+	scanned_ports INTEGER NOT NULL,
+	result        TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS ports (
+	scan_id INTEGER NOT NULL REFERENCES scans(id) ON DELETE CASCADE,
+	port    INTEGER NOT NULL,
+	state   TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS ports_scan_id ON ports(scan_id);
+`
+
+// defaultHistoryPath follows the XDG base directory spec, falling back to ~/.local/share.
+func defaultHistoryPath() string {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "port-scanner-history.db"
+		}
+		dir = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dir, "port-scanner", "history.db")
+}
+
+func registerHistoryDBFlag(fl *pflag.FlagSet, path *string) {
+	fl.StringVar(path, "history-db", defaultHistoryPath(), "sqlite database scan history is kept in")
+}
+
+// openHistory opens the history database at path, creating it if it doesn't exist yet.
+func openHistory(path string) (*sql.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, xerrors.Errorf("failed to create %q: %w", filepath.Dir(path), err)
+	}
+
+	// Foreign keys are off by default in sqlite, pruning relies on them to cascade.
+	db, err := sql.Open("sqlite3", path+"?_foreign_keys=on")
+	if err != nil {
+		return nil, xerrors.Errorf("failed to open %q: %w", path, err)
+	}
+
+	if _, err := db.Exec(historySchema); err != nil {
+		db.Close()
+		return nil, xerrors.Errorf("failed to create schema: %w", err)
+	}
+	return db, nil
+}
+
+// recordScan stores every host of rep as its own scan, then prunes what keep doesn't keep.
+func recordScan(path string, rep *report, keep historyRetention) error {
+	db, err := openHistory(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return xerrors.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, h := range rep.Hosts {
+		result, err := encodeResult(h)
+		if err != nil {
+			return err
+		}
+
+		res, err := tx.Exec(
+			`INSERT INTO scans(started_at, duration_ns, invocation, host, ip, protocol, scanned_ports, result)
+			VALUES(?, ?, ?, ?, ?, ?, ?, ?)`,
+			h.Timestamp, int64(h.Duration), rep.Invocation, h.Host, h.IP, h.Protocol, h.ScannedPorts, result,
+		)
+		if err != nil {
+			return xerrors.Errorf("failed to insert scan: %w", err)
+		}
+
+		id, err := res.LastInsertId()
+		if err != nil {
+			return xerrors.Errorf("failed to get scan id: %w", err)
+		}
+
+		for _, p := range h.Ports {
+			if _, err := tx.Exec(`INSERT INTO ports(scan_id, port, state) VALUES(?, ?, ?)`, id, p.Port, string(p.State)); err != nil {
+				return xerrors.Errorf("failed to insert port: %w", err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return xerrors.Errorf("failed to commit scan: %w", err)
+	}
+
+	if !keep.enabled() {
+		return nil
+	}
+	_, err = pruneHistory(db, keep)
+	return err
+}
+
+// encodeResult gzips h's json for the result column.
+func encodeResult(h *hostResult) ([]byte, error) {
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	if err := json.NewEncoder(zw).Encode(h); err != nil {
+		return nil, xerrors.Errorf("failed to encode result: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, xerrors.Errorf("failed to compress result: %w", err)
+	}
+	return b.Bytes(), nil
+}
+
+// decodeResult reads a result column, which is plain json for scans recorded before results were gzipped.
+func decodeResult(result []byte) (*hostResult, error) {
+	var r io.Reader = bytes.NewReader(result)
+	if len(result) > 1 && result[0] == 0x1f && result[1] == 0x8b {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to decompress scan: %w", err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	var h hostResult
+	if err := json.NewDecoder(r).Decode(&h); err != nil {
+		return nil, xerrors.Errorf("failed to decode scan: %w", err)
+	}
+	return &h, nil
+}
+
+// lastOpenPorts returns the ports that were open in most of the last n scans
+// recorded of h's host at the same address over the same protocol, the ones the
+// scans don't agree on go to the older state like mergeStates has it. A nil db
+// has no history.
+func lastOpenPorts(db *sql.DB, h *hostResult, n int) (map[int]bool, error) {
+	window, err := recentPortStates(db, h, n)
+	if err != nil {
+		return nil, err
+	}
+
+	open := make(map[int]bool)
+	for port, state := range mergeStates(window) {
+		if state == scanner.StateOpen {
+			open[port] = true
+		}
+	}
+	return open, nil
+}
+
+// recentPortStates returns the states of the reported ports of the last n scans
+// recorded of h's host at the same address over the same protocol, newest first.
+// A nil db has no history.
+func recentPortStates(db *sql.DB, h *hostResult, n int) ([]map[int]scanner.State, error) {
+	if db == nil || n < 1 {
+		return nil, nil
+	}
+
+	rows, err := db.Query(`
+		SELECT s.id FROM scans s
+		WHERE s.host = ? AND s.ip = ? AND s.protocol = ?
+		ORDER BY s.started_at DESC, s.id DESC
+		LIMIT ?`, h.Host, h.IP, h.Protocol, n)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to look up the last scans of %s: %w", h.Host, err)
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, xerrors.Errorf("failed to read scan: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, xerrors.Errorf("failed to look up the last scans of %s: %w", h.Host, err)
+	}
+
+	window := make([]map[int]scanner.State, 0, len(ids))
+	for _, id := range ids {
+		states, err := scanPortStates(db, id)
+		if err != nil {
+			return nil, err
+		}
+		window = append(window, states)
+	}
+	return window, nil
+}
+
+// scanPortStates maps each port recorded for the scan with id to its state.
+func scanPortStates(db *sql.DB, id int64) (map[int]scanner.State, error) {
+	rows, err := db.Query(`SELECT port, state FROM ports WHERE scan_id = ?`, id)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to look up the ports of scan %d: %w", id, err)
+	}
+	defer rows.Close()
+
+	states := make(map[int]scanner.State)
+	for rows.Next() {
+		var (
+			port  int
+			state string
+		)
+		if err := rows.Scan(&port, &state); err != nil {
+			return nil, xerrors.Errorf("failed to read port: %w", err)
+		}
+		states[port] = scanner.State(state)
+	}
+	return states, rows.Err()
+}
+
+// scanSummary is how a recorded scan is listed.
+type scanSummary struct {
+	ID           int64     `json:"id"`
+	StartedAt    time.Time `json:"started_at"`
+	Duration     duration  `json:"duration"`
+	Host         string    `json:"host"`
+	IP           string    `json:"ip"`
+	Protocol     string    `json:"protocol"`
+	ScannedPorts int       `json:"scanned_ports"`
+	OpenPorts    int       `json:"open_ports"`
+}
+
+// listScans returns up to limit of the scans recorded in db, newest first and
+// only those of host when it's set. A limit of 0 or less returns all of them.
+func listScans(db *sql.DB, host string, limit int) ([]scanSummary, error) {
+	if limit <= 0 {
+		limit = -1 // sqlite treats a negative limit as no limit at all
+	}
+
+	rows, err := db.Query(`
+		SELECT s.id, s.started_at, s.duration_ns, s.host, s.ip, s.protocol, s.scanned_ports,
+			(SELECT COUNT(*) FROM ports p WHERE p.scan_id = s.id AND p.state = 'open')
+		FROM scans s
+		WHERE ? = '' OR s.host = ?
+		ORDER BY s.started_at DESC, s.id DESC
+		LIMIT ?`, host, host, limit)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to list scans: %w", err)
+	}
+	defer rows.Close()
+
+	var scans []scanSummary
+	for rows.Next() {
+		var (
+			s          scanSummary
+			durationNS int64
+		)
+		if err := rows.Scan(&s.ID, &s.StartedAt, &durationNS, &s.Host, &s.IP, &s.Protocol, &s.ScannedPorts, &s.OpenPorts); err != nil {
+			return nil, xerrors.Errorf("failed to read scan: %w", err)
+		}
+		s.Duration = duration(durationNS)
+		scans = append(scans, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, xerrors.Errorf("failed to list scans: %w", err)
+	}
+	return scans, nil
+}
+
+// loadScan returns the result recorded as the scan with id, nil if there's no such scan.
+func loadScan(db *sql.DB, id int64) (*hostResult, error) {
+	var result []byte
+	err := db.QueryRow(`SELECT result FROM scans WHERE id = ?`, id).Scan(&result)
+	if xerrors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read scan: %w", err)
+	}
+	return decodeResult(result)
+}
+
+type historyCmd struct{}
+
+func (cmd *historyCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:    "history",
+		Usage:   "[subcommand] [flags]",
+		Aliases: []string{"h"},
+		Desc:    "List, show, import and prune scans recorded with scan --record.",
+	}
+}
+
+func (cmd *historyCmd) Run(fl *pflag.FlagSet) { fl.Usage() }
+
+func (cmd *historyCmd) Subcommands() []cli.Command {
+	return []cli.Command{
+		new(historyListCmd),
+		new(historyShowCmd),
+		new(historyImportCmd),
+		new(historyPruneCmd),
+	}
+}
+
+type historyListCmd struct {
+	db    string
+	host  string
+	limit int
+}
+
+func (cmd *historyListCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:    "list",
+		Usage:   "[flags]",
+		Aliases: []string{"ls"},
+		Desc:    "List recorded scans, newest first.",
+	}
+}
+
+func (cmd *historyListCmd) RegisterFlags(fl *pflag.FlagSet) {
+	registerHistoryDBFlag(fl, &cmd.db)
+	fl.StringVar(&cmd.host, "host", "", "only list scans of this host")
+	fl.IntVar(&cmd.limit, "limit", 20, "how many scans to list(0 lists all of them)")
+}
+
+func (cmd *historyListCmd) Run(fl *pflag.FlagSet) {
+	db, err := openHistory(cmd.db)
+	if err != nil {
+		log.Fatalf("failed to open history: %s", err)
+	}
+	defer db.Close()
+
+	scans, err := listScans(db, cmd.host, cmd.limit)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTARTED\tDURATION\tHOST\tPROTOCOL\tSCANNED\tOPEN")
+	for _, s := range scans {
+		target := s.Host
+		if s.Host != s.IP {
+			target = fmt.Sprintf("%s(%s)", s.Host, s.IP)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%d\t%d\n", s.ID, s.StartedAt.Local().Format(time.RFC3339), time.Duration(s.Duration).Round(time.Millisecond), target, s.Protocol, s.ScannedPorts, s.OpenPorts)
+	}
+	w.Flush()
+}
+
+type historyShowCmd struct {
+	db string
+}
+
+func (cmd *historyShowCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "show",
+		Usage: "[flags] <id>",
+		Desc:  "Print the full result of a recorded scan as json.",
+	}
+}
+
+func (cmd *historyShowCmd) RegisterFlags(fl *pflag.FlagSet) {
+	registerHistoryDBFlag(fl, &cmd.db)
+}
+
+func (cmd *historyShowCmd) Run(fl *pflag.FlagSet) {
+	if fl.NArg() != 1 {
+		fl.Usage()
+		log.Fatal("expected the id of a scan")
+	}
+
+	id, err := strconv.ParseInt(fl.Arg(0), 10, 64)
+	if err != nil {
+		fl.Usage()
+		log.Fatalf("%q is an invalid scan id", fl.Arg(0))
+	}
+
+	db, err := openHistory(cmd.db)
+	if err != nil {
+		log.Fatalf("failed to open history: %s", err)
+	}
+	defer db.Close()
+
+	h, err := loadScan(db, id)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if h == nil {
+		log.Fatalf("there's no scan with id %d", id)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(h); err != nil {
+		log.Fatalf("failed to write json output: %s", err)
+	}
+}
+
+// historyImportCmd records scans that were saved rather than recorded, including
+// ones nmap ran, so the history of a network doesn't start from scratch.
+type historyImportCmd struct {
+	db string
+}
+
+func (cmd *historyImportCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "import",
+		Usage: "[flags] <results.json|nmap.xml>...",
+		Desc:  "Record scans saved with --save or --output json, or nmap's -oX output, as if they were run with --record.",
+	}
+}
+
+func (cmd *historyImportCmd) RegisterFlags(fl *pflag.FlagSet) {
+	registerHistoryDBFlag(fl, &cmd.db)
+}
+
+func (cmd *historyImportCmd) Run(fl *pflag.FlagSet) {
+	if fl.NArg() == 0 {
+		fl.Usage()
+		log.Fatal("expected at least one result file")
+	}
+
+	// Reading every file first means a bad one doesn't leave the rest half imported.
+	reps := make([]*report, fl.NArg())
+	for i, path := range fl.Args() {
+		rep, err := readReport(path)
+		if err != nil {
+			log.Fatalf("failed to read results: %s", err)
+		}
+		reps[i] = rep
+	}
+
+	for i, rep := range reps {
+		if err := recordScan(cmd.db, rep, historyRetention{}); err != nil {
+			log.Fatalf("failed to import %s: %s", fl.Arg(i), err)
+		}
+		log.Printf("imported %d hosts from %s", len(rep.Hosts), fl.Arg(i))
+	}
+}
+
+type historyPruneCmd struct {
+	db        string
+	olderThan time.Duration
+	retention historyRetention
+}
+
+func (cmd *historyPruneCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "prune",
+		Usage: "[flags]",
+		Desc:  "Delete recorded scans older than --keep or past --max-size.",
+	}
+}
+
+func (cmd *historyPruneCmd) RegisterFlags(fl *pflag.FlagSet) {
+	registerHistoryDBFlag(fl, &cmd.db)
+	registerHistoryRetentionFlags(fl, &cmd.retention, "")
+	fl.DurationVar(&cmd.olderThan, "older-than", 0, "same as --keep")
+	_ = fl.MarkDeprecated("older-than", "use --keep instead")
+}
+
+func (cmd *historyPruneCmd) Run(fl *pflag.FlagSet) {
+	if err := cmd.retention.parse(); err != nil {
+		fl.Usage()
+		log.Fatal(err)
+	}
+	if cmd.olderThan > 0 && cmd.retention.keep == 0 {
+		cmd.retention.keep = cmd.olderThan
+	}
+	if !cmd.retention.enabled() {
+		fl.Usage()
+		log.Fatal("--keep or --max-size not provided")
+	}
+
+	db, err := openHistory(cmd.db)
+	if err != nil {
+		log.Fatalf("failed to open history: %s", err)
+	}
+	defer db.Close()
+
+	n, err := pruneHistory(db, cmd.retention)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("pruned %d scans", n)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/xerrors"
+)
+
+// historyRetention is how much of the history gets kept, so a scan recorded
+// every few minutes for months doesn't fill the disk. The zero value keeps everything.
+type historyRetention struct {
+	keepSpec    string
+	maxSizeSpec string
+	keep        time.Duration
+	maxSize     int64
+}
+
+// registerHistoryRetentionFlags registers --keep and --max-size, when says when
+// they're enforced(e.g. " after every recorded scan").
+func registerHistoryRetentionFlags(fl *pflag.FlagSet, r *historyRetention, when string) {
+	fl.StringVar(&r.keepSpec, "keep", "", "delete recorded scans that started longer ago than this"+when+"(e.g. 90d or 36h)")
+	fl.StringVar(&r.maxSizeSpec, "max-size", "", "delete the oldest recorded scans until the history takes up no more than this"+when+"(e.g. 500MB)")
+}
+
+// parse parses the flags registerHistoryRetentionFlags registered.
+func (r *historyRetention) parse() error {
+	var err error
+	if r.keepSpec != "" {
+		if r.keep, err = parseRetentionAge(r.keepSpec); err != nil {
+			return xerrors.Errorf("invalid --keep: %w", err)
+		}
+	}
+	if r.maxSizeSpec != "" {
+		if r.maxSize, err = parseByteSize(r.maxSizeSpec); err != nil {
+			return xerrors.Errorf("invalid --max-size: %w", err)
+		}
+	}
+	return nil
+}
+
+func (r historyRetention) enabled() bool { return r.keep > 0 || r.maxSize > 0 }
+
+// pruneHistory deletes the scans r doesn't keep and returns how many it deleted.
+// Deleted scans leave free pages behind that later ones reuse, the file itself
+// only shrinks when it's vacuumed, which --max-size has us do since it's about the file.
+func pruneHistory(db *sql.DB, r historyRetention) (int64, error) {
+	var pruned int64
+	if r.keep > 0 {
+		res, err := db.Exec(`DELETE FROM scans WHERE started_at < ?`, time.Now().Add(-r.keep).UTC())
+		if err != nil {
+			return 0, xerrors.Errorf("failed to prune scans: %w", err)
+		}
+		if pruned, err = res.RowsAffected(); err != nil {
+			return 0, xerrors.Errorf("failed to count pruned scans: %w", err)
+		}
+	}
+
+	if r.maxSize <= 0 {
+		return pruned, nil
+	}
+
+	var shrunk bool
+	for {
+		used, err := historySize(db)
+		if err != nil {
+			return pruned, err
+		}
+		if used <= r.maxSize {
+			break
+		}
+
+		// A tenth at a time keeps the number of round trips down for big
+		// histories without deleting much more than needed.
+		var total int64
+		if err := db.QueryRow(`SELECT COUNT(*) FROM scans`).Scan(&total); err != nil {
+			return pruned, xerrors.Errorf("failed to count scans: %w", err)
+		}
+		if total == 0 {
+			break
+		}
+		batch := total / 10
+		if batch < 1 {
+			batch = 1
+		}
+
+		res, err := db.Exec(`DELETE FROM scans WHERE id IN (SELECT id FROM scans ORDER BY started_at, id LIMIT ?)`, batch)
+		if err != nil {
+			return pruned, xerrors.Errorf("failed to prune scans: %w", err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return pruned, xerrors.Errorf("failed to count pruned scans: %w", err)
+		}
+		pruned += n
+		shrunk = true
+	}
+
+	if shrunk {
+		if _, err := db.Exec(`VACUUM`); err != nil {
+			return pruned, xerrors.Errorf("failed to vacuum history: %w", err)
+		}
+	}
+	return pruned, nil
+}
+
+// historySize is how many bytes of db are in use, the pages on its freelist don't count.
+func historySize(db *sql.DB) (int64, error) {
+	var size int64
+	err := db.QueryRow(`
+		SELECT (p.page_count - f.freelist_count) * s.page_size
+		FROM pragma_page_count() p, pragma_freelist_count() f, pragma_page_size() s`).Scan(&size)
+	if err != nil {
+		return 0, xerrors.Errorf("failed to measure history: %w", err)
+	}
+	return size, nil
+}
+
+// parseRetentionAge parses a duration that may also be a number of whole
+// days, which is what retention is usually thought of in.
+func parseRetentionAge(s string) (time.Duration, error) {
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, xerrors.Errorf("%q should be a positive number of days", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, xerrors.Errorf("%q should be a positive duration like 90d or 36h", s)
+	}
+	return d, nil
+}
+
+// byteUnits are the units parseByteSize takes, longer suffixes go first so "500MB" isn't taken for 500M bytes.
+var byteUnits = []struct {
+	suffix string
+	n      int64
+}{
+	{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30}, {"tib", 1 << 40},
+	{"kb", 1e3}, {"mb", 1e6}, {"gb", 1e9}, {"tb", 1e12},
+	{"k", 1e3}, {"m", 1e6}, {"g", 1e9}, {"t", 1e12},
+	{"b", 1},
+}
+
+// parseByteSize parses a size like 500MB, units of 1000 and 1024 both work.
+func parseByteSize(s string) (int64, error) {
+	lower := strings.ToLower(strings.TrimSpace(s))
+	unit := int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(lower, u.suffix) {
+			lower, unit = strings.TrimSpace(strings.TrimSuffix(lower, u.suffix)), u.n
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(lower, 64)
+	if err != nil || n <= 0 {
+		return 0, xerrors.Errorf("%q should be a positive size like 500MB or 2GiB", s)
+	}
+	return int64(n * float64(unit)), nil
+}
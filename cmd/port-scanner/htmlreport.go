@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"go.coder.com/cli"
+)
+
+//go:embed htmlreport.html
+var htmlReportTemplate string
+
+// reportCmd renders a saved scan as a single html file for the people who asked
+// for the scan but won't read its json: every host's ports in a table, what
+// changed since a baseline and how many ports each host had open over the scans
+// in the history. Styles and charts are inline, so the file can be mailed around
+// and opened anywhere without anything else.
+type reportCmd struct {
+	in           string
+	out          string
+	baseline     string
+	historyDB    string
+	historyLimit int
+	title        string
+}
+
+func (cmd *reportCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "report",
+		Usage: "--in <results.json|nmap.xml> --out <report.html> [flags]",
+		Desc:  "Render a scan saved with --save or --output json, or nmap's -oX output, as a self-contained html report.",
+	}
+}
+
+func (cmd *reportCmd) RegisterFlags(fl *pflag.FlagSet) {
+	fl.StringVar(&cmd.in, "in", "", "scan to report on")
+	fl.StringVar(&cmd.out, "out", "", "html file to write the report to")
+	fl.StringVar(&cmd.baseline, "baseline", "", "also list the ports that changed since this earlier scan")
+	registerHistoryDBFlag(fl, &cmd.historyDB)
+	fl.IntVar(&cmd.historyLimit, "history-limit", 30, "how many of each host's recorded scans to chart open ports over(0 skips the charts)")
+	fl.StringVar(&cmd.title, "title", "", "heading of the report(defaults to the scan's date)")
+}
+
+func (cmd *reportCmd) Run(fl *pflag.FlagSet) {
+	if cmd.in == "" || cmd.out == "" {
+		fl.Usage()
+		log.Fatal("--in and --out not provided")
+	}
+
+	rep, err := readReport(cmd.in)
+	if err != nil {
+		log.Fatalf("failed to read results: %s", err)
+	}
+
+	var baseline *report
+	if cmd.baseline != "" {
+		if baseline, err = readReport(cmd.baseline); err != nil {
+			log.Fatalf("failed to read --baseline: %s", err)
+		}
+	}
+
+	// A report shouldn't leave an empty history database behind when nothing was ever recorded.
+	var db *sql.DB
+	if _, err := os.Stat(cmd.historyDB); err == nil && cmd.historyLimit > 0 {
+		if db, err = openHistory(cmd.historyDB); err != nil {
+			log.Fatalf("failed to open history: %s", err)
+		}
+		defer db.Close()
+	}
+
+	page, err := newHTMLReport(rep, baseline, db, cmd.historyLimit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	page.Title = cmd.title
+	if page.Title == "" {
+		page.Title = "Port scan of " + page.Generated
+	}
+
+	// Rendering to memory first keeps a failed render from leaving half a report behind.
+	var b bytes.Buffer
+	if err := htmlReport.Execute(&b, page); err != nil {
+		log.Fatalf("failed to render report: %s", err)
+	}
+	if err := ioutil.WriteFile(cmd.out, b.Bytes(), 0644); err != nil {
+		log.Fatalf("failed to write report: %s", err)
+	}
+	log.Printf("wrote a report of %d hosts to %s", len(page.Hosts), cmd.out)
+}
+
+var htmlReport = template.Must(template.New("report").Funcs(template.FuncMap{
+	"timestamp": func(t time.Time) string { return t.Local().Format("2006-01-02 15:04:05") },
+	"join":      strings.Join,
+}).Parse(htmlReportTemplate))
+
+// htmlReportPage is what the report template renders.
+type htmlReportPage struct {
+	Title      string
+	Generated  string
+	Invocation string
+	Hosts      []htmlReportHost
+	// Gone are the hosts only the baseline has, with the ports they no longer report.
+	Gone        []htmlReportHost
+	Interrupted bool
+	Baseline    bool
+	Open        int
+}
+
+type htmlReportHost struct {
+	Key    string
+	Result *hostResult
+	// Open are the ports found open, the rest of Result.Ports are there for --all-states.
+	Open    int
+	Changes []htmlReportChange
+	Chart   *htmlReportChart
+}
+
+// htmlReportChange is a line of diff's output, which is added, removed or changed.
+type htmlReportChange struct {
+	Kind string
+	Text string
+}
+
+func newHTMLReport(rep, baseline *report, db *sql.DB, historyLimit int) (*htmlReportPage, error) {
+	page := &htmlReportPage{
+		Generated:   rep.Timestamp.Local().Format("2006-01-02 15:04"),
+		Invocation:  rep.Invocation,
+		Interrupted: rep.Interrupted,
+		Baseline:    baseline != nil,
+	}
+	if rep.Timestamp.IsZero() {
+		page.Generated = time.Now().Format("2006-01-02 15:04")
+	}
+
+	old := make(map[string]*hostResult)
+	if baseline != nil {
+		for _, h := range baseline.Hosts {
+			old[hostKey(h)] = h
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, h := range rep.Hosts {
+		key := hostKey(h)
+		seen[key] = true
+		host := htmlReportHost{Key: key, Result: h, Open: len(openPorts(h))}
+		page.Open += host.Open
+		if baseline != nil {
+			host.Changes = reportChanges(key, old[key], h)
+		}
+
+		if db != nil {
+			chart, err := openPortsChart(db, h, historyLimit)
+			if err != nil {
+				return nil, err
+			}
+			host.Chart = chart
+		}
+		page.Hosts = append(page.Hosts, host)
+	}
+
+	if baseline != nil {
+		for _, h := range baseline.Hosts {
+			if key := hostKey(h); !seen[key] {
+				seen[key] = true
+				page.Gone = append(page.Gone, htmlReportHost{Key: key, Result: h, Changes: reportChanges(key, h, nil)})
+			}
+		}
+	}
+	return page, nil
+}
+
+// reportChanges is diffHost without the host each line starts with, the report groups them by host already.
+func reportChanges(key string, before, after *hostResult) []htmlReportChange {
+	var changes []htmlReportChange
+	for _, line := range diffHost(key, before, after) {
+		text := strings.TrimPrefix(line, key+": ")
+		kind := map[byte]string{'+': "added", '-': "removed", '~': "changed"}[text[0]]
+		changes = append(changes, htmlReportChange{Kind: kind, Text: text[1:]})
+	}
+	return changes
+}
+
+// htmlReportChart is a line chart of how many ports a host had open over its recorded scans, drawn as svg.
+type htmlReportChart struct {
+	Width, Height int
+	// Line is the polyline's points.
+	Line   string
+	Points []htmlReportPoint
+	Max    int
+	From   string
+	To     string
+}
+
+type htmlReportPoint struct {
+	X, Y  int
+	Label string
+}
+
+const (
+	chartWidth   = 640
+	chartHeight  = 120
+	chartPadding = 10
+)
+
+// openPortsChart charts the last limit recorded scans of h's host at the same
+// address and over the same protocol, nil when there aren't at least two.
+func openPortsChart(db *sql.DB, h *hostResult, limit int) (*htmlReportChart, error) {
+	// listScans only filters by host, the same name may have been scanned at other addresses.
+	all, err := listScans(db, h.Host, 0)
+	if err != nil {
+		return nil, err
+	}
+	var scans []scanSummary
+	for _, s := range all {
+		if s.IP == h.IP && s.Protocol == h.Protocol {
+			scans = append(scans, s)
+		}
+		if len(scans) == limit {
+			break
+		}
+	}
+	if len(scans) < 2 {
+		return nil, nil
+	}
+
+	// Newest first is how they're listed, the chart goes left to right.
+	for i, j := 0, len(scans)-1; i < j; i, j = i+1, j-1 {
+		scans[i], scans[j] = scans[j], scans[i]
+	}
+
+	chart := &htmlReportChart{
+		Width:  chartWidth,
+		Height: chartHeight,
+		From:   scans[0].StartedAt.Local().Format("2006-01-02"),
+		To:     scans[len(scans)-1].StartedAt.Local().Format("2006-01-02"),
+	}
+	for _, s := range scans {
+		if s.OpenPorts > chart.Max {
+			chart.Max = s.OpenPorts
+		}
+	}
+
+	points := make([]string, len(scans))
+	for i, s := range scans {
+		p := htmlReportPoint{
+			X:     chartPadding + i*(chartWidth-2*chartPadding)/(len(scans)-1),
+			Y:     chartHeight - chartPadding,
+			Label: fmt.Sprintf("%s: %d open", s.StartedAt.Local().Format("2006-01-02 15:04"), s.OpenPorts),
+		}
+		if chart.Max > 0 {
+			p.Y -= s.OpenPorts * (chartHeight - 2*chartPadding) / chart.Max
+		}
+		chart.Points = append(chart.Points, p)
+		points[i] = fmt.Sprintf("%d,%d", p.X, p.Y)
+	}
+	chart.Line = strings.Join(points, " ")
+	return chart, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// secretAnnotation marks flags whose values shouldn't end up in reports.
+const secretAnnotation = "secret"
+
+// invocation renders the command as the scanner interpreted it.
+// It's built from the parsed flag set rather than os.Args so defaults
+// are spelled out and the output can be pasted back in to reproduce a scan.
+func invocation(name string, fl *pflag.FlagSet) string {
+	args := []string{name}
+	fl.VisitAll(func(f *pflag.Flag) {
+		_, secret := f.Annotations[secretAnnotation]
+		// Array values are set one flag at a time, commas and all.
+		if array, ok := f.Value.(pflag.SliceValue); ok && f.Value.Type() == "stringArray" && !secret {
+			for _, value := range array.GetSlice() {
+				args = append(args, "--"+f.Name+"="+quoteArg(value))
+			}
+			if len(array.GetSlice()) > 0 {
+				return
+			}
+		}
+
+		value := f.Value.String()
+		// Slice and map values render wrapped in brackets,
+		// which their own Set methods wouldn't accept back.
+		if t := f.Value.Type(); strings.HasSuffix(t, "Slice") || t == "stringToString" || t == "stringArray" {
+			value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+			// Nor an empty value, leaving the flag out sets the same nothing.
+			if value == "" {
+				return
+			}
+		}
+		if secret && value != "" {
+			value = "redacted"
+		}
+		args = append(args, "--"+f.Name+"="+quoteArg(value))
+	})
+	return strings.Join(args, " ")
+}
+
+// quoteArg quotes value when a shell would split or expand it otherwise,
+// like the & and ? of a url.
+func quoteArg(value string) string {
+	if value == "" || strings.ContainsAny(value, " \t\"'&;|<>()*?") {
+		return strconv.Quote(value)
+	}
+	return value
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/xerrors"
+)
+
+func registerStateFileFlag(fl *pflag.FlagSet, path *string) {
+	fl.StringVar(path, "state-file", "", "keep the jobs and their results in this file, so they survive a restart and running ones pick up where they left off(kept in memory only if not set)")
+}
+
+// savedJob is a job as written to --state-file, results included.
+type savedJob struct {
+	job
+	Hosts []*hostResult `json:"hosts,omitempty"`
+}
+
+// restore loads the jobs saved to path by an earlier run and saves them
+// there from then on. Jobs that were running are queued again, and a
+// missing file is a server that hasn't saved anything yet.
+func (q *jobQueue) restore(path string) error {
+	if path == "" {
+		return nil
+	}
+	q.state = path
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return xerrors.Errorf("failed to read %q: %w", path, err)
+	}
+
+	var saved []savedJob
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return xerrors.Errorf("failed to parse %q: %w", path, err)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i := range saved {
+		j := saved[i].job
+		j.hosts = saved[i].Hosts
+		j.changed = make(chan struct{})
+		if j.Status == jobRunning {
+			j.Status = jobQueued
+		}
+		// Followers get the results from before the restart replayed like any others.
+		for _, h := range j.hosts {
+			j.addEvent(scanEvent{Result: h})
+		}
+		q.jobs[j.ID] = &j
+	}
+	return nil
+}
+
+// save writes every job to --state-file next to it before moving it into
+// place, like checkpoints are. Failing to save doesn't stop the jobs, so
+// it's only logged.
+func (q *jobQueue) save() {
+	if q.state == "" {
+		return
+	}
+
+	// Saves are serialized so an older snapshot never replaces a newer one.
+	q.stateMu.Lock()
+	defer q.stateMu.Unlock()
+
+	q.mu.Lock()
+	saved := make([]savedJob, 0, len(q.jobs))
+	for _, j := range q.jobs {
+		saved = append(saved, savedJob{job: *j, Hosts: j.hosts})
+	}
+	b, err := json.MarshalIndent(saved, "", "  ")
+	q.mu.Unlock()
+	if err != nil {
+		log.Printf("failed to encode jobs: %s", err)
+		return
+	}
+
+	tmp := q.state + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		log.Printf("failed to write %q: %s", tmp, err)
+		return
+	}
+	if err := os.Rename(tmp, q.state); err != nil {
+		log.Printf("failed to replace %q: %s", q.state, err)
+	}
+}
@@ -0,0 +1,513 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// Kafka's api keys and the versions of them we speak, old enough for
+// every broker since 1.0 and new enough for those that dropped the oldest.
+const (
+	kafkaProduce         = 0
+	kafkaProduceVersion  = 3
+	kafkaMetadata        = 3
+	kafkaMetadataVersion = 4
+)
+
+// Error codes a broker answers with that clear up on their own after a moment.
+const (
+	kafkaLeaderNotAvailable      = 5
+	kafkaNotLeaderForPartition   = 6
+	kafkaUnknownTopicOrPartition = 3
+)
+
+// kafkaPublisher produces to a Kafka topic. Like the NATS one it speaks the
+// protocol itself, just enough of it to find the partitions' leaders and produce
+// a record batch to each. Records are spread across the partitions by their
+// key like the java client does, so the results of a host stay in order.
+type kafkaPublisher struct {
+	brokers []string
+	topic   string
+}
+
+func newKafkaPublisher(u *url.URL, topic string) (*kafkaPublisher, error) {
+	if len(topic) > 249 || strings.Trim(topic, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789._-") != "" {
+		return nil, xerrors.Errorf("%q is an invalid kafka topic", topic)
+	}
+
+	p := &kafkaPublisher{topic: topic}
+	for _, broker := range strings.Split(u.Host, ",") {
+		if _, _, err := net.SplitHostPort(broker); err != nil {
+			broker = net.JoinHostPort(broker, "9092")
+		}
+		p.brokers = append(p.brokers, broker)
+	}
+	return p, nil
+}
+
+func (p *kafkaPublisher) kind() string { return "kafka" }
+
+func (p *kafkaPublisher) publish(ctx context.Context, msgs []message) error {
+	// A topic that's just been created automatically has no leaders for a moment.
+	var err error
+	pending := msgs
+	for try := 0; try < 5; try++ {
+		if try > 0 {
+			select {
+			case <-time.After(time.Duration(try) * 200 * time.Millisecond):
+			case <-ctx.Done():
+				return err
+			}
+		}
+
+		var retry bool
+		if pending, retry, err = p.produce(ctx, pending); !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// produce sends every message to the leader of the partition its key maps to,
+// reporting whether an error is worth retrying over. It returns the messages
+// of the partitions no leader acknowledged, so retrying them doesn't produce
+// the rest twice. Only a request whose response got lost is sent again whole.
+func (p *kafkaPublisher) produce(ctx context.Context, msgs []message) ([]message, bool, error) {
+	leaders, addrs, err := p.metadata(ctx)
+	if err != nil {
+		return msgs, true, err
+	}
+
+	// Records go out to each leader in one request, a batch per partition.
+	batches := make(map[int32]map[int32][]message)
+	for _, m := range msgs {
+		partition := int32(kafkaPartition([]byte(m.key), len(leaders)))
+		leader := leaders[partition]
+		if batches[leader] == nil {
+			batches[leader] = make(map[int32][]message)
+		}
+		batches[leader][partition] = append(batches[leader][partition], m)
+	}
+
+	acked := make(map[int32]bool)
+	unacked := func() []message {
+		var left []message
+		for _, partitions := range batches {
+			for partition, msgs := range partitions {
+				if !acked[partition] {
+					left = append(left, msgs...)
+				}
+			}
+		}
+		return left
+	}
+
+	for leader, partitions := range batches {
+		addr, ok := addrs[leader]
+		if !ok {
+			return unacked(), true, xerrors.Errorf("the leader of %s, broker %d, isn't one the cluster listed", p.topic, leader)
+		}
+
+		conn, err := dialKafka(ctx, addr)
+		if err != nil {
+			return unacked(), true, err
+		}
+		retry, err := p.produceTo(conn, partitions, acked)
+		conn.Close()
+		if err != nil {
+			return unacked(), retry, err
+		}
+	}
+	return nil, false, nil
+}
+
+// metadata asks the brokers of the url, the first one that answers, for the
+// leader of every partition of the topic and the address of every broker.
+func (p *kafkaPublisher) metadata(ctx context.Context) ([]int32, map[int32]string, error) {
+	var req kafkaEncoder
+	req.int32(1)
+	req.string(p.topic)
+	req.bool(true) // create the topic if the brokers are allowed to
+
+	var lastErr error
+	for _, broker := range p.brokers {
+		conn, err := dialKafka(ctx, broker)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp, err := kafkaRoundTrip(conn, kafkaMetadata, kafkaMetadataVersion, req.Bytes())
+		conn.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return p.parseMetadata(resp)
+	}
+	return nil, nil, lastErr
+}
+
+func (p *kafkaPublisher) parseMetadata(resp []byte) ([]int32, map[int32]string, error) {
+	d := kafkaDecoder{b: resp}
+	d.int32() // throttle time
+	addrs := make(map[int32]string)
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		id := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.string() // rack
+		addrs[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.string() // cluster id
+	d.int32()  // controller
+
+	var leaders []int32
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		code := d.int16()
+		name := d.string()
+		d.bool() // internal
+		partitions := d.int32()
+		if name == p.topic && code != 0 {
+			return nil, nil, kafkaError(code)
+		}
+
+		if partitions > 0 {
+			leaders = make([]int32, partitions)
+		}
+		for ; partitions > 0 && d.err == nil; partitions-- {
+			d.int16() // error code
+			index := d.int32()
+			leader := d.int32()
+			d.int32Array() // replicas
+			d.int32Array() // in sync replicas
+			if name != p.topic {
+				continue
+			}
+			// Errors about the replicas don't keep the leader from taking records.
+			if leader < 0 {
+				return nil, nil, xerrors.Errorf("partition %d: %w", index, kafkaError(kafkaLeaderNotAvailable))
+			}
+			if index < 0 || int(index) >= len(leaders) {
+				return nil, nil, xerrors.Errorf("partition %d of %s is out of range", index, p.topic)
+			}
+			leaders[index] = leader
+		}
+	}
+	if d.err != nil {
+		return nil, nil, xerrors.Errorf("failed to parse metadata: %w", d.err)
+	}
+	if len(leaders) == 0 {
+		return nil, nil, xerrors.Errorf("%s has no partitions", p.topic)
+	}
+	return leaders, addrs, nil
+}
+
+// produceTo sends partitions to the broker they're led by, waiting on the leader
+// alone to accept them, and marks the ones it accepted in acked.
+func (p *kafkaPublisher) produceTo(conn net.Conn, partitions map[int32][]message, acked map[int32]bool) (bool, error) {
+	var req kafkaEncoder
+	req.int16(-1) // no transactional id
+	req.int16(1)  // acks
+	req.int32(int32(publishTimeout / time.Millisecond))
+	req.int32(1)
+	req.string(p.topic)
+	req.int32(int32(len(partitions)))
+	for partition, msgs := range partitions {
+		req.int32(partition)
+		req.bytes(recordBatch(msgs, time.Now()))
+	}
+
+	resp, err := kafkaRoundTrip(conn, kafkaProduce, kafkaProduceVersion, req.Bytes())
+	if err != nil {
+		return true, err
+	}
+
+	// Every partition answers for itself, the ones that took their records are done whatever the others say.
+	var (
+		retry    bool
+		firstErr error
+	)
+	d := kafkaDecoder{b: resp}
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		d.string() // topic
+		for partitions := d.int32(); partitions > 0 && d.err == nil; partitions-- {
+			index := d.int32()
+			code := d.int16()
+			d.int64() // base offset
+			d.int64() // log append time
+			if d.err != nil {
+				break
+			}
+			if code == 0 {
+				acked[index] = true
+				continue
+			}
+			if firstErr == nil {
+				retry = code == kafkaNotLeaderForPartition || code == kafkaLeaderNotAvailable || code == kafkaUnknownTopicOrPartition
+				firstErr = xerrors.Errorf("partition %d: %w", index, kafkaError(code))
+			}
+		}
+	}
+	if d.err != nil {
+		return true, xerrors.Errorf("failed to parse produce response: %w", d.err)
+	}
+	return retry, firstErr
+}
+
+func kafkaError(code int16) error {
+	switch code {
+	case kafkaUnknownTopicOrPartition:
+		return xerrors.New("the topic doesn't exist and the brokers don't create topics automatically")
+	case kafkaLeaderNotAvailable, kafkaNotLeaderForPartition:
+		return xerrors.New("the partition has no leader right now")
+	}
+	return xerrors.Errorf("broker answered with error code %d", code)
+}
+
+func dialKafka(ctx context.Context, addr string) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to connect to %s: %w", addr, err)
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(publishTimeout)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return nil, xerrors.Errorf("failed to set deadline: %w", err)
+	}
+	return conn, nil
+}
+
+// kafkaRoundTrip sends a request and returns the body of its response. We only
+// ever have one request in flight per connection, so there's no need to match
+// up correlation ids beyond checking them.
+func kafkaRoundTrip(conn net.Conn, apiKey, version int16, body []byte) ([]byte, error) {
+	const correlationID = 1
+
+	var req kafkaEncoder
+	req.int32(0) // size, filled in below
+	req.int16(apiKey)
+	req.int16(version)
+	req.int32(correlationID)
+	req.string("port-scanner")
+	req.Write(body)
+
+	b := req.Bytes()
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+	if _, err := conn.Write(b); err != nil {
+		return nil, xerrors.Errorf("failed to send request: %w", err)
+	}
+
+	var size [4]byte
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return nil, xerrors.Errorf("failed to read response: %w", err)
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n < 4 || n > 16<<20 {
+		return nil, xerrors.Errorf("broker sent a %d byte response", n)
+	}
+
+	resp := make([]byte, n)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, xerrors.Errorf("failed to read response: %w", err)
+	}
+	if id := binary.BigEndian.Uint32(resp); id != correlationID {
+		return nil, xerrors.Errorf("broker answered request %d, we sent %d", id, correlationID)
+	}
+	return resp[4:], nil
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// recordBatch encodes msgs as a v2 record batch, the format of messages since kafka 0.11.
+func recordBatch(msgs []message, now time.Time) []byte {
+	ts := now.UnixNano() / int64(time.Millisecond)
+
+	var records kafkaEncoder
+	for i, m := range msgs {
+		var r kafkaEncoder
+		r.WriteByte(0) // attributes
+		r.varint(0)    // timestamp delta
+		r.varint(int64(i))
+		r.varint(int64(len(m.key)))
+		r.WriteString(m.key)
+		r.varint(int64(len(m.value)))
+		r.Write(m.value)
+		r.varint(0) // headers
+
+		records.varint(int64(r.Len()))
+		records.Write(r.Bytes())
+	}
+
+	// Everything after the crc is what it covers.
+	var tail kafkaEncoder
+	tail.int16(0) // attributes, uncompressed
+	tail.int32(int32(len(msgs) - 1))
+	tail.int64(ts)
+	tail.int64(ts)
+	tail.int64(-1) // producer id
+	tail.int16(-1) // producer epoch
+	tail.int32(-1) // base sequence
+	tail.int32(int32(len(msgs)))
+	tail.Write(records.Bytes())
+
+	var batch kafkaEncoder
+	batch.int64(0) // base offset, assigned by the broker
+	batch.int32(int32(4 + 1 + 4 + tail.Len()))
+	batch.int32(-1) // partition leader epoch
+	batch.WriteByte(2)
+	batch.int32(int32(crc32.Checksum(tail.Bytes(), castagnoli)))
+	batch.Write(tail.Bytes())
+	return batch.Bytes()
+}
+
+// kafkaPartition picks the partition of key the way the java client's default
+// partitioner does, so consumers see the same spread whichever client produced.
+func kafkaPartition(key []byte, partitions int) int {
+	return int(murmur2(key)&0x7fffffff) % partitions
+}
+
+// murmur2 is the variant of MurmurHash2 kafka partitions with.
+func murmur2(data []byte) uint32 {
+	const (
+		seed = 0x9747b28c
+		m    = 0x5bd1e995
+		r    = 24
+	)
+
+	h := uint32(seed) ^ uint32(len(data))
+	for len(data) >= 4 {
+		k := binary.LittleEndian.Uint32(data)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+		data = data[4:]
+	}
+
+	switch len(data) {
+	case 3:
+		h ^= uint32(data[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[0])
+		h *= m
+	}
+
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
+
+// kafkaEncoder writes kafka's big endian primitives.
+type kafkaEncoder struct {
+	bytes.Buffer
+}
+
+func (e *kafkaEncoder) int16(v int16) { binary.Write(e, binary.BigEndian, v) }
+func (e *kafkaEncoder) int32(v int32) { binary.Write(e, binary.BigEndian, v) }
+func (e *kafkaEncoder) int64(v int64) { binary.Write(e, binary.BigEndian, v) }
+
+func (e *kafkaEncoder) bool(v bool) {
+	if v {
+		e.WriteByte(1)
+	} else {
+		e.WriteByte(0)
+	}
+}
+
+func (e *kafkaEncoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.WriteString(s)
+}
+
+func (e *kafkaEncoder) bytes(b []byte) {
+	e.int32(int32(len(b)))
+	e.Write(b)
+}
+
+// varint writes v zigzag encoded like protobuf does, which is how records encode their fields.
+func (e *kafkaEncoder) varint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	e.Write(b[:binary.PutVarint(b[:], v)])
+}
+
+// kafkaDecoder reads kafka's big endian primitives, the first error sticks
+// and every read after it returns zero values.
+type kafkaDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *kafkaDecoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.b) < n {
+		d.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *kafkaDecoder) bool() bool {
+	b := d.take(1)
+	return b != nil && b[0] != 0
+}
+
+func (d *kafkaDecoder) int16() int16 {
+	if b := d.take(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int32() int32 {
+	if b := d.take(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int64() int64 {
+	if b := d.take(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+// string reads a nullable string, null reads as empty.
+func (d *kafkaDecoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.take(int(n)))
+}
+
+func (d *kafkaDecoder) int32Array() {
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		d.int32()
+	}
+}
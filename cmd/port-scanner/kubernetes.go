@@ -0,0 +1,513 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v3"
+)
+
+const k8sTimeout = 30 * time.Second
+
+// k8sAllNamespaces is the --k8s-namespace that lists every namespace.
+const k8sAllNamespaces = "*"
+
+// k8sDiscovery is which Services and Pods of a cluster to scan, found
+// through the API server of a kubeconfig context the way kubectl would.
+type k8sDiscovery struct {
+	kubeconfig string
+	context    string
+	namespace  string
+	selector   string
+}
+
+func registerK8sFlags(fl *pflag.FlagSet, k *k8sDiscovery) {
+	fl.StringVar(&k.namespace, "k8s-namespace", "", "scan the Service and Pod ips of this kubernetes namespace on --ports and the ports they declare, flagging open ports none of them declares(* for every namespace)")
+	fl.StringVar(&k.selector, "k8s-selector", "", "only scan the Services and Pods matching this label selector(e.g. app=web,tier!=cache, the context's namespace if --k8s-namespace isn't set)")
+	fl.StringVar(&k.kubeconfig, "kubeconfig", defaultKubeconfig(), "kubeconfig to reach the cluster with")
+	fl.StringVar(&k.context, "k8s-context", "", "kubeconfig context to use(defaults to its current-context)")
+}
+
+// defaultKubeconfig is where kubectl looks. KUBECONFIG can list several files
+// kubectl merges, we only read the first.
+func defaultKubeconfig() string {
+	if env := os.Getenv("KUBECONFIG"); env != "" {
+		return filepath.SplitList(env)[0]
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".kube", "config")
+}
+
+// enabled reports whether any kubernetes discovery was asked for.
+func (k *k8sDiscovery) enabled() bool {
+	return k.namespace != "" || k.selector != ""
+}
+
+// k8sObject is a Service or Pod we found, along with the ports it declares.
+type k8sObject struct {
+	// Kind is "service" or "pod".
+	Kind      string
+	Namespace string
+	Name      string
+	IPs       []string
+	// Ports are the declared ports by protocol, lowercased like --protocol.
+	Ports map[string][]int
+}
+
+func (o k8sObject) String() string {
+	return o.Kind + "/" + o.Namespace + "/" + o.Name
+}
+
+// kubeconfig holds the fields of a kubeconfig we need to reach its clusters.
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+			TLSServerName            string `yaml:"tls-server-name"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string         `yaml:"name"`
+		User kubeconfigUser `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster   string `yaml:"cluster"`
+			User      string `yaml:"user"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+type kubeconfigUser struct {
+	Token                 string `yaml:"token"`
+	TokenFile             string `yaml:"tokenFile"`
+	ClientCertificate     string `yaml:"client-certificate"`
+	ClientCertificateData string `yaml:"client-certificate-data"`
+	ClientKey             string `yaml:"client-key"`
+	ClientKeyData         string `yaml:"client-key-data"`
+	Username              string `yaml:"username"`
+	Password              string `yaml:"password"`
+	// Exec is a credential plugin, how most managed clusters hand out tokens.
+	Exec *struct {
+		APIVersion string   `yaml:"apiVersion"`
+		Command    string   `yaml:"command"`
+		Args       []string `yaml:"args"`
+		Env        []struct {
+			Name  string `yaml:"name"`
+			Value string `yaml:"value"`
+		} `yaml:"env"`
+	} `yaml:"exec"`
+}
+
+// k8sClient talks to the API server of a kubeconfig context.
+type k8sClient struct {
+	server string
+	client *http.Client
+	// namespace is the context's namespace, "default" when it has none.
+	namespace      string
+	token          string
+	username, pass string
+}
+
+// client sets up a client for the context k asks for, reading credentials
+// the kubeconfig points at relative to where it lives.
+func (k *k8sDiscovery) client(ctx context.Context) (*k8sClient, error) {
+	b, err := ioutil.ReadFile(k.kubeconfig)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read kubeconfig: %w", err)
+	}
+	var cfg kubeconfig
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return nil, xerrors.Errorf("failed to parse %q: %w", k.kubeconfig, err)
+	}
+	dir := filepath.Dir(k.kubeconfig)
+
+	name := k.context
+	if name == "" {
+		name = cfg.CurrentContext
+	}
+	if name == "" {
+		return nil, xerrors.Errorf("%q has no current-context(pick one with --k8s-context)", k.kubeconfig)
+	}
+
+	c := &k8sClient{namespace: "default"}
+	var clusterName, userName string
+	found := false
+	for _, kc := range cfg.Contexts {
+		if kc.Name == name {
+			clusterName, userName, found = kc.Context.Cluster, kc.Context.User, true
+			if kc.Context.Namespace != "" {
+				c.namespace = kc.Context.Namespace
+			}
+		}
+	}
+	if !found {
+		return nil, xerrors.Errorf("%q has no context named %q", k.kubeconfig, name)
+	}
+
+	tlsConfig := &tls.Config{}
+	found = false
+	for _, cluster := range cfg.Clusters {
+		if cluster.Name != clusterName {
+			continue
+		}
+		found = true
+		c.server = strings.TrimSuffix(cluster.Cluster.Server, "/")
+		tlsConfig.InsecureSkipVerify = cluster.Cluster.InsecureSkipTLSVerify
+		tlsConfig.ServerName = cluster.Cluster.TLSServerName
+		ca, err := kubeconfigBytes(dir, cluster.Cluster.CertificateAuthority, cluster.Cluster.CertificateAuthorityData)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to read the certificate authority of cluster %q: %w", clusterName, err)
+		}
+		if ca != nil {
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+				return nil, xerrors.Errorf("the certificate authority of cluster %q has no pem certificates", clusterName)
+			}
+		}
+	}
+	if !found || c.server == "" {
+		return nil, xerrors.Errorf("%q has no server for cluster %q", k.kubeconfig, clusterName)
+	}
+
+	for _, u := range cfg.Users {
+		if u.Name != userName {
+			continue
+		}
+		if err := c.authenticate(ctx, dir, u.User, tlsConfig); err != nil {
+			return nil, xerrors.Errorf("failed to load the credentials of user %q: %w", userName, err)
+		}
+	}
+
+	c.client = &http.Client{
+		Timeout:   k8sTimeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+	}
+	return c, nil
+}
+
+// authenticate picks up whichever of a user's credentials it has.
+func (c *k8sClient) authenticate(ctx context.Context, dir string, u kubeconfigUser, tlsConfig *tls.Config) error {
+	cert, err := kubeconfigBytes(dir, u.ClientCertificate, u.ClientCertificateData)
+	if err != nil {
+		return err
+	}
+	key, err := kubeconfigBytes(dir, u.ClientKey, u.ClientKeyData)
+	if err != nil {
+		return err
+	}
+
+	c.token, c.username, c.pass = u.Token, u.Username, u.Password
+	if u.TokenFile != "" {
+		b, err := ioutil.ReadFile(kubeconfigPath(dir, u.TokenFile))
+		if err != nil {
+			return xerrors.Errorf("failed to read token file: %w", err)
+		}
+		c.token = strings.TrimSpace(string(b))
+	}
+
+	if u.Exec != nil {
+		cred, err := runCredentialPlugin(ctx, dir, u)
+		if err != nil {
+			return err
+		}
+		c.token = cred.Status.Token
+		if cred.Status.ClientCertificateData != "" {
+			cert, key = []byte(cred.Status.ClientCertificateData), []byte(cred.Status.ClientKeyData)
+		}
+	}
+
+	if cert != nil {
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return xerrors.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{pair}
+	}
+	return nil
+}
+
+// execCredential is what a credential plugin prints.
+type execCredential struct {
+	Status struct {
+		Token                 string `json:"token"`
+		ClientCertificateData string `json:"clientCertificateData"`
+		ClientKeyData         string `json:"clientKeyData"`
+	} `json:"status"`
+}
+
+func runCredentialPlugin(ctx context.Context, dir string, u kubeconfigUser) (*execCredential, error) {
+	// Like kubectl, a command with a path in it is relative to the kubeconfig
+	// and a bare name is looked up in PATH.
+	command := u.Exec.Command
+	if strings.ContainsRune(command, filepath.Separator) {
+		command = kubeconfigPath(dir, command)
+	}
+	plugin := exec.CommandContext(ctx, command, u.Exec.Args...)
+	plugin.Env = os.Environ()
+	for _, e := range u.Exec.Env {
+		plugin.Env = append(plugin.Env, e.Name+"="+e.Value)
+	}
+	// Plugins read what they're being run for from here, we never prompt.
+	info, _ := json.Marshal(map[string]interface{}{
+		"apiVersion": u.Exec.APIVersion,
+		"kind":       "ExecCredential",
+		"spec":       map[string]bool{"interactive": false},
+	})
+	plugin.Env = append(plugin.Env, "KUBERNETES_EXEC_INFO="+string(info))
+	var stderr bytes.Buffer
+	plugin.Stderr = &stderr
+
+	out, err := plugin.Output()
+	if err != nil {
+		return nil, xerrors.Errorf("credential plugin %s failed: %w(%s)", u.Exec.Command, err, strings.TrimSpace(stderr.String()))
+	}
+	var cred execCredential
+	if err := json.Unmarshal(out, &cred); err != nil {
+		return nil, xerrors.Errorf("failed to decode the credential plugin's output: %w", err)
+	}
+	return &cred, nil
+}
+
+// kubeconfigBytes returns inline base64 data if there is any, otherwise the
+// contents of the file at path. Both being empty is fine, it returns nil.
+func kubeconfigBytes(dir, path, data string) ([]byte, error) {
+	if data != "" {
+		b, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to decode inline data: %w", err)
+		}
+		return b, nil
+	}
+	if path == "" {
+		return nil, nil
+	}
+	return ioutil.ReadFile(kubeconfigPath(dir, path))
+}
+
+// kubeconfigPath resolves path the way kubectl does, relative to the kubeconfig.
+func kubeconfigPath(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// k8sList is the part of a ServiceList or PodList we read.
+type k8sList struct {
+	Metadata struct {
+		Continue string `json:"continue"`
+	} `json:"metadata"`
+	Items []k8sItem `json:"items"`
+}
+
+// k8sItem is a Service or a Pod, only one of them fills each field.
+type k8sItem struct {
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+	Spec struct {
+		ClusterIP  string    `json:"clusterIP"`
+		ClusterIPs []string  `json:"clusterIPs"`
+		Ports      []k8sPort `json:"ports"`
+		Containers []struct {
+			Ports []struct {
+				ContainerPort int    `json:"containerPort"`
+				Protocol      string `json:"protocol"`
+			} `json:"ports"`
+		} `json:"containers"`
+	} `json:"spec"`
+	Status struct {
+		Phase  string `json:"phase"`
+		PodIPs []struct {
+			IP string `json:"ip"`
+		} `json:"podIPs"`
+		PodIP string `json:"podIP"`
+	} `json:"status"`
+}
+
+type k8sPort struct {
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+}
+
+// discover lists the Services with a cluster ip and the running Pods k
+// asks for.
+func (k *k8sDiscovery) discover(ctx context.Context) ([]k8sObject, error) {
+	c, err := k.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace := k.namespace
+	if namespace == "" {
+		namespace = c.namespace
+	}
+
+	var objects []k8sObject
+	services, err := c.list(ctx, namespace, "services", k.selector)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range services {
+		ips := item.Spec.ClusterIPs
+		if len(ips) == 0 && item.Spec.ClusterIP != "" {
+			ips = []string{item.Spec.ClusterIP}
+		}
+		o := k8sObject{Kind: "service", Namespace: item.Metadata.Namespace, Name: item.Metadata.Name, Ports: make(map[string][]int)}
+		for _, ip := range ips {
+			// Headless services don't have an address of their own, their pods do.
+			if net.ParseIP(ip) != nil {
+				o.IPs = append(o.IPs, ip)
+			}
+		}
+		for _, p := range item.Spec.Ports {
+			o.addPort(p.Protocol, p.Port)
+		}
+		if len(o.IPs) > 0 {
+			objects = append(objects, o)
+		}
+	}
+
+	pods, err := c.list(ctx, namespace, "pods", k.selector)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range pods {
+		if item.Status.Phase != "Running" {
+			continue
+		}
+		o := k8sObject{Kind: "pod", Namespace: item.Metadata.Namespace, Name: item.Metadata.Name, Ports: make(map[string][]int)}
+		for _, ip := range item.Status.PodIPs {
+			o.IPs = append(o.IPs, ip.IP)
+		}
+		if len(o.IPs) == 0 && item.Status.PodIP != "" {
+			o.IPs = []string{item.Status.PodIP}
+		}
+		for _, container := range item.Spec.Containers {
+			for _, p := range container.Ports {
+				o.addPort(p.Protocol, p.ContainerPort)
+			}
+		}
+		if len(o.IPs) > 0 {
+			objects = append(objects, o)
+		}
+	}
+	return objects, nil
+}
+
+// addPort records a declared port, kubernetes leaves the protocol out when it's tcp.
+func (o *k8sObject) addPort(protocol string, port int) {
+	proto := strings.ToLower(protocol)
+	if proto == "" {
+		proto = "tcp"
+	}
+	if port > 0 {
+		o.Ports[proto] = append(o.Ports[proto], port)
+	}
+}
+
+// list returns every item of resource in namespace matching selector,
+// following the API server's pages.
+func (c *k8sClient) list(ctx context.Context, namespace, resource, selector string) ([]k8sItem, error) {
+	path := "/api/v1/namespaces/" + url.PathEscape(namespace) + "/" + resource
+	if namespace == k8sAllNamespaces {
+		path = "/api/v1/" + resource
+	}
+
+	var items []k8sItem
+	next := ""
+	for {
+		query := url.Values{"limit": {"500"}}
+		if selector != "" {
+			query.Set("labelSelector", selector)
+		}
+		if next != "" {
+			query.Set("continue", next)
+		}
+
+		var page k8sList
+		if err := c.get(ctx, path+"?"+query.Encode(), &page); err != nil {
+			return nil, xerrors.Errorf("failed to list %s: %w", resource, err)
+		}
+		items = append(items, page.Items...)
+		if next = page.Metadata.Continue; next == "" {
+			return items, nil
+		}
+	}
+}
+
+func (c *k8sClient) get(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.server+path, nil)
+	if err != nil {
+		return xerrors.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	switch {
+	case c.token != "":
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	case c.username != "":
+		req.SetBasicAuth(c.username, c.pass)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return xerrors.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		// The API server explains itself in a Status object.
+		var status struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(b, &status) == nil && status.Message != "" {
+			return xerrors.Errorf("%s: %s", resp.Status, status.Message)
+		}
+		return xerrors.Errorf("api server answered %s", resp.Status)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return xerrors.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// addK8sObjects adds the addresses of objects to d along with the ports
+// they declare over protocol.
+func addK8sObjects(d discovered, objects []k8sObject, protocol string) {
+	for _, o := range objects {
+		for _, ip := range o.IPs {
+			d.add(ip, o.String(), o.Ports[protocol], true)
+		}
+	}
+}
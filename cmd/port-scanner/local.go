@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/pflag"
+	"go.coder.com/cli"
+	"golang.org/x/xerrors"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+// local lists what the machine itself is listening on and who's doing the
+// listening, a quick look at what it exposes. With --verify it scans its own
+// addresses to see which of those listeners can actually be connected to.
+//
+//	port-scanner local --verify
+type localCmd struct {
+	verify    bool
+	addresses []string
+	timeout   time.Duration
+	output    string
+}
+
+func (cmd *localCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "local",
+		Usage: "[flags]",
+		Desc:  "List this machine's listening sockets and the processes behind them.",
+	}
+}
+
+func (cmd *localCmd) RegisterFlags(fl *pflag.FlagSet) {
+	fl.BoolVar(&cmd.verify, "verify", false, "scan our own addresses to check which tcp listeners are reachable")
+	fl.StringSliceVar(&cmd.addresses, "address", nil, "addresses --verify scans(defaults to the non-loopback addresses of our interfaces)")
+	fl.DurationVar(&cmd.timeout, "timeout", scanner.DefaultTimeout, "how long to wait on each connection attempt(e.g. 500ms)")
+	fl.StringVarP(&cmd.output, "output", "o", "text", "output format(text or json)")
+}
+
+// localListener is a listening socket, as it's written in json.
+type localListener struct {
+	Network string `json:"network"`
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+	Service string `json:"service,omitempty"`
+	PID     int    `json:"pid,omitempty"`
+	Process string `json:"process,omitempty"`
+	// Exposure is "loopback" for sockets only the machine itself can reach,
+	// "all" for the ones bound to every address and "address" for the rest.
+	Exposure string `json:"exposure"`
+	// Checked are the addresses --verify connected to and Reachable the ones that accepted.
+	Checked   []string `json:"checked,omitempty"`
+	Reachable []string `json:"reachable,omitempty"`
+}
+
+func (cmd *localCmd) Run(fl *pflag.FlagSet) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch cmd.output {
+	case "text", "json":
+	default:
+		fl.Usage()
+		log.Fatalf("%q is an unsupported output format", cmd.output)
+	}
+
+	if cmd.timeout <= 0 {
+		fl.Usage()
+		log.Fatalf("--timeout must be positive, got %s", cmd.timeout)
+	}
+
+	var addrs []net.IP
+	for _, raw := range cmd.addresses {
+		ip := net.ParseIP(raw)
+		if ip == nil {
+			fl.Usage()
+			log.Fatalf("invalid --address: %q is an invalid ip address", raw)
+		}
+		addrs = append(addrs, ip)
+	}
+	if len(addrs) > 0 && !cmd.verify {
+		fl.Usage()
+		log.Fatal("--address only applies with --verify")
+	}
+
+	ls, err := scanner.Listeners()
+	if err != nil {
+		log.Fatalf("failed to list listening sockets: %s", err)
+	}
+
+	results := make([]*localListener, len(ls))
+	for i, l := range ls {
+		results[i] = &localListener{
+			Network:  l.Network,
+			Address:  l.IP.String(),
+			Port:     l.Port,
+			Service:  scanner.ServiceName(l.Port, strings.TrimSuffix(l.Network, "6")),
+			PID:      l.PID,
+			Process:  l.Process,
+			Exposure: exposure(l),
+		}
+	}
+
+	if cmd.verify {
+		if len(addrs) == 0 {
+			if addrs, err = interfaceAddrs(); err != nil {
+				log.Fatalf("failed to list our addresses: %s", err)
+			}
+		}
+		// Connecting to our own addresses never leaves the machine, the kernel
+		// loops it back, so a firewall that only filters what comes in on an
+		// external interface isn't put to the test.
+		log.Print("connections to our own addresses go over loopback, scan from another machine to see past interface specific firewall rules")
+		if err := cmd.verifyListeners(ctx, ls, results, addrs); err != nil && ctx.Err() == nil {
+			log.Fatalf("failed to verify listeners: %s", err)
+		}
+	}
+
+	if cmd.output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			log.Fatalf("failed to write json output: %s", err)
+		}
+	} else {
+		printListeners(results, cmd.verify)
+	}
+
+	if ctx.Err() != nil {
+		os.Exit(exitInterrupted)
+	}
+}
+
+// exposure tells who can reach l going by what it's bound to.
+func exposure(l scanner.Listener) string {
+	switch {
+	case l.IP.IsLoopback():
+		return "loopback"
+	case l.Wildcard():
+		return "all"
+	default:
+		return "address"
+	}
+}
+
+// interfaceAddrs returns the addresses of our interfaces that other machines
+// could connect to. Link-local ipv6 addresses are left out since they need a zone.
+func interfaceAddrs() ([]net.IP, error) {
+	ifaceAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []net.IP
+	for _, a := range ifaceAddrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		addrs = append(addrs, ipNet.IP)
+	}
+	return addrs, nil
+}
+
+// verifyListeners connects to every tcp listener that isn't loopback only on
+// the addresses of addrs it accepts on. udp can't be told apart from a dropped
+// datagram without speaking the service's protocol, so it isn't verified.
+func (cmd *localCmd) verifyListeners(ctx context.Context, ls []scanner.Listener, results []*localListener, addrs []net.IP) error {
+	// The ports to scan on each address, and the listeners behind each of them.
+	ports := make(map[string][]int)
+	behind := make(map[string][]*localListener)
+	var order []string
+	for i, l := range ls {
+		if !strings.HasPrefix(l.Network, "tcp") || l.IP.IsLoopback() {
+			continue
+		}
+
+		for _, addr := range addrs {
+			if !accepts(l, addr) {
+				continue
+			}
+
+			key := net.JoinHostPort(addr.String(), strconv.Itoa(l.Port))
+			if _, ok := behind[key]; !ok {
+				if _, ok := ports[addr.String()]; !ok {
+					order = append(order, addr.String())
+				}
+				ports[addr.String()] = append(ports[addr.String()], l.Port)
+			}
+			behind[key] = append(behind[key], results[i])
+			results[i].Checked = append(results[i].Checked, addr.String())
+		}
+	}
+
+	for _, addr := range order {
+		log.Printf("scanning %d ports on %s...", len(ports[addr]), addr)
+		s, err := scanner.New(addr, scanner.Options{Network: "tcp", Ports: ports[addr], Timeout: cmd.timeout})
+		if err != nil {
+			return xerrors.Errorf("failed to initialize port scanner: %w", err)
+		}
+
+		res, err := s.Scan(ctx)
+		if err != nil {
+			return err
+		}
+		for _, p := range res.Open() {
+			for _, r := range behind[net.JoinHostPort(addr, strconv.Itoa(p.Port))] {
+				r.Reachable = append(r.Reachable, addr)
+			}
+		}
+	}
+	return nil
+}
+
+// accepts reports whether l takes connections to addr. An ipv6 wildcard takes
+// ipv4 connections too unless it's v6only, which we can't tell from /proc, and
+// if it is the connect is refused and it's reported unreachable on them.
+func accepts(l scanner.Listener, addr net.IP) bool {
+	if !l.Wildcard() {
+		return l.IP.Equal(addr)
+	}
+	return l.Network == "tcp6" || addr.To4() != nil
+}
+
+// printListeners writes results as aligned columns on stdout.
+func printListeners(results []*localListener, verified bool) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	header := "PROTO\tADDRESS\tPORT\tSERVICE\tPROCESS\tEXPOSURE"
+	if verified {
+		header += "\tREACHABLE"
+	}
+	fmt.Fprintln(w, header)
+
+	for _, r := range results {
+		process := "-"
+		if r.PID != 0 {
+			process = fmt.Sprintf("%s(%d)", r.Process, r.PID)
+		}
+		service := r.Service
+		if service == "" {
+			service = "-"
+		}
+
+		line := fmt.Sprintf("%s\t%s\t%d\t%s\t%s\t%s", r.Network, r.Address, r.Port, service, process, r.Exposure)
+		if verified {
+			switch {
+			case len(r.Checked) == 0:
+				line += "\t-"
+			case len(r.Reachable) == 0:
+				line += "\tno"
+			default:
+				line += "\t" + strings.Join(r.Reachable, ", ")
+			}
+		}
+		fmt.Fprintln(w, line)
+	}
+	w.Flush()
+}
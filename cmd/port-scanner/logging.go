@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/xerrors"
+)
+
+// Verbosity levels, every -v raises it by one.
+const (
+	levelInfo = iota
+	// levelVerbose is -v, extra detail about what was found.
+	levelVerbose
+	// levelDebug is -vv, everything that helps troubleshoot the scanner itself like per-port dial errors.
+	levelDebug
+)
+
+// logLevelPrefixes are how log lines announce their level, which --log-format json
+// turns into the level field. Lines without one are info.
+// The prefix is dropped from the message unless keep is set.
+var logLevelPrefixes = []struct {
+	prefix, level string
+	keep          bool
+}{
+	{prefix: "debug: ", level: "debug"},
+	// The scanner's own per-port dumps, see --raw-errors.
+	{prefix: "raw-error ", level: "debug", keep: true},
+	{prefix: "warning: ", level: "warn"},
+	{prefix: "note: ", level: "info"},
+}
+
+func registerLogFormatFlag(fl *pflag.FlagSet, format *string) {
+	fl.StringVar(format, "log-format", "text", "format of the log on stderr(text or json)")
+}
+
+// setLogFormat switches the log over to format, where json
+// makes every line an object with time, level and msg fields.
+func setLogFormat(format string) error {
+	switch format {
+	case "text":
+	case "json":
+		log.SetFlags(0)
+		log.SetOutput(&jsonLogWriter{w: log.Writer()})
+	default:
+		return xerrors.Errorf("%q is an unsupported log format", format)
+	}
+	return nil
+}
+
+// jsonLogWriter turns the lines the log package writes into json objects.
+type jsonLogWriter struct {
+	w io.Writer
+}
+
+type jsonLogLine struct {
+	Time  time.Time `json:"time"`
+	Level string    `json:"level"`
+	Msg   string    `json:"msg"`
+}
+
+func (j *jsonLogWriter) Write(p []byte) (int, error) {
+	line := jsonLogLine{Time: time.Now().UTC(), Level: "info", Msg: strings.TrimSuffix(string(p), "\n")}
+	for _, l := range logLevelPrefixes {
+		if strings.HasPrefix(line.Msg, l.prefix) {
+			line.Level = l.level
+			if !l.keep {
+				line.Msg = strings.TrimPrefix(line.Msg, l.prefix)
+			}
+			break
+		}
+	}
+
+	b, err := json.Marshal(line)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := j.w.Write(append(b, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// teeLog copies everything logged to f as well. Text output is the log,
+// so that's how it ends up in --out while still showing on the terminal.
+func teeLog(f *os.File) {
+	w := io.MultiWriter(os.Stderr, f)
+	if j, ok := log.Writer().(*jsonLogWriter); ok {
+		j.w = w
+		return
+	}
+	log.SetOutput(w)
+}
+
+// infof logs progress chatter, which --quiet leaves out.
+func (cmd *scanCmd) infof(format string, v ...interface{}) {
+	if !cmd.quiet {
+		log.Printf(format, v...)
+	}
+}
+
+// verbosef logs extra detail only -v asks for.
+func (cmd *scanCmd) verbosef(format string, v ...interface{}) {
+	if cmd.verbose >= levelVerbose {
+		log.Printf(format, v...)
+	}
+}
+
+// debugf logs what only helps troubleshoot the scanner, only -vv asks for it.
+func (cmd *scanCmd) debugf(format string, v ...interface{}) {
+	if cmd.verbose >= levelDebug {
+		log.Printf("debug: "+format, v...)
+	}
+}
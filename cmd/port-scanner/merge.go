@@ -0,0 +1,92 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+
+	"github.com/spf13/pflag"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+// registerMergeFlag registers --merge-adjacent-scans, which keeps a port that
+// blips for a single scan from counting as a deviation or a notification.
+func registerMergeFlag(fl *pflag.FlagSet, n *int) {
+	fl.IntVar(n, "merge-adjacent-scans", 1, "hold the state most of this many of the latest scans of each host agree on against --baseline and --notify's history rather than only the latest one, the scans before this one come from the history --record keeps(odd numbers avoid ties)")
+}
+
+// mergeStates merges window, a host's port states newest scan first, into the
+// state most scans found each port in. Ties go to the older state, and ports
+// most scans didn't report are left out.
+func mergeStates(window []map[int]scanner.State) map[int]scanner.State {
+	ports := make(map[int]bool)
+	for _, states := range window {
+		for port := range states {
+			ports[port] = true
+		}
+	}
+
+	merged := make(map[int]scanner.State)
+	for port := range ports {
+		votes := make(map[scanner.State]int)
+		for _, states := range window {
+			votes[states[port]]++
+		}
+
+		var (
+			best      scanner.State
+			bestVotes int
+		)
+		for _, states := range window {
+			if state := states[port]; votes[state] >= bestVotes {
+				best, bestVotes = state, votes[state]
+			}
+		}
+		if best != "" {
+			merged[port] = best
+		}
+	}
+	return merged
+}
+
+// mergeRecent returns rep with every host's ports merged with those of the
+// n-1 scans of it recorded in historyDB before it. Without a history rep is
+// all there is, and there's no need to create one for it.
+func mergeRecent(historyDB string, rep *report, n int) (*report, error) {
+	var db *sql.DB
+	if _, err := os.Stat(historyDB); err == nil {
+		if db, err = openHistory(historyDB); err != nil {
+			return nil, err
+		}
+		defer db.Close()
+	}
+
+	merged := *rep
+	merged.Hosts = make([]*hostResult, len(rep.Hosts))
+	for i, h := range rep.Hosts {
+		before, err := recentPortStates(db, h, n-1)
+		if err != nil {
+			return nil, err
+		}
+
+		m := *h
+		m.Ports, m.Found = nil, 0
+		states := mergeStates(append([]map[int]scanner.State{portStates(h)}, before...))
+		for _, port := range sortedStatePorts(states) {
+			m.Ports = append(m.Ports, portResult{Port: port, State: states[port], Service: scanner.ServiceName(port, h.Protocol)})
+			if states[port] == scanner.StateOpen {
+				m.Found++
+			}
+		}
+		merged.Hosts[i] = &m
+	}
+	return &merged, nil
+}
+
+func sortedStatePorts(states map[int]scanner.State) []int {
+	ports := make(map[int]bool, len(states))
+	for port := range states {
+		ports[port] = true
+	}
+	return sortedPorts(ports)
+}
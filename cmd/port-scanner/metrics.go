@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+// scanDurationBuckets are the upper bounds in seconds of the scan duration histogram,
+// from a handful of ports on a lan up to a full --all sweep over a slow link.
+var scanDurationBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 600, 1800}
+
+// metrics keeps what recurring scans found in a shape Prometheus can scrape.
+// It renders the text exposition format itself, which is all a scrape needs,
+// rather than pulling in the client library for a handful of series.
+type metrics struct {
+	mu sync.Mutex
+	// portOpen maps host, ip and port labels to 1 while the port is open
+	// and 0 once a later scan found it closed, so alerts can fire on either.
+	portOpen map[[3]string]float64
+	// scans counts finished scans by result, "ok" or "failed".
+	scans map[string]int
+	// probeErrors counts the probes that failed, by host and how they failed.
+	probeErrors map[[2]string]int
+
+	durationBuckets []int
+	durationSum     float64
+	durationCount   int
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		portOpen:        make(map[[3]string]float64),
+		scans:           make(map[string]int),
+		probeErrors:     make(map[[2]string]int),
+		durationBuckets: make([]int, len(scanDurationBuckets)),
+	}
+}
+
+// observe records a finished scan of host. A nil m records nothing,
+// so callers don't need to check whether metrics were asked for.
+func (m *metrics) observe(host, ip string, open []int, took time.Duration, failures map[string]int) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.scans["ok"]++
+	for key := range m.portOpen {
+		if key[0] == host && key[1] == ip {
+			m.portOpen[key] = 0
+		}
+	}
+	for _, port := range open {
+		m.portOpen[[3]string{host, ip, strconv.Itoa(port)}] = 1
+	}
+
+	for outcome, n := range failures {
+		m.probeErrors[[2]string{host, outcome}] += n
+	}
+
+	seconds := took.Seconds()
+	for i, bound := range scanDurationBuckets {
+		if seconds <= bound {
+			m.durationBuckets[i]++
+		}
+	}
+	m.durationSum += seconds
+	m.durationCount++
+}
+
+// observeFailure records a scan that didn't produce a result at all.
+func (m *metrics) observeFailure() {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.scans["failed"]++
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	b.WriteString("# HELP port_scanner_port_open Whether the port was open on the last scan of the host.\n")
+	b.WriteString("# TYPE port_scanner_port_open gauge\n")
+	var ports [][3]string
+	for key := range m.portOpen {
+		ports = append(ports, key)
+	}
+	sort.Slice(ports, func(i, j int) bool {
+		if ports[i][0] != ports[j][0] {
+			return ports[i][0] < ports[j][0]
+		}
+		if ports[i][1] != ports[j][1] {
+			return ports[i][1] < ports[j][1]
+		}
+		pi, _ := strconv.Atoi(ports[i][2])
+		pj, _ := strconv.Atoi(ports[j][2])
+		return pi < pj
+	})
+	for _, key := range ports {
+		fmt.Fprintf(&b, "port_scanner_port_open{host=%q,ip=%q,port=%q} %g\n", key[0], key[1], key[2], m.portOpen[key])
+	}
+
+	b.WriteString("# HELP port_scanner_scans_total Scans finished, by result.\n")
+	b.WriteString("# TYPE port_scanner_scans_total counter\n")
+	for _, result := range []string{"ok", "failed"} {
+		fmt.Fprintf(&b, "port_scanner_scans_total{result=%q} %d\n", result, m.scans[result])
+	}
+
+	b.WriteString("# HELP port_scanner_probe_errors_total Probes that failed, by host and how they failed.\n")
+	b.WriteString("# TYPE port_scanner_probe_errors_total counter\n")
+	var errs [][2]string
+	for key := range m.probeErrors {
+		errs = append(errs, key)
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		if errs[i][0] != errs[j][0] {
+			return errs[i][0] < errs[j][0]
+		}
+		return errs[i][1] < errs[j][1]
+	})
+	for _, key := range errs {
+		fmt.Fprintf(&b, "port_scanner_probe_errors_total{host=%q,outcome=%q} %d\n", key[0], key[1], m.probeErrors[key])
+	}
+
+	b.WriteString("# HELP port_scanner_scan_duration_seconds How long scans of a single host took.\n")
+	b.WriteString("# TYPE port_scanner_scan_duration_seconds histogram\n")
+	for i, bound := range scanDurationBuckets {
+		fmt.Fprintf(&b, "port_scanner_scan_duration_seconds_bucket{le=%q} %d\n", strconv.FormatFloat(bound, 'g', -1, 64), m.durationBuckets[i])
+	}
+	fmt.Fprintf(&b, "port_scanner_scan_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.durationCount)
+	fmt.Fprintf(&b, "port_scanner_scan_duration_seconds_sum %g\n", m.durationSum)
+	fmt.Fprintf(&b, "port_scanner_scan_duration_seconds_count %d\n", m.durationCount)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = w.Write([]byte(b.String()))
+}
+
+// openPorts returns the open ports of r.
+func openPorts(r *hostResult) []int {
+	var ports []int
+	for _, p := range r.Ports {
+		if p.State == scanner.StateOpen {
+			ports = append(ports, p.Port)
+		}
+	}
+	return ports
+}
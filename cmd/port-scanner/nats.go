@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// natsPublisher publishes to a NATS subject. The client protocol is a handful
+// of text commands, so it's spoken directly rather than through a client library.
+type natsPublisher struct {
+	addr    string
+	subject string
+	// user and pass, or just a token, come from the url's userinfo.
+	user, pass, token string
+}
+
+func newNATSPublisher(u *url.URL, subject string) (*natsPublisher, error) {
+	if strings.ContainsAny(subject, " \t\r\n") {
+		return nil, xerrors.Errorf("%q is an invalid nats subject", subject)
+	}
+
+	p := &natsPublisher{addr: u.Host, subject: strings.Replace(subject, "/", ".", -1)}
+	if u.Port() == "" {
+		p.addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			p.user, p.pass = u.User.Username(), pass
+		} else {
+			p.token = u.User.Username()
+		}
+	}
+	return p, nil
+}
+
+func (p *natsPublisher) kind() string { return "nats" }
+
+// natsInfo is the part of the server's INFO we care about.
+type natsInfo struct {
+	TLSRequired  bool `json:"tls_required"`
+	AuthRequired bool `json:"auth_required"`
+	MaxPayload   int  `json:"max_payload"`
+}
+
+func (p *natsPublisher) publish(ctx context.Context, msgs []message) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return xerrors.Errorf("failed to connect: %w", err)
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(publishTimeout)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return xerrors.Errorf("failed to set deadline: %w", err)
+	}
+
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return xerrors.Errorf("failed to read server info: %w", err)
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return xerrors.Errorf("expected INFO from the server, got %q", strings.TrimSpace(line))
+	}
+
+	var info natsInfo
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), &info); err != nil {
+		return xerrors.Errorf("failed to parse server info: %w", err)
+	}
+	if info.TLSRequired {
+		return xerrors.New("the server requires tls, which isn't supported")
+	}
+
+	connect, err := json.Marshal(map[string]interface{}{
+		"verbose":      false,
+		"pedantic":     false,
+		"name":         "port-scanner",
+		"lang":         "go",
+		"version":      productVersion(),
+		"user":         p.user,
+		"pass":         p.pass,
+		"auth_token":   p.token,
+		"tls_required": false,
+	})
+	if err != nil {
+		return xerrors.Errorf("failed to encode connect: %w", err)
+	}
+
+	w := bufio.NewWriter(conn)
+	w.WriteString("CONNECT " + string(connect) + "\r\n")
+	for _, m := range msgs {
+		if info.MaxPayload > 0 && len(m.value) > info.MaxPayload {
+			return xerrors.Errorf("a %d byte message is over the server's %d byte limit", len(m.value), info.MaxPayload)
+		}
+		w.WriteString("PUB " + p.subject + " " + strconv.Itoa(len(m.value)) + "\r\n")
+		w.Write(m.value)
+		w.WriteString("\r\n")
+	}
+	// The server answers a PING once it processed everything before it,
+	// or fails with an -ERR first when it didn't like something.
+	w.WriteString("PING\r\n")
+	if err := w.Flush(); err != nil {
+		return xerrors.Errorf("failed to send messages: %w", err)
+	}
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return xerrors.Errorf("failed to read reply: %w", err)
+		}
+
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			return xerrors.Errorf("server answered %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+		// Anything else, like a PING of the server's own or an updated INFO, can be ignored.
+	}
+}
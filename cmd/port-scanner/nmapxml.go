@@ -0,0 +1,429 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+// These mirror the parts of nmap's xml output(https://nmap.org/book/nmap-dtd.html)
+// that tools importing it actually read, so --output nmap-xml can go
+// wherever nmap -oX output does.
+type nmapRun struct {
+	XMLName          xml.Name     `xml:"nmaprun"`
+	Scanner          string       `xml:"scanner,attr"`
+	Args             string       `xml:"args,attr"`
+	Start            int64        `xml:"start,attr"`
+	StartStr         string       `xml:"startstr,attr"`
+	Version          string       `xml:"version,attr"`
+	XMLOutputVersion string       `xml:"xmloutputversion,attr"`
+	ScanInfo         []nmapInfo   `xml:"scaninfo"`
+	Hosts            []nmapHost   `xml:"host"`
+	RunStats         nmapRunStats `xml:"runstats"`
+}
+
+type nmapInfo struct {
+	Type        string `xml:"type,attr"`
+	Protocol    string `xml:"protocol,attr"`
+	NumServices int    `xml:"numservices,attr"`
+	Services    string `xml:"services,attr"`
+}
+
+type nmapHost struct {
+	StartTime int64      `xml:"starttime,attr"`
+	EndTime   int64      `xml:"endtime,attr"`
+	Status    nmapStatus `xml:"status"`
+	// Addresses holds the mac address too when nmap could see it.
+	Addresses []nmapAddress  `xml:"address"`
+	Hostnames []nmapHostname `xml:"hostnames>hostname"`
+	Ports     nmapPorts      `xml:"ports"`
+	OS        *nmapOS        `xml:"os,omitempty"`
+}
+
+type nmapStatus struct {
+	State  string `xml:"state,attr"`
+	Reason string `xml:"reason,attr"`
+}
+
+type nmapAddress struct {
+	Addr     string `xml:"addr,attr"`
+	AddrType string `xml:"addrtype,attr"`
+}
+
+type nmapHostname struct {
+	Name string `xml:"name,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type nmapPorts struct {
+	ExtraPorts []nmapExtraPorts `xml:"extraports"`
+	Ports      []nmapPort       `xml:"port"`
+}
+
+type nmapExtraPorts struct {
+	State string `xml:"state,attr"`
+	Count int    `xml:"count,attr"`
+}
+
+type nmapPort struct {
+	Protocol string       `xml:"protocol,attr"`
+	PortID   int          `xml:"portid,attr"`
+	State    nmapState    `xml:"state"`
+	Service  *nmapService `xml:"service,omitempty"`
+	Scripts  []nmapScript `xml:"script"`
+}
+
+type nmapState struct {
+	State  string `xml:"state,attr"`
+	Reason string `xml:"reason,attr"`
+}
+
+type nmapService struct {
+	Name      string `xml:"name,attr"`
+	Product   string `xml:"product,attr,omitempty"`
+	Version   string `xml:"version,attr,omitempty"`
+	ExtraInfo string `xml:"extrainfo,attr,omitempty"`
+	Tunnel    string `xml:"tunnel,attr,omitempty"`
+	Method    string `xml:"method,attr"`
+	Conf      int    `xml:"conf,attr"`
+}
+
+type nmapScript struct {
+	ID     string `xml:"id,attr"`
+	Output string `xml:"output,attr"`
+}
+
+type nmapOS struct {
+	Matches []nmapOSMatch `xml:"osmatch"`
+}
+
+type nmapOSMatch struct {
+	Name     string `xml:"name,attr"`
+	Accuracy int    `xml:"accuracy,attr"`
+}
+
+type nmapRunStats struct {
+	Finished nmapFinished  `xml:"finished"`
+	Hosts    nmapHostStats `xml:"hosts"`
+}
+
+type nmapFinished struct {
+	Time    int64  `xml:"time,attr"`
+	TimeStr string `xml:"timestr,attr"`
+	Elapsed string `xml:"elapsed,attr"`
+	Summary string `xml:"summary,attr"`
+	Exit    string `xml:"exit,attr"`
+}
+
+type nmapHostStats struct {
+	Up    int `xml:"up,attr"`
+	Down  int `xml:"down,attr"`
+	Total int `xml:"total,attr"`
+}
+
+// writeNmapXML renders rep in nmap's xml format.
+func writeNmapXML(w io.Writer, rep *report) error {
+	end := rep.Timestamp.Add(time.Duration(rep.Duration))
+	run := nmapRun{
+		Scanner:          "port-scanner",
+		Args:             rep.Invocation,
+		Start:            rep.Timestamp.Unix(),
+		StartStr:         rep.Timestamp.Format(time.ANSIC),
+		XMLOutputVersion: "1.05",
+	}
+
+	protocols := make(map[string]int)
+	for _, h := range rep.Hosts {
+		if _, ok := protocols[h.Protocol]; !ok {
+			run.ScanInfo = append(run.ScanInfo, nmapInfo{Type: nmapScanType(h.Protocol), Protocol: h.Protocol, NumServices: h.ScannedPorts})
+		}
+		protocols[h.Protocol]++
+		run.Hosts = append(run.Hosts, newNmapHost(h))
+	}
+
+	// Every host we scanned gets reported as up, the same as nmap -Pn does.
+	run.RunStats = nmapRunStats{
+		Finished: nmapFinished{
+			Time:    end.Unix(),
+			TimeStr: end.Format(time.ANSIC),
+			Elapsed: fmt.Sprintf("%.2f", time.Duration(rep.Duration).Seconds()),
+			Summary: fmt.Sprintf("port-scanner done at %s; %d IP addresses scanned in %.2f seconds", end.Format(time.ANSIC), len(rep.Hosts), time.Duration(rep.Duration).Seconds()),
+			Exit:    "success",
+		},
+		Hosts: nmapHostStats{Up: len(rep.Hosts), Total: len(rep.Hosts)},
+	}
+	if rep.Interrupted {
+		run.RunStats.Finished.Exit = "error"
+	}
+
+	if _, err := io.WriteString(w, xml.Header+"<!DOCTYPE nmaprun>\n"); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(run); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func newNmapHost(h *hostResult) nmapHost {
+	host := nmapHost{
+		StartTime: h.Timestamp.Unix(),
+		EndTime:   h.Timestamp.Add(time.Duration(h.Duration)).Unix(),
+		Status:    nmapStatus{State: "up", Reason: "user-set"},
+	}
+	addrType := "ipv4"
+	if ip := net.ParseIP(h.IP); ip != nil && ip.To4() == nil {
+		addrType = "ipv6"
+	}
+	host.Addresses = []nmapAddress{{Addr: h.IP, AddrType: addrType}}
+
+	if h.Host != h.IP {
+		host.Hostnames = append(host.Hostnames, nmapHostname{Name: h.Host, Type: "user"})
+	}
+	if h.PTR != "" {
+		host.Hostnames = append(host.Hostnames, nmapHostname{Name: h.PTR, Type: "PTR"})
+	}
+
+	// Ports we didn't report are summed up like nmap does.
+	closed, filtered := h.hidden()
+	if closed > 0 {
+		host.Ports.ExtraPorts = append(host.Ports.ExtraPorts, nmapExtraPorts{State: "closed", Count: closed})
+	}
+	if filtered > 0 {
+		host.Ports.ExtraPorts = append(host.Ports.ExtraPorts, nmapExtraPorts{State: "filtered", Count: filtered})
+	}
+
+	for _, p := range h.Ports {
+		host.Ports.Ports = append(host.Ports.Ports, newNmapPort(h.Protocol, p))
+	}
+
+	if h.OS != nil {
+		host.OS = &nmapOS{Matches: []nmapOSMatch{{Name: h.OS.Family, Accuracy: int(h.OS.Confidence * 100)}}}
+	}
+	return host
+}
+
+func newNmapPort(protocol string, p portResult) nmapPort {
+	port := nmapPort{
+		Protocol: protocol,
+		PortID:   p.Port,
+		State:    nmapState{State: string(p.State), Reason: "syn-ack"},
+	}
+	switch protocol {
+	case "udp":
+		port.State.Reason = "udp-response"
+	case "sctp":
+		port.State.Reason = "init-ack"
+	}
+	switch p.State {
+	case scanner.StateOpenFiltered, scanner.StateFiltered:
+		port.State.Reason = "no-response"
+		if p.Failure == "unreachable" {
+			port.State.Reason = "host-unreach"
+		}
+	case scanner.StateClosed:
+		port.State.Reason = "conn-refused"
+		if protocol == "sctp" {
+			port.State.Reason = "abort"
+		}
+	case scanner.StateUnfiltered:
+		port.State.Reason = "reset"
+	case scanner.StateClosedFiltered:
+		port.State.Reason = "no-ipid-change"
+	}
+
+	// A protocol we recognised on the wire beats the port's well-known name,
+	// nmap calls those probed and table lookups respectively.
+	switch {
+	case p.GuessedProtocol != "":
+		port.Service = &nmapService{Name: p.GuessedProtocol, Method: "probed", Conf: 10}
+	case p.Service != "":
+		port.Service = &nmapService{Name: p.Service, Method: "table", Conf: 3}
+	}
+	if port.Service != nil && p.TLS != nil {
+		port.Service.Tunnel = "ssl"
+	}
+	if port.Service != nil && p.Version != nil {
+		port.Service.Product, port.Service.Version, port.Service.ExtraInfo = p.Version.Product, p.Version.Version, p.Version.Info
+	}
+
+	// The rest mimics the output of the nmap scripts gathering the same thing.
+	if p.Banner != "" {
+		port.Scripts = append(port.Scripts, nmapScript{ID: "banner", Output: p.Banner})
+	}
+	if p.HTTP != nil && p.HTTP.Title != "" {
+		port.Scripts = append(port.Scripts, nmapScript{ID: "http-title", Output: p.HTTP.Title})
+	}
+	if p.TLS != nil {
+		port.Scripts = append(port.Scripts, nmapScript{
+			ID: "ssl-cert",
+			Output: fmt.Sprintf("Subject: %s\nIssuer: %s\nNot valid before: %s\nNot valid after:  %s",
+				p.TLS.Subject, p.TLS.Issuer, p.TLS.NotBefore.Format("2006-01-02T15:04:05"), p.TLS.NotAfter.Format("2006-01-02T15:04:05")),
+		})
+	}
+	if len(p.Script) > 0 {
+		var lines []string
+		for _, k := range scriptFindings(p.Script) {
+			lines = append(lines, fmt.Sprintf("%s: %v", k, p.Script[k]))
+		}
+		port.Scripts = append(port.Scripts, nmapScript{ID: "port-scanner-script", Output: strings.Join(lines, "\n")})
+	}
+	return port
+}
+
+// readNmapXML reads the results of nmap -oX into a report, so nmap scans can be
+// diffed against ours and imported into the history. Every protocol of a host
+// becomes a result of its own like ours are, hosts nmap found down are left out,
+// and closed and filtered ports are counted rather than listed, the same as
+// a scan without --all-states.
+func readNmapXML(r io.Reader) (*report, error) {
+	var run nmapRun
+	if err := xml.NewDecoder(r).Decode(&run); err != nil {
+		return nil, err
+	}
+
+	rep := &report{
+		Version:    reportVersion,
+		Invocation: run.Args,
+		Timestamp:  time.Unix(run.Start, 0).UTC(),
+	}
+	if run.RunStats.Finished.Time > 0 {
+		rep.Duration = duration(time.Unix(run.RunStats.Finished.Time, 0).Sub(time.Unix(run.Start, 0)))
+	}
+	// nmap still writes out what it got before its scan was cut short.
+	rep.Interrupted = run.RunStats.Finished.Exit == "error"
+
+	scanned := make(map[string]int)
+	for _, info := range run.ScanInfo {
+		scanned[info.Protocol] = info.NumServices
+	}
+
+	for _, h := range run.Hosts {
+		if h.Status.State != "" && h.Status.State != "up" {
+			continue
+		}
+		rep.Hosts = append(rep.Hosts, h.results(rep.Timestamp, scanned)...)
+	}
+	return rep, nil
+}
+
+// results converts h into a result for every protocol it had ports scanned over.
+func (h nmapHost) results(start time.Time, scanned map[string]int) []*hostResult {
+	var template hostResult
+	for _, a := range h.Addresses {
+		if a.AddrType == "ipv4" || a.AddrType == "ipv6" {
+			template.IP = a.Addr
+			break
+		}
+	}
+	template.Host = template.IP
+	for _, name := range h.Hostnames {
+		switch name.Type {
+		case "user":
+			template.Host = name.Name
+		case "PTR":
+			template.PTR = name.Name
+		}
+	}
+
+	template.Timestamp = start
+	if h.StartTime > 0 {
+		template.Timestamp = time.Unix(h.StartTime, 0).UTC()
+	}
+	if h.EndTime > h.StartTime && h.StartTime > 0 {
+		template.Duration = duration(time.Duration(h.EndTime-h.StartTime) * time.Second)
+	}
+
+	var order []string
+	results := make(map[string]*hostResult)
+	result := func(protocol string) *hostResult {
+		if r, ok := results[protocol]; ok {
+			return r
+		}
+		r := template
+		r.Protocol = protocol
+		r.Ports = []portResult{}
+		r.ScannedPorts = scanned[protocol]
+		r.TotalPorts = r.ScannedPorts
+		r.Failures = make(map[string]int)
+		results[protocol] = &r
+		order = append(order, protocol)
+		return &r
+	}
+
+	for _, p := range h.Ports.Ports {
+		r := result(p.Protocol)
+		state := scanner.State(p.State.State)
+		switch state {
+		case scanner.StateClosed:
+			r.Failures["refused"]++
+			continue
+		case scanner.StateFiltered, "closed|filtered":
+			r.Failures["timeout"]++
+			continue
+		}
+
+		port := portResult{Port: p.PortID, State: state, Service: scanner.ServiceName(p.PortID, p.Protocol)}
+		if p.Service != nil && p.Service.Method == "probed" {
+			port.GuessedProtocol, port.MatchedSignature = p.Service.Name, "nmap's service probes"
+		}
+		if p.Service != nil && p.Service.Product != "" {
+			port.Version = &versionResult{Product: p.Service.Product, Version: p.Service.Version, Info: p.Service.ExtraInfo}
+		}
+		r.Ports = append(r.Ports, port)
+		if state == scanner.StateOpen {
+			r.Found++
+		}
+	}
+
+	// Ports nmap summed up belong to the only protocol the host was scanned over,
+	// it doesn't say which one they were when there were several.
+	if len(order) <= 1 && len(scanned) <= 1 {
+		protocol := "tcp"
+		for p := range scanned {
+			protocol = p
+		}
+		if len(order) == 1 {
+			protocol = order[0]
+		}
+		for _, extra := range h.Ports.ExtraPorts {
+			r := result(protocol)
+			switch extra.State {
+			case "closed":
+				r.Failures["refused"] += extra.Count
+			default:
+				r.Failures["timeout"] += extra.Count
+			}
+		}
+	}
+
+	var hosts []*hostResult
+	for _, protocol := range order {
+		r := results[protocol]
+		if len(r.Failures) == 0 {
+			r.Failures = nil
+		}
+		hosts = append(hosts, r)
+	}
+	return hosts
+}
+
+// nmapScanType is what nmap calls the kind of scan we ran over protocol.
+func nmapScanType(protocol string) string {
+	switch protocol {
+	case "udp":
+		return "udp"
+	case "sctp":
+		return "sctpinit"
+	}
+	return "connect"
+}
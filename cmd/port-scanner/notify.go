@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/xerrors"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+const notifyTimeout = 10 * time.Second
+
+// discordMaxContent is the longest message discord accepts.
+const discordMaxContent = 2000
+
+// notification is a message for people rather than programs, like the open
+// ports a scan hadn't seen before or the ports violating an audit policy.
+type notification struct {
+	title string
+	lines []string
+}
+
+// notifier posts notifications to a chat service.
+type notifier interface {
+	// kind is what --notify picks the notifier by, e.g. "slack".
+	kind() string
+	// payload renders n as the json body the service expects.
+	payload(n notification) interface{}
+}
+
+// slackNotifier posts to a slack incoming webhook.
+type slackNotifier struct{}
+
+func (slackNotifier) kind() string { return "slack" }
+
+func (slackNotifier) payload(n notification) interface{} {
+	text := "*" + n.title + "*"
+	for _, line := range n.lines {
+		text += "\n• `" + line + "`"
+	}
+	return map[string]string{"text": text}
+}
+
+// discordNotifier posts to a discord channel webhook.
+type discordNotifier struct{}
+
+func (discordNotifier) kind() string { return "discord" }
+
+func (discordNotifier) payload(n notification) interface{} {
+	content := "**" + n.title + "**"
+	for i, line := range n.lines {
+		next := "\n- `" + line + "`"
+		// Lets leave room to say how many didn't fit.
+		if len(content)+len(next) > discordMaxContent-32 {
+			content += fmt.Sprintf("\n…and %d more", len(n.lines)-i)
+			break
+		}
+		content += next
+	}
+	return map[string]string{"content": content}
+}
+
+// notifyTarget is one of --notify, a notifier and the webhook url it posts to.
+type notifyTarget struct {
+	notifier
+	url string
+}
+
+// notifiers are every target of --notify.
+type notifiers []notifyTarget
+
+func registerNotifyFlag(fl *pflag.FlagSet, specs *[]string, when string) {
+	fl.StringSliceVar(specs, "notify", nil, "post to slack or discord "+when+"(e.g. slack:https://hooks.slack.com/services/..., discord:https://discord.com/api/webhooks/...)")
+	// The webhook urls are all it takes to post to the channel.
+	_ = fl.SetAnnotation("notify", secretAnnotation, []string{"true"})
+}
+
+// parseNotifiers parses --notify, every spec is the kind of notifier and its webhook url.
+func parseNotifiers(specs []string) (notifiers, error) {
+	var ns notifiers
+	for _, spec := range specs {
+		i := strings.IndexByte(spec, ':')
+		if i < 0 {
+			return nil, xerrors.Errorf("%q should be slack:<url> or discord:<url>", spec)
+		}
+		kind, rawURL := spec[:i], spec[i+1:]
+
+		var n notifier
+		switch kind {
+		case "slack":
+			n = slackNotifier{}
+		case "discord":
+			n = discordNotifier{}
+		default:
+			return nil, xerrors.Errorf("%q is an unsupported notifier(slack or discord)", kind)
+		}
+
+		u, err := url.Parse(rawURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, xerrors.Errorf("%s needs the http(s) url of a webhook, got %q", kind, rawURL)
+		}
+		ns = append(ns, notifyTarget{notifier: n, url: rawURL})
+	}
+	return ns, nil
+}
+
+// send posts n to every notifier. One of them failing doesn't keep n from the rest,
+// and none of them are worth failing over, so failures are only logged.
+func (ns notifiers) send(ctx context.Context, n notification) {
+	for _, t := range ns {
+		if err := t.post(ctx, n); err != nil {
+			log.Printf("failed to notify %s: %s", t.kind(), err)
+		}
+	}
+}
+
+func (t notifyTarget) post(ctx context.Context, n notification) error {
+	body, err := json.Marshal(t.payload(n))
+	if err != nil {
+		return xerrors.Errorf("failed to encode message: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return xerrors.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// The error quotes the url, which is as good as a password.
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return xerrors.Errorf("failed to post message: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return xerrors.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// newOpenPorts returns a notification of the ports rep found open that weren't open
+// the last time their host was recorded in the history. Every open port of a host
+// that was never recorded is new. It's nil when there's nothing new.
+func newOpenPorts(historyDB string, rep *report, window int) (*notification, error) {
+	// Without a history everything is new, and there's no need to create one for it.
+	var db *sql.DB
+	if _, err := os.Stat(historyDB); err == nil {
+		if db, err = openHistory(historyDB); err != nil {
+			return nil, err
+		}
+		defer db.Close()
+	}
+
+	var lines []string
+	for _, h := range rep.Hosts {
+		before, err := lastOpenPorts(db, h, window)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range h.Ports {
+			if p.State == scanner.StateOpen && !before[p.Port] {
+				lines = append(lines, hostKey(h)+": "+p.name())
+			}
+		}
+	}
+
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	return &notification{
+		title: fmt.Sprintf("port-scanner found %d new open ports", len(lines)),
+		lines: lines,
+	}, nil
+}
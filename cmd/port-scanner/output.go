@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/xerrors"
+)
+
+// reportWriters render the whole report once every host has been scanned,
+// keyed by their --output name. Text output isn't one of them since it's logged as we go.
+var reportWriters = map[string]func(io.Writer, *report) error{
+	"grep":     writeGrepable,
+	"json":     writeJSON,
+	"csv":      writeCSV,
+	"nmap-xml": writeNmapXML,
+}
+
+// outputFormats lists the --output names for flag usage.
+func outputFormats() string {
+	formats := []string{"text"}
+	for name := range reportWriters {
+		formats = append(formats, name)
+	}
+	sort.Strings(formats[1:])
+	return strings.Join(formats, ", ")
+}
+
+// outFile is the file --out sends the results to instead of stdout.
+type outFile struct {
+	path   string
+	append bool
+}
+
+func registerOutFlags(fl *pflag.FlagSet, o *outFile) {
+	fl.StringVar(&o.path, "out", "", "write the results to this file instead of stdout(in the chosen output format)")
+	fl.BoolVar(&o.append, "append", false, "append to --out instead of overwriting it")
+}
+
+// open opens the file for writing, or returns nil when --out isn't set.
+func (o *outFile) open() (*os.File, error) {
+	if o.path == "" {
+		return nil, nil
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if o.append {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+
+	f, err := os.OpenFile(o.path, flags, 0644)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to open %q: %w", o.path, err)
+	}
+	return f, nil
+}
+
+// outDir is the directory --out-dir writes a file of results per host to.
+type outDir struct {
+	path string
+	// write renders a host's results, ext is what its files end in.
+	write func(io.Writer, *report) error
+	ext   string
+	// taken are the names handed out so far, a host scanned at several
+	// addresses or listed twice still gets a file of its own each time.
+	taken map[string]bool
+}
+
+// outDirExtensions are what the files of each --output format end in.
+var outDirExtensions = map[string]string{
+	"text":     ".txt",
+	"json":     ".json",
+	"csv":      ".csv",
+	"grep":     ".gnmap",
+	"nmap-xml": ".xml",
+	"template": ".out",
+}
+
+// newOutDir creates path if it doesn't exist yet. Text output is logged rather
+// than written, so its files get the port table instead, without colors.
+func newOutDir(path, output string, write func(io.Writer, *report) error) (*outDir, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, xerrors.Errorf("failed to create %q: %w", path, err)
+	}
+	if write == nil {
+		write = writeHostTables
+	}
+	return &outDir{path: path, write: write, ext: outDirExtensions[output], taken: make(map[string]bool)}, nil
+}
+
+// writeHost writes the results of h, part of rep, to a file named after it
+// and returns the file's path.
+func (d *outDir) writeHost(rep *report, h *hostResult) (string, error) {
+	name := hostFileName(h)
+	for n := 2; d.taken[name]; n++ {
+		name = fmt.Sprintf("%s-%d", hostFileName(h), n)
+	}
+	d.taken[name] = true
+	path := filepath.Join(d.path, name+d.ext)
+
+	// Every file reads like the whole run's output would if the host had been its only target.
+	single := &report{
+		Version:          rep.Version,
+		Invocation:       rep.Invocation,
+		Timestamp:        h.Timestamp,
+		Duration:         h.Duration,
+		Interrupted:      h.Interrupted,
+		DeadlineExceeded: h.DeadlineExceeded,
+		Hosts:            []*hostResult{h},
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return "", xerrors.Errorf("failed to create %q: %w", path, err)
+	}
+	if err := d.write(f, single); err != nil {
+		f.Close()
+		return "", xerrors.Errorf("failed to write %q: %w", path, err)
+	}
+	return path, f.Close()
+}
+
+// hostFileName names the file of h's results after its address, along with the
+// name it was scanned by when there is one, e.g. "example.com_93.184.216.34".
+func hostFileName(h *hostResult) string {
+	name := h.IP
+	if h.Host != h.IP {
+		name = h.Host + "_" + h.IP
+	}
+	// Colons of ipv6 addresses and anything else a filesystem might choke on go.
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// writeHostTables is the text output of --out-dir, each host's port table. A
+// host without any still gets a line, an empty file would look like a failed write.
+func writeHostTables(w io.Writer, rep *report) error {
+	for _, h := range rep.Hosts {
+		if len(h.Ports) == 0 {
+			if _, err := fmt.Fprintf(w, "%s: none of %d scanned %s ports open\n", hostKey(h), h.ScannedPorts, h.Protocol); err != nil {
+				return err
+			}
+			continue
+		}
+		printTable(w, h, false)
+	}
+	return nil
+}
+
+// hasContent reports whether f is a regular file with something in it already.
+func hasContent(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode().IsRegular() && fi.Size() > 0
+}
+
+// csvHeader is the first row of --output csv.
+var csvHeader = []string{"host", "ip", "protocol", "port", "state", "service", "latency_ms"}
+
+// writeCSV renders rep as one row per reported port, ready for a spreadsheet or an asset inventory.
+// Hosts without any reported ports don't get a row.
+func writeCSV(w io.Writer, rep *report) error {
+	cw := csv.NewWriter(w)
+	// Appending to a csv that already has rows with --append shouldn't repeat the header.
+	if f, ok := w.(*os.File); !ok || f == os.Stdout || !hasContent(f) {
+		if err := cw.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+
+	for _, h := range rep.Hosts {
+		for _, p := range h.Ports {
+			// Only ports that answered have a latency to report.
+			var latency string
+			if p.Latency > 0 {
+				latency = fmt.Sprintf("%.3f", float64(p.Latency)/1e6)
+			}
+
+			row := []string{h.Host, h.IP, h.Protocol, strconv.Itoa(p.Port), string(p.State), p.Service, latency}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"golang.org/x/xerrors"
+)
+
+// templateFuncs are available to --output-template on top of text/template's builtins.
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// parseOutputTemplate reads the text/template at path. It's executed against the
+// same report --output json writes, so the field names are the json ones in
+// Go's casing, e.g. {{range .Hosts}}{{.IP}}{{range .Ports}} {{.Port}}{{end}}{{end}}.
+func parseOutputTemplate(path string) (*template.Template, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read %q: %w", path, err)
+	}
+
+	tmpl, err := template.New(filepath.Base(path)).Funcs(templateFuncs).Parse(string(b))
+	if err != nil {
+		return nil, xerrors.Errorf("failed to parse %q: %w", path, err)
+	}
+	return tmpl, nil
+}
+
+// templateWriter renders reports through tmpl.
+func templateWriter(tmpl *template.Template) func(io.Writer, *report) error {
+	return func(w io.Writer, rep *report) error {
+		return tmpl.Execute(w, rep)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/xerrors"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+// parsePing sets up how --skip-dead pings the targets from --ping. Like
+// nmap's -Pn, "n" or "none" turns pinging off, even when --skip-dead is set
+// by a profile or the config file.
+func (cmd *scanCmd) parsePing(fl *pflag.FlagSet) error {
+	for _, method := range cmd.pingMethods {
+		switch method {
+		case "icmp":
+			cmd.ping.ICMP = true
+		case "tcp":
+			cmd.ping.TCP = true
+		case "n", "none":
+			if len(cmd.pingMethods) > 1 {
+				return xerrors.Errorf("--ping %s can't be combined with other methods", method)
+			}
+			cmd.skipDead = false
+			return nil
+		default:
+			return xerrors.Errorf("%q is an unsupported ping method(icmp, tcp or n)", method)
+		}
+	}
+
+	if !cmd.skipDead {
+		return nil
+	}
+
+	// The targets may only be reachable through the proxy or bastion, and the agents see other routes than us.
+	if cmd.proxy != "" || cmd.sshJump.enabled() || cmd.zombieSpec != "" {
+		return xerrors.New("--skip-dead can't be used with --proxy, --ssh-jump or --zombie")
+	}
+
+	// Not everyone can open raw sockets, so unless icmp was asked for
+	// explicitly lets make do with tcp, the same as discover does.
+	if cmd.ping.ICMP {
+		if err := scanner.CheckICMP(); err != nil {
+			if fl.Changed("ping") {
+				return err
+			}
+			log.Printf("warning: pinging with tcp only, %s", err)
+			cmd.ping.ICMP = false
+		}
+	}
+	if !cmd.ping.ICMP && !cmd.ping.TCP {
+		return xerrors.New("--skip-dead has no ping method left to use")
+	}
+	return nil
+}
+
+// skipDeadTargets pings every target and returns the ones that answered,
+// along with the names of those that didn't.
+func (cmd *scanCmd) skipDeadTargets(ctx context.Context, targets []target) (alive []target, down []string, err error) {
+	ips := make([]net.IP, len(targets))
+	for i, t := range targets {
+		ips[i] = t.ip
+	}
+
+	opts := cmd.ping
+	opts.Timeout, opts.Concurrency = cmd.timeout, cmd.concurrency
+	live, err := scanner.Discover(ctx, ips, opts)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("failed to ping targets: %w", err)
+	}
+
+	up := make(map[string]scanner.LiveHost, len(live))
+	for _, host := range live {
+		up[host.IP.String()] = host
+	}
+
+	for _, t := range targets {
+		name := t.host
+		if t.ip.String() != t.host {
+			name += "(" + t.ip.String() + ")"
+		}
+
+		host, ok := up[t.ip.String()]
+		if !ok {
+			cmd.verbosef("skipping %s, it didn't answer pings", name)
+			down = append(down, name)
+			continue
+		}
+		cmd.debugf("%s answered a %s ping in %s", name, host.Method, host.Latency)
+		alive = append(alive, t)
+	}
+	return alive, down, nil
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/pflag"
+	"go.coder.com/cli"
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+// A policy declares which ports are allowed to be open where. The audit scans
+// every host a rule names and treats any other open port as a violation.
+// A host gets every port allowed by all the rules covering it, so broad rules
+// can set a baseline that narrower ones add to.
+//
+//	ports: 1-1024,3306,5432,6379,8000-9000
+//	rules:
+//	  - hosts: [10.0.0.0/24]
+//	    allow: [22]
+//	  - hosts: [10.0.0.10, web.example.com]
+//	    allow: [80, 443]
+//	  - hosts: [10.0.0.20]
+//	    allow: []
+//
+// ports is what gets scanned on every host and defaults to the first 1024,
+// open ports outside of it go unnoticed.
+type policy struct {
+	Ports policyPorts  `yaml:"ports"`
+	Rules []policyRule `yaml:"rules"`
+}
+
+type policyRule struct {
+	Hosts []string    `yaml:"hosts"`
+	Allow policyPorts `yaml:"allow"`
+}
+
+// policyPorts is written with the same syntax as --ports, either as a single
+// string or as a list of ports and ranges.
+type policyPorts []int
+
+func (p *policyPorts) UnmarshalYAML(n *yaml.Node) error {
+	var specs []string
+	switch n.Kind {
+	case yaml.ScalarNode:
+		specs = []string{n.Value}
+	case yaml.SequenceNode:
+		for _, elem := range n.Content {
+			if elem.Kind != yaml.ScalarNode {
+				return xerrors.Errorf("line %d: ports must be numbers or ranges", elem.Line)
+			}
+			specs = append(specs, elem.Value)
+		}
+	default:
+		return xerrors.Errorf("line %d: ports must be a list or a string", n.Line)
+	}
+
+	// An empty list is how a rule says nothing may be open.
+	if len(specs) == 0 || (len(specs) == 1 && specs[0] == "") {
+		*p = policyPorts{}
+		return nil
+	}
+
+	ports, err := scanner.ParsePorts(strings.Join(specs, ","))
+	if err != nil {
+		return xerrors.Errorf("line %d: %w", n.Line, err)
+	}
+	*p = ports
+	return nil
+}
+
+func readPolicy(path string) (*policy, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read policy: %w", err)
+	}
+
+	var p policy
+	if err := yaml.Unmarshal(b, &p); err != nil {
+		return nil, xerrors.Errorf("failed to parse policy: %w", err)
+	}
+
+	if len(p.Rules) == 0 {
+		return nil, xerrors.New("policy doesn't have any rules")
+	}
+	for i, r := range p.Rules {
+		if len(r.Hosts) == 0 {
+			return nil, xerrors.Errorf("rule %d doesn't name any hosts", i+1)
+		}
+		if r.Allow == nil {
+			return nil, xerrors.Errorf("rule %d doesn't say which ports are allowed(use \"allow: []\" for none)", i+1)
+		}
+	}
+
+	if p.Ports == nil {
+		p.Ports = defaultPorts(false)
+	}
+	return &p, nil
+}
+
+// hosts returns every host the rules name, with cidr ranges expanded, in the order they're first named.
+func (p *policy) hosts() ([]string, error) {
+	seen := make(map[string]bool)
+	var hosts []string
+	for _, r := range p.Rules {
+		for _, spec := range r.Hosts {
+			expanded, err := scanner.ExpandHost(spec)
+			if err != nil {
+				return nil, err
+			}
+			for _, host := range expanded {
+				if !seen[host] {
+					seen[host] = true
+					hosts = append(hosts, host)
+				}
+			}
+		}
+	}
+	return hosts, nil
+}
+
+// allowed returns the ports allowed on host, which resolved to ip, by every rule that covers it.
+// A rule covers the host when it names it, its address or a cidr range holding its address.
+func (p *policy) allowed(host string, ip net.IP) map[int]bool {
+	allowed := make(map[int]bool)
+	for _, r := range p.Rules {
+		if !r.covers(host, ip) {
+			continue
+		}
+		for _, port := range r.Allow {
+			allowed[port] = true
+		}
+	}
+	return allowed
+}
+
+func (r policyRule) covers(host string, ip net.IP) bool {
+	for _, spec := range r.Hosts {
+		spec = strings.Trim(spec, "[]")
+		if _, ipNet, err := net.ParseCIDR(spec); err == nil {
+			if ipNet.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if spec == host || ip.Equal(net.ParseIP(spec)) {
+			return true
+		}
+	}
+	return false
+}
+
+// audit checks a network against a policy of the ports allowed to be open,
+// which is what nightly compliance jobs want out of a scan.
+type auditCmd struct {
+	policy      string
+	timeout     time.Duration
+	concurrency int
+	output      string
+	config      string
+	notifySpecs []string
+}
+
+func (cmd *auditCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "audit",
+		Usage: "--policy policy.yaml [flags]",
+		Desc:  "Scan the hosts of a policy and report the open ports it doesn't allow, exiting non-zero if there are any.",
+	}
+}
+
+func (cmd *auditCmd) RegisterFlags(fl *pflag.FlagSet) {
+	fl.StringVar(&cmd.policy, "policy", "", "yaml file of the ports allowed per host or cidr range(see policy.go for the format)")
+	fl.DurationVar(&cmd.timeout, "timeout", scanner.DefaultTimeout, "how long to wait on each connection attempt(e.g. 500ms)")
+	fl.IntVar(&cmd.concurrency, "concurrency", scanner.DefaultConcurrency, "how many ports to scan at once")
+	fl.StringVarP(&cmd.output, "output", "o", "text", "output format(text or json)")
+	registerConfigFlag(fl, &cmd.config)
+	registerNotifyFlag(fl, &cmd.notifySpecs, "when open ports violate the policy")
+}
+
+// hostAudit is how a single host measured up against the policy, as it's written in json.
+type hostAudit struct {
+	Host       string `json:"host"`
+	IP         string `json:"ip,omitempty"`
+	Open       []int  `json:"open"`
+	Allowed    []int  `json:"allowed"`
+	Violations []int  `json:"violations"`
+	Error      string `json:"error,omitempty"`
+}
+
+type auditReport struct {
+	Policy     string       `json:"policy"`
+	Timestamp  time.Time    `json:"timestamp"`
+	Violations int          `json:"violations"`
+	Hosts      []*hostAudit `json:"hosts"`
+}
+
+func (cmd *auditCmd) Run(fl *pflag.FlagSet) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if _, err := applyConfig(fl, cmd.config, cmd.Spec().Name); err != nil {
+		log.Fatalf("failed to load config: %s", err)
+	}
+
+	if cmd.policy == "" {
+		fl.Usage()
+		log.Fatal("policy not provided")
+	}
+
+	switch cmd.output {
+	case "text", "json":
+	default:
+		fl.Usage()
+		log.Fatalf("%q is an unsupported output format", cmd.output)
+	}
+
+	if cmd.timeout <= 0 {
+		fl.Usage()
+		log.Fatalf("--timeout must be positive, got %s", cmd.timeout)
+	}
+
+	notifiers, err := parseNotifiers(cmd.notifySpecs)
+	if err != nil {
+		fl.Usage()
+		log.Fatalf("invalid --notify: %s", err)
+	}
+
+	p, err := readPolicy(cmd.policy)
+	if err != nil {
+		log.Fatalf("invalid --policy: %s", err)
+	}
+
+	hosts, err := p.hosts()
+	if err != nil {
+		log.Fatalf("invalid --policy: %s", err)
+	}
+
+	log.Printf("auditing %d hosts against %s...", len(hosts), cmd.policy)
+	rep := &auditReport{Policy: cmd.policy, Timestamp: time.Now().UTC()}
+	var failed int
+	for _, host := range hosts {
+		a := cmd.audit(ctx, p, host)
+		if ctx.Err() != nil {
+			exitf(exitInterrupted, "audit interrupted after %d/%d hosts", len(rep.Hosts), len(hosts))
+		}
+
+		if a.Error != "" {
+			failed++
+			log.Printf("%s: failed to audit: %s", host, a.Error)
+		} else {
+			for _, name := range portNames(a.Violations) {
+				log.Printf("%s: %s is open but not allowed", host, name)
+			}
+		}
+		rep.Violations += len(a.Violations)
+		rep.Hosts = append(rep.Hosts, a)
+	}
+
+	if cmd.output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rep); err != nil {
+			log.Fatalf("failed to write json output: %s", err)
+		}
+	} else {
+		// Every violation goes to stdout as host:port so jobs can act on the list.
+		for _, a := range rep.Hosts {
+			for _, port := range a.Violations {
+				fmt.Println(net.JoinHostPort(a.Host, fmt.Sprint(port)))
+			}
+		}
+	}
+
+	if rep.Violations > 0 && len(notifiers) > 0 {
+		notifiers.send(ctx, cmd.violations(rep))
+	}
+
+	if rep.Violations > 0 {
+		exitf(exitPolicyViolations, "%d open ports violate %s", rep.Violations, cmd.policy)
+	}
+	// A host we couldn't scan might be hiding violations, so a clean audit needs all of them.
+	if failed > 0 {
+		exitf(exitError, "failed to audit %d/%d hosts", failed, len(hosts))
+	}
+	log.Printf("all %d hosts comply with %s", len(hosts), cmd.policy)
+}
+
+// violations lists every port of rep open against the policy.
+func (cmd *auditCmd) violations(rep *auditReport) notification {
+	n := notification{title: fmt.Sprintf("%d open ports violate %s", rep.Violations, cmd.policy)}
+	for _, a := range rep.Hosts {
+		for _, name := range portNames(a.Violations) {
+			n.lines = append(n.lines, a.Host+": "+name)
+		}
+	}
+	return n
+}
+
+// audit scans host and compares its open ports with the ones p allows.
+func (cmd *auditCmd) audit(ctx context.Context, p *policy, host string) *hostAudit {
+	a := &hostAudit{Host: host, Open: []int{}, Allowed: []int{}, Violations: []int{}}
+
+	ips, err := scanner.Resolve(ctx, host, "tcp", scanner.DefaultResolveTimeout)
+	if err != nil {
+		a.Error = xerrors.Errorf("failed to resolve: %w", err).Error()
+		return a
+	}
+	a.IP = scanner.ZonedAddr(host, ips[0])
+
+	s, err := scanner.New(a.IP, scanner.Options{
+		Network:     "tcp",
+		Ports:       p.Ports,
+		Timeout:     cmd.timeout,
+		Concurrency: cmd.concurrency,
+	})
+	if err != nil {
+		a.Error = xerrors.Errorf("failed to initialize port scanner: %w", err).Error()
+		return a
+	}
+
+	res, err := s.Scan(ctx)
+	if err != nil {
+		a.Error = err.Error()
+		return a
+	}
+
+	allowed := p.allowed(host, ips[0])
+	a.Allowed = sortedPorts(allowed)
+	for _, port := range res.Open() {
+		a.Open = append(a.Open, port.Port)
+		if !allowed[port.Port] {
+			a.Violations = append(a.Violations, port.Port)
+		}
+	}
+	return a
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+
+	"github.com/spf13/pflag"
+)
+
+// privDropEnv is set for the unprivileged run dropPrivileges starts, so it
+// doesn't try to drop privileges all over again.
+const privDropEnv = "PORT_SCANNER_PRIVILEGES_DROPPED"
+
+// nobodyID is the uid and gid of nobody on systems that don't list it.
+const nobodyID = 65534
+
+func registerNoPrivDropFlag(fl *pflag.FlagSet, p *bool) {
+	fl.BoolVar(p, "no-priv-drop", false, "keep running as root for raw socket modes, instead of running as $SUDO_USER(or nobody) with only the capabilities raw sockets need, which is also who output files and the history are written as")
+}
+
+// unprivilegedUser is who a root run of a raw socket mode continues as: the
+// user that ran sudo when there is one, since they'll want to read what it
+// writes, and nobody otherwise.
+func unprivilegedUser() (uid, gid uint32, home string) {
+	uid, gid, home = nobodyID, nobodyID, "/"
+	if u, err := user.Lookup("nobody"); err == nil {
+		uid, gid, home = parseID(u.Uid, uid), parseID(u.Gid, gid), u.HomeDir
+	}
+
+	sudoUID, sudoGID := parseID(os.Getenv("SUDO_UID"), 0), parseID(os.Getenv("SUDO_GID"), 0)
+	if sudoUID == 0 {
+		return uid, gid, home
+	}
+	uid, gid, home = sudoUID, sudoGID, "/"
+	if gid == 0 {
+		gid = nobodyID
+	}
+	if u, err := user.LookupId(strconv.FormatUint(uint64(uid), 10)); err == nil {
+		home = u.HomeDir
+	}
+	return uid, gid, home
+}
+
+// parseID parses a uid or gid, it's def when s isn't one.
+func parseID(s string, def uint32) uint32 {
+	id, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return def
+	}
+	return uint32(id)
+}
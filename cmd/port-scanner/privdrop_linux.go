@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/xerrors"
+)
+
+// Capabilities from linux/capability.h.
+const (
+	capNetAdmin = 12
+	capNetRaw   = 13
+)
+
+// dropPrivileges is for commands that run as root only for the raw sockets
+// they need. Rather than parse the packets and answers of untrusted hosts as
+// root, it runs the command again as an unprivileged user that keeps the raw
+// socket capability and nothing else, plus CAP_NET_ADMIN with admin for
+// fwmarks. It returns right away in that run, when we aren't root or when
+// --no-priv-drop was given, and exits with the rerun's exit code otherwise.
+//
+// Capabilities are per thread, and with cgo in the binary the go runtime can't
+// change them for all of its threads at once, so the rerun is what leaves us
+// without root everywhere.
+func dropPrivileges(noPrivDrop, admin bool) {
+	if noPrivDrop || os.Geteuid() != 0 || os.Getenv(privDropEnv) != "" {
+		return
+	}
+
+	code, err := rerunUnprivileged(admin)
+	if err != nil {
+		log.Fatalf("failed to drop root privileges(use --no-priv-drop to keep them): %s", err)
+	}
+	os.Exit(code)
+}
+
+func rerunUnprivileged(admin bool) (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, xerrors.Errorf("failed to find our executable: %w", err)
+	}
+
+	uid, gid, home := unprivilegedUser()
+	caps := []uintptr{capNetRaw}
+	if admin {
+		caps = append(caps, capNetAdmin)
+	}
+
+	c := exec.Command(exe, os.Args[1:]...)
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	// The later HOME wins, so config files and the history default to the user's own.
+	c.Env = append(os.Environ(), privDropEnv+"=1", "HOME="+home)
+	c.SysProcAttr = &syscall.SysProcAttr{
+		Credential:  &syscall.Credential{Uid: uid, Gid: gid, Groups: []uint32{}},
+		AmbientCaps: caps,
+	}
+
+	// Ctrl+C reaches the rerun too since it's in our process group, we just
+	// stay around until it's done reporting. Anything sent to us alone is passed on.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigs)
+
+	if err := c.Start(); err != nil {
+		return 0, xerrors.Errorf("failed to run as uid %d: %w", uid, err)
+	}
+	go func() {
+		for sig := range sigs {
+			if sig != os.Interrupt {
+				_ = c.Process.Signal(sig)
+			}
+		}
+	}()
+
+	err = c.Wait()
+	var exitErr *exec.ExitError
+	if xerrors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal()), nil
+		}
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 0, xerrors.Errorf("failed to wait on the unprivileged run: %w", err)
+	}
+	return 0, nil
+}
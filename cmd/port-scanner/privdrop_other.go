@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// dropPrivileges does nothing outside linux, where the raw socket modes aren't
+// supported and there are no ambient capabilities to keep them with anyway.
+func dropPrivileges(noPrivDrop, admin bool) {}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io/ioutil"
+	"regexp"
+
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+// A probe file defines probes for protocols we don't ship one for. Every
+// probe sends its payload to the ports it lists, or to any port whose banner
+// it matches, and matches the reply against a regular expression. The named
+// groups of the match are reported as the probe's details.
+//
+//	probes:
+//	  - name: acme-rpc
+//	    ports: [7000, 7100-7110]
+//	    payload: "HELLO\r\n"
+//	    match: '^ACME-RPC/(?P<version>[0-9.]+) (?P<node>\S+)'
+//	    summary: acme rpc $version on $node
+//
+// The payload takes yaml's double quoted escapes, like "\x00", for binary
+// protocols, and leaving it out waits on whatever the service greets us with.
+type probeFile struct {
+	Probes []probeDefinition `yaml:"probes"`
+}
+
+type probeDefinition struct {
+	Name    string      `yaml:"name"`
+	Ports   policyPorts `yaml:"ports"`
+	Payload string      `yaml:"payload"`
+	Match   string      `yaml:"match"`
+	Summary string      `yaml:"summary"`
+}
+
+// loadProbeFile registers the probes defined in path and returns their names.
+func loadProbeFile(path string) ([]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read probe file: %w", err)
+	}
+
+	var f probeFile
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return nil, xerrors.Errorf("failed to parse probe file: %w", err)
+	}
+	if len(f.Probes) == 0 {
+		return nil, xerrors.New("probe file doesn't define any probes")
+	}
+
+	taken := make(map[string]bool)
+	for _, name := range scanner.ProbeNames() {
+		taken[name] = true
+	}
+
+	probes := make([]*scanner.PatternProbe, len(f.Probes))
+	names := make([]string, len(f.Probes))
+	for i, def := range f.Probes {
+		switch {
+		case def.Name == "":
+			return nil, xerrors.Errorf("probe %d doesn't have a name", i+1)
+		case def.Name == "all" || taken[def.Name]:
+			return nil, xerrors.Errorf("probe %d: %q is already taken", i+1, def.Name)
+		case def.Match == "":
+			return nil, xerrors.Errorf("probe %q doesn't say what to match", def.Name)
+		}
+		taken[def.Name] = true
+
+		pattern, err := regexp.Compile(def.Match)
+		if err != nil {
+			return nil, xerrors.Errorf("probe %q has an invalid match: %w", def.Name, err)
+		}
+
+		probes[i] = &scanner.PatternProbe{
+			ProbeName: def.Name,
+			Ports:     def.Ports,
+			Payload:   []byte(def.Payload),
+			Pattern:   pattern,
+			Summary:   def.Summary,
+		}
+		names[i] = def.Name
+	}
+
+	// Only registering once they're all valid keeps a bad file from leaving half of it behind.
+	for _, p := range probes {
+		scanner.RegisterProbe(p)
+	}
+	return names, nil
+}
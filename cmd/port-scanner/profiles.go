@@ -0,0 +1,170 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v3"
+)
+
+// builtinProfiles bundle the flags for the scans people run most often,
+// a profile of the same name in the config file replaces the built in one.
+var builtinProfiles = map[string]map[string]interface{}{
+	// quick is a first look, the most common ports with little patience for slow ones.
+	"quick": {
+		"top-ports": 100,
+		"timeout":   "500ms",
+		"retries":   0,
+	},
+	// full-tcp is every port, with a retry so a dropped SYN doesn't hide anything.
+	"full-tcp": {
+		"all":         true,
+		"timeout":     "1s",
+		"concurrency": 1024,
+		"retries":     1,
+	},
+	// web-only looks for web servers and tells us what they are.
+	"web-only": {
+		"ports":           "80,443,3000,5000,8000,8008,8080,8081,8443,8888,9000,9443",
+		"banners":         true,
+		"service-version": true,
+	},
+}
+
+func registerProfileFlag(fl *pflag.FlagSet, profile *string) {
+	fl.StringVar(profile, "profile", "", "apply a named bundle of settings from the config file or built in("+strings.Join(profileNames(nil), ", ")+")")
+}
+
+// findProfile returns the settings of the profile called name.
+func findProfile(c config, name string) (map[string]interface{}, error) {
+	if settings, ok := c["profiles"][name]; ok {
+		profile, ok := settings.(map[string]interface{})
+		if !ok {
+			return nil, xerrors.Errorf("profile %q must be a map of settings", name)
+		}
+		return profile, nil
+	}
+
+	if profile, ok := builtinProfiles[name]; ok {
+		return profile, nil
+	}
+	return nil, xerrors.Errorf("unknown profile %q(available: %s)", name, strings.Join(profileNames(c), ", "))
+}
+
+// profileNames returns the names of the built in profiles and those defined in c.
+func profileNames(c config) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for name := range builtinProfiles {
+		seen[name] = true
+		names = append(names, name)
+	}
+	for name := range c["profiles"] {
+		if !seen[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// saveProfile sets settings on the profile called name in the config file at
+// path, creating the file, its profiles section or the profile as needed.
+// The file is edited as a yaml tree rather than re-encoded from a config so
+// its comments and the order of its keys survive.
+func saveProfile(path, name string, settings map[string]interface{}) error {
+	if path == "" {
+		return xerrors.New("no config file to save to(pass --config)")
+	}
+
+	var doc yaml.Node
+	b, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return xerrors.Errorf("failed to read %q: %w", path, err)
+	}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return xerrors.Errorf("failed to parse %q: %w", path, err)
+	}
+	if doc.Kind == 0 {
+		doc.Kind = yaml.DocumentNode
+	}
+	if len(doc.Content) == 0 {
+		doc.Content = []*yaml.Node{{Kind: yaml.MappingNode}}
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return xerrors.Errorf("%q must be a map of sections", path)
+	}
+	profiles, err := mappingValue(root, "profiles")
+	if err != nil {
+		return xerrors.Errorf("invalid profiles in %q: %w", path, err)
+	}
+	profile, err := mappingValue(profiles, name)
+	if err != nil {
+		return xerrors.Errorf("invalid profiles.%s in %q: %w", name, path, err)
+	}
+	for _, key := range sortedKeys(settings) {
+		var value yaml.Node
+		if err := value.Encode(settings[key]); err != nil {
+			return xerrors.Errorf("failed to encode %s: %w", key, err)
+		}
+		setMappingValue(profile, key, &value)
+	}
+
+	var out strings.Builder
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return xerrors.Errorf("failed to encode %q: %w", path, err)
+	}
+	if err := enc.Close(); err != nil {
+		return xerrors.Errorf("failed to encode %q: %w", path, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return xerrors.Errorf("failed to create %q: %w", filepath.Dir(path), err)
+	}
+	// The config can hold an smtp password, keep a new one to ourselves.
+	if err := ioutil.WriteFile(path, []byte(out.String()), 0600); err != nil {
+		return xerrors.Errorf("failed to write %q: %w", path, err)
+	}
+	return nil
+}
+
+// mappingValue returns the map under key in m, adding an empty one if it's missing.
+func mappingValue(m *yaml.Node, key string) (*yaml.Node, error) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value != key {
+			continue
+		}
+		value := m.Content[i+1]
+		if value.Kind == yaml.ScalarNode && value.Tag == "!!null" {
+			value.Kind, value.Tag, value.Value = yaml.MappingNode, "", ""
+		}
+		if value.Kind != yaml.MappingNode {
+			return nil, xerrors.New("expected a map")
+		}
+		return value, nil
+	}
+
+	value := &yaml.Node{Kind: yaml.MappingNode}
+	setMappingValue(m, key, value)
+	return value, nil
+}
+
+// setMappingValue sets key in m to value, in place if m already has it.
+func setMappingValue(m *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			m.Content[i+1] = value
+			return
+		}
+	}
+	m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+}
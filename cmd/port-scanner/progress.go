@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+const progressInterval = 250 * time.Millisecond
+
+// isTerminal reports whether f looks like a terminal rather than a pipe or a file.
+// A progress line redrawn with carriage returns is just noise anywhere else.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// progressor is something scanning a host that can tell how far along it is,
+// a scanner.Scanner or an agentScan.
+type progressor interface {
+	Progress() (scanned, total int)
+}
+
+// watch reports on s while it scans host. In text mode every port sent on found
+// is logged right away, and on a terminal a progress line is kept drawn below them.
+// Close found once the scan is over, the returned channel is closed when watch is done.
+func (cmd *scanCmd) watch(s progressor, host string, found <-chan scanner.PortResult) <-chan struct{} {
+	done := make(chan struct{})
+	// Hosts scanned in parallel would fight over the one progress line.
+	showProgress := !cmd.noProgress && !cmd.quiet && cmd.parallelHosts <= 1 && isTerminal(os.Stderr)
+	start := time.Now()
+
+	// The progress line lives on stderr along with the log, so it has to be
+	// cleared before anything gets logged or the two end up interleaved.
+	clearLine := func() {
+		if showProgress {
+			fmt.Fprint(os.Stderr, "\r\033[K")
+		}
+	}
+	draw := func() {
+		if showProgress {
+			scanned, total := s.Progress()
+			fmt.Fprintf(os.Stderr, "\r\033[K%s", progressLine(host, scanned, total, time.Since(start)))
+		}
+	}
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case p, ok := <-found:
+				if !ok {
+					clearLine()
+					return
+				}
+
+				if cmd.onFound != nil {
+					cmd.onFound(host, p)
+				}
+
+				// The ports are in the result anyway, seeing them early is just chatter to --quiet,
+				// and against a --baseline only the ones that deviate are reported.
+				if cmd.output == "text" && !cmd.quiet && cmd.baseline == nil {
+					clearLine()
+					line := fmt.Sprintf("%s %s", net.JoinHostPort(host, strconv.Itoa(p.Port)), p.State)
+					if service := scanner.ServiceName(p.Port, cmd.protocol); service != "" {
+						line += " " + service
+					}
+					if cmd.verbose >= levelVerbose && p.Attempts > 1 {
+						line += fmt.Sprintf("(after %d attempts)", p.Attempts)
+					}
+					log.Print(line)
+					draw()
+				}
+			case <-ticker.C:
+				draw()
+			}
+		}
+	}()
+	return done
+}
+
+// progressLine renders something like "10.0.0.1: 12000/65535 ports(18%) 4000/s eta 13s".
+func progressLine(host string, scanned, total int, elapsed time.Duration) string {
+	line := fmt.Sprintf("%s: %d/%d ports", host, scanned, total)
+	if total > 0 {
+		line += fmt.Sprintf("(%d%%)", scanned*100/total)
+	}
+
+	if scanned == 0 || elapsed <= 0 {
+		return line
+	}
+
+	rate := float64(scanned) / elapsed.Seconds()
+	eta := time.Duration(float64(total-scanned) / rate * float64(time.Second))
+	return line + fmt.Sprintf(" %.0f/s eta %s", rate, eta.Round(time.Second))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/xerrors"
+)
+
+const publishTimeout = 10 * time.Second
+
+// publishedResult is the message published for every reported port. Scanner
+// is the machine that ran the scan, which is what tells a fleet's results apart
+// once they're all in the same topic.
+type publishedResult struct {
+	Scanner   string     `json:"scanner"`
+	Host      string     `json:"host"`
+	IP        string     `json:"ip"`
+	Protocol  string     `json:"protocol"`
+	Timestamp time.Time  `json:"timestamp"`
+	Port      portResult `json:"port"`
+}
+
+// message is a message to publish. Key keeps the messages about the same host
+// together on queues that partition, like kafka.
+type message struct {
+	key   string
+	value []byte
+}
+
+// publisher sends messages to a message queue.
+type publisher interface {
+	// kind is the scheme of --publish the publisher is picked by, e.g. "nats".
+	kind() string
+	publish(ctx context.Context, msgs []message) error
+}
+
+// publishers are every target of --publish.
+type publishers []publisher
+
+func registerPublishFlag(fl *pflag.FlagSet, urls *[]string) {
+	fl.StringSliceVar(urls, "publish", nil, "publish a json message for every reported port to these queues(e.g. nats://nats:4222/scans or kafka://broker1:9092,broker2:9092/scans)")
+	// The urls can carry credentials.
+	_ = fl.SetAnnotation("publish", secretAnnotation, []string{"true"})
+}
+
+// parsePublishers parses --publish, the scheme of every url picks the queue and its path the subject or topic.
+func parsePublishers(urls []string) (publishers, error) {
+	var ps publishers
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" {
+			return nil, xerrors.Errorf("%q should be a url like nats://host:4222/subject or kafka://host:9092/topic", raw)
+		}
+
+		topic := strings.TrimPrefix(u.Path, "/")
+		if topic == "" {
+			topic = "port-scanner"
+		}
+
+		switch u.Scheme {
+		case "nats":
+			p, err := newNATSPublisher(u, topic)
+			if err != nil {
+				return nil, err
+			}
+			ps = append(ps, p)
+		case "kafka":
+			p, err := newKafkaPublisher(u, topic)
+			if err != nil {
+				return nil, err
+			}
+			ps = append(ps, p)
+		default:
+			return nil, xerrors.Errorf("%q is an unsupported queue(nats or kafka)", u.Scheme)
+		}
+	}
+	return ps, nil
+}
+
+// send publishes a message for every port of rep to every publisher.
+func (ps publishers) send(ctx context.Context, rep *report) error {
+	scanner, _ := os.Hostname()
+
+	var msgs []message
+	for _, h := range rep.Hosts {
+		for _, p := range h.Ports {
+			value, err := json.Marshal(publishedResult{
+				Scanner:   scanner,
+				Host:      h.Host,
+				IP:        h.IP,
+				Protocol:  h.Protocol,
+				Timestamp: h.Timestamp,
+				Port:      p,
+			})
+			if err != nil {
+				return xerrors.Errorf("failed to encode message: %w", err)
+			}
+			msgs = append(msgs, message{key: h.IP, value: value})
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	for _, p := range ps {
+		ctx, cancel := context.WithTimeout(ctx, publishTimeout)
+		err := p.publish(ctx, msgs)
+		cancel()
+		if err != nil {
+			return xerrors.Errorf("failed to publish to %s: %w", p.kind(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// defaultRDAPServer redirects every query to the registry the address was allocated by.
+const defaultRDAPServer = "https://rdap.org"
+
+const rdapTimeout = 10 * time.Second
+
+// rdapSkipped are the special purpose ranges no registry has a record of.
+var rdapSkipped = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+		"172.16.0.0/12", "192.0.0.0/24", "192.0.2.0/24", "192.168.0.0/16", "198.18.0.0/15",
+		"198.51.100.0/24", "203.0.113.0/24", "224.0.0.0/3",
+		"::/127", "64:ff9b::/96", "2001:db8::/32", "fc00::/7", "fe80::/10", "ff00::/8",
+	} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// rdapResult is who a target's netblock belongs to and where to report abuse coming from it.
+type rdapResult struct {
+	// Netblock is the range the record covers, as a cidr when it's a single one.
+	Netblock string `json:"netblock"`
+	Name     string `json:"name,omitempty"`
+	Handle   string `json:"handle,omitempty"`
+	Owner    string `json:"owner,omitempty"`
+	Country  string `json:"country,omitempty"`
+	Abuse    string `json:"abuse,omitempty"`
+}
+
+// rdapNetwork holds the fields we read from an RDAP ip network(RFC 9083).
+type rdapNetwork struct {
+	Handle       string `json:"handle"`
+	StartAddress string `json:"startAddress"`
+	EndAddress   string `json:"endAddress"`
+	Name         string `json:"name"`
+	Country      string `json:"country"`
+	CIDRs        []struct {
+		V4Prefix string `json:"v4prefix"`
+		V6Prefix string `json:"v6prefix"`
+		Length   int    `json:"length"`
+	} `json:"cidr0_cidrs"`
+	Entities []rdapEntity `json:"entities"`
+}
+
+type rdapEntity struct {
+	Roles []string `json:"roles"`
+	// VCardArray is a jCard(RFC 7095), ["vcard", [[name, params, type, value], ...]].
+	VCardArray []json.RawMessage `json:"vcardArray"`
+	Entities   []rdapEntity      `json:"entities"`
+}
+
+// vcard returns the first text value of the property called name, e.g. "fn" or "email".
+func (e *rdapEntity) vcard(name string) string {
+	if len(e.VCardArray) < 2 {
+		return ""
+	}
+	var props [][]json.RawMessage
+	if err := json.Unmarshal(e.VCardArray[1], &props); err != nil {
+		return ""
+	}
+	for _, prop := range props {
+		if len(prop) < 4 {
+			continue
+		}
+		var propName, value string
+		if json.Unmarshal(prop[0], &propName) != nil || propName != name {
+			continue
+		}
+		if json.Unmarshal(prop[3], &value) == nil && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// findEntity returns the first entity with role, looking through the ones nested in them too.
+// Registries tend to hang the abuse contact off the registrant rather than the network.
+func findEntity(entities []rdapEntity, role string) *rdapEntity {
+	for i := range entities {
+		for _, r := range entities[i].Roles {
+			if r == role {
+				return &entities[i]
+			}
+		}
+	}
+	for i := range entities {
+		if e := findEntity(entities[i].Entities, role); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// rdapBlock is a netblock we already have the record of.
+type rdapBlock struct {
+	start, end net.IP
+	result     *rdapResult
+}
+
+// rdapLookups finds out who owns the netblocks of targets over RDAP. Lookups are made
+// one at a time and cached by netblock, registries rate limit hard and the targets of
+// a range mostly share one.
+type rdapLookups struct {
+	server string
+	client *http.Client
+
+	mu     sync.Mutex
+	blocks []rdapBlock
+}
+
+func newRDAPLookups(server string) *rdapLookups {
+	return &rdapLookups{
+		server: strings.TrimSuffix(server, "/"),
+		client: &http.Client{Timeout: rdapTimeout},
+	}
+}
+
+// lookup returns the record of the netblock holding ip,
+// nil when it's an address registries don't know about.
+func (r *rdapLookups) lookup(ctx context.Context, ip net.IP) (*rdapResult, error) {
+	if r == nil {
+		return nil, nil
+	}
+	for _, n := range rdapSkipped {
+		if n.Contains(ip) {
+			return nil, nil
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, b := range r.blocks {
+		if bytes.Compare(ip.To16(), b.start) >= 0 && bytes.Compare(ip.To16(), b.end) <= 0 {
+			return b.result, nil
+		}
+	}
+
+	network, err := r.query(ctx, ip)
+	if err != nil || network == nil {
+		return nil, err
+	}
+
+	result := &rdapResult{
+		Netblock: network.StartAddress + " - " + network.EndAddress,
+		Name:     network.Name,
+		Handle:   network.Handle,
+		Country:  network.Country,
+	}
+	if len(network.CIDRs) == 1 {
+		prefix := network.CIDRs[0].V4Prefix
+		if prefix == "" {
+			prefix = network.CIDRs[0].V6Prefix
+		}
+		result.Netblock = prefix + "/" + strconv.Itoa(network.CIDRs[0].Length)
+	}
+	if e := findEntity(network.Entities, "registrant"); e != nil {
+		result.Owner = e.vcard("fn")
+	}
+	if e := findEntity(network.Entities, "abuse"); e != nil {
+		result.Abuse = e.vcard("email")
+	}
+
+	// A record without a usable range still answers for ip, it just can't answer for its neighbours.
+	start, end := net.ParseIP(network.StartAddress), net.ParseIP(network.EndAddress)
+	if start == nil || end == nil {
+		start, end = ip, ip
+	}
+	r.blocks = append(r.blocks, rdapBlock{start: start.To16(), end: end.To16(), result: result})
+	return result, nil
+}
+
+// query fetches the ip network record of ip, following redirects to the registry holding it.
+func (r *rdapLookups) query(ctx context.Context, ip net.IP) (*rdapNetwork, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.server+"/ip/"+ip.String(), nil)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Accept", "application/rdap+json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to query rdap: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return nil, nil
+	case resp.StatusCode != http.StatusOK:
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return nil, xerrors.Errorf("rdap server answered %s", resp.Status)
+	}
+
+	var network rdapNetwork
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&network); err != nil {
+		return nil, xerrors.Errorf("failed to decode rdap record: %w", err)
+	}
+	return &network, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reverseLookupWorkers caps how many PTR lookups are in flight, so sweeping
+// a /16 doesn't flood the resolver while the scan itself is running.
+const reverseLookupWorkers = 16
+
+// reverseLookups resolves the names of targets in the background while they're being scanned.
+type reverseLookups struct {
+	mu    sync.Mutex
+	names map[string]string
+	done  map[string]chan struct{}
+}
+
+func startReverseLookups(ctx context.Context, targets []target, timeout time.Duration) *reverseLookups {
+	r := &reverseLookups{
+		names: make(map[string]string),
+		done:  make(map[string]chan struct{}),
+	}
+
+	ips := make(chan net.IP)
+	for _, t := range targets {
+		key := t.ip.String()
+		if _, ok := r.done[key]; !ok {
+			r.done[key] = make(chan struct{})
+		}
+	}
+
+	go func() {
+		defer close(ips)
+		seen := make(map[string]bool)
+		for _, t := range targets {
+			if seen[t.ip.String()] {
+				continue
+			}
+			seen[t.ip.String()] = true
+			select {
+			case ips <- t.ip:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < reverseLookupWorkers; i++ {
+		go func() {
+			for ip := range ips {
+				r.lookup(ctx, ip, timeout)
+			}
+		}()
+	}
+	return r
+}
+
+func (r *reverseLookups) lookup(ctx context.Context, ip net.IP, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// A missing PTR record is the norm rather than an error worth reporting.
+	names, _ := net.DefaultResolver.LookupAddr(ctx, ip.String())
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(names) > 0 {
+		r.names[ip.String()] = strings.TrimSuffix(names[0], ".")
+	}
+	close(r.done[ip.String()])
+}
+
+// name waits for the lookup of ip to finish and returns its name, or "" if it has none.
+func (r *reverseLookups) name(ctx context.Context, ip net.IP) string {
+	if r == nil {
+		return ""
+	}
+
+	select {
+	case <-r.done[ip.String()]:
+	case <-ctx.Done():
+		return ""
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.names[ip.String()]
+}
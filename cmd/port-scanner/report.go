@@ -0,0 +1,771 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/xerrors"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+// reportVersion is the version of the report format, bumped whenever a change
+// would break whoever reads it. Reports from before it was versioned read as 1.
+const reportVersion = 1
+
+// report is the document we emit for --output json, and the one every
+// saved result is read back as, see readReport.
+type report struct {
+	// Version is the reportVersion the report was written with.
+	Version    int       `json:"version"`
+	Invocation string    `json:"invocation"`
+	Timestamp  time.Time `json:"timestamp"`
+	Duration   duration  `json:"duration"`
+	// Interrupted is set when Ctrl+C or --max-duration cut the run short,
+	// hosts that weren't being scanned by then are left out.
+	Interrupted bool `json:"interrupted,omitempty"`
+	// DeadlineExceeded is set when it was --max-duration.
+	DeadlineExceeded bool `json:"deadline_exceeded,omitempty"`
+	// Down are the targets --skip-dead left out for not answering pings.
+	Down  []string      `json:"down,omitempty"`
+	Hosts []*hostResult `json:"hosts"`
+	// HostSummary counts the hosts by how they fared, reports from before it was added have none.
+	HostSummary *hostSummary `json:"host_summary,omitempty"`
+}
+
+// hostSummary is the host level breakdown of a run, the top-line numbers
+// of a subnet scan as opposed to the port counts of each host.
+type hostSummary struct {
+	Total int `json:"total"`
+	// Up are the hosts that answered any probe, Unreachable the ones that answered
+	// none, the hosts --skip-dead left out for not answering pings included.
+	Up          int `json:"up"`
+	Unreachable int `json:"unreachable"`
+	// Exposed are the hosts with open ports.
+	Exposed int `json:"exposed"`
+}
+
+func summarizeHosts(rep *report) *hostSummary {
+	s := &hostSummary{Total: len(rep.Hosts) + len(rep.Down), Unreachable: len(rep.Down)}
+	for _, h := range rep.Hosts {
+		if h.responded() {
+			s.Up++
+		} else {
+			s.Unreachable++
+		}
+		if h.Found > 0 {
+			s.Exposed++
+		}
+	}
+	return s
+}
+
+// logHostSummary logs s as a single line, e.g. "hosts: 256 total, 12 up, 244 unreachable, 3 with open ports".
+func logHostSummary(s *hostSummary) {
+	log.Printf("hosts: %d total, %d up, %d unreachable, %d with open ports", s.Total, s.Up, s.Unreachable, s.Exposed)
+}
+
+// hostResult is everything we found out about a single target.
+type hostResult struct {
+	Host string `json:"host"`
+	// Aliases are the other names the address was listed under, it's only scanned once.
+	Aliases []string `json:"aliases,omitempty"`
+	IP      string   `json:"ip"`
+	// Discovered are the pods, services and containers --k8s-namespace and --docker found at the address,
+	// Undeclared the open ports none of them declares and NotListening the declared ports that aren't open.
+	Discovered   []string `json:"discovered,omitempty"`
+	Undeclared   []int    `json:"undeclared,omitempty"`
+	NotListening []int    `json:"not_listening,omitempty"`
+	// PTR is the name the address resolves back to, only looked up with --resolve.
+	PTR string     `json:"ptr,omitempty"`
+	Geo *geoResult `json:"geo,omitempty"`
+	// RDAP is who owns the netblock of the address, only looked up with --rdap.
+	RDAP      *rdapResult `json:"rdap,omitempty"`
+	Protocol  string      `json:"protocol"`
+	Timestamp time.Time   `json:"timestamp"`
+	Duration  duration    `json:"duration"`
+	// Found is the number of open ports, Ports may hold fewer when --fastest is set.
+	Found        int          `json:"found"`
+	Ports        []portResult `json:"ports"`
+	Unscanned    []int        `json:"unscanned,omitempty"`
+	ScannedPorts int          `json:"scanned_ports"`
+	// TotalPorts is the number of ports we'd have scanned without --sample.
+	TotalPorts int `json:"total_ports"`
+	// Interrupted means the scan was cancelled part way through and Ports is partial.
+	Interrupted bool `json:"interrupted,omitempty"`
+	// DeadlineExceeded means it was cancelled by --max-duration running out.
+	DeadlineExceeded bool `json:"deadline_exceeded,omitempty"`
+	// Incomplete means --host-timeout ran out on the host, the ports it didn't get to are in Unscanned.
+	Incomplete bool      `json:"incomplete,omitempty"`
+	OS         *osResult `json:"os,omitempty"`
+	OSError    string    `json:"os_error,omitempty"`
+	// Latency sums up how long the open ports took to connect to.
+	Latency *latencyResult `json:"latency,omitempty"`
+	// Failures counts the probes that failed by how they failed, e.g. "timeout" or "refused".
+	Failures map[string]int `json:"failures,omitempty"`
+	// Probes counts the probes sent, Retries the ones that went out again after getting no answer.
+	Probes  int `json:"probes,omitempty"`
+	Retries int `json:"retries,omitempty"`
+	// RTT is the round trip --auto-timeout measured and Timeout what it derived from it.
+	RTT     duration `json:"rtt,omitempty"`
+	Timeout duration `json:"timeout,omitempty"`
+	// Verify is what --verify made of the open ports.
+	Verify *verifyResult `json:"verify,omitempty"`
+	// Error is why the scan stopped before getting through every port, if it did.
+	Error string `json:"error,omitempty"`
+}
+
+// verifyResult lists the open ports --verify dropped, and how many closed
+// ports it connected to again along with the ones among them that accepted.
+type verifyResult struct {
+	Dropped  []int `json:"dropped,omitempty"`
+	Sampled  int   `json:"sampled"`
+	Reopened []int `json:"reopened,omitempty"`
+}
+
+type latencyResult struct {
+	Min    duration `json:"min"`
+	Median duration `json:"median"`
+	P90    duration `json:"p90"`
+	Max    duration `json:"max"`
+	// Histogram only holds the buckets that have ports in them.
+	Histogram []latencyBucket `json:"histogram"`
+}
+
+// latencyBucket counts the ports that connected within Within but not
+// within the bucket before it. The last bucket has no bound.
+type latencyBucket struct {
+	Within *duration `json:"within,omitempty"`
+	Ports  int       `json:"ports"`
+}
+
+func newLatencyResult(s *scanner.LatencySummary) *latencyResult {
+	if s == nil {
+		return nil
+	}
+
+	r := &latencyResult{
+		Min:    duration(s.Min),
+		Median: duration(s.Median),
+		P90:    duration(s.P90),
+		Max:    duration(s.Max),
+	}
+	for i, n := range s.Counts {
+		if n == 0 {
+			continue
+		}
+		b := latencyBucket{Ports: n}
+		if i < len(scanner.LatencyBuckets) {
+			within := duration(scanner.LatencyBuckets[i])
+			b.Within = &within
+		}
+		r.Histogram = append(r.Histogram, b)
+	}
+	return r
+}
+
+// histogram lays the buckets out on a single line, e.g. "<=1ms:3 <=50ms:1 >1s:2".
+func (r *latencyResult) histogram() string {
+	buckets := make([]string, len(r.Histogram))
+	for i, b := range r.Histogram {
+		if b.Within != nil {
+			buckets[i] = fmt.Sprintf("<=%s:%d", b.Within, b.Ports)
+		} else {
+			buckets[i] = fmt.Sprintf(">%s:%d", duration(scanner.LatencyBuckets[len(scanner.LatencyBuckets)-1]), b.Ports)
+		}
+	}
+	return strings.Join(buckets, " ")
+}
+
+// osResult is the os family guessed for the host along with the fingerprint it's based on.
+type osResult struct {
+	Family string `json:"family"`
+	// Confidence is between 0 and 1.
+	Confidence float64 `json:"confidence"`
+	TTL        int     `json:"ttl"`
+	InitialTTL int     `json:"initial_ttl"`
+	Window     int     `json:"window"`
+	Options    string  `json:"options"`
+}
+
+type portResult struct {
+	Port int `json:"port"`
+	// Service is the well-known name of the port, not what we found listening on it.
+	Service    string        `json:"service,omitempty"`
+	State      scanner.State `json:"state"`
+	Latency    duration      `json:"latency,omitempty"`
+	Attempts   int           `json:"attempts,omitempty"`
+	Suspicious bool          `json:"suspicious,omitempty"`
+	// Failure is how the last probe failed for ports that aren't open, e.g. "timeout" or "unreachable".
+	Failure string `json:"failure,omitempty"`
+
+	GuessedProtocol  string `json:"guessed_protocol,omitempty"`
+	MatchedSignature string `json:"matched_signature,omitempty"`
+	GuessError       string `json:"guess_error,omitempty"`
+	Banner           string `json:"banner,omitempty"`
+
+	Version *versionResult `json:"version,omitempty"`
+	// Vulns are the known advisories --vulns found for Version.
+	Vulns []vulnResult `json:"vulns,omitempty"`
+
+	AuthService  string `json:"auth_service,omitempty"`
+	RequiresAuth *bool  `json:"requires_auth,omitempty"`
+	AuthError    string `json:"auth_error,omitempty"`
+
+	HTTP      *httpResult `json:"http,omitempty"`
+	HTTPError string      `json:"http_error,omitempty"`
+
+	TLS      *tlsResult `json:"tls,omitempty"`
+	TLSError string     `json:"tls_error,omitempty"`
+
+	Probes []probeResult `json:"probes,omitempty"`
+
+	// Script holds whatever the --script found out about the port.
+	Script      map[string]interface{} `json:"script,omitempty"`
+	ScriptError string                 `json:"script_error,omitempty"`
+}
+
+// versionResult is the product --service-version identified on a port.
+type versionResult struct {
+	Product string `json:"product"`
+	Version string `json:"version,omitempty"`
+	Info    string `json:"info,omitempty"`
+}
+
+// vulnResult is an advisory the version identified on a port is possibly affected by.
+type vulnResult struct {
+	ID       string `json:"id"`
+	Severity string `json:"severity"`
+	Summary  string `json:"summary,omitempty"`
+}
+
+// String reads like nmap's version column, e.g. "OpenSSH 9.6p1 (Ubuntu 3ubuntu13; protocol 2.0)".
+func (v *versionResult) String() string {
+	s := v.Product
+	if v.Version != "" {
+		s += " " + v.Version
+	}
+	if v.Info != "" {
+		s += " (" + v.Info + ")"
+	}
+	return s
+}
+
+// probeResult is the outcome of one of the --probes that matched the port.
+type probeResult struct {
+	Name    string            `json:"name"`
+	Summary string            `json:"summary,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+	Error   string            `json:"error,omitempty"`
+}
+
+type httpResult struct {
+	Scheme   string `json:"scheme"`
+	Status   int    `json:"status"`
+	Server   string `json:"server,omitempty"`
+	Location string `json:"location,omitempty"`
+	Title    string `json:"title,omitempty"`
+}
+
+// tlsExpiryWarning is how close to expiring a certificate gets called out in text output.
+const tlsExpiryWarning = 30 * 24 * time.Hour
+
+type tlsResult struct {
+	Version     string    `json:"version"`
+	CipherSuite string    `json:"cipher_suite"`
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	SANs        []string  `json:"sans,omitempty"`
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+	SelfSigned  bool      `json:"self_signed,omitempty"`
+	VerifyError string    `json:"verify_error,omitempty"`
+}
+
+func newTLSResult(info scanner.TLSInfo) *tlsResult {
+	return &tlsResult{
+		Version:     info.Version,
+		CipherSuite: info.CipherSuite,
+		Subject:     info.Subject,
+		Issuer:      info.Issuer,
+		SANs:        info.SANs,
+		NotBefore:   info.NotBefore.UTC(),
+		NotAfter:    info.NotAfter.UTC(),
+		SelfSigned:  info.SelfSigned,
+		VerifyError: info.VerifyError,
+	}
+}
+
+// duration marshals as a Go duration string like "1.5s".
+type duration time.Duration
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+func (d duration) String() string { return time.Duration(d).String() }
+
+// name renders p as its number followed by its well-known service name if it has one.
+func (p portResult) name() string {
+	if p.Service == "" {
+		return strconv.Itoa(p.Port)
+	}
+	return fmt.Sprintf("%d(%s)", p.Port, p.Service)
+}
+
+func (r *hostResult) sampled() bool { return r.ScannedPorts < r.TotalPorts }
+
+// unreported is how many of the scanned ports were left out of Ports for not
+// being reachable, ports --fastest left out and ports never scanned don't count.
+func (r *hostResult) unreported() int {
+	return r.ScannedPorts - len(r.Ports) - (r.Found - len(r.portsIn(scanner.StateOpen))) - len(r.Unscanned)
+}
+
+// hidden splits the unreported ports into the ones that refused us
+// and the ones that didn't answer or were blocked on the way.
+func (r *hostResult) hidden() (closed, filtered int) {
+	unreported := r.unreported()
+	closed = r.Failures["refused"] - len(r.portsIn(scanner.StateClosed))
+	switch {
+	case closed < 0:
+		closed = 0
+	case closed > unreported:
+		closed = unreported
+	}
+	return closed, unreported - closed
+}
+
+// responded reports whether any probe got an answer out of the host,
+// a port refusing us tells it's up as much as one accepting does.
+func (r *hostResult) responded() bool {
+	closed, _ := r.hidden()
+	return r.Found > 0 || closed > 0 || len(r.portsIn(scanner.StateClosed)) > 0 || len(r.portsIn(scanner.StateUnfiltered)) > 0
+}
+
+func (r *hostResult) portsIn(state scanner.State) []int {
+	var ports []int
+	for _, p := range r.Ports {
+		if p.State == state {
+			ports = append(ports, p.Port)
+		}
+	}
+	return ports
+}
+
+// logStats sums up how the scan of r went, so a host that's quiet can be
+// told apart from one whose probes are being dropped on the way.
+func (cmd *scanCmd) logStats(r *hostResult) {
+	name := hostKey(r)
+	closed, filtered := r.hidden()
+	closed += len(r.portsIn(scanner.StateClosed))
+	filtered += len(r.portsIn(scanner.StateFiltered))
+	states := []string{fmt.Sprintf("%d open", r.Found), fmt.Sprintf("%d closed", closed), fmt.Sprintf("%d filtered", filtered)}
+	if n := len(r.portsIn(scanner.StateOpenFiltered)); n > 0 {
+		states = append(states, fmt.Sprintf("%d open|filtered", n))
+	}
+	if n := len(r.portsIn(scanner.StateUnfiltered)); n > 0 {
+		states = append(states, fmt.Sprintf("%d unfiltered", n))
+	}
+	if n := len(r.portsIn(scanner.StateClosedFiltered)); n > 0 {
+		states = append(states, fmt.Sprintf("%d closed|filtered", n))
+	}
+	cmd.infof("%s: states: %s", name, strings.Join(states, ", "))
+
+	if len(r.Failures) > 0 {
+		outcomes := make([]string, 0, len(r.Failures))
+		for outcome := range r.Failures {
+			outcomes = append(outcomes, outcome)
+		}
+		sort.Strings(outcomes)
+		for i, outcome := range outcomes {
+			outcomes[i] = fmt.Sprintf("%d %s", r.Failures[outcome], outcome)
+		}
+		cmd.infof("%s: failures: %s", name, strings.Join(outcomes, ", "))
+	}
+
+	if r.Probes > 0 {
+		line := fmt.Sprintf("probes: %d sent in %s", r.Probes, time.Duration(r.Duration).Round(time.Millisecond))
+		if secs := time.Duration(r.Duration).Seconds(); secs > 0 {
+			line += fmt.Sprintf("(%.0f/s)", float64(r.Probes)/secs)
+		}
+		line += fmt.Sprintf(", %d retries(%.1f%%)", r.Retries, 100*float64(r.Retries)/float64(r.Probes))
+		cmd.infof("%s: %s", name, line)
+	}
+
+	// Firewalls drop probes, routers only answer them with unreachables when
+	// the path to the host is broken.
+	if unreachable := r.Failures["unreachable"]; unreachable > 0 {
+		log.Printf("warning: %s: %d probes came back unreachable, the network path to it looks broken rather than firewalled", name, unreachable)
+	}
+
+	var late int
+	for _, p := range r.Ports {
+		if p.Attempts > 1 {
+			late++
+		}
+	}
+	if late > 0 {
+		log.Printf("warning: %s: %d ports only answered a retry, probes are being dropped or rate limited on the way(try a lower --max-rate)", name, late)
+	}
+}
+
+// logResult renders r as plain log lines, which is what --output text gives you.
+func (cmd *scanCmd) logResult(r *hostResult) {
+	// Every line names the host, -q on several targets leaves little else to tell them apart by.
+	name := hostKey(r)
+	if len(r.Aliases) > 0 {
+		log.Printf("%s is also listed as %s", r.Host, strings.Join(r.Aliases, ", "))
+	}
+	if len(r.Discovered) > 0 {
+		log.Printf("%s is %s", r.IP, strings.Join(r.Discovered, ", "))
+	}
+	if r.PTR != "" && r.PTR != r.Host {
+		log.Printf("%s resolves back to %s", r.IP, r.PTR)
+	}
+
+	if r.Geo != nil {
+		var where []string
+		if r.Geo.Country != "" {
+			where = append(where, r.Geo.Country)
+		}
+		if r.Geo.ASN != 0 {
+			where = append(where, fmt.Sprintf("AS%d %s", r.Geo.ASN, r.Geo.Org))
+		}
+		log.Printf("%s is in %s", r.IP, strings.Join(where, ", "))
+	}
+
+	if r.RDAP != nil {
+		owner := r.RDAP.Owner
+		if owner == "" {
+			owner = r.RDAP.Name
+		}
+		line := fmt.Sprintf("%s is in %s owned by %s", r.IP, r.RDAP.Netblock, owner)
+		if r.RDAP.Abuse != "" {
+			line += ", report abuse to " + r.RDAP.Abuse
+		}
+		log.Printf("%s: %s", name, line)
+	}
+
+	if r.DeadlineExceeded {
+		log.Printf("%s: --max-duration ran out after %s of scanning, showing the ports found so far", name, r.Duration)
+	} else if r.Interrupted {
+		log.Printf("%s: scan interrupted after %s, showing the ports found so far", name, r.Duration)
+	} else {
+		cmd.infof("%s: scan completed in %s", name, r.Duration)
+	}
+
+	if openFiltered := r.portsIn(scanner.StateOpenFiltered); len(openFiltered) > 0 {
+		log.Printf("%s: %d ports didn't answer and are open or filtered", name, len(openFiltered))
+		log.Printf("%s: open|filtered-ports: %v", name, openFiltered)
+	}
+
+	if unfiltered := r.portsIn(scanner.StateUnfiltered); len(unfiltered) > 0 {
+		log.Printf("%s: %d ports answered our ACKs and are unfiltered", name, len(unfiltered))
+		log.Printf("%s: unfiltered-ports: %v", name, unfiltered)
+	}
+
+	if closed := r.portsIn(scanner.StateClosed); len(closed) > 0 {
+		log.Printf("%s: %d ports refused the connection and are closed", name, len(closed))
+		log.Printf("%s: closed-ports: %v", name, closed)
+	}
+
+	if filtered := r.portsIn(scanner.StateFiltered); len(filtered) > 0 {
+		log.Printf("%s: %d ports didn't answer or were blocked and are filtered", name, len(filtered))
+		log.Printf("%s: filtered-ports: %v", name, filtered)
+	}
+
+	// Without --all-states we can still tell a host refusing everything from
+	// a firewall dropping everything by how the ports we left out failed.
+	if closed, filtered := r.hidden(); closed > 0 || filtered > 0 {
+		cmd.infof("%s: not shown: %d closed, %d filtered ports", name, closed, filtered)
+	}
+
+	cmd.logStats(r)
+
+	if exhausted := r.Failures["exhausted"]; exhausted > 0 {
+		log.Printf("warning: %s: ran out of sockets or local ports dialing %d ports, they're left unscanned(lower --concurrency or raise the open file limit)", name, exhausted)
+	}
+
+	if len(r.Unscanned) > 0 {
+		if r.Incomplete {
+			log.Printf("%s: --host-timeout ran out after scanning %d/%d ports", name, r.ScannedPorts-len(r.Unscanned), r.ScannedPorts)
+		} else if cmd.maxConnections > 0 {
+			log.Printf("%s: connection budget of %d reached after scanning %d/%d ports", name, cmd.maxConnections, r.ScannedPorts-len(r.Unscanned), r.ScannedPorts)
+		}
+		log.Printf("%s: unscanned-ports: %v", name, r.Unscanned)
+	}
+
+	if r.sampled() {
+		log.Printf("note: %s: results are from a sample of %d/%d ports", name, r.ScannedPorts, r.TotalPorts)
+	}
+
+	if len(r.NotListening) > 0 {
+		log.Printf("warning: %s: nothing listens on %d ports declared by %s: %v", name, len(r.NotListening), strings.Join(r.Discovered, ", "), r.NotListening)
+	}
+
+	if r.Found == 0 {
+		log.Printf("%q has no exposed ports", r.Host)
+		return
+	}
+	log.Printf("%s: found %d open ports", name, r.Found)
+
+	open := r.portsIn(scanner.StateOpen)
+	if cmd.fastest > 0 {
+		var fastest []string
+		for _, p := range r.Ports {
+			if p.State == scanner.StateOpen {
+				fastest = append(fastest, fmt.Sprintf("%d(%s)", p.Port, p.Latency))
+			}
+		}
+		log.Printf("%s: fastest-ports: %s", name, strings.Join(fastest, " "))
+	} else {
+		var named []string
+		for _, p := range r.Ports {
+			if p.State == scanner.StateOpen {
+				named = append(named, p.name())
+			}
+		}
+		log.Printf("%s: open-ports: [%s]", name, strings.Join(named, " "))
+	}
+
+	if len(r.Undeclared) > 0 {
+		log.Printf("warning: %s: %d open ports aren't declared by %s: %v", name, len(r.Undeclared), strings.Join(r.Discovered, ", "), r.Undeclared)
+	}
+
+	if r.Latency != nil {
+		cmd.infof("%s: latency: min %s, median %s, p90 %s, max %s", name, r.Latency.Min, r.Latency.Median, r.Latency.P90, r.Latency.Max)
+		cmd.infof("%s: latency-histogram: %s", name, r.Latency.histogram())
+	}
+
+	for _, p := range r.Ports {
+		if p.Attempts > 1 {
+			cmd.verbosef("%s: %d: only answered on attempt %d, the target may be dropping or rate limiting probes", name, p.Port, p.Attempts)
+		}
+	}
+
+	for _, p := range r.Ports {
+		if p.Suspicious {
+			log.Printf("%s: %d: suspicious, connected in %s(below --min-latency %s), possibly a middlebox or transparent proxy", name, p.Port, p.Latency, cmd.minLatency)
+		}
+	}
+
+	if cmd.guessProtocol || cmd.banners {
+		for _, p := range r.Ports {
+			switch {
+			case p.State != scanner.StateOpen:
+			case p.GuessError != "":
+				log.Printf("%s: %d: failed to guess protocol: %s", name, p.Port, p.GuessError)
+			case p.GuessedProtocol == "":
+				log.Printf("%s: %d: unknown protocol", name, p.Port)
+			default:
+				log.Printf("%s: %d: %s(matched %s)", name, p.Port, p.GuessedProtocol, p.MatchedSignature)
+			}
+		}
+	}
+
+	if cmd.banners {
+		for _, p := range r.Ports {
+			if p.Banner != "" {
+				log.Printf("%s: %d: banner %q", name, p.Port, p.Banner)
+			}
+		}
+	}
+
+	if cmd.serviceVersion {
+		for _, p := range r.Ports {
+			if p.Version != nil {
+				log.Printf("%s: %d: version %s", name, p.Port, p.Version)
+			}
+			for _, v := range p.Vulns {
+				log.Printf("%s: %d: possibly affected by %s(%s): %s", name, p.Port, v.ID, v.Severity, v.Summary)
+			}
+		}
+	}
+
+	for _, p := range r.Ports {
+		switch {
+		case p.AuthService == "":
+		case p.AuthError != "":
+			log.Printf("%s: %d/%s: failed to check authentication: %s", name, p.Port, p.AuthService, p.AuthError)
+		case *p.RequiresAuth:
+			log.Printf("%s: %d/%s: authentication required", name, p.Port, p.AuthService)
+		default:
+			log.Printf("%s: %d/%s: unauthenticated access possible", name, p.Port, p.AuthService)
+		}
+	}
+
+	for _, p := range r.Ports {
+		switch {
+		case p.HTTP != nil:
+			line := fmt.Sprintf("%d: %s %d", p.Port, p.HTTP.Scheme, p.HTTP.Status)
+			if p.HTTP.Server != "" {
+				line += fmt.Sprintf(", server %q", p.HTTP.Server)
+			}
+			if p.HTTP.Title != "" {
+				line += fmt.Sprintf(", title %q", p.HTTP.Title)
+			}
+			if p.HTTP.Location != "" {
+				line += ", redirects to " + p.HTTP.Location
+			}
+			log.Printf("%s: %s", name, line)
+		case p.HTTPError != "":
+			cmd.verbosef("%s: %d: no http: %s", name, p.Port, p.HTTPError)
+		}
+	}
+
+	for _, p := range r.Ports {
+		switch {
+		case p.TLS != nil:
+			cmd.logTLS(name, p.Port, p.TLS)
+		case p.TLSError != "":
+			// Most open ports don't speak tls at all, so this is only worth mentioning when asked.
+			cmd.verbosef("%s: %d: no tls: %s", name, p.Port, p.TLSError)
+		}
+	}
+
+	for _, p := range r.Ports {
+		for _, probe := range p.Probes {
+			if probe.Error != "" {
+				log.Printf("%s: %d/%s: probe failed: %s", name, p.Port, probe.Name, probe.Error)
+				continue
+			}
+			log.Printf("%s: %d/%s: %s", name, p.Port, probe.Name, probe.Summary)
+
+			keys := make([]string, 0, len(probe.Details))
+			for k := range probe.Details {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				cmd.verbosef("%s: %d/%s: %s=%s", name, p.Port, probe.Name, k, probe.Details[k])
+			}
+		}
+	}
+
+	for _, p := range r.Ports {
+		if p.ScriptError != "" {
+			log.Printf("%s: %d/script: failed: %s", name, p.Port, p.ScriptError)
+		}
+		for _, k := range scriptFindings(p.Script) {
+			log.Printf("%s: %d/script: %s=%v", name, p.Port, k, p.Script[k])
+		}
+	}
+
+	switch {
+	case r.OS != nil:
+		log.Printf("%s: os: %s(%.0f%% confidence, ttl %d of %d, window %d, options %s)", name, r.OS.Family, r.OS.Confidence*100, r.OS.TTL, r.OS.InitialTTL, r.OS.Window, r.OS.Options)
+	case r.OSError != "":
+		log.Printf("%s: os: failed to detect: %s", name, r.OSError)
+	}
+
+	if r.sampled() {
+		estimate := len(open) * r.TotalPorts / r.ScannedPorts
+		log.Printf("%s: extrapolated: roughly %d of %d ports may be open", name, estimate, r.TotalPorts)
+	}
+}
+
+// logTLS logs what the handshake with port of the host name found, calling out certificates that need attention.
+func (cmd *scanCmd) logTLS(name string, port int, t *tlsResult) {
+	log.Printf("%s: %d: %s %s, subject %q issued by %q", name, port, t.Version, t.CipherSuite, t.Subject, t.Issuer)
+	if len(t.SANs) > 0 {
+		log.Printf("%s: %d: valid for %s", name, port, strings.Join(t.SANs, ", "))
+	}
+
+	left := time.Until(t.NotAfter)
+	switch {
+	case left <= 0:
+		log.Printf("%s: %d: certificate expired on %s", name, port, t.NotAfter.Format("2006-01-02"))
+	case left < tlsExpiryWarning:
+		log.Printf("%s: %d: certificate expires on %s, in %d days", name, port, t.NotAfter.Format("2006-01-02"), int(left.Hours()/24))
+	default:
+		cmd.verbosef("%s: %d: certificate expires on %s", name, port, t.NotAfter.Format("2006-01-02"))
+	}
+
+	if t.SelfSigned {
+		log.Printf("%s: %d: certificate is self-signed", name, port)
+	} else if t.VerifyError != "" {
+		log.Printf("%s: %d: certificate isn't trusted: %s", name, port, t.VerifyError)
+	}
+}
+
+func writeJSON(w io.Writer, rep *report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rep)
+}
+
+// saveReport writes rep to path as json, the same document --output json prints.
+func saveReport(path string, rep *report) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return xerrors.Errorf("failed to create %q: %w", path, err)
+	}
+
+	if err := writeJSON(f, rep); err != nil {
+		f.Close()
+		return xerrors.Errorf("failed to write %q: %w", path, err)
+	}
+	return f.Close()
+}
+
+// readReport reads a report saved with --save or --output json,
+// or the xml output of nmap -oX.
+func readReport(path string) (*report, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to open %q: %w", path, err)
+	}
+	defer f.Close()
+
+	// Our reports are json objects, so anything starting out like xml is nmap's.
+	r := bufio.NewReader(f)
+	var first byte
+	for {
+		if first, err = r.ReadByte(); err != nil || !unicode.IsSpace(rune(first)) {
+			break
+		}
+	}
+	_ = r.UnreadByte()
+	if first == '<' {
+		rep, err := readNmapXML(r)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to parse %q as nmap xml: %w", path, err)
+		}
+		return rep, nil
+	}
+
+	var rep report
+	if err := json.NewDecoder(r).Decode(&rep); err != nil {
+		return nil, xerrors.Errorf("failed to parse %q: %w", path, err)
+	}
+	if rep.Version == 0 {
+		rep.Version = 1
+	}
+	if rep.Version > reportVersion {
+		return nil, xerrors.Errorf("%q was written by a newer port-scanner(report version %d, this one reads up to %d)", path, rep.Version, reportVersion)
+	}
+	return &rep, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/xerrors"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+// rescan is what --rescan-from found in the saved report.
+type rescan struct {
+	// specs are the targets to scan, every host that had open ports by its address along with those ports.
+	specs    []string
+	protocol string
+	// names are the names the addresses were scanned by, so the fresh results line up with the old ones in diff.
+	names map[string]string
+}
+
+// loadRescan reads the report saved at path, by --save, --output json or nmap -oX,
+// and works out the hosts and open ports it holds.
+func loadRescan(path string) (*rescan, error) {
+	rep, err := readReport(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &rescan{names: make(map[string]string)}
+	for _, h := range rep.Hosts {
+		var open []string
+		for _, p := range h.Ports {
+			if p.State == scanner.StateOpen {
+				open = append(open, strconv.Itoa(p.Port))
+			}
+		}
+		if len(open) == 0 {
+			continue
+		}
+
+		protocol := h.Protocol
+		if protocol == "" {
+			protocol = "tcp"
+		}
+		if r.protocol != "" && r.protocol != protocol {
+			return nil, xerrors.Errorf("%q mixes %s and %s results, rescan them one protocol at a time", path, r.protocol, protocol)
+		}
+		r.protocol = protocol
+
+		// An ipv6 address takes brackets to have ports, see splitTargetPorts.
+		addr := h.IP
+		if strings.Contains(addr, ":") {
+			addr = "[" + addr + "]"
+		}
+		r.specs = append(r.specs, addr+":"+strings.Join(open, ","))
+		if h.Host != h.IP {
+			r.names[h.IP] = h.Host
+		}
+	}
+
+	if len(r.specs) == 0 {
+		return nil, xerrors.Errorf("%q has no open ports to rescan", path)
+	}
+	return r, nil
+}
+
+// applyRescan reads --rescan-from and scans with the protocol the report was
+// scanned with, unless --protocol asks for that same one anyway.
+func (cmd *scanCmd) applyRescan(fl *pflag.FlagSet) error {
+	if cmd.rescanFrom == "" {
+		return nil
+	}
+
+	// The report's ports are the only ones there are to scan.
+	for _, name := range []string{"ports", "all", "top-ports"} {
+		if fl.Changed(name) {
+			return xerrors.Errorf("can't be used with --%s, the ports the report found open are what gets scanned", name)
+		}
+	}
+
+	r, err := loadRescan(cmd.rescanFrom)
+	if err != nil {
+		return err
+	}
+
+	if fl.Changed("protocol") && cmd.protocol != r.protocol {
+		return xerrors.Errorf("--protocol %s doesn't match the %s scan of %q", cmd.protocol, r.protocol, cmd.rescanFrom)
+	}
+	cmd.protocol = r.protocol
+	cmd.rescan = r
+	return nil
+}
+
+// name returns the name the report scanned addr by, a nil rescan has none.
+func (r *rescan) name(addr string) (string, bool) {
+	if r == nil {
+		return "", false
+	}
+	name, ok := r.names[addr]
+	return name, ok
+}
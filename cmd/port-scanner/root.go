@@ -20,5 +20,22 @@ func (r *root) Spec() cli.CommandSpec {
 func (r *root) Subcommands() []cli.Command {
 	return []cli.Command{
 		new(scanCmd),
+		new(doctorCmd),
+		new(watchCmd),
+		new(diffCmd),
+		new(showCmd),
+		new(reportCmd),
+		new(auditCmd),
+		new(historyCmd),
+		new(serveCmd),
+		new(webCmd),
+		new(discoverCmd),
+		new(localCmd),
+		new(calibrateCmd),
+		new(traceCmd),
+		new(agentCmd),
+		new(tuiCmd),
+		new(updateCmd),
+		new(completionCmd),
 	}
 }
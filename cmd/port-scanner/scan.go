@@ -2,28 +2,160 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"math"
 	"net"
-	"strconv"
+	"os"
+	"os/signal"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/spf13/pflag"
 	"go.coder.com/cli"
 	"golang.org/x/xerrors"
-)
 
-const (
-	wellKnownPorts = 1024
-	allPorts       = 65535
+	"github.com/fuskovic/port-scanner/pkg/scanner"
 )
 
-var timeout = 3 * time.Second
+// defaultParallelHosts is how many hosts are scanned at once without --host-concurrency.
+const defaultParallelHosts = 4
 
 // This time our command struct has a few fields, we can use these to store flag values.
 type scanCmd struct {
-	host          string
-	shouldScanAll bool
+	host            string
+	targetsFile     string
+	rescanFrom      string
+	rescan          *rescan
+	srvDomains      []string
+	srvServices     []string
+	sources         []string
+	k8s             k8sDiscovery
+	docker          dockerDiscovery
+	discovered      discovered
+	exclude         []string
+	shouldScanAll   bool
+	ipv4Only        bool
+	ipv6Only        bool
+	confirm         map[string]string
+	resolveTimeout  time.Duration
+	sample          int
+	seed            int64
+	randomize       bool
+	retries         int
+	retryDelay      time.Duration
+	retryJitter     float64
+	retryBackoff    float64
+	verbose         int
+	logFormat       string
+	quiet           bool
+	failOnOpen      bool
+	checkAuth       bool
+	tlsProbe        bool
+	probeNames      []string
+	probeFile       string
+	probes          []scanner.Probe
+	scriptPath      string
+	allStates       bool
+	script          *script
+	httpProbe       bool
+	osDetect        bool
+	reverseDNS      bool
+	geoIPDBs        []string
+	rdap            bool
+	rdapServer      string
+	sourceIPs       []string
+	sourceIP        string
+	iface           string
+	fwmark          int
+	noPrivDrop      bool
+	fast            bool
+	rawErrors       bool
+	maxConnections  int64
+	maxRate         float64
+	minRate         float64
+	jitterRange     string
+	jitter          *scanner.Jitter
+	guessProtocol   bool
+	serviceVersion  bool
+	vulns           bool
+	vulnDB          string
+	advisories      *scanner.AdvisoryDB
+	fastest         int
+	auditLog        string
+	minLatency      time.Duration
+	verify          bool
+	verifySample    int
+	addresses       string
+	protocol        string
+	ports           string
+	topPorts        int
+	excludePorts    string
+	output          string
+	outputTemplate  string
+	out             outFile
+	outDirPath      string
+	outDir          *outDir
+	save            string
+	baselinePath    string
+	baseline        *report
+	mergeScans      int
+	checkpoint      string
+	resume          string
+	checkpoints     *checkpointFile
+	record          bool
+	historyDB       string
+	retention       historyRetention
+	timeout         time.Duration
+	autoTimeout     bool
+	hostTimeout     time.Duration
+	skipDead        bool
+	pingMethods     []string
+	ping            scanner.DiscoverOptions
+	maxDuration     time.Duration
+	concurrency     int
+	hostConcurrency int
+	adaptive        bool
+	raiseFDLimit    bool
+	dryRun          bool
+	// parallelHosts is how many targets are scanned at once.
+	parallelHosts int
+	syn           bool
+	stateless     bool
+	fin           bool
+	null          bool
+	xmas          bool
+	ack           bool
+	flagScan      scanner.FlagScan
+	zombieSpec    string
+	zombie        *scanner.Zombie
+	decoySpecs    []string
+	decoys        scanner.Decoys
+	ipHeader      scanner.IPHeader
+	proxy         string
+	sshJump       sshJump
+	agentFlags    agentFlags
+	agents        *agentPool
+	banners       bool
+	noProgress    bool
+	noColor       bool
+	table         bool
+	webhook       webhook
+	notifySpecs   []string
+	syslog        syslogSink
+	notifiers     notifiers
+	publishURLs   []string
+	publishers    publishers
+	config        string
+	profile       string
+	timing        string
+
+	// onFound is called with every port of host as soon as it turns out to be reachable.
+	onFound func(host string, p scanner.PortResult)
 }
 
 // cdr/cli supports subcommand aliases so lets define one in our
@@ -40,111 +172,1649 @@ func (cmd *scanCmd) Spec() cli.CommandSpec {
 // When adding flags, use the following method-signature to implement FlaggedCommand as defined by cdr/cli.
 // See https://pkg.go.dev/go.coder.com/cli#FlaggedCommand for more details.
 func (cmd *scanCmd) RegisterFlags(fl *pflag.FlagSet) {
-	fl.StringVar(&cmd.host, "host", "", "host to scan(ip address)")
+	fl.StringVar(&cmd.host, "host", "", "host to scan(ip address, hostname or cidr range)")
+	fl.StringVar(&cmd.targetsFile, "targets-file", "", "file of newline-delimited hosts to scan, each optionally with its own ports(e.g. 10.0.0.5:22,80,443 or [::1]:8000-8100; - reads stdin)")
+	fl.StringVar(&cmd.rescanFrom, "rescan-from", "", "scan the hosts of a saved report(--save, --output json or nmap -oX) again on just the ports it found open, to diff against it and see whether the findings still stand")
+	fl.StringSliceVar(&cmd.srvDomains, "srv", nil, "scan the hosts and ports the SRV records of these domains point at, e.g. the domain controllers of an AD domain or the sip servers of a voip deployment(e.g. corp.example.com)")
+	fl.StringSliceVar(&cmd.srvServices, "srv-services", nil, "SRV services to look up for --srv(e.g. _ldap._tcp,_sip._udp, defaults to common AD, VoIP, mail and chat services)")
+	// Tag filters take comma separated values, so each inventory gets a flag of its own.
+	fl.StringArrayVar(&cmd.sources, "targets", nil, "pull targets from this inventory, aws://region lists the running ec2 instances of a region and consul://agent the services of a consul catalog, flagging open ports that aren't registered(repeatable, e.g. aws://us-east-1?tag:Env=prod&tag:Role=web,api&ips=public or consul://10.0.0.5:8500?dc=eu1&service=web,api&tag=prod)")
+	registerK8sFlags(fl, &cmd.k8s)
+	registerDockerFlags(fl, &cmd.docker)
+	fl.BoolVar(&cmd.dryRun, "dry-run", false, "print the targets, ports and limits the scan would use and exit without sending anything to the targets(hostnames are still resolved)")
+	fl.StringSliceVar(&cmd.exclude, "exclude", nil, "never scan these hosts, addresses or cidr ranges, even when a target covers them(e.g. 10.0.0.1,10.0.5.0/24)")
 	fl.BoolVarP(&cmd.shouldScanAll, "all", "a", false, "scan all ports(scans first 1024 if not enabled)")
+	fl.StringVarP(&cmd.ports, "ports", "p", "", "ports to scan as a list and/or ranges(e.g. 22,80,443,8000-9000), along with port groups(@"+strings.Join(scanner.PortGroupNames(), ", @")+" or ones from the config file)")
+	fl.StringVar(&cmd.excludePorts, "exclude-ports", "", "ports to skip out of whatever would be scanned, same syntax as --ports(e.g. 25,135-139)")
+	fl.IntVar(&cmd.topPorts, "top-ports", 0, "scan the n most commonly open tcp ports(e.g. 100 or 1000)")
+	fl.BoolVarP(&cmd.ipv4Only, "ipv4-only", "4", false, "only scan ipv4 addresses(dials tcp4)")
+	fl.BoolVarP(&cmd.ipv6Only, "ipv6-only", "6", false, "only scan ipv6 addresses(dials tcp6)")
+	fl.IntVar(&cmd.sample, "sample", 0, "only scan a random sample of this many ports")
+	fl.BoolVar(&cmd.randomize, "randomize", false, "scan the ports in a random order instead of ascending")
+	fl.Int64Var(&cmd.seed, "seed", 0, "seed for --sample and --randomize(random if not set)")
+	fl.IntVar(&cmd.retries, "retries", 0, "how many times to retry a port that timed out")
+	fl.DurationVar(&cmd.retryDelay, "retry-delay", scanner.DefaultRetryDelay, "how long to wait between retries")
+	fl.Float64Var(&cmd.retryJitter, "retry-jitter", scanner.DefaultRetryJitter, "fraction of --retry-delay to randomly spread retries by(0-1)")
+	fl.Float64Var(&cmd.retryBackoff, "retry-backoff", scanner.DefaultRetryBackoff, "multiply --retry-delay by this after every retry(1 keeps it constant)")
+	fl.BoolVar(&cmd.checkAuth, "check-auth", false, "check whether open redis(6379) and elasticsearch(9200) ports allow unauthenticated access")
+	fl.BoolVar(&cmd.httpProbe, "http-probe", false, "GET / from open ports and report the status, server, redirect and page title of the ones speaking http(s)")
+	fl.BoolVar(&cmd.osDetect, "os-detect", false, "guess the os family from how an open port answers a SYN(linux and ipv4 only, needs root or CAP_NET_RAW)")
+	fl.StringSliceVar(&cmd.probeNames, "probes", nil, "run these service probes against the open ports they match("+strings.Join(scanner.ProbeNames(), ", ")+" or all)")
+	fl.StringVar(&cmd.probeFile, "probe-file", "", "yaml file of extra probes sending a payload and matching the reply with a regex, which run along with --probes")
+	fl.StringVar(&cmd.scriptPath, "script", "", "starlark script whose "+scriptHook+"(port) is called with every open port to run follow-up checks and add to what's reported")
+	fl.BoolVar(&cmd.tlsProbe, "tls-probe", false, "hand-shake tls with open ports and report the version, cipher and certificate(subject, sans, expiry)")
+	fl.StringSliceVar(&cmd.sourceIPs, "source-ips", nil, "local addresses to round-robin outbound connections across")
+	fl.StringVar(&cmd.sourceIP, "source-ip", "", "local address to send every probe from(shorthand for a single --source-ips)")
+	fl.StringVarP(&cmd.iface, "interface", "i", "", "send every probe out of this interface, like a vpn tunnel(linux only)")
+	fl.IntVar(&cmd.fwmark, "fwmark", 0, "mark every probe with this fwmark for policy routing and firewall rules to match(e.g. 0x10, linux only, needs root or CAP_NET_ADMIN)")
+	registerNoPrivDropFlag(fl, &cmd.noPrivDrop)
+	fl.BoolVar(&cmd.fast, "fast", false, "trade accuracy for speed: the top 1000 ports, --auto-timeout capped at 500ms, no retries, up to 2048 ports at once with --adaptive and --skip-dead(each overridden by setting its own flag)")
+	fl.StringVarP(&cmd.timing, "timing", "T", "", "timing template setting timeout, concurrency, retries and probe rate at once("+timingNames()+")")
+	fl.BoolVar(&cmd.rawErrors, "raw-errors", false, "debug: dump the underlying error for every port that isn't open")
+	fl.DurationVar(&cmd.timeout, "timeout", scanner.DefaultTimeout, "how long to wait on each connection attempt(e.g. 500ms)")
+	fl.BoolVar(&cmd.autoTimeout, "auto-timeout", false, fmt.Sprintf("measure the round trip to each host before scanning it and wait %d round trips on each probe instead, at least %s and at most --timeout", scanner.AutoTimeoutFactor, scanner.MinAutoTimeout))
+	fl.BoolVar(&cmd.skipDead, "skip-dead", false, "ping every target before scanning it and skip the ones that don't answer, instead of waiting out the timeouts of addresses nothing is at")
+	fl.StringSliceVarP(&cmd.pingMethods, "ping", "P", []string{"icmp", "tcp"}, "how --skip-dead pings targets(icmp and/or tcp, icmp needs root or CAP_NET_RAW), -Pn scans every target without pinging like nmap")
+	fl.DurationVar(&cmd.hostTimeout, "host-timeout", 0, "give up on a host after scanning it for this long and mark it incomplete(e.g. 90s, unlimited if not set)")
+	fl.DurationVar(&cmd.maxDuration, "max-duration", 0, "stop the whole run after this long and report what was found so far(e.g. 2m, unlimited if not set)")
+	fl.IntVar(&cmd.concurrency, "concurrency", scanner.DefaultConcurrency, "how many ports to scan at once across all hosts")
+	fl.BoolVar(&cmd.adaptive, "adaptive", false, "start small and scale how many ports are scanned at once up to --concurrency while the network keeps up, backing off on timeouts and socket exhaustion(connect and udp scans only)")
+	fl.BoolVar(&cmd.raiseFDLimit, "raise-fd-limit", false, "raise the soft open file limit when it's too low for --concurrency, instead of only scanning fewer ports at once")
+	fl.IntVar(&cmd.hostConcurrency, "host-concurrency", 0, "how many ports of a single host to scan at once, hosts are scanned in parallel while --concurrency allows(defaults to --concurrency split between up to 4 hosts)")
+	fl.Int64Var(&cmd.maxConnections, "max-connections", 0, "stop the scan after this many connection attempts(unlimited if not set)")
+	fl.Float64Var(&cmd.maxRate, "max-rate", 0, "send at most this many probes per second across all hosts(unlimited if not set)")
+	fl.Float64Var(&cmd.minRate, "min-rate", 0, "start at least this many ports a second across all hosts, scanning more ports at once than --concurrency whenever it falls behind(connect and udp scans only)")
+	fl.StringVar(&cmd.jitterRange, "jitter", "", "wait a random gap in this range between probes across all hosts, so they don't go out on a regular beat(e.g. 50ms-300ms)")
+	fl.BoolVar(&cmd.guessProtocol, "guess-protocol", false, "guess what's listening on open ports from the bytes they send")
+	fl.BoolVar(&cmd.serviceVersion, "service-version", false, "identify the product and version listening on open ports from the bytes they send(e.g. OpenSSH 9.6p1 or nginx 1.25.3)")
+	fl.BoolVar(&cmd.vulns, "vulns", false, "list known advisories of the versions --service-version identifies from a small bundled list, a match only means possibly affected since distros backport fixes(implies --service-version)")
+	fl.StringVar(&cmd.vulnDB, "vuln-db", "", "file of extra advisories in the syntax of the bundled vulns.txt, checked along with them by --vulns")
+	fl.BoolVar(&cmd.banners, "banners", false, "grab the banner of each open port along with a guess at its protocol")
+	fl.BoolVar(&cmd.allStates, "all-states", false, "also report closed ports(refused) and filtered ones(no answer or blocked) instead of only counting them")
+	fl.IntVar(&cmd.fastest, "fastest", 0, "only report the n open ports with the lowest connect latency")
+	fl.StringVar(&cmd.auditLog, "audit-log", "", "append a json record of every connection attempt to this file")
+	fl.DurationVar(&cmd.minLatency, "min-latency", 0, "flag open ports that connect faster than this as possible middleboxes")
+	fl.BoolVar(&cmd.verify, "verify", false, "connect to every open port again once the scan is done and drop the ones that don't hold up, like middleboxes that accept and then reset(tcp connect scans only)")
+	fl.IntVar(&cmd.verifySample, "verify-sample", 10, "how many closed ports --verify also connects to again, to catch refusals that didn't come from the host")
+	fl.CountVarP(&cmd.verbose, "verbose", "v", "log extra detail like ports that only answered after a retry, -vv adds debug output like per-port dial errors")
+	registerLogFormatFlag(fl, &cmd.logFormat)
+	fl.BoolVar(&cmd.failOnOpen, "fail-on-open", false, "exit with code 4 when any open port is found(for ci checks)")
+	fl.BoolVarP(&cmd.quiet, "quiet", "q", false, "only print the results and errors, without progress, timing or usage(for scripts and cron jobs)")
+	fl.BoolVar(&cmd.noColor, "no-color", false, "don't color the port table(only drawn when stdout is a terminal)")
+	fl.BoolVar(&cmd.noProgress, "no-progress", false, "don't draw a progress line on stderr(only drawn on a terminal)")
+	fl.StringVar(&cmd.save, "save", "", "also save the results as json to this file(for the diff and show subcommands)")
+	registerBaselineFlag(fl, &cmd.baselinePath, "the ports")
+	registerMergeFlag(fl, &cmd.mergeScans)
+	fl.StringVar(&cmd.checkpoint, "checkpoint", "", "save the scan's progress to this file as it goes so a killed scan can be picked up with --resume")
+	fl.StringVar(&cmd.resume, "resume", "", "pick a killed scan back up from the --checkpoint file it left behind(rerun the same command with it)")
+	fl.BoolVar(&cmd.record, "record", false, "record the results in the scan history(see the history subcommand)")
+	registerHistoryDBFlag(fl, &cmd.historyDB)
+	registerHistoryRetentionFlags(fl, &cmd.retention, " after recording with --record")
+	registerWebhookFlags(fl, &cmd.webhook)
+	registerSyslogFlags(fl, &cmd.syslog)
+	registerNotifyFlag(fl, &cmd.notifySpecs, "when the scan finds open ports that weren't open the last time --record recorded the host")
+	registerPublishFlag(fl, &cmd.publishURLs)
+	registerOutFlags(fl, &cmd.out)
+	fl.StringVar(&cmd.outDirPath, "out-dir", "", "also write a file of results per host to this directory in the --output format, named after its address(the port table for text)")
+	fl.StringVar(&cmd.outputTemplate, "output-template", "", "render the results through this go text/template file instead of an --output format")
+	fl.StringVarP(&cmd.output, "output", "o", "text", "output format("+outputFormats()+")")
+	fl.BoolVar(&cmd.syn, "syn", false, "half-open scan with raw SYN packets instead of full connects(linux and ipv4 only, needs root or CAP_NET_RAW)")
+	fl.BoolVar(&cmd.stateless, "stateless", false, "send the --syn probes of every host from one sender and match the replies by a cookie in their sequence number, masscan style, to sweep big ranges at --max-rate")
+	fl.BoolVar(&cmd.fin, "fin", false, "stealth scan with raw FIN packets, closed ports answer with a RST and open ones stay quiet(same requirements as --syn)")
+	fl.BoolVar(&cmd.null, "null", false, "stealth scan with raw packets without any flags set, read like --fin")
+	fl.BoolVar(&cmd.xmas, "xmas", false, "stealth scan with raw FIN+PSH+URG packets, read like --fin")
+	fl.BoolVar(&cmd.ack, "ack", false, "map firewall rules with raw ACK packets, ports that answer with a RST are unfiltered and the rest filtered(same requirements as --syn)")
+	fl.StringVar(&cmd.zombieSpec, "zombie", "", "idle scan by bouncing spoofed SYNs off this idle host's ip id counter, so none of the probes come from our address(host or host:port, port 80 if not set, same requirements as --syn)")
+	fl.StringSliceVar(&cmd.decoySpecs, "decoys", nil, "also send every raw probe from these spoofed addresses, ME marks where ours goes among them(e.g. 10.0.0.1,10.0.0.2,ME,10.0.0.3, for ids testing in a lab)")
+	fl.IntVar(&cmd.ipHeader.TTL, "ttl", 0, "time to live of raw probes(1-255, the kernel's default if not set)")
+	fl.IntVar(&cmd.ipHeader.TOS, "tos", 0, "type of service byte of raw probes, a dscp shifted left by 2 plus the ecn bits(e.g. 0x10 or 184 for dscp ef)")
+	fl.IntVar(&cmd.ipHeader.FragmentSize, "fragment", 0, "split raw probes into ip fragments carrying this many bytes each(a multiple of 8, e.g. 8)")
+	fl.StringVar(&cmd.proxy, "proxy", "", "route connect scans through this SOCKS5 proxy(e.g. socks5://127.0.0.1:1080)")
+	// The url can carry the proxy's username and password.
+	_ = fl.SetAnnotation("proxy", secretAnnotation, []string{"true"})
+	registerSSHJumpFlags(fl, &cmd.sshJump)
+	registerAgentFlags(fl, &cmd.agentFlags)
+	fl.StringVar(&cmd.protocol, "protocol", "tcp", "protocol to scan(tcp, udp or sctp, sctp needs the same as --syn)")
+	fl.StringVar(&cmd.addresses, "addresses", "dual", "which addresses of a hostname to scan(first, dual for the first of each family, all or one of its ips)")
+	fl.StringSliceVar(&cmd.geoIPDBs, "geoip-db", nil, "annotate targets with their country, asn and org from these mmdb files(e.g. GeoLite2-Country.mmdb,GeoLite2-ASN.mmdb)")
+	fl.BoolVar(&cmd.rdap, "rdap", false, "annotate public targets with the owner and abuse contact of their netblock, looked up over rdap")
+	fl.StringVar(&cmd.rdapServer, "rdap-server", defaultRDAPServer, "rdap server to look netblocks up with(the default redirects to the right registry)")
+	fl.BoolVar(&cmd.reverseDNS, "resolve", false, "look up the PTR record of each scanned address and report its hostname")
+	fl.DurationVar(&cmd.resolveTimeout, "resolve-timeout", scanner.DefaultResolveTimeout, "how long to wait for hostname resolution")
+	registerConfigFlag(fl, &cmd.config)
+	registerProfileFlag(fl, &cmd.profile)
+	fl.StringToStringVar(&cmd.confirm, "confirm", nil, "per-port confirmation level as port=level(connect, http or tls), merged over the defaults(80,8000,8080=http 443,8443=tls)")
 }
 
 func (cmd *scanCmd) Run(fl *pflag.FlagSet) {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	// Ctrl+C cancels the scan rather than killing us so we still get to
+	// report whatever was found up to that point.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		// Give the signals back so a second Ctrl+C exits right away.
+		stop()
+	}()
+
+	configHosts, err := applyConfig(fl, cmd.config, cmd.Spec().Name)
+	if err != nil {
+		log.Fatalf("failed to load config: %s", err)
+	}
+
+	// Scripts only care about the results, timestamps and usage on every mistake just get in the way.
+	if cmd.quiet {
+		log.SetFlags(0)
+		fl.Usage = func() {}
+	}
+
+	if err := setLogFormat(cmd.logFormat); err != nil {
+		fl.Usage()
+		log.Fatalf("invalid --log-format: %s", err)
+	}
+
+	if cmd.quiet && cmd.verbose > 0 {
+		log.Fatal("--quiet and --verbose are mutually exclusive")
+	}
+
+	if err := cmd.applyRescan(fl); err != nil {
+		fl.Usage()
+		log.Fatalf("invalid --rescan-from: %s", err)
+	}
+
+	if cmd.flagScan, err = cmd.rawFlagScan(); err != nil {
+		fl.Usage()
+		log.Fatal(err)
+	}
+
+	if cmd.fast && cmd.timing != "" {
+		fl.Usage()
+		log.Fatal("--fast and --timing are mutually exclusive")
+	}
+
+	// --fast can turn on --skip-dead, which needs the pings worked out before privileges are dropped.
+	if err := cmd.applyFast(fl); err != nil {
+		fl.Usage()
+		log.Fatalf("invalid --fast: %s", err)
+	}
+
+	if err := cmd.parsePing(fl); err != nil {
+		fl.Usage()
+		log.Fatal(err)
+	}
+
+	// Raw sockets are the only thing we'd need root for, so we let go of it
+	// before a single packet or name from the network gets parsed.
+	if cmd.raw() || cmd.protocol == "sctp" || cmd.osDetect || cmd.skipDead && cmd.ping.ICMP {
+		dropPrivileges(cmd.noPrivDrop, cmd.fwmark != 0)
+	}
+
+	cmd.fallBackFromRaw()
+
+	if cmd.host == "" && cmd.targetsFile == "" && cmd.rescanFrom == "" && len(cmd.srvDomains) == 0 && len(cmd.sources) == 0 && !cmd.k8s.enabled() && !cmd.docker.enabled && len(configHosts) == 0 {
+		fl.Usage()
+		log.Fatal("host not provided(set --host, --targets-file, --rescan-from, --targets, --srv, --k8s-namespace, --docker or hosts in the config file)")
+	}
+
+	if len(cmd.srvServices) > 0 && len(cmd.srvDomains) == 0 {
+		fl.Usage()
+		log.Fatal("--srv-services only makes sense along with --srv")
+	}
+
+	if cmd.ipv4Only && cmd.ipv6Only {
+		fl.Usage()
+		log.Fatal("--ipv4-only and --ipv6-only are mutually exclusive")
+	}
+
+	write, ok := reportWriters[cmd.output]
+	if !ok && cmd.output != "text" {
+		fl.Usage()
+		log.Fatalf("%q is an unsupported output format", cmd.output)
+	}
+
+	if cmd.outputTemplate != "" {
+		if cmd.output != "text" {
+			fl.Usage()
+			log.Fatal("--output and --output-template are mutually exclusive")
+		}
+
+		tmpl, err := parseOutputTemplate(cmd.outputTemplate)
+		if err != nil {
+			log.Fatalf("invalid --output-template: %s", err)
+		}
+		// The template takes over stdout, so the text output stays quiet like it does for json.
+		write, cmd.output = templateWriter(tmpl), "template"
+	}
+
+	if cmd.out.append && cmd.out.path == "" {
+		fl.Usage()
+		log.Fatal("--append needs --out")
+	}
+
+	if err := cmd.retention.parse(); err != nil {
+		fl.Usage()
+		log.Fatal(err)
+	}
+	if cmd.retention.enabled() && !cmd.record {
+		fl.Usage()
+		log.Fatal("--keep and --max-size only apply along with --record")
+	}
 
-	if cmd.host == "" {
+	if cmd.mergeScans < 1 {
 		fl.Usage()
-		log.Fatal("host not provided")
+		log.Fatalf("--merge-adjacent-scans must be at least 1, got %d", cmd.mergeScans)
+	}
+	if cmd.mergeScans > 1 && cmd.baselinePath == "" && len(cmd.notifySpecs) == 0 {
+		fl.Usage()
+		log.Fatal("--merge-adjacent-scans only applies along with --baseline or --notify")
 	}
 
-	scanner, err := newScanner(cmd.host, cmd.shouldScanAll)
+	out, err := cmd.out.open()
 	if err != nil {
+		log.Fatalf("invalid --out: %s", err)
+	}
+	if cmd.outDirPath != "" {
+		if cmd.outDir, err = newOutDir(cmd.outDirPath, cmd.output, write); err != nil {
+			log.Fatalf("invalid --out-dir: %s", err)
+		}
+	}
+	var stdout io.Writer = os.Stdout
+	if out != nil {
+		defer out.Close()
+		stdout = out
+		if cmd.output == "text" {
+			teeLog(out)
+		}
+	}
+	cmd.table = cmd.output == "text" && out == nil && isTerminal(os.Stdout)
+
+	if cmd.vulnDB != "" && !cmd.vulns {
 		fl.Usage()
-		log.Fatalf("failed to initialize port scanner: %s", err)
+		log.Fatal("--vuln-db only makes sense with --vulns")
+	}
+	// Advisories are looked up by the versions we identify.
+	if cmd.vulns {
+		cmd.serviceVersion = true
 	}
 
-	log.Printf("scanning %s...", cmd.host)
-	start := time.Now()
-	openPorts := scanner.scan(ctx)
-	log.Printf("scan completed in %s", time.Since(start))
+	switch cmd.protocol {
+	case "tcp":
+		// Raw scans never finish the handshake, so there's nothing to confirm over.
+		if cmd.raw() && len(cmd.confirm) > 0 {
+			fl.Usage()
+			log.Fatalf("--confirm is not supported for %s scans", cmd.rawFlag())
+		}
+
+		// There's no connection to hold open after a raw probe.
+		if cmd.raw() && cmd.verify {
+			fl.Usage()
+			log.Fatalf("--verify is not supported for %s scans", cmd.rawFlag())
+		}
+
+		if cmd.raw() && cmd.ipv6Only {
+			fl.Usage()
+			log.Fatalf("%s only supports ipv4 targets", cmd.rawFlag())
+		}
+
+		// The proxy or bastion opens the connections for us, so raw packets never get anywhere near the target.
+		if (cmd.proxy != "" || cmd.sshJump.enabled()) && (cmd.raw() || cmd.osDetect) {
+			fl.Usage()
+			log.Fatal("--syn, --fin, --null, --xmas, --ack, --zombie and --os-detect can't be routed through --proxy or --ssh-jump")
+		}
+
+		if cmd.proxy != "" && cmd.sshJump.enabled() {
+			fl.Usage()
+			log.Fatal("--proxy and --ssh-jump are mutually exclusive")
+		}
+	case "udp", "sctp":
+		if cmd.protocol == "sctp" && cmd.ipv6Only {
+			fl.Usage()
+			log.Fatal("sctp scans only support ipv4 targets")
+		}
+
+		// These all talk to the service over a tcp stream, which we don't get over either.
+		if cmd.checkAuth || cmd.tlsProbe || cmd.httpProbe || len(cmd.probeNames) > 0 || cmd.probeFile != "" || cmd.osDetect || cmd.guessProtocol || cmd.serviceVersion || cmd.banners || len(cmd.confirm) > 0 || cmd.verify || cmd.raw() || cmd.proxy != "" || cmd.sshJump.enabled() || (cmd.allStates && cmd.protocol == "udp") {
+			fl.Usage()
+			log.Fatal("--check-auth, --tls-probe, --http-probe, --probes, --probe-file, --os-detect, --guess-protocol, --service-version, --vulns, --banners, --confirm, --verify, --syn, --fin, --null, --xmas, --ack, --zombie, --proxy, --ssh-jump and --all-states are only supported for tcp scans")
+		}
+	default:
+		fl.Usage()
+		log.Fatalf("%q is an unsupported protocol", cmd.protocol)
+	}
 
-	if len(openPorts) == 0 {
-		log.Printf("%q has no exposed ports", cmd.host)
+	if fl.Changed("verify-sample") && !cmd.verify {
+		fl.Usage()
+		log.Fatal("--verify-sample only makes sense with --verify")
+	}
+
+	if cmd.verifySample < 0 {
+		fl.Usage()
+		log.Fatalf("--verify-sample can't be negative, got %d", cmd.verifySample)
+	}
+
+	if cmd.stateless && (!cmd.syn || cmd.protocol != "tcp") {
+		fl.Usage()
+		log.Fatal("--stateless only applies to --syn scans")
+	}
+
+	if cmd.stateless && (len(cmd.decoySpecs) > 0 || cmd.ipHeader != (scanner.IPHeader{}) || cmd.auditLog != "") {
+		fl.Usage()
+		log.Fatal("--decoys, --ttl, --tos, --fragment and --audit-log don't apply to --stateless scans")
+	}
+
+	if (len(cmd.decoySpecs) > 0 || cmd.ipHeader != (scanner.IPHeader{})) && !cmd.raw() && cmd.protocol != "sctp" {
+		fl.Usage()
+		log.Fatal("--decoys, --ttl, --tos and --fragment only apply to --syn, --fin, --null, --xmas, --ack and sctp scans")
+	}
+
+	if fl.Changed("ttl") && (cmd.ipHeader.TTL < 1 || cmd.ipHeader.TTL > 255) {
+		fl.Usage()
+		log.Fatalf("--ttl must be between 1 and 255, got %d", cmd.ipHeader.TTL)
+	}
+
+	if cmd.ipHeader.TOS < 0 || cmd.ipHeader.TOS > 255 {
+		fl.Usage()
+		log.Fatalf("--tos must be between 0 and 255, got %d", cmd.ipHeader.TOS)
+	}
+
+	if cmd.ipHeader.FragmentSize < 0 || cmd.ipHeader.FragmentSize%8 != 0 {
+		fl.Usage()
+		log.Fatalf("--fragment must be a multiple of 8, got %d", cmd.ipHeader.FragmentSize)
+	}
+
+	if cmd.zombieSpec != "" {
+		if len(cmd.decoySpecs) > 0 || cmd.ipHeader != (scanner.IPHeader{}) || cmd.auditLog != "" {
+			fl.Usage()
+			log.Fatal("--decoys, --ttl, --tos, --fragment and --audit-log don't apply to --zombie scans")
+		}
+
+		// Anything connecting to the open ports afterwards would do it from our own address.
+		if cmd.checkAuth || cmd.tlsProbe || cmd.httpProbe || len(cmd.probeNames) > 0 || cmd.probeFile != "" || cmd.scriptPath != "" || cmd.osDetect || cmd.guessProtocol || cmd.serviceVersion || cmd.banners {
+			fl.Usage()
+			log.Fatal("--check-auth, --tls-probe, --http-probe, --probes, --probe-file, --script, --os-detect, --guess-protocol, --service-version, --vulns and --banners would contact the target from our own address, which --zombie is meant to hide")
+		}
+
+		if cmd.zombie, err = scanner.ParseZombie(cmd.zombieSpec); err != nil {
+			fl.Usage()
+			log.Fatalf("invalid --zombie: %s", err)
+		}
+	}
+
+	if len(cmd.decoySpecs) > 0 {
+		if cmd.decoys, err = scanner.ParseDecoys(cmd.decoySpecs); err != nil {
+			fl.Usage()
+			log.Fatalf("invalid --decoys: %s", err)
+		}
+	}
+
+	if cmd.agentFlags.enabled() {
+		if err := checkAgentFlags(fl); err != nil {
+			fl.Usage()
+			log.Fatal(err)
+		}
+	}
+
+	if cmd.vulns {
+		cmd.advisories = scanner.Advisories()
+		if cmd.vulnDB != "" {
+			b, err := ioutil.ReadFile(cmd.vulnDB)
+			if err != nil {
+				log.Fatalf("failed to read --vuln-db: %s", err)
+			}
+			db, err := scanner.ParseAdvisories(cmd.vulnDB, string(b))
+			if err != nil {
+				log.Fatalf("invalid --vuln-db: %s", err)
+			}
+			cmd.advisories = cmd.advisories.Merge(db)
+		}
+	}
+
+	if cmd.probeFile != "" {
+		names, err := loadProbeFile(cmd.probeFile)
+		if err != nil {
+			log.Fatalf("invalid --probe-file: %s", err)
+		}
+
+		// The file's probes run without having to be listed in --probes too.
+		if len(cmd.probeNames) != 1 || cmd.probeNames[0] != "all" {
+			listed := make(map[string]bool)
+			for _, name := range cmd.probeNames {
+				listed[name] = true
+			}
+			for _, name := range names {
+				if !listed[name] {
+					cmd.probeNames = append(cmd.probeNames, name)
+				}
+			}
+		}
+	}
+
+	if len(cmd.probeNames) == 1 && cmd.probeNames[0] == "all" {
+		cmd.probeNames = scanner.ProbeNames()
+	}
+	if cmd.probes, err = scanner.LookupProbes(cmd.probeNames); err != nil {
+		fl.Usage()
+		log.Fatalf("invalid --probes: %s", err)
+	}
+
+	if cmd.scriptPath != "" {
+		if cmd.script, err = loadScript(cmd.scriptPath); err != nil {
+			log.Fatalf("invalid --script: %s", err)
+		}
+	}
+
+	if cmd.baselinePath != "" {
+		if cmd.baseline, err = loadBaseline(cmd.baselinePath); err != nil {
+			log.Fatalf("invalid --baseline: %s", err)
+		}
+	}
+
+	if err := cmd.syslog.validate(); err != nil {
+		fl.Usage()
+		log.Fatalf("invalid --syslog: %s", err)
+	}
+
+	if cmd.notifiers, err = parseNotifiers(cmd.notifySpecs); err != nil {
+		fl.Usage()
+		log.Fatalf("invalid --notify: %s", err)
+	}
+
+	if cmd.publishers, err = parsePublishers(cmd.publishURLs); err != nil {
+		fl.Usage()
+		log.Fatalf("invalid --publish: %s", err)
+	}
+
+	if cmd.osDetect {
+		if err := scanner.CheckOSDetect(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if cmd.sourceIP != "" {
+		cmd.sourceIPs = append(cmd.sourceIPs, cmd.sourceIP)
+	}
+
+	var proxy *scanner.Proxy
+	if cmd.proxy != "" {
+		if proxy, err = scanner.ParseProxy(cmd.proxy); err != nil {
+			fl.Usage()
+			log.Fatalf("invalid --proxy: %s", err)
+		}
+	}
+
+	levels, err := scanner.ParseConfirmLevels(cmd.confirm)
+	if err != nil {
+		fl.Usage()
+		log.Fatalf("failed to parse confirmation levels: %s", err)
+	}
+
+	if err := cmd.applyTiming(fl); err != nil {
+		fl.Usage()
+		log.Fatalf("invalid --timing: %s", err)
+	}
+
+	if cmd.retryJitter < 0 || cmd.retryJitter > 1 {
+		fl.Usage()
+		log.Fatalf("--retry-jitter must be between 0 and 1, got %v", cmd.retryJitter)
+	}
+
+	if cmd.maxRate < 0 {
+		fl.Usage()
+		log.Fatalf("--max-rate can't be negative, got %v", cmd.maxRate)
+	}
+
+	if cmd.minRate < 0 {
+		fl.Usage()
+		log.Fatalf("--min-rate can't be negative, got %v", cmd.minRate)
+	}
+
+	if cmd.maxRate > 0 && cmd.minRate > cmd.maxRate {
+		fl.Usage()
+		log.Fatalf("--min-rate can't be above --max-rate, got %v and %v", cmd.minRate, cmd.maxRate)
+	}
+
+	if cmd.jitterRange != "" {
+		if cmd.jitter, err = scanner.ParseJitter(cmd.jitterRange); err != nil {
+			fl.Usage()
+			log.Fatalf("invalid --jitter: %s", err)
+		}
+		if cmd.minRate > 0 && cmd.jitter.Min.Seconds()*cmd.minRate > 1 {
+			fl.Usage()
+			log.Fatalf("--jitter waits at least %s between probes, which is too long for a --min-rate of %v", cmd.jitter.Min, cmd.minRate)
+		}
+	}
+
+	if cmd.fwmark < 0 || int64(cmd.fwmark) > math.MaxUint32 {
+		fl.Usage()
+		log.Fatalf("--fwmark must be between 0 and 0xffffffff, got %d", cmd.fwmark)
+	}
+
+	if cmd.retryBackoff < 1 {
+		fl.Usage()
+		log.Fatalf("--retry-backoff must be at least 1, got %v", cmd.retryBackoff)
+	}
+
+	if cmd.timeout <= 0 {
+		fl.Usage()
+		log.Fatalf("--timeout must be positive, got %s", cmd.timeout)
+	}
+
+	// The proxy, bastion or zombie is what the probes' answers come back through, not a path we can time.
+	if cmd.autoTimeout && (cmd.proxy != "" || cmd.sshJump.enabled() || cmd.zombieSpec != "") {
+		fl.Usage()
+		log.Fatal("--auto-timeout can't be used with --proxy, --ssh-jump or --zombie")
+	}
+
+	if cmd.concurrency < 1 {
+		fl.Usage()
+		log.Fatalf("--concurrency must be at least 1, got %d", cmd.concurrency)
+	}
+
+	if cmd.hostTimeout < 0 {
+		fl.Usage()
+		log.Fatalf("--host-timeout can't be negative, got %s", cmd.hostTimeout)
+	}
+
+	if cmd.maxDuration < 0 {
+		fl.Usage()
+		log.Fatalf("--max-duration can't be negative, got %s", cmd.maxDuration)
+	}
+
+	// The deadline covers everything from resolving the targets on, it cuts
+	// the run short just like Ctrl+C does so we still report what we found.
+	if cmd.maxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cmd.maxDuration)
+		defer cancel()
+	}
+
+	if cmd.hostConcurrency < 0 || cmd.hostConcurrency > cmd.concurrency {
+		fl.Usage()
+		log.Fatalf("--host-concurrency must be between 0 and --concurrency(%d), got %d", cmd.concurrency, cmd.hostConcurrency)
+	}
+
+	// Raw scans send every probe over the one socket, and the agents
+	// make the connections on their own machines.
+	if !cmd.raw() && cmd.protocol != "sctp" && !cmd.agentFlags.enabled() {
+		cmd.concurrency = fitFileLimit(cmd.concurrency, cmd.raiseFDLimit)
+		if cmd.hostConcurrency > cmd.concurrency {
+			cmd.hostConcurrency = cmd.concurrency
+		}
+	}
+
+	if cmd.adaptive && (cmd.raw() || cmd.protocol == "sctp") {
+		fl.Usage()
+		log.Fatal("--adaptive only applies to connect and udp scans")
+	}
+
+	if cmd.minRate > 0 && (cmd.raw() || cmd.protocol == "sctp") {
+		fl.Usage()
+		log.Fatal("--min-rate only applies to connect and udp scans")
+	}
+
+	portSelectors := 0
+	for _, set := range []bool{cmd.ports != "", cmd.shouldScanAll, fl.Changed("top-ports")} {
+		if set {
+			portSelectors++
+		}
+	}
+	if portSelectors > 1 {
+		fl.Usage()
+		log.Fatal("--ports, --all and --top-ports are mutually exclusive")
+	}
+
+	include := defaultPorts(cmd.shouldScanAll)
+	// Hardly any sctp services sit in the well-known range, so lets
+	// look where they're registered instead.
+	if cmd.protocol == "sctp" && !cmd.shouldScanAll {
+		include = scanner.ServicePorts("sctp")
+	}
+	switch {
+	case cmd.ports != "":
+		include, err = scanner.ParsePorts(cmd.ports)
+		if err != nil {
+			fl.Usage()
+			log.Fatalf("invalid ports: %s", err)
+		}
+	case fl.Changed("top-ports"):
+		include, err = scanner.TopPorts(cmd.topPorts)
+		if err != nil {
+			fl.Usage()
+			log.Fatalf("invalid --top-ports: %s", err)
+		}
+	}
+
+	var exclude []int
+	if cmd.excludePorts != "" {
+		exclude, err = scanner.ParsePorts(cmd.excludePorts)
+		if err != nil {
+			fl.Usage()
+			log.Fatalf("invalid --exclude-ports: %s", err)
+		}
+	}
+
+	ports := portsToScan(include, exclude)
+	if len(ports) == 0 {
+		fl.Usage()
+		log.Fatal("--exclude-ports excludes every port there was to scan")
+	}
+
+	var specs []string
+	if cmd.host != "" {
+		specs = append(specs, cmd.host)
+	}
+
+	// The config file's hosts are only a fallback for when none were given.
+	if cmd.host == "" && cmd.targetsFile == "" && cmd.rescanFrom == "" && len(cmd.srvDomains) == 0 && len(cmd.sources) == 0 && !cmd.k8s.enabled() && !cmd.docker.enabled {
+		specs = append(specs, configHosts...)
+	}
+
+	if cmd.targetsFile != "" {
+		fromFile, err := readTargets(cmd.targetsFile)
+		if err != nil {
+			log.Fatalf("failed to read targets file: %s", err)
+		}
+		specs = append(specs, fromFile...)
+	}
+
+	if cmd.rescan != nil {
+		specs = append(specs, cmd.rescan.specs...)
+	}
+
+	for _, domain := range cmd.srvDomains {
+		specs = append(specs, cmd.srvTargets(ctx, domain)...)
+	}
+
+	cmd.discovered = make(discovered)
+	for _, source := range cmd.sources {
+		cmd.addSource(ctx, fl, source)
+	}
+
+	if cmd.k8s.enabled() {
+		objects, err := cmd.k8s.discover(ctx)
+		if err != nil {
+			log.Fatalf("failed to discover kubernetes targets: %s", err)
+		}
+		if len(objects) == 0 {
+			log.Fatal("no running pods or services with a cluster ip match --k8s-namespace and --k8s-selector")
+		}
+		before := len(cmd.discovered)
+		addK8sObjects(cmd.discovered, objects, cmd.protocol)
+		cmd.infof("kubernetes: found %d pods and services at %d addresses", len(objects), len(cmd.discovered)-before)
+	}
+
+	if cmd.docker.enabled {
+		containers, err := cmd.docker.discover(ctx)
+		if err != nil {
+			log.Fatalf("failed to list docker containers: %s", err)
+		}
+		if len(containers) == 0 {
+			log.Fatalf("%s has no running containers", cmd.docker.host)
+		}
+		before := len(cmd.discovered)
+		addContainers(cmd.discovered, containers, cmd.protocol)
+		cmd.infof("docker: found %d running containers at %d addresses", len(containers), len(cmd.discovered)-before)
+	}
+	specs = append(specs, cmd.discovered.specs()...)
+
+	var hosts []hostPorts
+	for _, spec := range specs {
+		host, specPorts, err := splitTargetPorts(spec)
+		if err != nil {
+			fl.Usage()
+			log.Fatalf("invalid host: %s", err)
+		}
+		if specPorts != nil {
+			if specPorts = portsToScan(specPorts, exclude); len(specPorts) == 0 {
+				fl.Usage()
+				log.Fatalf("--exclude-ports excludes every port of %q", spec)
+			}
+		}
+
+		expanded, err := scanner.ExpandHost(host)
+		if err != nil {
+			fl.Usage()
+			log.Fatalf("invalid host: %s", err)
+		}
+		for _, h := range expanded {
+			hosts = append(hosts, hostPorts{host: h, ports: specPorts})
+		}
+	}
+
+	if len(hosts) == 0 {
+		fl.Usage()
+		log.Fatalf("no hosts found in %q", cmd.targetsFile)
+	}
+
+	exclusions, err := scanner.ParseExclusions(cmd.exclude)
+	if err != nil {
+		fl.Usage()
+		log.Fatalf("invalid --exclude: %s", err)
+	}
+
+	// Without an explicit seed we pick one, but we still report it
+	// so whoever is looking at the results can reproduce the sample or order.
+	if (cmd.sample > 0 || cmd.randomize) && !fl.Changed("seed") {
+		cmd.seed = time.Now().UnixNano()
+	}
+	if cmd.output == "text" {
+		cmd.infof("invocation: %s", invocation(new(root).Spec().Name+" "+cmd.Spec().Name, fl))
+	}
+
+	// Lets resolve everything before we start scanning so one bad
+	// hostname doesn't leave us with half a sweep.
+	var targets []target
+	var excluded []string
+	for _, hp := range hosts {
+		host := hp.host
+		ips, err := scanner.Resolve(ctx, host, cmd.network(), cmd.resolveTimeout)
+		if err != nil {
+			// A timeout says more about the resolver than the host,
+			// so lets report it separately from other failures.
+			if xerrors.Is(err, scanner.ErrResolveTimeout) {
+				log.Printf("timed out resolving %q after %s", host, cmd.resolveTimeout)
+			} else {
+				log.Printf("failed to resolve host: %s", err)
+			}
+			continue
+		}
+
+		ips, err = scanner.PickAddresses(host, ips, cmd.addresses)
+		if err != nil {
+			fl.Usage()
+			log.Fatalf("invalid --addresses: %s", err)
+		}
+
+		if cmd.addresses == "dual" {
+			ips = cmd.routedAddresses(host, ips)
+		}
+
+		// Exclusions are checked against what names resolve to as well,
+		// so an out of scope address can't sneak in under a hostname.
+		for _, ip := range ips {
+			if exclusions.Excludes(host, ip) {
+				name := host
+				if ip.String() != host {
+					name += "(" + ip.String() + ")"
+				}
+				cmd.verbosef("leaving %s out since --exclude covers it", name)
+				excluded = append(excluded, name)
+				continue
+			}
+			t := target{host: host, ip: ip, ports: hp.ports, multi: len(ips) > 1}
+			// Rescanned hosts go by the names the report had them under.
+			if name, ok := cmd.rescan.name(host); ok {
+				t.host = name
+			}
+			targets = append(targets, t)
+		}
+	}
+
+	if xerrors.Is(ctx.Err(), context.DeadlineExceeded) {
+		exitf(exitDeadlineExceeded, "--max-duration of %s ran out while resolving targets", cmd.maxDuration)
+	}
+	if ctx.Err() != nil {
+		exitf(exitInterrupted, "interrupted while resolving targets")
+	}
+
+	if len(excluded) > 0 {
+		cmd.infof("--exclude left out %d targets", len(excluded))
+		if len(targets) == 0 {
+			fl.Usage()
+			log.Fatal("--exclude excludes every target there was to scan")
+		}
+	}
+
+	if len(targets) == 0 {
+		exitf(exitNoResolvableTargets, "no resolvable targets out of %d hosts", len(hosts))
+	}
+
+	total := len(ports)
+	if cmd.sample > 0 {
+		ports = scanner.SamplePorts(ports, cmd.sample, cmd.seed)
+		cmd.infof("sampling %d of %d ports(seed %d)", len(ports), total, cmd.seed)
+	}
+
+	var merged int
+	if targets, merged = dedupeTargets(targets, ports); merged > 0 {
+		cmd.infof("%d targets turned out to be addresses that were already listed, scanning each address once", merged)
+	}
+
+	if cmd.randomize {
+		ports = scanner.ShufflePorts(ports, cmd.seed)
+		for i := range targets {
+			if targets[i].ports != nil {
+				targets[i].ports = scanner.ShufflePorts(targets[i].ports, cmd.seed)
+			}
+		}
+		cmd.infof("scanning ports in random order(seed %d)", cmd.seed)
+	}
+
+	// Everything past this point talks to the targets or leaves files behind.
+	if cmd.dryRun {
+		plan := scanPlan{targets: targets, excluded: excluded, ports: ports, total: total, perHost: cmd.hostLimits(len(targets)), proxy: proxy}
+		if err := cmd.printPlan(os.Stdout, plan); err != nil {
+			log.Fatalf("failed to write the plan: %s", err)
+		}
 		return
 	}
-	log.Printf("found %d open ports", len(openPorts))
-	log.Printf("open-ports: %v", openPorts)
-}
 
-// Now lets implement our port scanner.
-type scanner struct {
-	// we're going to wan't to scan each port concurrently
-	// so let's embed a mutex lock to help us make sure we
-	// do this in a thread-safe way.
-	sync.Mutex
-	host      string
-	openPorts []int
-	scanAll   bool
+	var down []string
+	if cmd.skipDead {
+		if targets, down, err = cmd.skipDeadTargets(ctx, targets); err != nil {
+			log.Fatal(err)
+		}
+		if ctx.Err() != nil {
+			exitf(exitInterrupted, "interrupted while pinging targets")
+		}
+		if len(down) > 0 {
+			cmd.infof("--skip-dead left out %d targets that didn't answer pings", len(down))
+		}
+		if len(targets) == 0 {
+			log.Fatalf("none of the %d targets answered pings(-Pn scans them anyway)", len(down))
+		}
+	}
+
+	if cmd.resume != "" {
+		if cmd.checkpoint != "" && cmd.checkpoint != cmd.resume {
+			fl.Usage()
+			log.Fatal("--resume keeps saving progress to the file it resumed from, it can't be used with another --checkpoint")
+		}
+		cmd.checkpoint = cmd.resume
+	}
+
+	if cmd.checkpoint != "" {
+		cmd.checkpoints, err = newCheckpointFile(cmd.checkpoint, cmd.resume != "", invocation(new(root).Spec().Name+" "+cmd.Spec().Name, fl), cmd.protocol, targets, ports)
+		if err != nil {
+			log.Fatalf("failed to start checkpoint: %s", err)
+		}
+	}
+
+	audit, err := scanner.OpenAuditLog(cmd.auditLog)
+	if err != nil {
+		log.Fatalf("failed to open audit log: %s", err)
+	}
+	defer audit.Close()
+
+	var jump *scanner.SSHJump
+	if cmd.sshJump.enabled() {
+		if jump, err = cmd.sshJump.dial(ctx); err != nil {
+			log.Fatalf("failed to connect to ssh bastion: %s", err)
+		}
+		defer jump.Close()
+		cmd.infof("tunneling through ssh bastion %s", jump)
+	}
+
+	if cmd.agentFlags.enabled() {
+		if cmd.agents, err = cmd.agentFlags.dial(ctx); err != nil {
+			log.Fatal(err)
+		}
+		defer cmd.agents.Close()
+		cmd.infof("sharding ports across agents %s", cmd.agents)
+	}
+
+	perHost := cmd.hostLimits(len(targets))
+	var (
+		inFlight *scanner.InFlight
+		engine   *scanner.Engine
+	)
+	switch {
+	case cmd.stateless:
+		if engine, err = scanner.NewEngine(cmd.iface, cmd.fwmark); err != nil {
+			log.Fatalf("failed to start the stateless engine: %s", err)
+		}
+		defer engine.Close()
+		if cmd.maxRate == 0 {
+			cmd.infof("warning: --stateless sends as fast as it can without --max-rate, which can easily flood the network")
+		}
+		cmd.infof("scanning %d hosts at once through the stateless engine", cmd.parallelHosts)
+	case cmd.parallelHosts > 1:
+		inFlight = scanner.NewInFlight(cmd.concurrency)
+		cmd.infof("scanning %d hosts at once, %d ports each and %d ports in total", cmd.parallelHosts, perHost, cmd.concurrency)
+	}
+
+	// Everything but the source addresses is shared between hosts,
+	// including the connection budget which caps the run as a whole.
+	opts := scanner.Options{
+		Network:       cmd.network(),
+		Ports:         ports,
+		Timeout:       cmd.timeout,
+		AutoTimeout:   cmd.autoTimeout,
+		HostTimeout:   cmd.hostTimeout,
+		Verify:        cmd.verify,
+		VerifySample:  cmd.verifySample,
+		Concurrency:   perHost,
+		ConfirmLevels: levels,
+		Retry: scanner.RetryPolicy{
+			Retries: cmd.retries,
+			Delay:   cmd.retryDelay,
+			Jitter:  cmd.retryJitter,
+			Backoff: cmd.retryBackoff,
+		},
+		SYN:       cmd.syn,
+		FlagScan:  cmd.flagScan,
+		Zombie:    cmd.zombie,
+		Decoys:    cmd.decoys,
+		IPHeader:  cmd.ipHeader,
+		Proxy:     proxy,
+		SSHJump:   jump,
+		Mark:      cmd.fwmark,
+		Engine:    engine,
+		RawErrors: cmd.rawErrors || cmd.verbose >= levelDebug,
+		AllStates: cmd.allStates,
+		Adaptive:  cmd.adaptive,
+		Budget:    scanner.NewBudget(cmd.maxConnections),
+		Rate:      scanner.NewRateLimiter(cmd.maxRate),
+		MinRate:   scanner.NewPacer(cmd.minRate),
+		Jitter:    cmd.jitter,
+		InFlight:  inFlight,
+		Audit:     audit,
+	}
+
+	rep := &report{
+		Version:    reportVersion,
+		Invocation: invocation(new(root).Spec().Name+" "+cmd.Spec().Name, fl),
+		Timestamp:  time.Now().UTC(),
+		Down:       down,
+	}
+
+	geoDBs, err := openGeoDBs(cmd.geoIPDBs)
+	if err != nil {
+		log.Fatalf("failed to open geoip database: %s", err)
+	}
+	defer geoDBs.Close()
+
+	var owners *rdapLookups
+	if cmd.rdap {
+		owners = newRDAPLookups(cmd.rdapServer)
+	}
+
+	// Lookups run alongside the scans, by the time a host is scanned its name is usually in.
+	var names *reverseLookups
+	if cmd.reverseDNS {
+		names = startReverseLookups(ctx, targets, cmd.resolveTimeout)
+	}
+
+	scans, wait := cmd.scanTargets(ctx, targets, func(i int, t target) (*hostResult, error) {
+		if t.progress = cmd.checkpoints.progress(i); t.progress != nil && t.progress.Result != nil {
+			cmd.infof("%s was already scanned by the run we resumed", t.host)
+			return t.progress.Result, nil
+		}
+
+		// Ports given along with the target are scanned as they are, --sample is for the ports of every host.
+		hostOpts, hostTotal := opts, total
+		if t.ports != nil {
+			hostOpts.Ports, hostTotal = t.ports, len(t.ports)
+		}
+		result, err := cmd.scanHost(ctx, t, hostOpts, hostTotal)
+		if err != nil {
+			return nil, err
+		}
+		result.PTR = names.name(ctx, t.ip)
+		if result.Geo, err = geoDBs.lookup(t.ip); err != nil {
+			log.Printf("failed to annotate %s: %s", t.ip, err)
+		}
+		if result.RDAP, err = owners.lookup(ctx, t.ip); err != nil && ctx.Err() == nil {
+			log.Printf("failed to look up who owns %s: %s", t.ip, err)
+		}
+
+		// Anything short of every port scanned is left for --resume to finish.
+		if !result.Interrupted && result.Error == "" && len(result.Unscanned) == 0 {
+			cmd.checkpoints.finish(t.progress, result)
+		}
+		return result, nil
+	})
+	for i, scan := range scans {
+		hs := <-scan
+		if hs.err != nil {
+			log.Fatalf("failed to scan %s: %s", targets[i].host, hs.err)
+		}
+		result := hs.result
+		// Hosts we hadn't got to yet when the scan was cut short are left out.
+		if result == nil {
+			continue
+		}
+		// Against a baseline only the deviations are worth printing, once every host is in.
+		if cmd.output == "text" && cmd.baseline == nil {
+			cmd.logResult(result)
+			if cmd.table {
+				printTable(os.Stdout, result, cmd.useColor())
+			}
+		}
+		rep.Hosts = append(rep.Hosts, result)
+
+		// Files are written as hosts finish, a sweep cut short still leaves the ones it got through.
+		if cmd.outDir != nil {
+			path, err := cmd.outDir.writeHost(rep, result)
+			if err != nil {
+				log.Fatalf("failed to write results of %s: %s", targets[i].host, err)
+			}
+			cmd.verbosef("wrote the results of %s to %s", hostKey(result), path)
+		}
+
+		if result.Interrupted {
+			rep.Interrupted = true
+			rep.DeadlineExceeded = result.DeadlineExceeded
+		}
+	}
+	wait()
+	rep.Duration = duration(time.Since(rep.Timestamp))
+	rep.HostSummary = summarizeHosts(rep)
+	// A single host's own lines already say all of it.
+	if cmd.output == "text" && cmd.baseline == nil && rep.HostSummary.Total > 1 {
+		logHostSummary(rep.HostSummary)
+	}
+
+	// The deviations go to stdout ahead of the output only when it's text, which has none there.
+	drift := cmd.checkBaseline(stdout, rep)
+
+	// What's new is judged against the history, before this scan joins it.
+	if len(cmd.notifiers) > 0 {
+		if n, err := newOpenPorts(cmd.historyDB, rep, cmd.mergeScans); err != nil {
+			log.Printf("failed to look for new open ports: %s", err)
+		} else if n != nil {
+			cmd.notifiers.send(context.Background(), *n)
+		}
+	}
+
+	for _, sink := range cmd.reportSinks(write, stdout) {
+		if err := sink.deliver(rep); err != nil {
+			log.Fatalf("failed to %s: %s", sink.what(), err)
+		}
+	}
+
+	if cmd.checkpoints.done() {
+		if err := cmd.checkpoints.remove(); err != nil {
+			log.Printf("failed to remove checkpoint: %s", err)
+		}
+	} else if cmd.checkpoints != nil {
+		log.Printf("progress saved to %s, rerun the same command with --resume %s to pick up where it left off", cmd.checkpoint, cmd.checkpoint)
+	}
+
+	if rep.DeadlineExceeded {
+		exitf(exitDeadlineExceeded, "--max-duration of %s ran out after %d/%d hosts, results are partial", cmd.maxDuration, len(rep.Hosts), len(targets))
+	}
+	if rep.Interrupted {
+		exitf(exitInterrupted, "scan interrupted after %d/%d hosts, results are partial", len(rep.Hosts), len(targets))
+	}
+
+	var failed, open int
+	for _, h := range rep.Hosts {
+		if h.Error != "" {
+			failed++
+		}
+		open += h.Found
+	}
+
+	if failed > 0 {
+		exitf(exitError, "%d/%d scans stopped early, results are partial", failed, len(rep.Hosts))
+	}
+
+	if drift > 0 {
+		exitf(exitBaselineDrift, "%d ports deviate from the baseline in %s", drift, cmd.baselinePath)
+	}
+
+	if cmd.failOnOpen && open > 0 {
+		exitf(exitOpenPorts, "failing since --fail-on-open is set and %d ports are open", open)
+	}
 }
 
-func newScanner(host string, scanAll bool) (*scanner, error) {
-	if net.ParseIP(host) == nil {
-		return nil, xerrors.Errorf("%q is an invalid ip address", host)
+// checkBaseline saves rep as the baseline when there isn't one yet, otherwise it reports
+// how rep deviates from it and returns how many ports do.
+func (cmd *scanCmd) checkBaseline(stdout io.Writer, rep *report) int {
+	if cmd.baselinePath == "" {
+		return 0
 	}
 
-	return &scanner{
-		Mutex:   sync.Mutex{},
-		host:    host,
-		scanAll: scanAll,
-	}, nil
+	if cmd.baseline == nil {
+		// A partial scan would have everything it didn't get to show up as new next time.
+		if rep.Interrupted {
+			log.Printf("not saving the baseline since the scan was cut short")
+			return 0
+		}
+		if err := saveReport(cmd.baselinePath, rep); err != nil {
+			log.Fatalf("failed to save baseline: %s", err)
+		}
+		cmd.infof("saved the results as the baseline in %s", cmd.baselinePath)
+		return 0
+	}
+
+	if cmd.mergeScans > 1 {
+		merged, err := mergeRecent(cmd.historyDB, rep, cmd.mergeScans)
+		if err != nil {
+			log.Fatalf("failed to merge the latest scans: %s", err)
+		}
+		rep = merged
+	}
+
+	changes := deviations(cmd.baseline, rep)
+	for _, c := range changes {
+		if cmd.output == "text" {
+			fmt.Fprintln(stdout, c)
+		} else {
+			log.Print(c)
+		}
+	}
+	if len(changes) == 0 {
+		cmd.infof("no deviations from the baseline in %s", cmd.baselinePath)
+	}
+	return len(changes)
 }
 
-func (s *scanner) add(port int) {
-	// Since we'll be appending to the same slice from different goroutines,
-	// lets make sure we're locking and unlocking between writes.
-	s.Lock()
-	s.openPorts = append(s.openPorts, port)
-	s.Unlock()
+// cutShort reports whether ctx was cancelled and whether that was its deadline.
+// Dials share the deadline, so they can give up on it a moment before ctx
+// says it's done, which still means the scan didn't get to finish.
+func cutShort(ctx context.Context) (interrupted, deadlineExceeded bool) {
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return true, true
+	}
+	return ctx.Err() != nil, false
 }
 
-func (s *scanner) scan(ctx context.Context) []int {
-	// Lets use a wait group so we can wait for all of our
-	// goroutines to exit before returning our result.
+// hostScan is the outcome of scanning one of the targets.
+type hostScan struct {
+	result *hostResult
+	err    error
+}
+
+// scanTargets calls scan for every target, cmd.parallelHosts of them at once. The i'th
+// channel it returns receives the outcome for the i'th target, so results can be
+// reported in order however long each host takes. wait blocks until every scan is done.
+func (cmd *scanCmd) scanTargets(ctx context.Context, targets []target, scan func(int, target) (*hostResult, error)) (scans []chan hostScan, wait func()) {
+	scans = make([]chan hostScan, len(targets))
+	for i := range scans {
+		scans[i] = make(chan hostScan, 1)
+	}
+
+	// Targets we get to once the scan is cut short get a nil result right away,
+	// so whoever reads the results in order is never left waiting.
+	slots := make(chan struct{}, cmd.parallelHosts)
 	var wg sync.WaitGroup
-	for _, port := range portsToScan(s.scanAll) {
-		wg.Add(1)
-		// Because 'port' is a loop-variable in this context,
-		// we'll wan't to explicitly pass a copy of its value into
-		// each goroutine on every iteration.
-		go func(p int) {
-			defer wg.Done()
-			// We don't need to explicitly pass the 'host' variable
-			// into the goroutine as a param because its not a
-			// loop-variable and its value never changes.
-			if isOpen(s.host, p) {
-				s.add(p)
-			}
-		}(port)
-	}
-	wg.Wait()
-	return s.openPorts
-}
-
-func portsToScan(shouldScanAll bool) []int {
-	max := wellKnownPorts
+	wg.Add(len(targets))
+	go func() {
+		for i, t := range targets {
+			slots <- struct{}{}
+			go func(i int, t target) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				if ctx.Err() != nil {
+					scans[i] <- hostScan{}
+					return
+				}
+				result, err := scan(i, t)
+				scans[i] <- hostScan{result: result, err: err}
+			}(i, t)
+		}
+	}()
+	return scans, wg.Wait
+}
+
+// hostLimits sets how many of targets to scan at once and returns
+// how many ports of each of them to scan at once.
+func (cmd *scanCmd) hostLimits(targets int) (perHost int) {
+	// The engine sends for every host at once, each of them only waits on its replies.
+	if cmd.stateless {
+		cmd.parallelHosts = targets
+		return cmd.concurrency
+	}
+
+	if cmd.hostConcurrency == 0 {
+		cmd.parallelHosts = defaultParallelHosts
+		if cmd.parallelHosts > targets {
+			cmd.parallelHosts = targets
+		}
+		if cmd.parallelHosts > cmd.concurrency {
+			cmd.parallelHosts = cmd.concurrency
+		}
+		return cmd.concurrency / cmd.parallelHosts
+	}
+
+	// Rounding up lets the next host use the room left by one winding down
+	// its last ports, the cap shared between them keeps the total in check.
+	cmd.parallelHosts = (cmd.concurrency + cmd.hostConcurrency - 1) / cmd.hostConcurrency
+	if cmd.parallelHosts > targets {
+		cmd.parallelHosts = targets
+	}
+	return cmd.hostConcurrency
+}
+
+// scanHost scans a single target and gathers what we found into a result.
+// total is the number of ports we'd have scanned without --sample.
+func (cmd *scanCmd) scanHost(ctx context.Context, t target, opts scanner.Options, total int) (*hostResult, error) {
+	if warning := selfScanWarning(t.ip); warning != "" {
+		cmd.infof("warning: %s %s", t.ip, warning)
+	}
+
+	sources, err := scanner.NewSourcePool(cmd.sourceIPs, cmd.iface, t.ip)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid source addresses: %w", err)
+	}
+	opts.Sources = sources
+
+	// A resumed target only has the ports the last run didn't get to left.
+	scanned := len(opts.Ports)
+	var prior []scanner.PortResult
+	if p := t.progress; p != nil && p.Started {
+		prior = p.Found
+		opts.Ports = append([]int{}, p.Remaining...)
+		cmd.infof("resuming %s with %d/%d ports left to scan", t.host, len(opts.Ports), scanned)
+	}
+
+	found := make(chan scanner.PortResult)
+	opts.Found = found
+
+	s, err := scanner.New(t.addr(), opts)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to initialize port scanner: %w", err)
+	}
+
+	if t.addr() != t.host {
+		cmd.infof("scanning %s(%s)...", t.host, t.addr())
+	} else {
+		cmd.infof("scanning %s...", t.host)
+	}
+	cmd.debugf("%s: %d ports over %s, %s timeout, %d at once, %d retries", t.ip, len(opts.Ports), opts.Network, opts.Timeout, opts.Concurrency, opts.Retry.Retries)
+	// The agents make the connections for us when we have them, s is still
+	// what validated the options.
+	var scan interface {
+		progressor
+		Scan(context.Context) (scanner.Result, error)
+	} = s
+	if cmd.agents != nil {
+		scan = cmd.agents.newScan(t.addr(), opts)
+	}
+
+	watched := cmd.watch(scan, t.label(), found)
+	stopCheckpoints := cmd.checkpoints.every(s, t.progress)
+
+	res, err := scan.Scan(ctx)
+	close(found)
+	<-watched
+	stopCheckpoints()
+	cmd.checkpoints.update(t.progress, s)
+	if prior != nil {
+		res.Ports = mergePorts(prior, res.Ports)
+	}
+	interrupted, deadlineExceeded := cutShort(ctx)
+	var scanErr string
+	if err != nil && !interrupted {
+		log.Printf("scan of %s stopped early: %s", t.host, err)
+		scanErr = err.Error()
+	}
+
+	result := &hostResult{
+		Host:             t.host,
+		Aliases:          t.aliases,
+		IP:               t.addr(),
+		Protocol:         cmd.protocol,
+		Timestamp:        res.Start.UTC(),
+		Duration:         duration(res.Duration),
+		Found:            len(res.Open()),
+		ScannedPorts:     scanned,
+		TotalPorts:       total,
+		Interrupted:      interrupted,
+		DeadlineExceeded: deadlineExceeded,
+		Latency:          newLatencyResult(res.Latency()),
+		Failures:         res.Failures,
+		Probes:           res.Probes,
+		Retries:          res.Retries,
+		Error:            scanErr,
+	}
+	if len(res.Failures) > 0 {
+		cmd.debugf("%s: probes that failed by outcome %v", t.ip, res.Failures)
+	}
+	if cmd.autoTimeout {
+		result.RTT, result.Timeout = duration(res.RTT), duration(res.Timeout)
+		if res.RTT == 0 {
+			log.Printf("warning: %s didn't answer any of the connects measuring its round trip, scanning it with the full --timeout of %s", t.ip, res.Timeout)
+		} else {
+			cmd.verbosef("%s: round trip of %s, probes time out after %s", t.ip, res.RTT.Round(time.Microsecond), res.Timeout)
+		}
+	}
+	if cmd.adaptive {
+		cmd.verbosef("%s: adaptive concurrency settled on %d ports at once", t.ip, s.Concurrency())
+	}
+
+	if len(res.Unscanned) > 0 {
+		result.Unscanned = res.Unscanned
+	}
+	if v := res.Verify; v != nil {
+		result.Verify = &verifyResult{Dropped: v.Dropped, Sampled: len(v.Sampled), Reopened: v.Reopened}
+		if len(v.Dropped) > 0 {
+			cmd.verbosef("%s: %d ports didn't hold up when verified and aren't open: %v", t.ip, len(v.Dropped), v.Dropped)
+		}
+		if len(v.Reopened) > 0 {
+			log.Printf("warning: %d/%d closed ports of %s accepted when verified, something other than the host refused them the first time(try a lower --max-rate or --concurrency): %v", len(v.Reopened), len(v.Sampled), t.ip, v.Reopened)
+		}
+	}
+	if res.Incomplete {
+		result.Incomplete = true
+		log.Printf("warning: gave up on %s after --host-timeout of %s with %d/%d ports scanned", t.host, cmd.hostTimeout, scanned-len(res.Unscanned), scanned)
+	}
+
+	if a, ok := cmd.discovered[t.addr()]; ok {
+		var openPorts []int
+		for _, p := range res.Open() {
+			openPorts = append(openPorts, p.Port)
+		}
+		result.Discovered = a.objects
+		// An interrupted scan didn't get to hear from every declared port.
+		if !interrupted {
+			result.Undeclared, result.NotListening = a.compare(openPorts)
+		}
+	}
+
+	open := res.Open()
+	if cmd.fastest > 0 {
+		open = res.Fastest(cmd.fastest)
+	}
+
+	var guesses []scanner.Guess
+	// Banners come from the same exchange we guess protocols from,
+	// and probes and scripts get to match on them.
+	if (cmd.guessProtocol || cmd.serviceVersion || cmd.banners || len(cmd.probes) > 0 || cmd.script != nil) && !interrupted {
+		openPorts := make([]int, len(open))
+		for i, p := range open {
+			openPorts[i] = p.Port
+		}
+		guesses = s.GuessProtocols(ctx, openPorts)
+	}
+
+	for i, port := range open {
+		p := portResult{
+			Port:       port.Port,
+			Service:    scanner.ServiceName(port.Port, cmd.protocol),
+			State:      port.State,
+			Latency:    duration(port.Latency),
+			Attempts:   port.Attempts,
+			Suspicious: cmd.minLatency > 0 && port.Suspicious(cmd.minLatency),
+		}
+
+		if guesses != nil {
+			p.GuessedProtocol, p.MatchedSignature = guesses[i].Protocol, guesses[i].Matched
+			if cmd.banners {
+				p.Banner = guesses[i].Banner
+			}
+			if v := guesses[i].Version; cmd.serviceVersion && v != nil {
+				p.Version = &versionResult{Product: v.Product, Version: v.Version, Info: v.Info}
+				if cmd.advisories != nil {
+					for _, a := range cmd.advisories.Lookup(*v) {
+						p.Vulns = append(p.Vulns, vulnResult{ID: a.ID, Severity: a.Severity, Summary: a.Summary})
+					}
+				}
+			}
+			if guesses[i].Err != nil {
+				p.GuessError = guesses[i].Err.Error()
+			}
+		}
+
+		if len(cmd.probes) > 0 && !interrupted {
+			var banner string
+			if guesses != nil {
+				banner = guesses[i].Banner
+			}
+			for _, o := range s.RunProbes(ctx, port.Port, banner, cmd.probes) {
+				r := probeResult{Name: o.Probe, Summary: o.Result.Summary, Details: o.Result.Details}
+				if o.Err != nil {
+					r.Error = o.Err.Error()
+				}
+				p.Probes = append(p.Probes, r)
+			}
+		}
+
+		if cmd.checkAuth && !interrupted && scanner.HasAuthProbe(port.Port) {
+			service, requiresAuth, err := s.CheckAuth(ctx, port.Port)
+			p.AuthService = service
+			p.RequiresAuth = &requiresAuth
+			if err != nil {
+				p.AuthError = err.Error()
+			}
+		}
+
+		// Only names are sent as SNI or Host, servers don't expect an ip there.
+		var serverName string
+		if scanner.IsHostname(t.host) {
+			serverName = t.host
+		}
+
+		if cmd.httpProbe && !interrupted {
+			info, err := s.ProbeHTTP(ctx, port.Port, serverName)
+			if err != nil {
+				p.HTTPError = err.Error()
+			} else {
+				p.HTTP = &httpResult{
+					Scheme:   info.Scheme,
+					Status:   info.StatusCode,
+					Server:   info.Server,
+					Location: info.Location,
+					Title:    info.Title,
+				}
+			}
+		}
+
+		if cmd.tlsProbe && !interrupted {
+			info, err := s.InspectTLS(ctx, port.Port, serverName)
+			if err != nil {
+				p.TLSError = err.Error()
+			} else {
+				p.TLS = newTLSResult(info)
+			}
+		}
+
+		// The script goes last so it gets to see what everything else found.
+		if cmd.script != nil && !interrupted {
+			var banner string
+			if guesses != nil {
+				banner = guesses[i].Banner
+			}
+			cmd.script.run(ctx, s, t, banner, &p)
+		}
+		result.Ports = append(result.Ports, p)
+	}
+
+	// Any open port will do, they all sit on the same stack.
+	if cmd.osDetect && !interrupted && len(open) > 0 {
+		if t.ip.To4() == nil {
+			result.OSError = "os detection only supports ipv4 targets"
+		} else if guess, err := s.DetectOS(ctx, open[0].Port); err != nil {
+			result.OSError = err.Error()
+		} else {
+			result.OS = &osResult{
+				Family:     guess.Family,
+				Confidence: guess.Confidence,
+				TTL:        guess.Fingerprint.TTL,
+				InitialTTL: guess.Fingerprint.InitialTTL,
+				Window:     guess.Fingerprint.Window,
+				Options:    guess.Fingerprint.Options,
+			}
+		}
+	}
+
+	// That leaves the udp ports that never answered, and the closed
+	// and filtered ones which are only kept with --all-states.
+	for _, port := range res.Ports {
+		if port.State == scanner.StateOpen {
+			continue
+		}
+		result.Ports = append(result.Ports, portResult{
+			Port:    port.Port,
+			Service: scanner.ServiceName(port.Port, cmd.protocol),
+			State:   port.State,
+			Failure: port.Failure,
+		})
+	}
+	return result, nil
+}
+
+// rawFlagScan returns the scan --fin, --null, --xmas or --ack picked, if any.
+// An idle scan through a --zombie doesn't combine with any of them either.
+// Raw scans only send one kind of probe, so they can't be combined.
+func (cmd *scanCmd) rawFlagScan() (scanner.FlagScan, error) {
+	var set []string
+	if cmd.syn {
+		set = append(set, "--syn")
+	}
+	if cmd.zombieSpec != "" {
+		set = append(set, "--zombie")
+	}
+
+	var scan scanner.FlagScan
+	for _, f := range []struct {
+		on   bool
+		scan scanner.FlagScan
+	}{{cmd.fin, scanner.FlagScanFIN}, {cmd.null, scanner.FlagScanNull}, {cmd.xmas, scanner.FlagScanXmas}, {cmd.ack, scanner.FlagScanACK}} {
+		if f.on {
+			set = append(set, "--"+string(f.scan))
+			scan = f.scan
+		}
+	}
+
+	if len(set) > 1 {
+		return "", xerrors.Errorf("%s are mutually exclusive", strings.Join(set, ", "))
+	}
+	return scan, nil
+}
+
+// fallBackFromRaw turns SYN and flag scans into connect scans, and skips
+// --os-detect, on platforms that don't have the raw sockets they need rather
+// than fail the whole run over it. Idle and SCTP scans have nothing to fall
+// back to, so they fail right away instead of once the first host is up.
+// Missing privileges aren't a platform problem, those still fail later on.
+func (cmd *scanCmd) fallBackFromRaw() {
+	if cmd.syn || cmd.flagScan != "" {
+		if err := scanner.CheckSYN(); xerrors.Is(err, scanner.ErrUnsupported) {
+			log.Printf("warning: %s, falling back to connect scan", err)
+			cmd.syn, cmd.stateless, cmd.flagScan = false, false, ""
+		}
+	}
+
+	if cmd.zombieSpec != "" {
+		if err := scanner.CheckSYN(); xerrors.Is(err, scanner.ErrUnsupported) {
+			log.Fatalf("idle scans need raw sockets: %s", err)
+		}
+	}
+
+	if cmd.protocol == "sctp" {
+		if err := scanner.CheckSCTP(); xerrors.Is(err, scanner.ErrUnsupported) {
+			log.Fatal(err)
+		}
+	}
+
+	if cmd.osDetect {
+		if err := scanner.CheckOSDetect(); xerrors.Is(err, scanner.ErrUnsupported) {
+			log.Printf("warning: %s, skipping --os-detect", err)
+			cmd.osDetect = false
+		}
+	}
+}
+
+// raw reports whether ports are probed with raw packets rather than connects.
+func (cmd *scanCmd) raw() bool { return cmd.syn || cmd.flagScan != "" || cmd.zombieSpec != "" }
+
+// rawFlag is the flag that picked the raw scan, for error messages.
+func (cmd *scanCmd) rawFlag() string {
+	if cmd.zombieSpec != "" {
+		return "--zombie"
+	}
+	if cmd.flagScan != "" {
+		return "--" + string(cmd.flagScan)
+	}
+	return "--syn"
+}
+
+// network returns the dial network for the selected protocol and address family.
+// Leaving both family flags off keeps the plain "tcp" or "udp" network so the
+// dialer is free to use whichever family the address belongs to.
+// Raw scans only speak ipv4 so they always resolve to ipv4 addresses.
+func (cmd *scanCmd) network() string {
+	switch {
+	case cmd.ipv4Only, cmd.raw(), cmd.protocol == "sctp":
+		return cmd.protocol + "4"
+	case cmd.ipv6Only:
+		return cmd.protocol + "6"
+	}
+	return cmd.protocol
+}
+
+// routedAddresses leaves the addresses of host there's no route to out of ips.
+// Both families get scanned by default, so lets not have one we can't reach
+// report every port unreachable. A host we can't route to at all is left
+// to fail the usual way.
+func (cmd *scanCmd) routedAddresses(host string, ips []net.IP) []net.IP {
+	if len(ips) < 2 {
+		return ips
+	}
+
+	var routed []net.IP
+	for _, ip := range ips {
+		if !scanner.HasRoute(ip) {
+			cmd.verbosef("leaving %s(%s) out since there's no route to it", host, ip)
+			continue
+		}
+		routed = append(routed, ip)
+	}
+	if len(routed) == 0 {
+		return ips
+	}
+	return routed
+}
+
+func defaultPorts(shouldScanAll bool) []int {
+	max := scanner.WellKnownPorts
 	if shouldScanAll {
-		max = allPorts
+		max = scanner.AllPorts
+	}
+	return scanner.PortRange(1, max)
+}
+
+// portsToScan returns the ports of include that aren't in exclude, keeping their order.
+func portsToScan(include, exclude []int) []int {
+	excluded := make(map[int]bool, len(exclude))
+	for _, port := range exclude {
+		excluded[port] = true
 	}
 
 	var ports []int
-	for port := 1; port < max; port++ {
-		ports = append(ports, port)
+	for _, port := range include {
+		if !excluded[port] {
+			ports = append(ports, port)
+		}
 	}
 	return ports
 }
 
-func isOpen(host string, port int) bool {
-	addr := net.JoinHostPort(host, strconv.Itoa(port))
-	conn, err := net.DialTimeout("tcp", addr, timeout)
-	if err != nil {
-		return false
+// target is a host we're about to scan along with the address it resolved to.
+type target struct {
+	host string
+	ip   net.IP
+	// ports are the ports the target was given with, which it's scanned on in
+	// place of everyone else's. It's nil when it wasn't given any.
+	ports []int
+	// aliases are the other names the target's address was listed under.
+	aliases []string
+	// multi is set when other addresses of the target's host are scanned
+	// too, its ports are then reported by address as they're found.
+	multi bool
+	// progress is how far an earlier run got with the target when resuming.
+	progress *targetProgress
+}
+
+// hostPorts is a host, or an address of a range, along with the ports it was given with.
+type hostPorts struct {
+	host  string
+	ports []int
+}
+
+// label is what the ports of t are reported under as they're found.
+func (t target) label() string {
+	if t.multi {
+		return t.addr()
 	}
-	_ = conn.Close()
-	return true
+	return t.host
+}
+
+// addr is the address t is scanned at, which keeps the zone a link-local
+// ipv6 host like fe80::1%eth0 needs to tell what interface it's on.
+func (t target) addr() string {
+	return scanner.ZonedAddr(t.host, t.ip)
 }
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// schedule is a cron expression of the five standard fields, minute hour
+// day-of-month month day-of-week, evaluated in local time like cron does.
+// Every field takes *, lists, ranges and steps(e.g. */15, 1-5 or 0,30),
+// months and weekdays their three letter names too.
+type schedule struct {
+	minute, hour, dom, month, dow uint64
+	// Like cron, when both days are restricted a day matching either one will do.
+	domStar, dowStar bool
+}
+
+// scheduleMacros are the shorthands cron accepts in place of the fields.
+var scheduleMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	monthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	dayNames   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+func parseSchedule(expr string) (*schedule, error) {
+	if macro, ok := scheduleMacros[strings.ToLower(strings.TrimSpace(expr))]; ok {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, xerrors.Errorf("%q should have 5 fields(minute hour day-of-month month day-of-week), got %d", expr, len(fields))
+	}
+
+	var (
+		s   schedule
+		err error
+	)
+	if s.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, xerrors.Errorf("invalid minute: %w", err)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, xerrors.Errorf("invalid hour: %w", err)
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, xerrors.Errorf("invalid day of month: %w", err)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, xerrors.Errorf("invalid month: %w", err)
+	}
+	// Sunday is both 0 and 7.
+	if s.dow, err = parseCronField(fields[4], 0, 7, dayNames); err != nil {
+		return nil, xerrors.Errorf("invalid day of week: %w", err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domStar = strings.HasPrefix(fields[2], "*")
+	s.dowStar = strings.HasPrefix(fields[4], "*")
+	return &s, nil
+}
+
+// parseCronField returns the values field matches between lo and hi as a bitset.
+// names, when there are any, are accepted for the values from lo on.
+func parseCronField(field string, lo, hi int, names []string) (uint64, error) {
+	value := func(s string) (int, error) {
+		for i, name := range names {
+			if strings.EqualFold(s, name) {
+				return lo + i, nil
+			}
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < lo || n > hi {
+			return 0, xerrors.Errorf("%q is not a number between %d and %d", s, lo, hi)
+		}
+		return n, nil
+	}
+
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return 0, xerrors.Errorf("%q is not a valid step", part[i+1:])
+			}
+			part = part[:i]
+		}
+
+		start, end := lo, hi
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			i := strings.IndexByte(part, '-')
+			var err error
+			if start, err = value(part[:i]); err != nil {
+				return 0, err
+			}
+			if end, err = value(part[i+1:]); err != nil {
+				return 0, err
+			}
+			if start > end {
+				return 0, xerrors.Errorf("range %q runs backwards", part)
+			}
+		default:
+			var err error
+			if start, err = value(part); err != nil {
+				return 0, err
+			}
+			// A single value with a step, like 5/15, runs to the end of the field.
+			end = start
+			if step > 1 {
+				end = hi
+			}
+		}
+
+		for n := start; n <= end; n += step {
+			bits |= 1 << uint(n)
+		}
+	}
+	return bits, nil
+}
+
+// next returns the first minute after t the schedule matches. A schedule that
+// can never match, like the 31st of February, returns the zero time.
+func (s *schedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// Every possible day comes around within a few years, leap days included.
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s *schedule) matchesDay(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
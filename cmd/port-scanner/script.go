@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkjson"
+	"go.starlark.net/starlarkstruct"
+	"golang.org/x/xerrors"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+// scriptHook is the function a --script has to define. It's handed every open port
+// once the other checks are done with it and returns a dict of findings to report
+// along with the port, or None when it has nothing to add.
+const scriptHook = "on_open"
+
+// script is a starlark script loaded with --script. Its globals are frozen once
+// it's loaded, so the hook can be called for several hosts at once.
+type script struct {
+	path   string
+	onOpen starlark.Callable
+}
+
+// scriptPredeclared is what scripts get on top of the starlark builtins.
+var scriptPredeclared = starlark.StringDict{
+	"struct": starlark.NewBuiltin("struct", starlarkstruct.Make),
+	"json":   starlarkjson.Module,
+}
+
+func loadScript(path string) (*script, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read script: %w", err)
+	}
+
+	thread := newScriptThread(path)
+	globals, err := starlark.ExecFile(thread, path, src, scriptPredeclared)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to load %s: %w", path, scriptError(err))
+	}
+	globals.Freeze()
+
+	fn, ok := globals[scriptHook].(starlark.Callable)
+	if !ok {
+		return nil, xerrors.Errorf("%s doesn't define an %s(port) function", path, scriptHook)
+	}
+	return &script{path: path, onOpen: fn}, nil
+}
+
+// newScriptThread sends whatever the script prints to the log.
+func newScriptThread(path string) *starlark.Thread {
+	return &starlark.Thread{
+		Name: path,
+		Print: func(_ *starlark.Thread, msg string) {
+			log.Printf("%s: %s", path, msg)
+		},
+	}
+}
+
+// scriptError adds the starlark backtrace to errors raised by the script
+// so it's clear what line they came from.
+func scriptError(err error) error {
+	if evalErr, ok := err.(*starlark.EvalError); ok {
+		return xerrors.New(evalErr.Backtrace())
+	}
+	return err
+}
+
+// run calls the script's hook for p and records what it returned on p. The port
+// the hook is handed lets it run follow-up checks against the service through s:
+//
+//	port.send(data="")  sends data over a fresh connection and returns the reply,
+//	                    or None if the service couldn't be reached
+//	port.probe(name)    runs one of the --probes whether or not it matches the port
+func (sc *script) run(ctx context.Context, s *scanner.Scanner, t target, banner string, p *portResult) {
+	thread := newScriptThread(sc.path)
+	// Starlark has no way of catching errors, so the follow-up checks are
+	// cancelled along with the scan by cancelling the whole hook.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			thread.Cancel("scan interrupted")
+		case <-stop:
+		}
+	}()
+
+	probes := starlark.NewDict(len(p.Probes))
+	for _, probe := range p.Probes {
+		_ = probes.SetKey(starlark.String(probe.Name), starlark.String(probe.Summary))
+	}
+
+	port := starlarkstruct.FromStringDict(starlark.String("port"), starlark.StringDict{
+		"host":             starlark.String(t.host),
+		"ip":               starlark.String(t.addr()),
+		"port":             starlark.MakeInt(p.Port),
+		"service":          starlark.String(p.Service),
+		"state":            starlark.String(p.State),
+		"latency_ms":       starlark.Float(time.Duration(p.Latency).Seconds() * 1000),
+		"banner":           starlark.String(banner),
+		"guessed_protocol": starlark.String(p.GuessedProtocol),
+		"probes":           probes,
+		"send":             starlark.NewBuiltin("send", sendBuiltin(ctx, s, p.Port)),
+		"probe":            starlark.NewBuiltin("probe", probeBuiltin(ctx, s, p.Port)),
+	})
+
+	v, err := starlark.Call(thread, sc.onOpen, starlark.Tuple{port}, nil)
+	if err != nil {
+		p.ScriptError = scriptError(err).Error()
+		return
+	}
+
+	switch v := v.(type) {
+	case starlark.NoneType:
+	case *starlark.Dict:
+		findings, err := fromStarlark(v)
+		if err != nil {
+			p.ScriptError = fmt.Sprintf("%s returned an invalid result: %s", scriptHook, err)
+			return
+		}
+		if m := findings.(map[string]interface{}); len(m) > 0 {
+			p.Script = m
+		}
+	default:
+		p.ScriptError = fmt.Sprintf("%s returned a %s, it should return a dict or None", scriptHook, v.Type())
+	}
+}
+
+func sendBuiltin(ctx context.Context, s *scanner.Scanner, port int) func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+	return func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var data string
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "data?", &data); err != nil {
+			return nil, err
+		}
+
+		reply, err := s.Exchange(ctx, port, []byte(data))
+		if err != nil {
+			return starlark.None, nil
+		}
+		return starlark.String(reply), nil
+	}
+}
+
+func probeBuiltin(ctx context.Context, s *scanner.Scanner, port int) func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+	return func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var name string
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name); err != nil {
+			return nil, err
+		}
+
+		probes, err := scanner.LookupProbes([]string{name})
+		if err != nil {
+			return nil, err
+		}
+
+		o := s.RunProbe(ctx, port, probes[0])
+		details := starlark.NewDict(len(o.Result.Details))
+		for k, v := range o.Result.Details {
+			_ = details.SetKey(starlark.String(k), starlark.String(v))
+		}
+		var probeErr starlark.Value = starlark.None
+		if o.Err != nil {
+			probeErr = starlark.String(o.Err.Error())
+		}
+		return starlarkstruct.FromStringDict(starlark.String("probe"), starlark.StringDict{
+			"name":    starlark.String(o.Probe),
+			"summary": starlark.String(o.Result.Summary),
+			"details": details,
+			"error":   probeErr,
+		}), nil
+	}
+}
+
+// fromStarlark turns what a script returned into something we can encode.
+func fromStarlark(v starlark.Value) (interface{}, error) {
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return i, nil
+		}
+		return v.String(), nil
+	case starlark.Float:
+		return float64(v), nil
+	case starlark.String:
+		return string(v), nil
+	case *starlark.List:
+		out := make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			elem, err := fromStarlark(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, elem)
+		}
+		return out, nil
+	case starlark.Tuple:
+		out := make([]interface{}, 0, len(v))
+		for _, e := range v {
+			elem, err := fromStarlark(e)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, elem)
+		}
+		return out, nil
+	case *starlark.Dict:
+		out := make(map[string]interface{}, v.Len())
+		for _, item := range v.Items() {
+			k, ok := item[0].(starlark.String)
+			if !ok {
+				return nil, xerrors.Errorf("dict keys have to be strings, got a %s", item[0].Type())
+			}
+			elem, err := fromStarlark(item[1])
+			if err != nil {
+				return nil, err
+			}
+			out[string(k)] = elem
+		}
+		return out, nil
+	}
+	return nil, xerrors.Errorf("can't report a %s", v.Type())
+}
+
+// scriptFindings returns the keys of findings sorted, so they're logged in a stable order.
+func scriptFindings(findings map[string]interface{}) []string {
+	keys := make([]string, 0, len(findings))
+	for k := range findings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+// selfScanWarning explains why scanning ip probably means scanning this machine.
+// It returns an empty string when ip looks like somebody else.
+func selfScanWarning(ip net.IP) string {
+	if ip.IsLoopback() {
+		return "is a loopback address, you are scanning this machine"
+	}
+
+	// Failing to list our own addresses shouldn't get in the way of the scan,
+	// this is only ever a hint.
+	local, err := scanner.LocalAddrs()
+	if err == nil && scanner.ContainsIP(local, ip) {
+		return "is one of this machine's own addresses, you might be scanning yourself"
+	}
+	return ""
+}
@@ -0,0 +1,968 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/pflag"
+	"go.coder.com/cli"
+	"golang.org/x/xerrors"
+	"google.golang.org/grpc"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+// serve exposes the scanner over a small REST API so dashboards and other
+// tooling can submit scans without wrapping the CLI. Jobs are queued and
+// run in the background, clients poll for their status and fetch the results.
+//
+//	POST /scans                                submit a scan, returns the job
+//	GET  /scans                                list every job
+//	GET  /scans/{id}                           a job's status and progress
+//	GET  /scans/{id}/results?offset=&limit=    a page of a finished job's host results
+//	POST /scans/{id}/pause                     stop a job until it's resumed
+//	POST /scans/{id}/resume                    queue a paused job again
+//	POST /scans/{id}/cancel                    stop a job for good
+//	GET  /metrics                              open ports and scan health for Prometheus to scrape
+//
+// Results come 100 hosts to a page unless limit asks for another page size.
+// Limits above 1000 are capped at 1000, and a limit below 1 or a negative
+// offset gets a 400.
+//
+// Submitting a scan that's already queued, running or finished and not yet
+// expired returns that job with a 200 rather than a new one with a 202,
+// unless the request sets "force".
+//
+// A paused job picks up with the hosts it hadn't finished, the host it was
+// part way through gets scanned again from the start. With --state-file the
+// jobs are kept across restarts, the ones still running when the server
+// stopped go back to the queue.
+//
+// With --grpc-addr the same jobs can be submitted and followed over gRPC,
+// which streams the results back as they come in(see scansServiceDesc).
+//
+// With --token set every request, gRPC ones and Prometheus' scrapes included,
+// has to carry an "Authorization: Bearer <token>" header.
+type serveCmd struct {
+	addr      string
+	grpcAddr  string
+	token     string
+	workers   int
+	queueSize int
+	ttl       time.Duration
+	stateFile string
+	config    string
+}
+
+func (cmd *serveCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "serve",
+		Usage: "[flags]",
+		Desc:  "Run scans submitted over a REST API.",
+	}
+}
+
+func (cmd *serveCmd) RegisterFlags(fl *pflag.FlagSet) {
+	fl.StringVar(&cmd.addr, "addr", "127.0.0.1:8080", "address to listen on")
+	fl.StringVar(&cmd.grpcAddr, "grpc-addr", "", "also serve the scans over gRPC on this address, streaming results as they come in(e.g. 127.0.0.1:50052, off if not set)")
+	fl.StringVar(&cmd.token, "token", os.Getenv(serveTokenEnv), "only take requests presenting this token(defaults to $"+serveTokenEnv+", open to anyone who can reach the server if not set)")
+	_ = fl.SetAnnotation("token", secretAnnotation, []string{"true"})
+	fl.IntVar(&cmd.workers, "workers", 1, "how many scan jobs to run at once")
+	fl.IntVar(&cmd.queueSize, "queue-size", 64, "how many jobs can wait in the queue, paused ones included, before submissions are turned away")
+	fl.DurationVar(&cmd.ttl, "ttl", time.Hour, "how long finished jobs and their results are kept around")
+	registerStateFileFlag(fl, &cmd.stateFile)
+	registerConfigFlag(fl, &cmd.config)
+}
+
+func (cmd *serveCmd) Run(fl *pflag.FlagSet) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if _, err := applyConfig(fl, cmd.config, cmd.Spec().Name); err != nil {
+		log.Fatalf("failed to load config: %s", err)
+	}
+
+	if cmd.workers < 1 {
+		fl.Usage()
+		log.Fatalf("--workers must be at least 1, got %d", cmd.workers)
+	}
+
+	if cmd.queueSize < 1 {
+		fl.Usage()
+		log.Fatalf("--queue-size must be at least 1, got %d", cmd.queueSize)
+	}
+
+	if cmd.ttl <= 0 {
+		fl.Usage()
+		log.Fatalf("--ttl must be positive, got %s", cmd.ttl)
+	}
+
+	if cmd.token == "" {
+		for _, addr := range []string{cmd.addr, cmd.grpcAddr} {
+			if addr != "" && !loopbackAddr(addr) {
+				log.Printf("warning: no --token set, anyone who can reach %s can run scans through it", addr)
+			}
+		}
+	}
+
+	q := newJobQueue(cmd.queueSize)
+	if err := q.restore(cmd.stateFile); err != nil {
+		log.Fatalf("failed to restore jobs: %s", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < cmd.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+	go q.expire(ctx, cmd.ttl)
+
+	if cmd.grpcAddr != "" {
+		lis, err := net.Listen("tcp", cmd.grpcAddr)
+		if err != nil {
+			log.Fatalf("failed to listen: %s", err)
+		}
+
+		grpcSrv := grpc.NewServer()
+		grpcSrv.RegisterService(&scansServiceDesc, &scansServer{q: q, token: cmd.token})
+		go func() {
+			<-ctx.Done()
+			// Stopping ends every stream, the jobs they follow carry on.
+			grpcSrv.Stop()
+		}()
+		go func() {
+			if err := grpcSrv.Serve(lis); err != nil {
+				log.Printf("failed to serve gRPC: %s", err)
+			}
+		}()
+		log.Printf("serving gRPC on %s...", lis.Addr())
+	}
+
+	handler := sameOrigin(q.handler())
+	if cmd.token == "" {
+		// Without a token a rebound name is all it takes for a page elsewhere to drive the server.
+		handler = knownHost(handler)
+	}
+	srv := &http.Server{Addr: cmd.addr, Handler: requireToken(cmd.token, handler)}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("listening on %s...", cmd.addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatalf("failed to serve: %s", err)
+	}
+
+	// Cancelling ctx stops the running scans, lets wait for them to wrap up.
+	wg.Wait()
+	log.Print("server stopped")
+}
+
+// serveTokenEnv is where serve picks up its token when --token isn't set.
+const serveTokenEnv = "PORT_SCANNER_SERVE_TOKEN"
+
+// loopbackAddr reports whether addr only listens on this machine.
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// validToken reports whether one of the authorization values presented is token.
+func validToken(values []string, token string) bool {
+	for _, v := range values {
+		if subtle.ConstantTimeCompare([]byte(v), []byte("Bearer "+token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// requireToken turns away requests that don't present token, if there is one.
+func requireToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !validToken(r.Header.Values("Authorization"), token) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			respondError(w, http.StatusUnauthorized, xerrors.New("missing or invalid token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// scanRequest is what clients POST to submit a scan. It covers the
+// subset of the scan command's flags that make sense for a shared server.
+type scanRequest struct {
+	Host        string `json:"host"`
+	Ports       string `json:"ports,omitempty"`
+	TopPorts    int    `json:"top_ports,omitempty"`
+	All         bool   `json:"all,omitempty"`
+	Timeout     string `json:"timeout,omitempty"`
+	Concurrency int    `json:"concurrency,omitempty"`
+	// Family forces the address family hostnames are resolved to, "ipv4" or "ipv6".
+	Family string `json:"family,omitempty"`
+	// Force runs a fresh scan even if the same scan is already running or recently finished.
+	Force bool `json:"force,omitempty"`
+}
+
+// requestHash identifies the scan a request plans, the same addresses, ports
+// and options hash the same however the request spelled them out.
+func requestHash(hosts []string, ports []int, opts scanner.Options) string {
+	if opts.Timeout <= 0 {
+		opts.Timeout = scanner.DefaultTimeout
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = scanner.DefaultConcurrency
+	}
+
+	b, _ := json.Marshal(struct {
+		Hosts       []string      `json:"hosts"`
+		Ports       []int         `json:"ports"`
+		Network     string        `json:"network"`
+		Timeout     time.Duration `json:"timeout"`
+		Concurrency int           `json:"concurrency"`
+	}{hosts, ports, opts.Network, opts.Timeout, opts.Concurrency})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// maxRequestConcurrency caps the concurrency a client can ask for, the
+// server's file descriptors are shared by every job running at once.
+const maxRequestConcurrency = 4096
+
+// plan validates r and works out the addresses, ports and options it asks for.
+func (r scanRequest) plan() ([]string, []int, scanner.Options, error) {
+	opts := scanner.Options{
+		Network:       "tcp",
+		Concurrency:   r.Concurrency,
+		ConfirmLevels: scanner.DefaultConfirmLevels,
+	}
+
+	if r.Host == "" {
+		return nil, nil, opts, xerrors.New("host not provided")
+	}
+
+	// A range too big to scan is turned away now rather than failing the job later.
+	hosts, err := scanner.ExpandHost(r.Host)
+	if err != nil {
+		return nil, nil, opts, err
+	}
+
+	selectors := 0
+	for _, set := range []bool{r.Ports != "", r.All, r.TopPorts != 0} {
+		if set {
+			selectors++
+		}
+	}
+	if selectors > 1 {
+		return nil, nil, opts, xerrors.New("ports, all and top_ports are mutually exclusive")
+	}
+
+	if r.Timeout != "" {
+		timeout, err := time.ParseDuration(r.Timeout)
+		if err != nil || timeout <= 0 {
+			return nil, nil, opts, xerrors.Errorf("%q is an invalid timeout", r.Timeout)
+		}
+		opts.Timeout = timeout
+	}
+
+	switch r.Family {
+	case "":
+	case "ipv4":
+		opts.Network = "tcp4"
+	case "ipv6":
+		opts.Network = "tcp6"
+	default:
+		return nil, nil, opts, xerrors.Errorf("%q is an invalid family(ipv4 or ipv6)", r.Family)
+	}
+
+	if r.Concurrency < 0 {
+		return nil, nil, opts, xerrors.Errorf("concurrency can't be negative, got %d", r.Concurrency)
+	}
+	if opts.Concurrency > maxRequestConcurrency {
+		opts.Concurrency = maxRequestConcurrency
+	}
+
+	ports := defaultPorts(r.All)
+	switch {
+	case r.Ports != "":
+		ports, err = scanner.ParsePorts(r.Ports)
+	case r.TopPorts != 0:
+		ports, err = scanner.TopPorts(r.TopPorts)
+	}
+	if err != nil {
+		return nil, nil, opts, err
+	}
+	opts.Ports = ports
+	return hosts, ports, opts, nil
+}
+
+type jobStatus string
+
+const (
+	jobQueued    jobStatus = "queued"
+	jobRunning   jobStatus = "running"
+	jobPaused    jobStatus = "paused"
+	jobDone      jobStatus = "done"
+	jobFailed    jobStatus = "failed"
+	jobCancelled jobStatus = "cancelled"
+)
+
+// job is a submitted scan. Only the fields with json tags are reported on
+// /scans/{id}, the results are paged through /scans/{id}/results.
+type job struct {
+	ID     string    `json:"id"`
+	Status jobStatus `json:"status"`
+	// Hash identifies the scan the job runs(see requestHash).
+	Hash      string      `json:"hash"`
+	Request   scanRequest `json:"request"`
+	Submitted time.Time   `json:"submitted"`
+	Started   *time.Time  `json:"started,omitempty"`
+	Finished  *time.Time  `json:"finished,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	// HostsScanned out of HostsTotal is how far along a running job is.
+	HostsScanned int `json:"hosts_scanned"`
+	HostsTotal   int `json:"hosts_total"`
+
+	// hosts only ever gets appended to under the lock,
+	// so a copied job can keep reading its own slice of it.
+	hosts []*hostResult
+	// events are the latest of what's streamed to the clients following
+	// the job, dropped counts the older ones let go of to keep it under
+	// maxJobEvents, and changed is closed and replaced whenever the job
+	// changes(see follow).
+	events  []scanEvent
+	dropped int
+	changed chan struct{}
+	// stop cancels the job's scan, it's only set while a worker runs it. A
+	// job paused, resumed or cancelled while running keeps it until the
+	// scan has wound down.
+	stop context.CancelFunc
+}
+
+// jobQueue holds every job the server knows about. Workers take the
+// queued job submitted first, and wait on wake when there's none.
+type jobQueue struct {
+	mu      sync.Mutex
+	jobs    map[string]*job
+	size    int
+	wake    chan struct{}
+	metrics *metrics
+	// state is where the jobs are saved as they change, if anywhere(see save).
+	state   string
+	stateMu sync.Mutex
+
+	// historyDB is where finished jobs are recorded, if anywhere, and retention how much of it is kept.
+	historyDB string
+	retention historyRetention
+}
+
+func newJobQueue(size int) *jobQueue {
+	return &jobQueue{
+		jobs:    make(map[string]*job),
+		size:    size,
+		wake:    make(chan struct{}),
+		metrics: newMetrics(),
+	}
+}
+
+var (
+	// errQueueFull is returned when a job is submitted while the queue has no room left.
+	errQueueFull = xerrors.New("the job queue is full, try again later")
+	// errJobNotFound is returned when there's no job with the id asked for.
+	errJobNotFound = xerrors.New("job not found")
+	// errJobState is returned when a job is asked to do something its status doesn't allow.
+	errJobState = xerrors.New("invalid job status")
+)
+
+// submit queues the scan req asks for. Submitting the same scan again while it's
+// queued, running or still kept after finishing returns that job instead,
+// unless req forces a fresh one, so clients can retry without scanning twice.
+// created reports whether the job is a new one.
+func (q *jobQueue) submit(req scanRequest) (j job, created bool, err error) {
+	hosts, ports, opts, err := req.plan()
+	if err != nil {
+		return job{}, false, err
+	}
+	hash := requestHash(hosts, ports, opts)
+
+	q.mu.Lock()
+	if !req.Force {
+		if existing := q.same(hash); existing != nil {
+			j = *existing
+			q.mu.Unlock()
+			return j, false, nil
+		}
+	}
+
+	waiting := 0
+	for _, other := range q.jobs {
+		if other.Status == jobQueued || other.Status == jobPaused {
+			waiting++
+		}
+	}
+	if waiting >= q.size {
+		q.mu.Unlock()
+		return job{}, false, errQueueFull
+	}
+	submitted := &job{
+		ID:        newJobID(),
+		Status:    jobQueued,
+		Hash:      hash,
+		Request:   req,
+		Submitted: time.Now().UTC(),
+		changed:   make(chan struct{}),
+	}
+	q.jobs[submitted.ID] = submitted
+	q.wakeWorkers()
+	j = *submitted
+	q.mu.Unlock()
+
+	q.save()
+	return j, true, nil
+}
+
+// same returns the latest job with hash that's queued, running or done, call
+// it with the lock held. Paused, failed and cancelled jobs aren't reused.
+func (q *jobQueue) same(hash string) *job {
+	var latest *job
+	for _, j := range q.jobs {
+		switch j.Status {
+		case jobQueued, jobRunning, jobDone:
+		default:
+			continue
+		}
+		if j.Hash == hash && (latest == nil || j.Submitted.After(latest.Submitted)) {
+			latest = j
+		}
+	}
+	return latest
+}
+
+// get returns a copy of the job with id since the original keeps changing under the lock.
+func (q *jobQueue) get(id string) (job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return job{}, false
+	}
+	return *j, true
+}
+
+func (q *jobQueue) list() []job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs := make([]job, 0, len(q.jobs))
+	for _, j := range q.jobs {
+		jobs = append(jobs, *j)
+	}
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].Submitted.Before(jobs[k].Submitted) })
+	return jobs
+}
+
+// update applies fn to the job under the lock and wakes up whoever follows it.
+func (q *jobQueue) update(j *job, fn func(j *job)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	fn(j)
+	j.notify()
+}
+
+// maxJobEvents is how many events a job keeps for its followers. Every port
+// found on every host of a range adds up, the results stay pageable on
+// /scans/{id}/results either way.
+const maxJobEvents = 4096
+
+// addEvent keeps e for j's followers, letting go of the oldest half of the
+// events once there are too many. Call it with the lock held.
+func (j *job) addEvent(e scanEvent) {
+	j.events = append(j.events, e)
+	if len(j.events) <= maxJobEvents {
+		return
+	}
+
+	// Followers may still be reading the old slice, so the rest is copied rather than moved.
+	drop := len(j.events) - maxJobEvents/2
+	j.events = append([]scanEvent(nil), j.events[drop:]...)
+	j.dropped += drop
+}
+
+// notify wakes up whoever follows j, call it with the lock held.
+func (j *job) notify() {
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// wakeWorkers wakes up the workers waiting on a job to run, call it with the lock held.
+func (q *jobQueue) wakeWorkers() {
+	close(q.wake)
+	q.wake = make(chan struct{})
+}
+
+// work runs queued jobs one at a time until ctx is done.
+func (q *jobQueue) work(ctx context.Context) {
+	for {
+		j, jobCtx := q.next(ctx)
+		if j == nil {
+			return
+		}
+		q.run(ctx, jobCtx, j)
+	}
+}
+
+// next waits for a queued job, marks it running and returns it along with
+// the context to scan it under. It returns a nil job once ctx is done.
+func (q *jobQueue) next(ctx context.Context) (*job, context.Context) {
+	for {
+		// Jobs put back in the queue by shutting down are left for the next run.
+		if ctx.Err() != nil {
+			return nil, nil
+		}
+
+		q.mu.Lock()
+		var next *job
+		for _, j := range q.jobs {
+			// A job resumed before its scan wound down is still taken.
+			if j.Status == jobQueued && j.stop == nil && (next == nil || j.Submitted.Before(next.Submitted)) {
+				next = j
+			}
+		}
+		if next != nil {
+			jobCtx, stop := context.WithCancel(ctx)
+			next.Status, next.stop = jobRunning, stop
+			// A resumed job keeps when it first started.
+			if next.Started == nil {
+				start := time.Now().UTC()
+				next.Started = &start
+			}
+			next.notify()
+			q.mu.Unlock()
+			q.save()
+			return next, jobCtx
+		}
+		wake := q.wake
+		q.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return nil, nil
+		}
+	}
+}
+
+// run scans j under jobCtx, which is cancelled when j is paused or cancelled
+// and along with ctx when the server shuts down.
+func (q *jobQueue) run(ctx, jobCtx context.Context, j *job) {
+	start := time.Now().UTC()
+	err := q.scan(jobCtx, j)
+
+	q.mu.Lock()
+	j.stop()
+	j.stop = nil
+	finished := time.Now().UTC()
+	switch {
+	// Pausing, resuming or cancelling the job while it ran already decided what comes next.
+	case j.Status == jobPaused:
+	case j.Status == jobQueued:
+		q.wakeWorkers()
+	case j.Status == jobCancelled:
+		j.Finished = &finished
+	// Jobs we save get picked back up once the server is back.
+	case ctx.Err() != nil && q.state != "":
+		j.Status = jobQueued
+	case err != nil:
+		j.Status, j.Error, j.Finished = jobFailed, err.Error(), &finished
+	default:
+		j.Status, j.Finished = jobDone, &finished
+	}
+	done := j.Status == jobDone
+	j.notify()
+	q.mu.Unlock()
+
+	if done && q.historyDB != "" {
+		q.record(j, start)
+	}
+	q.save()
+}
+
+// pause stops the job with id until it's resumed, it can't be finished yet.
+func (q *jobQueue) pause(id string) (job, error) {
+	return q.control(id, func(j *job) error {
+		switch j.Status {
+		case jobQueued, jobRunning:
+			j.Status = jobPaused
+		default:
+			return xerrors.Errorf("can't pause a job that's %s: %w", j.Status, errJobState)
+		}
+		if j.stop != nil {
+			j.stop()
+		}
+		return nil
+	})
+}
+
+// resume queues the paused job with id again.
+func (q *jobQueue) resume(id string) (job, error) {
+	return q.control(id, func(j *job) error {
+		if j.Status != jobPaused {
+			return xerrors.Errorf("can't resume a job that's %s: %w", j.Status, errJobState)
+		}
+		j.Status = jobQueued
+		q.wakeWorkers()
+		return nil
+	})
+}
+
+// cancel stops the job with id for good, keeping the hosts it finished.
+func (q *jobQueue) cancel(id string) (job, error) {
+	return q.control(id, func(j *job) error {
+		switch j.Status {
+		case jobQueued, jobRunning, jobPaused:
+			j.Status = jobCancelled
+		default:
+			return xerrors.Errorf("can't cancel a job that's %s: %w", j.Status, errJobState)
+		}
+		// A running job is finished once its scan has wound down.
+		if j.stop != nil {
+			j.stop()
+			return nil
+		}
+		finished := time.Now().UTC()
+		j.Finished = &finished
+		return nil
+	})
+}
+
+// control applies fn to the job with id under the lock, saves the jobs and
+// returns a copy of the job as fn left it.
+func (q *jobQueue) control(id string, fn func(j *job) error) (job, error) {
+	q.mu.Lock()
+	j, ok := q.jobs[id]
+	if !ok {
+		q.mu.Unlock()
+		return job{}, xerrors.Errorf("there's no job with id %q: %w", id, errJobNotFound)
+	}
+	if err := fn(j); err != nil {
+		q.mu.Unlock()
+		return job{}, err
+	}
+	j.notify()
+	updated := *j
+	q.mu.Unlock()
+
+	q.save()
+	return updated, nil
+}
+
+// scan runs the scan j asks for, reporting progress on j as each host finishes.
+func (q *jobQueue) scan(ctx context.Context, j *job) error {
+	hosts, ports, opts, err := j.Request.plan()
+	if err != nil {
+		return err
+	}
+
+	// A resumed job skips the addresses it already has results for.
+	q.mu.Lock()
+	finished := make(map[string]bool, len(j.hosts))
+	for _, h := range j.hosts {
+		finished[h.Host+" "+h.IP] = true
+	}
+	q.mu.Unlock()
+	q.update(j, func(j *job) { j.HostsScanned, j.HostsTotal = 0, len(hosts) })
+
+	// Lets reuse the scan command, set up the way the request would have set its flags.
+	cmd := &scanCmd{protocol: "tcp", output: "json", noProgress: true}
+	cmd.onFound = func(host string, p scanner.PortResult) {
+		q.update(j, func(j *job) {
+			j.addEvent(scanEvent{Host: host, Port: &p})
+		})
+	}
+
+	for _, host := range hosts {
+		ips, err := scanner.Resolve(ctx, host, opts.Network, scanner.DefaultResolveTimeout)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("job %s: failed to resolve %s: %s", j.ID, host, err)
+			q.metrics.observeFailure()
+			q.update(j, func(j *job) { j.HostsScanned++ })
+			continue
+		}
+
+		// Like scan, a dual-stack host gets scanned over both families.
+		ips, _ = scanner.PickAddresses(host, ips, "dual")
+		ips = cmd.routedAddresses(host, ips)
+		for _, ip := range ips {
+			t := target{host: host, ip: ip, multi: len(ips) > 1}
+			if finished[t.host+" "+t.addr()] {
+				continue
+			}
+			result, err := cmd.scanHost(ctx, t, opts, len(ports))
+			if err != nil {
+				return err
+			}
+
+			// The host is scanned again from the start if the job gets resumed.
+			if result.Interrupted {
+				return xerrors.New("server shut down before the scan finished")
+			}
+			q.update(j, func(j *job) {
+				j.hosts = append(j.hosts, result)
+				j.addEvent(scanEvent{Result: result})
+			})
+			q.save()
+			q.metrics.observe(result.Host, result.IP, openPorts(result), time.Duration(result.Duration), result.Failures)
+		}
+		q.update(j, func(j *job) { j.HostsScanned++ })
+	}
+	return nil
+}
+
+// record adds the hosts j scanned to the history. The job still succeeded
+// when it couldn't be recorded, so that's only logged.
+func (q *jobQueue) record(j *job, start time.Time) {
+	q.mu.Lock()
+	hosts := j.hosts
+	q.mu.Unlock()
+
+	rep := &report{
+		Version:    reportVersion,
+		Invocation: "job " + j.ID + " scanning " + j.Request.Host,
+		Timestamp:  start,
+		Duration:   duration(time.Since(start)),
+		Hosts:      hosts,
+	}
+	if err := recordScan(q.historyDB, rep, q.retention); err != nil {
+		log.Printf("job %s: failed to record scan: %s", j.ID, err)
+	}
+}
+
+// expire drops finished jobs once they're older than ttl. Without it
+// a long running server would keep every result it ever produced.
+func (q *jobQueue) expire(ctx context.Context, ttl time.Duration) {
+	interval := ttl / 10
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			q.mu.Lock()
+			expired := 0
+			for id, j := range q.jobs {
+				if j.Finished != nil && time.Since(*j.Finished) > ttl {
+					delete(q.jobs, id)
+					expired++
+				}
+			}
+			q.mu.Unlock()
+			if expired > 0 {
+				q.save()
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand doesn't fail on any platform we support.
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// A page of results holds defaultPageSize hosts unless the client asks for a
+// limit, which is capped at maxPageSize.
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// resultsPage is a slice of a finished job's host results.
+type resultsPage struct {
+	Total  int           `json:"total"`
+	Offset int           `json:"offset"`
+	Limit  int           `json:"limit"`
+	Hosts  []*hostResult `json:"hosts"`
+}
+
+func (q *jobQueue) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", q.metrics)
+	mux.HandleFunc("/scans", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			respond(w, http.StatusOK, q.list())
+		case http.MethodPost:
+			var req scanRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				respondError(w, http.StatusBadRequest, xerrors.Errorf("failed to parse request: %w", err))
+				return
+			}
+
+			j, created, err := q.submit(req)
+			switch {
+			case xerrors.Is(err, errQueueFull):
+				respondError(w, http.StatusServiceUnavailable, err)
+			case err != nil:
+				respondError(w, http.StatusBadRequest, err)
+			case !created:
+				w.Header().Set("Location", "/scans/"+j.ID)
+				respond(w, http.StatusOK, j)
+			default:
+				w.Header().Set("Location", "/scans/"+j.ID)
+				respond(w, http.StatusAccepted, j)
+			}
+		default:
+			respondError(w, http.StatusMethodNotAllowed, xerrors.Errorf("%s isn't supported", r.Method))
+		}
+	})
+
+	mux.HandleFunc("/scans/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/scans/"), "/")
+		if len(parts) == 2 && (parts[1] == "pause" || parts[1] == "resume" || parts[1] == "cancel") {
+			q.handleControl(w, r, parts[0], parts[1])
+			return
+		}
+
+		if r.Method != http.MethodGet {
+			respondError(w, http.StatusMethodNotAllowed, xerrors.Errorf("%s isn't supported", r.Method))
+			return
+		}
+
+		j, ok := q.get(parts[0])
+		if !ok {
+			respondError(w, http.StatusNotFound, xerrors.Errorf("there's no job with id %q", parts[0]))
+			return
+		}
+
+		switch {
+		case len(parts) == 1:
+			respond(w, http.StatusOK, j)
+		case len(parts) == 2 && parts[1] == "results":
+			page, err := pageResults(j, r)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, err)
+				return
+			}
+			respond(w, http.StatusOK, page)
+		default:
+			respondError(w, http.StatusNotFound, xerrors.Errorf("%q not found", r.URL.Path))
+		}
+	})
+	return mux
+}
+
+// handleControl pauses, resumes or cancels the job with id as action says.
+func (q *jobQueue) handleControl(w http.ResponseWriter, r *http.Request, id, action string) {
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, xerrors.Errorf("%s isn't supported", r.Method))
+		return
+	}
+
+	control := map[string]func(string) (job, error){
+		"pause":  q.pause,
+		"resume": q.resume,
+		"cancel": q.cancel,
+	}[action]
+	j, err := control(id)
+	switch {
+	case xerrors.Is(err, errJobNotFound):
+		respondError(w, http.StatusNotFound, err)
+	case xerrors.Is(err, errJobState):
+		respondError(w, http.StatusConflict, err)
+	case err != nil:
+		respondError(w, http.StatusInternalServerError, err)
+	default:
+		respond(w, http.StatusOK, j)
+	}
+}
+
+// pageResults cuts the page the offset and limit query parameters ask for out of j's results.
+// Results of hosts scanned so far are available while the job is still running.
+func pageResults(j job, r *http.Request) (resultsPage, error) {
+	page := resultsPage{Limit: defaultPageSize, Hosts: []*hostResult{}}
+
+	for name, dst := range map[string]*int{"offset": &page.Offset, "limit": &page.Limit} {
+		raw := r.URL.Query().Get(name)
+		if raw == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return page, xerrors.Errorf("%q is an invalid %s", raw, name)
+		}
+		*dst = n
+	}
+
+	if page.Limit == 0 {
+		return page, xerrors.New("limit has to be at least 1")
+	}
+	if page.Limit > maxPageSize {
+		page.Limit = maxPageSize
+	}
+
+	hosts := j.hosts
+	page.Total = len(hosts)
+	if page.Offset < len(hosts) {
+		end := page.Offset + page.Limit
+		if end > len(hosts) {
+			end = len(hosts)
+		}
+		page.Hosts = hosts[page.Offset:end]
+	}
+	return page, nil
+}
+
+func respond(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Printf("failed to write response: %s", err)
+	}
+}
+
+func respondError(w http.ResponseWriter, code int, err error) {
+	respond(w, code, map[string]string{"error": err.Error()})
+}
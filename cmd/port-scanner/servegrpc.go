@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+// The scans service lets infrastructure written in any language with a gRPC
+// library submit scans to serve and consume the results as they come in. Like the
+// agent service it's described by hand with json messages, so clients call it
+// with the "json" content-subtype rather than protobufs:
+//
+//	service Scans {
+//	  // Scan submits a scan like POST /scans does, or picks up the same one
+//	  // submitted before, and follows it.
+//	  rpc Scan(scanRequest) returns (stream scanEvent);
+//	  // Follow follows a job that's already been submitted, even over REST.
+//	  rpc Follow(followRequest) returns (stream scanEvent);
+//	}
+//
+// A stream starts with the job as it was submitted, replays whatever the job
+// found so far, or the latest maxJobEvents of it, and then keeps going until
+// the job is done. Jobs go through the same queue the REST API uses, and like
+// it the service takes serve's --token, which clients send as
+// "authorization: Bearer <token>" metadata.
+var scansServiceDesc = grpc.ServiceDesc{
+	ServiceName: "portscanner.Scans",
+	HandlerType: (*scansService)(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Scan",
+			ServerStreams: true,
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				var req scanRequest
+				if err := stream.RecvMsg(&req); err != nil {
+					return err
+				}
+				return srv.(scansService).Scan(&req, stream)
+			},
+		},
+		{
+			StreamName:    "Follow",
+			ServerStreams: true,
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				var req followRequest
+				if err := stream.RecvMsg(&req); err != nil {
+					return err
+				}
+				return srv.(scansService).Follow(&req, stream)
+			},
+		},
+	},
+}
+
+type scansService interface {
+	Scan(req *scanRequest, stream grpc.ServerStream) error
+	Follow(req *followRequest, stream grpc.ServerStream) error
+}
+
+// followRequest names the job to follow.
+type followRequest struct {
+	ID string `json:"id"`
+}
+
+// scanEvent is streamed to the clients following a job, each one carries either
+// the job, a port or a host's result.
+type scanEvent struct {
+	// Job is the job's status, sent first and last.
+	Job *job `json:"job,omitempty"`
+	// Port is every port of Host as soon as it turns out to be reachable.
+	Host string              `json:"host,omitempty"`
+	Port *scanner.PortResult `json:"port,omitempty"`
+	// Result is every host's result once it's done.
+	Result *hostResult `json:"result,omitempty"`
+	// Done is set along with the job on the last event.
+	Done bool `json:"done,omitempty"`
+}
+
+type scansServer struct {
+	q     *jobQueue
+	token string
+}
+
+// authenticate checks the client presented serve's token, if it has one.
+func (s *scansServer) authenticate(ctx context.Context) error {
+	if s.token == "" {
+		return nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	if validToken(md.Get("authorization"), s.token) {
+		return nil
+	}
+	return status.Error(codes.Unauthenticated, "missing or invalid token")
+}
+
+func (s *scansServer) Scan(req *scanRequest, stream grpc.ServerStream) error {
+	if err := s.authenticate(stream.Context()); err != nil {
+		return err
+	}
+
+	j, _, err := s.q.submit(*req)
+	switch {
+	case xerrors.Is(err, errQueueFull):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case err != nil:
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return s.q.follow(stream.Context(), j.ID, stream)
+}
+
+func (s *scansServer) Follow(req *followRequest, stream grpc.ServerStream) error {
+	if err := s.authenticate(stream.Context()); err != nil {
+		return err
+	}
+	return s.q.follow(stream.Context(), req.ID, stream)
+}
+
+// follow streams the events of the job with id until it's done or ctx is.
+func (q *jobQueue) follow(ctx context.Context, id string, stream grpc.ServerStream) error {
+	q.mu.Lock()
+	j, ok := q.jobs[id]
+	q.mu.Unlock()
+	if !ok {
+		return status.Errorf(codes.NotFound, "there's no job with id %q", id)
+	}
+
+	first := true
+	sent := 0
+	for {
+		q.mu.Lock()
+		snapshot := *j
+		// A follower that fell behind the events the job keeps picks up with the oldest one left.
+		if sent < j.dropped {
+			sent = j.dropped
+		}
+		events := j.events[sent-j.dropped:]
+		q.mu.Unlock()
+
+		if first {
+			if err := stream.SendMsg(&scanEvent{Job: &snapshot}); err != nil {
+				return err
+			}
+			first = false
+		}
+
+		for i := range events {
+			if err := stream.SendMsg(&events[i]); err != nil {
+				return err
+			}
+		}
+		sent += len(events)
+
+		// Everything the job found is published before it's marked finished.
+		if snapshot.Finished != nil {
+			return stream.SendMsg(&scanEvent{Job: &snapshot, Done: true})
+		}
+
+		select {
+		case <-snapshot.changed:
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
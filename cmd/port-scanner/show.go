@@ -0,0 +1,92 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/spf13/pflag"
+	"go.coder.com/cli"
+)
+
+// show renders a saved result again, so a scan only has to be run once
+// to end up in every format somebody asks for.
+type showCmd struct {
+	output         string
+	outputTemplate string
+	noColor        bool
+}
+
+func (cmd *showCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "show",
+		Usage: "[flags] <results.json|nmap.xml>",
+		Desc:  "Print a scan saved with --save, --baseline or --output json, or nmap's -oX output, in any output format.",
+	}
+}
+
+func (cmd *showCmd) RegisterFlags(fl *pflag.FlagSet) {
+	fl.StringVarP(&cmd.output, "output", "o", "text", "output format("+outputFormats()+")")
+	fl.StringVar(&cmd.outputTemplate, "output-template", "", "render the results through this go text/template file instead of an --output format")
+	fl.BoolVar(&cmd.noColor, "no-color", false, "don't color the port table(only drawn when stdout is a terminal)")
+}
+
+func (cmd *showCmd) Run(fl *pflag.FlagSet) {
+	if fl.NArg() != 1 {
+		fl.Usage()
+		log.Fatal("expected exactly one result file")
+	}
+
+	write, ok := reportWriters[cmd.output]
+	if !ok && cmd.output != "text" {
+		fl.Usage()
+		log.Fatalf("%q is an unsupported output format", cmd.output)
+	}
+
+	if cmd.outputTemplate != "" {
+		if cmd.output != "text" {
+			fl.Usage()
+			log.Fatal("--output and --output-template are mutually exclusive")
+		}
+
+		tmpl, err := parseOutputTemplate(cmd.outputTemplate)
+		if err != nil {
+			log.Fatalf("invalid --output-template: %s", err)
+		}
+		write = templateWriter(tmpl)
+	}
+
+	rep, err := readReport(fl.Arg(0))
+	if err != nil {
+		log.Fatalf("failed to read results: %s", err)
+	}
+
+	if write != nil {
+		if err := write(os.Stdout, rep); err != nil {
+			log.Fatalf("failed to write %s output: %s", cmd.output, err)
+		}
+		return
+	}
+
+	log.Printf("results of %s", rep.Invocation)
+	for _, h := range rep.Hosts {
+		// The scan's flags aren't saved, but what they added to the ports is.
+		text := &scanCmd{output: "text", noColor: cmd.noColor}
+		for _, p := range h.Ports {
+			text.guessProtocol = text.guessProtocol || p.GuessedProtocol != "" || p.GuessError != ""
+			text.banners = text.banners || p.Banner != ""
+			text.serviceVersion = text.serviceVersion || p.Version != nil
+		}
+
+		log.Printf("%s was scanned at %s", hostKey(h), h.Timestamp.Local().Format("2006-01-02 15:04:05"))
+		text.logResult(h)
+		if isTerminal(os.Stdout) {
+			printTable(os.Stdout, h, text.useColor())
+		}
+	}
+	if rep.HostSummary != nil && rep.HostSummary.Total > 1 {
+		logHostSummary(rep.HostSummary)
+	}
+	if rep.Interrupted {
+		log.Print("the scan was interrupted, results are partial")
+	}
+}
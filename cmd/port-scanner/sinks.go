@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"io"
+)
+
+// reportSink is somewhere the report of a finished scan gets delivered. Every
+// output a run was asked for is one, so adding another is a matter of adding
+// a sink instead of another step at the end of the scan.
+type reportSink interface {
+	// what is what the sink does, for the error a failed delivery ends the run with.
+	what() string
+	deliver(rep *report) error
+}
+
+// reportSinks are the sinks the flags of cmd ask for, in the order they get the report.
+// write is the --output format's writer, nil for text output which is logged as hosts finish.
+func (cmd *scanCmd) reportSinks(write func(io.Writer, *report) error, stdout io.Writer) []reportSink {
+	var sinks []reportSink
+	if write != nil {
+		sinks = append(sinks, &outputSink{format: cmd.output, write: write, w: stdout})
+	}
+	if cmd.save != "" {
+		sinks = append(sinks, saveSink(cmd.save))
+	}
+	if cmd.record {
+		sinks = append(sinks, &historySink{path: cmd.historyDB, retention: cmd.retention})
+	}
+	if cmd.webhook.enabled() {
+		sinks = append(sinks, webhookSink{&cmd.webhook})
+	}
+	if cmd.syslog.enabled() {
+		sinks = append(sinks, &cmd.syslog)
+	}
+	if len(cmd.publishers) > 0 {
+		sinks = append(sinks, cmd.publishers)
+	}
+	return sinks
+}
+
+// outputSink writes the report in an --output format to stdout or --out.
+type outputSink struct {
+	format string
+	write  func(io.Writer, *report) error
+	w      io.Writer
+}
+
+func (s *outputSink) what() string              { return "write " + s.format + " output" }
+func (s *outputSink) deliver(rep *report) error { return s.write(s.w, rep) }
+
+// saveSink saves the report as json for diff and show, it's the path of --save.
+type saveSink string
+
+func (s saveSink) what() string              { return "save results" }
+func (s saveSink) deliver(rep *report) error { return saveReport(string(s), rep) }
+
+// historySink records the report in the scan history.
+type historySink struct {
+	path      string
+	retention historyRetention
+}
+
+func (s *historySink) what() string              { return "record scan" }
+func (s *historySink) deliver(rep *report) error { return recordScan(s.path, rep, s.retention) }
+
+// webhookSink POSTs the report as a "scan" event.
+type webhookSink struct{ *webhook }
+
+func (s webhookSink) what() string { return "send results to webhook" }
+
+func (s webhookSink) deliver(rep *report) error {
+	// The scan's context is already cancelled when it was interrupted,
+	// but partial results are still worth delivering.
+	return s.send(context.Background(), "scan", rep)
+}
+
+func (s *syslogSink) what() string              { return "send results to syslog" }
+func (s *syslogSink) deliver(rep *report) error { return s.send(rep) }
+
+func (ps publishers) what() string              { return "publish results" }
+func (ps publishers) deliver(rep *report) error { return ps.send(context.Background(), rep) }
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+	"github.com/spf13/pflag"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/xerrors"
+)
+
+// sshJump is how to reach the ssh bastion scans get tunneled through.
+type sshJump struct {
+	target     string
+	keys       []string
+	knownHosts string
+	timeout    time.Duration
+}
+
+// defaultSSHKeys are the private keys we try when --ssh-key isn't set, the same ones ssh does.
+var defaultSSHKeys = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+func registerSSHJumpFlags(fl *pflag.FlagSet, j *sshJump) {
+	fl.StringVar(&j.target, "ssh-jump", "", "tunnel connect scans through this ssh bastion(user@host[:port])")
+	fl.StringSliceVar(&j.keys, "ssh-key", nil, "private keys to authenticate to the bastion with(defaults to ssh-agent and ~/.ssh/id_*)")
+	fl.StringVar(&j.knownHosts, "ssh-known-hosts", defaultKnownHosts(), "known_hosts file to verify the bastion's host key against")
+	fl.DurationVar(&j.timeout, "ssh-timeout", 10*time.Second, "how long to wait on connecting to the bastion")
+}
+
+func defaultKnownHosts() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".ssh", "known_hosts")
+}
+
+// enabled reports whether a bastion was set.
+func (j *sshJump) enabled() bool {
+	return j.target != ""
+}
+
+// dial connects to the bastion. Its host key has to be in the known_hosts
+// file already, just like ssh with StrictHostKeyChecking on.
+func (j *sshJump) dial(ctx context.Context) (*scanner.SSHJump, error) {
+	username, addr, err := parseSSHTarget(j.target)
+	if err != nil {
+		return nil, err
+	}
+
+	hostKeys, err := knownhosts.New(j.knownHosts)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read known hosts: %w", err)
+	}
+
+	auth, closeAgent, err := j.authMethods()
+	if err != nil {
+		return nil, err
+	}
+	defer closeAgent()
+
+	return scanner.DialSSHJump(ctx, addr, &ssh.ClientConfig{
+		User:            username,
+		Auth:            auth,
+		HostKeyCallback: hostKeys,
+		Timeout:         j.timeout,
+	})
+}
+
+// authMethods offers the bastion whatever ssh-agent holds followed by our key files.
+// The agent is only needed until we're authenticated, so call done once dialed.
+func (j *sshJump) authMethods() (methods []ssh.AuthMethod, done func(), err error) {
+	done = func() {}
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+			done = func() { conn.Close() }
+		}
+	}
+
+	paths := j.keys
+	explicit := len(paths) > 0
+	if !explicit {
+		home, _ := os.UserHomeDir()
+		for _, name := range defaultSSHKeys {
+			paths = append(paths, filepath.Join(home, ".ssh", name))
+		}
+	}
+
+	var signers []ssh.Signer
+	for _, path := range paths {
+		pem, err := ioutil.ReadFile(path)
+		if err != nil {
+			// Not everyone has every kind of key, only the ones asked for have to be there.
+			if !explicit && os.IsNotExist(err) {
+				continue
+			}
+			return nil, done, xerrors.Errorf("failed to read ssh key: %w", err)
+		}
+
+		signer, err := ssh.ParsePrivateKey(pem)
+		if err != nil {
+			var missing *ssh.PassphraseMissingError
+			if !explicit && xerrors.As(err, &missing) {
+				continue
+			}
+			return nil, done, xerrors.Errorf("failed to parse ssh key %s(load passphrase protected keys into ssh-agent instead): %w", path, err)
+		}
+		signers = append(signers, signer)
+	}
+
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+
+	if len(methods) == 0 {
+		return nil, done, xerrors.New("no ssh keys to authenticate with(start ssh-agent or pass --ssh-key)")
+	}
+	return methods, done, nil
+}
+
+// parseSSHTarget splits user@host[:port] into the username and bastion address,
+// defaulting to the current user and port 22 like ssh does.
+func parseSSHTarget(target string) (username, addr string, err error) {
+	host := target
+	if i := strings.LastIndex(target, "@"); i >= 0 {
+		username, host = target[:i], target[i+1:]
+	}
+
+	if username == "" {
+		u, err := user.Current()
+		if err != nil {
+			return "", "", xerrors.Errorf("failed to look up the current user: %w", err)
+		}
+		username = u.Username
+	}
+
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), "22")
+	}
+
+	if strings.HasPrefix(host, ":") {
+		return "", "", xerrors.Errorf("%q is missing the bastion host", target)
+	}
+	return username, host, nil
+}
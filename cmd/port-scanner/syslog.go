@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/xerrors"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+const syslogTimeout = 10 * time.Second
+
+// Events are sent from the local0 facility with the notice severity.
+const syslogPriority = 16*8 + 5
+
+// syslogSink sends a CEF or LEEF event for every reported port to a syslog
+// endpoint, which is how SIEMs like Splunk and QRadar prefer to ingest them.
+type syslogSink struct {
+	addr   string
+	format string
+	ca     string
+}
+
+func registerSyslogFlags(fl *pflag.FlagSet, s *syslogSink) {
+	fl.StringVar(&s.addr, "syslog", "", "send an event for every reported port to this syslog endpoint(e.g. udp://siem:514, tcp://siem:514 or tls://siem:6514)")
+	fl.StringVar(&s.format, "syslog-format", "cef", "format of the syslog events(cef or leef)")
+	fl.StringVar(&s.ca, "syslog-ca", "", "trust certificates signed by this ca for tls:// syslog endpoints(the system roots if not set)")
+}
+
+func (s *syslogSink) enabled() bool { return s.addr != "" }
+
+// validate checks the flags without connecting, so a typo fails before the scan does.
+func (s *syslogSink) validate() error {
+	if !s.enabled() {
+		return nil
+	}
+
+	switch s.format {
+	case "cef", "leef":
+	default:
+		return xerrors.Errorf("%q is an unsupported syslog format(cef or leef)", s.format)
+	}
+
+	_, _, err := s.endpoint()
+	return err
+}
+
+// endpoint returns the network and address of --syslog.
+func (s *syslogSink) endpoint() (network, addr string, err error) {
+	u, err := url.Parse(s.addr)
+	if err != nil || u.Host == "" {
+		return "", "", xerrors.Errorf("--syslog must be a url like udp://host:514, got %q", s.addr)
+	}
+
+	switch u.Scheme {
+	case "udp", "tcp", "tls":
+	default:
+		return "", "", xerrors.Errorf("%q is an unsupported syslog transport(udp, tcp or tls)", u.Scheme)
+	}
+
+	addr = u.Host
+	if u.Port() == "" {
+		port := "514"
+		if u.Scheme == "tls" {
+			port = "6514"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	return u.Scheme, addr, nil
+}
+
+// send delivers an event for every port of rep, in the order they're reported.
+func (s *syslogSink) send(rep *report) error {
+	network, addr, err := s.endpoint()
+	if err != nil {
+		return err
+	}
+
+	var conn net.Conn
+	d := &net.Dialer{Timeout: syslogTimeout}
+	if network == "tls" {
+		cfg := &tls.Config{}
+		if s.ca != "" {
+			pem, err := ioutil.ReadFile(s.ca)
+			if err != nil {
+				return xerrors.Errorf("failed to read --syslog-ca: %w", err)
+			}
+			cfg.RootCAs = x509.NewCertPool()
+			if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+				return xerrors.Errorf("no certificates in --syslog-ca %q", s.ca)
+			}
+		}
+		conn, err = tls.DialWithDialer(d, "tcp", addr, cfg)
+	} else {
+		conn, err = d.Dial(network, addr)
+	}
+	if err != nil {
+		return xerrors.Errorf("failed to connect to syslog endpoint: %w", err)
+	}
+	defer conn.Close()
+
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+
+	for _, h := range rep.Hosts {
+		for _, p := range h.Ports {
+			event := s.event(h, p)
+			// RFC 5424 syslog, minus the structured data.
+			msg := fmt.Sprintf("<%d>1 %s %s port-scanner %d - - %s", syslogPriority, h.Timestamp.Format(time.RFC3339), hostname, os.Getpid(), event)
+			if err := conn.SetWriteDeadline(time.Now().Add(syslogTimeout)); err != nil {
+				return xerrors.Errorf("failed to set deadline: %w", err)
+			}
+			if _, err := conn.Write(syslogFrame(network, msg)); err != nil {
+				return xerrors.Errorf("failed to send event: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// syslogFrame frames msg for network. A datagram is a message of its own, over
+// tls RFC 5425 has every message prefixed with its length, and over plain tcp
+// receivers expect one message per line.
+func syslogFrame(network, msg string) []byte {
+	switch network {
+	case "udp":
+		return []byte(msg)
+	case "tls":
+		return []byte(strconv.Itoa(len(msg)) + " " + msg)
+	}
+	return []byte(msg + "\n")
+}
+
+func (s *syslogSink) event(h *hostResult, p portResult) string {
+	if s.format == "leef" {
+		return leefEvent(h, p)
+	}
+	return cefEvent(h, p)
+}
+
+// productVersion is the version of the module we were built from, if it's known.
+func productVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
+
+// eventSeverity rates how interesting a port is to a SIEM on CEF's 0-10 scale.
+func eventSeverity(p portResult) int {
+	if p.State == scanner.StateOpen {
+		return 5
+	}
+	return 2
+}
+
+// eventID identifies what kind of event p is, e.g. "port-open" or "port-open-filtered".
+func eventID(p portResult) string {
+	return "port-" + strings.Replace(string(p.State), "|", "-", -1)
+}
+
+// cefEvent renders p as an ArcSight Common Event Format event.
+func cefEvent(h *hostResult, p portResult) string {
+	header := []string{
+		"CEF:0", "fuskovic", "port-scanner", productVersion(),
+		eventID(p), "Port " + string(p.State), strconv.Itoa(eventSeverity(p)),
+	}
+	for i := 1; i < len(header); i++ {
+		header[i] = cefHeaderEscaper.Replace(header[i])
+	}
+
+	ext := [][2]string{
+		{"rt", strconv.FormatInt(h.Timestamp.UnixNano()/int64(time.Millisecond), 10)},
+		{"dst", h.IP},
+		{"dpt", strconv.Itoa(p.Port)},
+		{"proto", strings.ToUpper(h.Protocol)},
+		{"outcome", string(p.State)},
+	}
+	if h.Host != h.IP {
+		ext = append(ext, [2]string{"dhost", h.Host})
+	}
+	if p.Service != "" {
+		ext = append(ext, [2]string{"app", p.Service})
+	}
+
+	fields := make([]string, len(ext))
+	for i, kv := range ext {
+		fields[i] = kv[0] + "=" + cefValueEscaper.Replace(kv[1])
+	}
+	return strings.Join(header, "|") + "|" + strings.Join(fields, " ")
+}
+
+var (
+	cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+	cefValueEscaper  = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+)
+
+// leefEvent renders p as an IBM QRadar Log Event Extended Format 1.0 event,
+// its attributes are separated by tabs.
+func leefEvent(h *hostResult, p portResult) string {
+	header := strings.Join([]string{
+		"LEEF:1.0", "fuskovic", "port-scanner", productVersion(), eventID(p),
+	}, "|")
+
+	attrs := [][2]string{
+		{"devTime", h.Timestamp.Format("Jan 02 2006 15:04:05.000 MST")},
+		{"devTimeFormat", "MMM dd yyyy HH:mm:ss.SSS z"},
+		{"dst", h.IP},
+		{"dstPort", strconv.Itoa(p.Port)},
+		{"proto", strings.ToUpper(h.Protocol)},
+		{"sev", strconv.Itoa(eventSeverity(p))},
+		{"state", string(p.State)},
+	}
+	if h.Host != h.IP {
+		attrs = append(attrs, [2]string{"dstHost", h.Host})
+	}
+	if p.Service != "" {
+		attrs = append(attrs, [2]string{"service", p.Service})
+	}
+
+	fields := make([]string, len(attrs))
+	for i, kv := range attrs {
+		fields[i] = kv[0] + "=" + leefValueEscaper.Replace(kv[1])
+	}
+	return header + "|" + strings.Join(fields, "\t")
+}
+
+var leefValueEscaper = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+// stateColors are the ansi colors port states get in the table.
+var stateColors = map[scanner.State]string{
+	scanner.StateOpen:         "\033[32m",
+	scanner.StateOpenFiltered: "\033[33m",
+	scanner.StateClosed:       "\033[31m",
+	scanner.StateFiltered:     "\033[2m",
+	scanner.StateUnfiltered:   "\033[36m",
+	// An idle scan can't tell the two apart.
+	scanner.StateClosedFiltered: "\033[2m",
+}
+
+const (
+	colorDefault = "\033[39m"
+	colorReset   = "\033[0m"
+)
+
+// useColor reports whether the table should be colored, NO_COLOR(https://no-color.org) counts as --no-color.
+func (cmd *scanCmd) useColor() bool {
+	return !cmd.noColor && os.Getenv("NO_COLOR") == ""
+}
+
+// printTable renders the ports of r as aligned columns, which text output
+// adds on stdout when it's a terminal since that's where people read it.
+func printTable(w io.Writer, r *hostResult, color bool) {
+	if len(r.Ports) == 0 {
+		return
+	}
+
+	target := r.Host
+	if r.Host != r.IP {
+		target = fmt.Sprintf("%s(%s)", r.Host, r.IP)
+	}
+	fmt.Fprintln(w, target)
+
+	// tabwriter counts the escape codes as width, so every cell of the state column,
+	// header included, gets wrapped in codes of the same length to keep the columns lined up.
+	colorize := func(code, text string) string {
+		if !color {
+			return text
+		}
+		return code + text + colorReset
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "PORT\t%s\tSERVICE\tLATENCY\n", colorize(colorDefault, "STATE"))
+	for _, p := range r.Ports {
+		code, ok := stateColors[p.State]
+		if !ok {
+			code = colorDefault
+		}
+		state := colorize(code, string(p.State))
+
+		service := p.Service
+		if p.GuessedProtocol != "" {
+			service = p.GuessedProtocol
+		}
+
+		var latency string
+		if p.Latency > 0 {
+			latency = p.Latency.String()
+		}
+		fmt.Fprintf(tw, "%d/%s\t%s\t%s\t%s\n", p.Port, r.Protocol, state, service, latency)
+	}
+	tw.Flush()
+}
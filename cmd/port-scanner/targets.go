@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/xerrors"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+// readTargets reads newline-delimited targets from path, "-" reads stdin.
+// Blank lines and lines starting with # are skipped so target lists can be commented.
+func readTargets(path string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to open %q: %w", path, err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var targets []string
+	lines := bufio.NewScanner(r)
+	for lines.Scan() {
+		line := strings.TrimSpace(lines.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		targets = append(targets, line)
+	}
+
+	if err := lines.Err(); err != nil {
+		return nil, xerrors.Errorf("failed to read targets: %w", err)
+	}
+	return targets, nil
+}
+
+// srvTargets looks up the SRV records of --srv-services under domain and
+// returns the targets they point at as host:port, the way --targets-file
+// takes them. Records of another protocol than the one being scanned are
+// left out, an SRV record names the protocol its port is served over.
+func (cmd *scanCmd) srvTargets(ctx context.Context, domain string) []string {
+	records, err := scanner.LookupSRV(ctx, domain, cmd.srvServices, cmd.resolveTimeout)
+	if err != nil {
+		log.Fatalf("failed to look up srv records of %q: %s", domain, err)
+	}
+
+	var specs []string
+	for _, r := range records {
+		// tls is tcp as far as the scan is concerned.
+		proto := r.Protocol
+		if proto == "tls" {
+			proto = "tcp"
+		}
+		if proto != cmd.protocol {
+			cmd.debugf("skipping %s.%s(%s:%d), it's served over %s", r.Service, domain, r.Host, r.Port, r.Protocol)
+			continue
+		}
+		cmd.debugf("%s.%s points at %s:%d", r.Service, domain, r.Host, r.Port)
+		specs = append(specs, net.JoinHostPort(r.Host, strconv.Itoa(r.Port)))
+	}
+
+	if len(specs) == 0 {
+		log.Fatalf("%q has no %s srv records to scan", domain, cmd.protocol)
+	}
+	cmd.infof("%s: found %d %s srv records", domain, len(specs), cmd.protocol)
+	return specs
+}
+
+// addSource adds the targets of a --targets inventory to cmd.discovered.
+func (cmd *scanCmd) addSource(ctx context.Context, fl *pflag.FlagSet, source string) {
+	u, err := url.Parse(source)
+	if err != nil {
+		fl.Usage()
+		log.Fatalf("invalid --targets: %s", err)
+	}
+
+	switch u.Scheme {
+	case "aws":
+		src, err := parseAWSSource(u)
+		if err != nil {
+			fl.Usage()
+			log.Fatalf("invalid --targets: %s", err)
+		}
+		instances, err := src.instances(ctx)
+		if err != nil {
+			log.Fatalf("failed to list the ec2 instances of %s: %s", src.region, err)
+		}
+		if len(instances) == 0 {
+			log.Fatalf("%s has no running instances to scan", source)
+		}
+		before := len(cmd.discovered)
+		src.addInstances(cmd.discovered, instances)
+		cmd.infof("aws %s: found %d running instances at %d addresses", src.region, len(instances), len(cmd.discovered)-before)
+	case "consul":
+		src, err := parseConsulSource(u)
+		if err != nil {
+			fl.Usage()
+			log.Fatalf("invalid --targets: %s", err)
+		}
+		instances, err := src.instances(ctx)
+		if err != nil {
+			log.Fatalf("failed to read the consul catalog at %s: %s", src.base, err)
+		}
+		if len(instances) == 0 {
+			log.Fatalf("%s has no registered services to scan", source)
+		}
+		before := len(cmd.discovered)
+		addConsulInstances(cmd.discovered, instances, cmd.protocol)
+		cmd.infof("consul %s: found %d service instances at %d addresses", src.base, len(instances), len(cmd.discovered)-before)
+	default:
+		fl.Usage()
+		log.Fatalf("invalid --targets: %q isn't a supported inventory(aws://region or consul://agent)", source)
+	}
+}
+
+// splitTargetPorts splits the ports off a target like 10.0.0.5:22,80,443,
+// host:8000-8100 or [2001:db8::1]:22, which are then the only ports that
+// target gets scanned on. ports is nil when spec doesn't come with any.
+// A bare ipv6 address has colons of its own, so it takes brackets to have ports.
+func splitTargetPorts(spec string) (host string, ports []int, err error) {
+	i := strings.LastIndexByte(spec, ':')
+	if i < 0 {
+		return spec, nil, nil
+	}
+
+	host = spec[:i]
+	if strings.HasPrefix(host, "[") {
+		if !strings.HasSuffix(host, "]") {
+			return spec, nil, nil
+		}
+	} else if strings.Contains(host, ":") {
+		return spec, nil, nil
+	}
+
+	ports, err = scanner.ParsePorts(spec[i+1:])
+	if err != nil {
+		return "", nil, xerrors.Errorf("%q has invalid ports: %w", spec, err)
+	}
+	return host, ports, nil
+}
+
+// dedupeTargets merges the targets sharing an address, which overlapping
+// ranges or a name resolving to an address that's already listed make for,
+// so every address is only scanned once. The first name an address was
+// listed under that isn't the address itself names the target, the other
+// names become its aliases. A target given ports of its own is scanned on
+// every port it was given under any name, and on ports too if it was also
+// listed without any. merged is how many targets were folded into others.
+func dedupeTargets(targets []target, ports []int) (deduped []target, merged int) {
+	byAddr := make(map[string]int, len(targets))
+	// bare marks the targets that were listed without ports of their own under some name.
+	bare := make(map[int]bool)
+	for _, t := range targets {
+		i, ok := byAddr[t.addr()]
+		if !ok {
+			byAddr[t.addr()] = len(deduped)
+			bare[len(deduped)] = t.ports == nil
+			deduped = append(deduped, t)
+			continue
+		}
+		merged++
+
+		d := &deduped[i]
+		switch {
+		case !scanner.IsHostname(t.host) || t.host == d.host:
+		case !scanner.IsHostname(d.host):
+			d.host = t.host
+		case !containsString(d.aliases, t.host):
+			d.aliases = append(d.aliases, t.host)
+		}
+
+		switch {
+		case t.ports == nil && d.ports != nil && !bare[i]:
+			d.ports = mergeInts(d.ports, ports)
+		case t.ports != nil && d.ports == nil:
+			d.ports = mergeInts(ports, t.ports)
+		case t.ports != nil:
+			d.ports = mergeInts(d.ports, t.ports)
+		}
+		bare[i] = bare[i] || t.ports == nil
+	}
+	return deduped, merged
+}
+
+// mergeInts returns a followed by the ints of b that aren't in it.
+func mergeInts(a, b []int) []int {
+	seen := make(map[int]bool, len(a))
+	merged := append([]int(nil), a...)
+	for _, n := range a {
+		seen[n] = true
+	}
+	for _, n := range b {
+		if !seen[n] {
+			seen[n] = true
+			merged = append(merged, n)
+		}
+	}
+	return merged
+}
+
+func containsString(list []string, s string) bool {
+	for _, elem := range list {
+		if elem == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(list []int, n int) bool {
+	for _, elem := range list {
+		if elem == n {
+			return true
+		}
+	}
+	return false
+}
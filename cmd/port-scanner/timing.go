@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/xerrors"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+// timingTemplate bundles the knobs trading stealth for speed, after nmap's -T0 to -T5.
+type timingTemplate struct {
+	name        string
+	timeout     time.Duration
+	concurrency int
+	retries     int
+	// delay is the least time between two probes, zero doesn't hold them back.
+	delay time.Duration
+}
+
+var timingTemplates = []timingTemplate{
+	{name: "paranoid", timeout: 10 * time.Second, concurrency: 1, retries: 2, delay: 5 * time.Minute},
+	{name: "sneaky", timeout: 10 * time.Second, concurrency: 1, retries: 2, delay: 15 * time.Second},
+	{name: "polite", timeout: 5 * time.Second, concurrency: 16, retries: 2, delay: 400 * time.Millisecond},
+	{name: "normal", timeout: scanner.DefaultTimeout, concurrency: scanner.DefaultConcurrency},
+	{name: "aggressive", timeout: time.Second, concurrency: 1024, retries: 1},
+	{name: "insane", timeout: 250 * time.Millisecond, concurrency: 4096},
+}
+
+// parseTiming accepts a template by its number or its name.
+func parseTiming(s string) (timingTemplate, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < 0 || n >= len(timingTemplates) {
+			return timingTemplate{}, xerrors.Errorf("%d is out of range(0-%d)", n, len(timingTemplates)-1)
+		}
+		return timingTemplates[n], nil
+	}
+
+	for _, t := range timingTemplates {
+		if strings.EqualFold(s, t.name) {
+			return t, nil
+		}
+	}
+	return timingTemplate{}, xerrors.Errorf("%q is not a timing template", s)
+}
+
+func timingNames() string {
+	names := make([]string, len(timingTemplates))
+	for i, t := range timingTemplates {
+		names[i] = strconv.Itoa(i) + "=" + t.name
+	}
+	return strings.Join(names, " ")
+}
+
+// applyTiming sets the timeout, concurrency, retries and probe rate from the --timing template.
+// Like --fast, anything set explicitly on the command line wins over the template.
+func (cmd *scanCmd) applyTiming(fl *pflag.FlagSet) error {
+	if cmd.timing == "" {
+		return nil
+	}
+
+	t, err := parseTiming(cmd.timing)
+	if err != nil {
+		return err
+	}
+
+	if !fl.Changed("timeout") {
+		cmd.timeout = t.timeout
+	}
+	if !fl.Changed("concurrency") {
+		cmd.concurrency = t.concurrency
+	}
+	if !fl.Changed("retries") {
+		cmd.retries = t.retries
+	}
+	if !fl.Changed("max-rate") && t.delay > 0 {
+		cmd.maxRate = float64(time.Second) / float64(t.delay)
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/pflag"
+	"go.coder.com/cli"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+// trace shows where along the path to a host the probes for a port stop
+// getting answers, which is usually where a filtered port is being dropped.
+//
+//	port-scanner trace --host example.com --port 8443
+type traceCmd struct {
+	host       string
+	protocol   string
+	port       int
+	maxHops    int
+	queries    int
+	timeout    time.Duration
+	output     string
+	noPrivDrop bool
+}
+
+func (cmd *traceCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:    "trace",
+		Usage:   "--host HOST [flags]",
+		Aliases: []string{"traceroute"},
+		Desc:    "Trace the path probes for a port take to a host, to see where filtered ports are dropped.",
+	}
+}
+
+func (cmd *traceCmd) RegisterFlags(fl *pflag.FlagSet) {
+	fl.StringVar(&cmd.host, "host", "", "host to trace the path to")
+	fl.StringVar(&cmd.protocol, "protocol", "tcp", "what to send the probes over(tcp, udp or icmp)")
+	fl.IntVarP(&cmd.port, "port", "p", 0, "port the tcp or udp probes are sent to(defaults to 80 for tcp and 33434 for udp)")
+	fl.IntVar(&cmd.maxHops, "max-hops", scanner.DefaultTraceMaxHops, "highest ttl to probe with")
+	fl.IntVar(&cmd.queries, "queries", scanner.DefaultTraceQueries, "how many probes to send per hop")
+	fl.DurationVar(&cmd.timeout, "timeout", scanner.DefaultTraceTimeout, "how long to wait on the reply to each probe")
+	fl.StringVarP(&cmd.output, "output", "o", "text", "output format(text or json)")
+	registerNoPrivDropFlag(fl, &cmd.noPrivDrop)
+}
+
+// traceHop is a hop along the path, as it's written in json.
+type traceHop struct {
+	TTL     int        `json:"ttl"`
+	Addr    string     `json:"addr,omitempty"`
+	RTTs    []duration `json:"rtts"`
+	Reply   string     `json:"reply,omitempty"`
+	Reached bool       `json:"reached"`
+}
+
+type traceReport struct {
+	Host     string      `json:"host"`
+	IP       string      `json:"ip"`
+	Protocol string      `json:"protocol"`
+	Port     int         `json:"port,omitempty"`
+	Reached  bool        `json:"reached"`
+	Hops     []*traceHop `json:"hops"`
+}
+
+func (cmd *traceCmd) Run(fl *pflag.FlagSet) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if cmd.host == "" {
+		fl.Usage()
+		log.Fatal("host not provided")
+	}
+
+	switch cmd.output {
+	case "text", "json":
+	default:
+		fl.Usage()
+		log.Fatalf("%q is an unsupported output format", cmd.output)
+	}
+
+	switch cmd.protocol {
+	case "tcp", "udp", "icmp":
+	default:
+		fl.Usage()
+		log.Fatalf("%q is an unsupported protocol", cmd.protocol)
+	}
+
+	if cmd.port == 0 {
+		switch cmd.protocol {
+		case "tcp":
+			cmd.port = 80
+		case "udp":
+			cmd.port = 33434
+		}
+	} else if cmd.protocol == "icmp" {
+		fl.Usage()
+		log.Fatal("--port doesn't apply to icmp probes")
+	}
+	if cmd.protocol != "icmp" && (cmd.port < 1 || cmd.port > 65535) {
+		fl.Usage()
+		log.Fatalf("%d is an invalid port", cmd.port)
+	}
+
+	if cmd.maxHops < 1 || cmd.maxHops > 255 {
+		fl.Usage()
+		log.Fatalf("--max-hops must be between 1 and 255, got %d", cmd.maxHops)
+	}
+
+	if cmd.queries < 1 || cmd.queries > 10 {
+		fl.Usage()
+		log.Fatalf("--queries must be between 1 and 10, got %d", cmd.queries)
+	}
+
+	if cmd.timeout <= 0 {
+		fl.Usage()
+		log.Fatalf("--timeout must be positive, got %s", cmd.timeout)
+	}
+
+	dropPrivileges(cmd.noPrivDrop, false)
+
+	if err := scanner.CheckTrace(cmd.protocol); err != nil {
+		log.Fatal(err)
+	}
+
+	ips, err := scanner.Resolve(ctx, cmd.host, "ip4", scanner.DefaultResolveTimeout)
+	if err != nil {
+		log.Fatalf("failed to resolve host: %s", err)
+	}
+	ip := ips[0]
+
+	rep := &traceReport{Host: cmd.host, IP: ip.String(), Protocol: cmd.protocol, Port: cmd.port, Hops: []*traceHop{}}
+	over := cmd.protocol
+	if cmd.port != 0 {
+		over += fmt.Sprintf(" port %d", cmd.port)
+	}
+	log.Printf("tracing the path to %s(%s) over %s, %d hops max...", cmd.host, ip, over, cmd.maxHops)
+
+	hops, err := scanner.Trace(ctx, ip, scanner.TraceOptions{
+		Protocol: cmd.protocol,
+		Port:     cmd.port,
+		MaxHops:  cmd.maxHops,
+		Queries:  cmd.queries,
+		Timeout:  cmd.timeout,
+		Hop: func(h scanner.Hop) {
+			if cmd.output == "text" {
+				fmt.Println(formatHop(h, cmd.queries))
+			}
+		},
+	})
+	if err != nil && ctx.Err() == nil {
+		log.Fatalf("failed to trace %s: %s", cmd.host, err)
+	}
+
+	for _, h := range hops {
+		th := &traceHop{TTL: h.TTL, RTTs: []duration{}, Reply: h.Reply, Reached: h.Reached}
+		if h.Addr != nil {
+			th.Addr = h.Addr.String()
+		}
+		for _, rtt := range h.RTTs {
+			th.RTTs = append(th.RTTs, duration(rtt))
+		}
+		rep.Reached = rep.Reached || h.Reached
+		rep.Hops = append(rep.Hops, th)
+	}
+
+	if cmd.output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rep); err != nil {
+			log.Fatalf("failed to write json output: %s", err)
+		}
+	}
+
+	if ctx.Err() != nil {
+		exitf(exitInterrupted, "trace interrupted after %d hops", len(hops))
+	}
+	cmd.logVerdict(hops)
+}
+
+// logVerdict says where the probes stopped, when they didn't make it to the target.
+func (cmd *traceCmd) logVerdict(hops []scanner.Hop) {
+	if len(hops) == 0 {
+		return
+	}
+	last := hops[len(hops)-1]
+	if last.Reached {
+		log.Printf("reached %s in %d hops", cmd.host, last.TTL)
+		return
+	}
+
+	if last.Addr != nil && last.Reply != "ttl-exceeded" {
+		log.Printf("%s rejected the probes with %s at hop %d", last.Addr, last.Reply, last.TTL)
+		return
+	}
+
+	// Find the last hop that answered at all, past it the probes are being dropped.
+	for i := len(hops) - 1; i >= 0; i-- {
+		if hops[i].Addr != nil {
+			log.Printf("the probes stopped getting answers past %s at hop %d, which is likely where they're being dropped", hops[i].Addr, hops[i].TTL)
+			return
+		}
+	}
+	log.Printf("none of the probes got an answer, they're being dropped before the first hop or the hops don't answer probes that run out of ttl")
+}
+
+// formatHop writes a hop the way traceroute does, with a * for every unanswered probe.
+func formatHop(h scanner.Hop, queries int) string {
+	fields := []string{fmt.Sprintf("%2d", h.TTL)}
+	if h.Addr != nil {
+		fields = append(fields, h.Addr.String())
+	}
+	for _, rtt := range h.RTTs {
+		fields = append(fields, fmt.Sprintf("%.3fms", float64(rtt)/float64(time.Millisecond)))
+	}
+	for i := len(h.RTTs); i < queries; i++ {
+		fields = append(fields, "*")
+	}
+	if h.Reply != "" && h.Reply != "ttl-exceeded" {
+		fields = append(fields, "("+h.Reply+")")
+	}
+	return strings.Join(fields, "  ")
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"go.coder.com/cli"
+	"golang.org/x/term"
+	"golang.org/x/xerrors"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+// tui is an interactive front end to the scanner for exploring a network
+// without re-running the cli for every host: type a target, watch the ports
+// come in and sort them, then move on to the next one.
+type tuiCmd struct {
+	host        string
+	ports       string
+	timeout     time.Duration
+	concurrency int
+	noColor     bool
+}
+
+func (cmd *tuiCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "tui",
+		Usage: "[flags]",
+		Desc:  "Scan hosts interactively from a terminal ui with a live, sortable port table.",
+	}
+}
+
+func (cmd *tuiCmd) RegisterFlags(fl *pflag.FlagSet) {
+	fl.StringVar(&cmd.host, "host", "", "host to fill the target field with")
+	fl.StringVarP(&cmd.ports, "ports", "p", "", "ports to fill the ports field with(scans first 1024 if left empty)")
+	fl.DurationVar(&cmd.timeout, "timeout", scanner.DefaultTimeout, "how long to wait on each connection attempt(e.g. 500ms)")
+	fl.IntVar(&cmd.concurrency, "concurrency", scanner.DefaultConcurrency, "how many ports to scan at once")
+	fl.BoolVar(&cmd.noColor, "no-color", false, "don't color port states")
+}
+
+func (cmd *tuiCmd) Run(fl *pflag.FlagSet) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		log.Fatal("the tui needs a terminal on stdin and stdout, use the scan subcommand from scripts")
+	}
+
+	if cmd.timeout <= 0 {
+		fl.Usage()
+		log.Fatalf("--timeout must be positive, got %s", cmd.timeout)
+	}
+
+	if cmd.concurrency < 1 {
+		fl.Usage()
+		log.Fatalf("--concurrency must be at least 1, got %d", cmd.concurrency)
+	}
+
+	state, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		log.Fatalf("failed to put the terminal in raw mode: %s", err)
+	}
+	// Switch to the alternate screen so the shell's scrollback is left as it was.
+	fmt.Print("\033[?1049h\033[?25l")
+
+	t := newTUI(cmd)
+	err = t.run()
+
+	fmt.Print("\033[?25h\033[?1049l")
+	_ = term.Restore(int(os.Stdin.Fd()), state)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// tuiColumns are the columns of the port table, in the order the s key cycles through them.
+var tuiColumns = []struct {
+	name  string
+	width int
+	less  func(a, b scanner.PortResult) bool
+}{
+	{"PORT", 8, func(a, b scanner.PortResult) bool { return a.Port < b.Port }},
+	{"STATE", 15, func(a, b scanner.PortResult) bool { return a.State < b.State }},
+	{"SERVICE", 16, func(a, b scanner.PortResult) bool {
+		return scanner.ServiceName(a.Port, "tcp") < scanner.ServiceName(b.Port, "tcp")
+	}},
+	{"LATENCY", 12, func(a, b scanner.PortResult) bool { return a.Latency < b.Latency }},
+}
+
+// tuiField is one of the input fields at the top of the screen.
+type tuiField struct {
+	label string
+	value string
+}
+
+type tui struct {
+	cmd    *tuiCmd
+	fields []tuiField
+	focus  int
+	// editing is set while keys go to the input fields rather than the table.
+	editing bool
+	status  string
+
+	// A scan is running from the moment it's started until done is received.
+	// scanner is only set once the target has been resolved.
+	running bool
+	cancel  context.CancelFunc
+	target  string
+	start   time.Time
+	scanner *scanner.Scanner
+	ports   []scanner.PortResult
+
+	sortBy  int
+	reverse bool
+	offset  int
+}
+
+func newTUI(cmd *tuiCmd) *tui {
+	return &tui{
+		cmd: cmd,
+		fields: []tuiField{
+			{label: "target", value: cmd.host},
+			{label: "ports", value: cmd.ports},
+		},
+		editing: true,
+		status:  "type a target and press enter to scan it",
+	}
+}
+
+// tuiScanEvents carry a running scan's progress back to the ui loop, which owns all of the ui's state.
+type tuiScanEvents struct {
+	started chan *scanner.Scanner
+	found   chan scanner.PortResult
+	done    chan error
+}
+
+// run draws the ui and handles keys until the user quits.
+func (t *tui) run() error {
+	keys := make(chan []byte)
+	go func() {
+		for {
+			buf := make([]byte, 32)
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				close(keys)
+				return
+			}
+			keys <- buf[:n]
+		}
+	}()
+
+	var events tuiScanEvents
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		t.draw()
+
+		select {
+		case key, ok := <-keys:
+			if !ok {
+				return xerrors.New("failed to read from the terminal")
+			}
+			quit, start := t.handleKey(key)
+			if quit {
+				if t.cancel != nil {
+					t.cancel()
+				}
+				return nil
+			}
+			if start {
+				events = t.startScan()
+			}
+		case s := <-events.started:
+			t.scanner = s
+		case p := <-events.found:
+			t.ports = append(t.ports, p)
+			t.sortPorts()
+		case err := <-events.done:
+			t.finishScan(err)
+			events = tuiScanEvents{}
+		case <-ticker.C:
+		}
+	}
+}
+
+// handleKey applies a key press, it reports whether the user asked to quit or start a scan.
+func (t *tui) handleKey(key []byte) (quit, start bool) {
+	const ctrlC, tab, enter, esc, backspace, ctrlH = 3, 9, 13, 27, 127, 8
+
+	switch {
+	case len(key) == 1 && key[0] == ctrlC:
+		return true, false
+	case string(key) == "\033[A":
+		if t.offset > 0 {
+			t.offset--
+		}
+		return false, false
+	case string(key) == "\033[B":
+		if t.offset < len(t.ports)-1 {
+			t.offset++
+		}
+		return false, false
+	case len(key) > 1 && key[0] == esc:
+		// Some other escape sequence, like a function key.
+		return false, false
+	}
+
+	if t.editing {
+		f := &t.fields[t.focus]
+		for _, b := range key {
+			switch {
+			case b == tab:
+				t.focus = (t.focus + 1) % len(t.fields)
+				f = &t.fields[t.focus]
+			case b == enter:
+				if t.running {
+					t.status = "a scan is still running, press esc and then esc again to stop it"
+					continue
+				}
+				t.editing = false
+				return false, true
+			case b == esc:
+				t.editing = false
+			case b == backspace || b == ctrlH:
+				if f.value != "" {
+					f.value = f.value[:len(f.value)-1]
+				}
+			case b >= ' ' && b < backspace:
+				f.value += string(b)
+			}
+		}
+		return false, false
+	}
+
+	switch string(key) {
+	case "q":
+		return true, false
+	case "n", "/":
+		t.editing = true
+	case "s":
+		t.sortBy = (t.sortBy + 1) % len(tuiColumns)
+		t.sortPorts()
+	case "r":
+		t.reverse = !t.reverse
+		t.sortPorts()
+	case "1", "2", "3", "4":
+		t.sortBy = int(key[0] - '1')
+		t.sortPorts()
+	case "\033":
+		if t.running {
+			t.cancel()
+			t.status = "stopping the scan..."
+		}
+	}
+	return false, false
+}
+
+// startScan parses the input fields and starts scanning in the background.
+func (t *tui) startScan() tuiScanEvents {
+	host := strings.TrimSpace(t.fields[0].value)
+	if host == "" {
+		t.status = "no target to scan"
+		t.editing = true
+		return tuiScanEvents{}
+	}
+
+	ports := defaultPorts(false)
+	if raw := strings.TrimSpace(t.fields[1].value); raw != "" {
+		var err error
+		if ports, err = scanner.ParsePorts(raw); err != nil {
+			t.status = fmt.Sprintf("invalid ports: %s", err)
+			t.editing = true
+			return tuiScanEvents{}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := tuiScanEvents{
+		started: make(chan *scanner.Scanner, 1),
+		found:   make(chan scanner.PortResult),
+		done:    make(chan error, 1),
+	}
+	t.running, t.cancel, t.target, t.start = true, cancel, host, time.Now()
+	t.scanner, t.ports, t.offset = nil, nil, 0
+	t.status = fmt.Sprintf("resolving %s...", host)
+
+	opts := scanner.Options{
+		Ports:       ports,
+		Timeout:     t.cmd.timeout,
+		Concurrency: t.cmd.concurrency,
+		Found:       events.found,
+	}
+	go func() {
+		events.done <- scanTarget(ctx, host, opts, events.started)
+	}()
+	return events
+}
+
+// scanTarget resolves host and scans its first address, the scanner is sent on started once it's set up.
+func scanTarget(ctx context.Context, host string, opts scanner.Options, started chan<- *scanner.Scanner) error {
+	ips, err := scanner.Resolve(ctx, host, "tcp", scanner.DefaultResolveTimeout)
+	if err != nil {
+		return xerrors.Errorf("failed to resolve %q: %w", host, err)
+	}
+
+	s, err := scanner.New(scanner.ZonedAddr(host, ips[0]), opts)
+	if err != nil {
+		return err
+	}
+	started <- s
+
+	_, err = s.Scan(ctx)
+	return err
+}
+
+func (t *tui) finishScan(err error) {
+	t.running = false
+	t.cancel()
+	t.cancel = nil
+
+	elapsed := time.Since(t.start).Round(time.Millisecond)
+	switch {
+	case xerrors.Is(err, context.Canceled):
+		t.status = fmt.Sprintf("stopped scanning %s after %s, found %d open ports", t.target, elapsed, len(t.ports))
+	case err != nil:
+		t.status = err.Error()
+	default:
+		t.status = fmt.Sprintf("scanned %s in %s, found %d open ports", t.target, elapsed, len(t.ports))
+	}
+}
+
+func (t *tui) sortPorts() {
+	less := tuiColumns[t.sortBy].less
+	sort.SliceStable(t.ports, func(i, j int) bool {
+		if t.reverse {
+			return less(t.ports[j], t.ports[i])
+		}
+		return less(t.ports[i], t.ports[j])
+	})
+}
+
+// draw redraws the whole screen. Lines are overwritten in place rather than
+// clearing the screen first, which would make it flicker on every tick.
+func (t *tui) draw() {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		width, height = 80, 24
+	}
+	// Anything smaller doesn't fit the fields and help line, let it scroll.
+	if height < 8 {
+		height = 8
+	}
+
+	var lines []string
+	var fields []string
+	for i, f := range t.fields {
+		cursor := ""
+		if t.editing && i == t.focus {
+			cursor = "_"
+		}
+		fields = append(fields, fmt.Sprintf("%s: [%s%s]", f.label, f.value, cursor))
+	}
+	lines = append(lines, "port-scanner tui", strings.Join(fields, "   "))
+
+	status := t.status
+	if t.running && t.scanner != nil {
+		scanned, total := t.scanner.Progress()
+		status = progressLine(t.target, scanned, total, time.Since(t.start))
+	}
+	lines = append(lines, status, "")
+
+	var header []string
+	for i, c := range tuiColumns {
+		name := c.name
+		if i == t.sortBy {
+			if t.reverse {
+				name += " v"
+			} else {
+				name += " ^"
+			}
+		}
+		header = append(header, pad(name, c.width))
+	}
+	lines = append(lines, strings.Join(header, " "))
+
+	// Whatever room is left under the header, minus the key help, goes to the ports.
+	rows := height - len(lines) - 2
+	if rows < 1 {
+		rows = 1
+	}
+	if t.offset > len(t.ports)-rows {
+		t.offset = len(t.ports) - rows
+	}
+	if t.offset < 0 {
+		t.offset = 0
+	}
+
+	end := t.offset + rows
+	if end > len(t.ports) {
+		end = len(t.ports)
+	}
+	for _, p := range t.ports[t.offset:end] {
+		var latency string
+		if p.Latency > 0 {
+			latency = p.Latency.Round(time.Microsecond).String()
+		}
+		// The state is colored after padding so escape codes don't count towards its width.
+		state := pad(string(p.State), tuiColumns[1].width)
+		if code, ok := stateColors[p.State]; ok && !t.cmd.noColor && os.Getenv("NO_COLOR") == "" {
+			state = code + state + colorReset
+		}
+		lines = append(lines, strings.Join([]string{
+			pad(fmt.Sprint(p.Port), tuiColumns[0].width),
+			state,
+			pad(scanner.ServiceName(p.Port, "tcp"), tuiColumns[2].width),
+			latency,
+		}, " "))
+	}
+
+	more := ""
+	if hidden := len(t.ports) - end; hidden > 0 {
+		more = fmt.Sprintf("%d more below, ", hidden)
+	}
+	for len(lines) < height-1 {
+		lines = append(lines, "")
+	}
+	help := "tab next field  enter scan  esc done editing  ctrl+c quit"
+	if !t.editing {
+		help = more + "n new target  s/1-4 sort  r reverse  up/down scroll  esc stop scan  q quit"
+	}
+	lines = append(lines[:height-1], help)
+
+	var b strings.Builder
+	b.WriteString("\033[H")
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		if !strings.Contains(line, "\033") && len(line) > width {
+			line = line[:width]
+		}
+		b.WriteString(line + "\033[K")
+	}
+	b.WriteString("\033[J")
+	fmt.Print(b.String())
+}
+
+// pad left aligns s in a cell of width, cutting it off when it doesn't fit.
+func pad(s string, width int) string {
+	if len(s) >= width {
+		return s[:width]
+	}
+	return s + strings.Repeat(" ", width-len(s))
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/pflag"
+	"go.coder.com/cli"
+	"golang.org/x/mod/semver"
+	"golang.org/x/xerrors"
+)
+
+const (
+	defaultReleasesURL = "https://api.github.com/repos/fuskovic/port-scanner/releases/latest"
+	// releaseChecksums lists the sha256 of every binary of a release, the
+	// way sha256sum writes them, along with a "version <tag>" line naming
+	// the release they're for. releaseSignature is its base64 encoded
+	// ed25519 signature.
+	releaseChecksums = "checksums.txt"
+	releaseSignature = "checksums.txt.sig"
+	// maxBinarySize is far more than a release binary ever gets to, it only
+	// keeps a broken mirror from filling the disk.
+	maxBinarySize = 256 << 20
+)
+
+// releasePublicKey is the base64 encoded ed25519 key releases are signed
+// with. Release builds set it with
+//
+//	go build -ldflags "-X main.releasePublicKey=<key>"
+//
+// builds without one can only update with --public-key.
+var releasePublicKey string
+
+// update replaces the running binary with the latest release, for the jump
+// boxes it gets copied onto without a package manager to keep it current.
+// Nothing is replaced unless the release's checksums are signed by the
+// release key for that very release and the binary matches them, and a
+// release older than the running binary takes --force.
+//
+//	port-scanner update --check
+type updateCmd struct {
+	releasesURL string
+	publicKey   string
+	check       bool
+	force       bool
+	timeout     time.Duration
+}
+
+func (cmd *updateCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "update",
+		Usage: "[flags]",
+		Desc:  "Replace this binary with the latest signed release.",
+	}
+}
+
+func (cmd *updateCmd) RegisterFlags(fl *pflag.FlagSet) {
+	fl.StringVar(&cmd.releasesURL, "releases-url", defaultReleasesURL, "github api url of the release to update to(e.g. a mirror or .../releases/tags/v1.2.0)")
+	fl.StringVar(&cmd.publicKey, "public-key", "", "base64 encoded ed25519 key the release checksums are signed with(defaults to the key built in)")
+	fl.BoolVar(&cmd.check, "check", false, "only report whether there is a newer release")
+	fl.BoolVar(&cmd.force, "force", false, "replace the binary even when it's already the release's version or newer")
+	fl.DurationVar(&cmd.timeout, "timeout", time.Minute, "how long each download may take")
+}
+
+// release is the part of a github release we need.
+type release struct {
+	Tag    string         `json:"tag_name"`
+	Assets []releaseAsset `json:"assets"`
+}
+
+type releaseAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+func (r *release) asset(name string) (releaseAsset, bool) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return releaseAsset{}, false
+}
+
+func (cmd *updateCmd) Run(fl *pflag.FlagSet) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if cmd.timeout <= 0 {
+		fl.Usage()
+		log.Fatalf("--timeout must be positive, got %s", cmd.timeout)
+	}
+
+	encodedKey := releasePublicKey
+	if cmd.publicKey != "" {
+		encodedKey = cmd.publicKey
+	}
+	if encodedKey == "" && !cmd.check {
+		log.Fatal("this build has no release key to verify updates with(pass --public-key)")
+	}
+	var key ed25519.PublicKey
+	if encodedKey != "" {
+		b, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil || len(b) != ed25519.PublicKeySize {
+			fl.Usage()
+			log.Fatalf("invalid --public-key: expected a base64 encoded %d byte ed25519 key", ed25519.PublicKeySize)
+		}
+		key = b
+	}
+
+	client := &http.Client{Timeout: cmd.timeout}
+	rel, err := fetchRelease(ctx, client, cmd.releasesURL)
+	if err != nil {
+		log.Fatalf("failed to look up the latest release: %s", err)
+	}
+
+	current := productVersion()
+	if rel.Tag == current && !cmd.force {
+		log.Printf("already up to date(%s)", current)
+		return
+	}
+	// Builds that aren't a release can't tell, a tag that isn't a version counts as older.
+	older := semver.IsValid(current) && semver.Compare(rel.Tag, current) < 0
+	if cmd.check {
+		if older {
+			log.Printf("the latest release %s is older than this %s", rel.Tag, current)
+			return
+		}
+		log.Printf("%s is available, this is %s(run port-scanner update to install it)", rel.Tag, current)
+		return
+	}
+	if older && !cmd.force {
+		log.Fatalf("release %s is older than this %s, pass --force to downgrade to it", rel.Tag, current)
+	}
+
+	name := releaseBinaryName(runtime.GOOS, runtime.GOARCH)
+	asset, ok := rel.asset(name)
+	if !ok {
+		log.Fatalf("release %s has no binary for %s/%s(expected an asset named %s)", rel.Tag, runtime.GOOS, runtime.GOARCH, name)
+	}
+
+	want, err := signedChecksum(ctx, client, rel, key, name)
+	if err != nil {
+		log.Fatalf("failed to verify release %s: %s", rel.Tag, err)
+	}
+
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		log.Fatalf("failed to find the running binary: %s", err)
+	}
+
+	log.Printf("downloading %s %s...", name, rel.Tag)
+	tmp, err := downloadBinary(ctx, client, asset.URL, filepath.Dir(exe), want)
+	if err != nil {
+		log.Fatalf("failed to download %s: %s", name, err)
+	}
+	if err := replaceBinary(exe, tmp); err != nil {
+		os.Remove(tmp)
+		log.Fatalf("failed to replace %s: %s", exe, err)
+	}
+	log.Printf("updated %s from %s to %s", exe, current, rel.Tag)
+}
+
+// releaseBinaryName is what the release binary for goos and goarch is called.
+func releaseBinaryName(goos, goarch string) string {
+	name := "port-scanner-" + goos + "-" + goarch
+	if goos == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+func fetchRelease(ctx context.Context, client *http.Client, url string) (*release, error) {
+	b, err := readURL(ctx, client, url, 1<<20)
+	if err != nil {
+		return nil, err
+	}
+
+	var rel release
+	if err := json.Unmarshal(b, &rel); err != nil {
+		return nil, xerrors.Errorf("failed to decode release: %w", err)
+	}
+	if rel.Tag == "" {
+		return nil, xerrors.Errorf("%s isn't a release", url)
+	}
+	return &rel, nil
+}
+
+// signedChecksum returns the sha256 the release's checksums list for name,
+// once it checked the list is signed by key for rel's tag. Without the tag
+// in what's signed, a mirror could serve an older release's binary and its
+// signed checksums under a newer tag.
+func signedChecksum(ctx context.Context, client *http.Client, rel *release, key ed25519.PublicKey, name string) ([]byte, error) {
+	checksumsAsset, ok := rel.asset(releaseChecksums)
+	if !ok {
+		return nil, xerrors.Errorf("release has no %s", releaseChecksums)
+	}
+	sigAsset, ok := rel.asset(releaseSignature)
+	if !ok {
+		return nil, xerrors.Errorf("release has no %s, unsigned releases aren't installed", releaseSignature)
+	}
+
+	checksums, err := readURL(ctx, client, checksumsAsset.URL, 1<<20)
+	if err != nil {
+		return nil, err
+	}
+	encodedSig, err := readURL(ctx, client, sigAsset.URL, 4<<10)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encodedSig)))
+	if err != nil {
+		return nil, xerrors.Errorf("failed to decode %s: %w", releaseSignature, err)
+	}
+	if !ed25519.Verify(key, checksums, sig) {
+		return nil, xerrors.Errorf("%s isn't signed by the release key", releaseChecksums)
+	}
+
+	var (
+		version string
+		sum     []byte
+	)
+	lines := bufio.NewScanner(bytes.NewReader(checksums))
+	for lines.Scan() {
+		fields := strings.Fields(lines.Text())
+		if len(fields) == 2 && fields[0] == "version" {
+			version = fields[1]
+			continue
+		}
+		// sha256sum marks files it read in binary mode with a *.
+		if len(fields) != 2 || strings.TrimPrefix(fields[1], "*") != name {
+			continue
+		}
+		sum, err = hex.DecodeString(fields[0])
+		if err != nil || len(sum) != sha256.Size {
+			return nil, xerrors.Errorf("%s has an invalid checksum for %s", releaseChecksums, name)
+		}
+	}
+
+	switch {
+	case version == "":
+		return nil, xerrors.Errorf("%s doesn't name the release it's for", releaseChecksums)
+	case version != rel.Tag:
+		return nil, xerrors.Errorf("%s is signed for %s, not %s", releaseChecksums, version, rel.Tag)
+	case sum == nil:
+		return nil, xerrors.Errorf("%s has no checksum for %s", releaseChecksums, name)
+	}
+	return sum, nil
+}
+
+// readURL returns the body of url, failing on anything past limit bytes.
+func readURL(ctx context.Context, client *http.Client, url string, limit int64) ([]byte, error) {
+	resp, err := openURL(ctx, client, url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read %s: %w", url, err)
+	}
+	if int64(len(b)) > limit {
+		return nil, xerrors.Errorf("%s is larger than the %d bytes expected", url, limit)
+	}
+	return b, nil
+}
+
+func openURL(ctx context.Context, client *http.Client, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("User-Agent", "port-scanner/"+productVersion())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get %s: %w", url, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return nil, xerrors.Errorf("%s answered %s", url, resp.Status)
+	}
+	return resp, nil
+}
+
+// downloadBinary downloads the binary at url into a file in dir, next to the
+// binary it replaces so moving it into place can't cross filesystems, and
+// returns its path once its sha256 turned out to be want.
+func downloadBinary(ctx context.Context, client *http.Client, url, dir string, want []byte) (path string, err error) {
+	resp, err := openURL(ctx, client, url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	f, err := ioutil.TempFile(dir, ".port-scanner-update-")
+	if err != nil {
+		return "", xerrors.Errorf("failed to create a file next to the binary: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), io.LimitReader(resp.Body, maxBinarySize+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", xerrors.Errorf("failed to write %q: %w", f.Name(), err)
+	}
+	if n > maxBinarySize {
+		return "", xerrors.Errorf("binary is larger than %d bytes", maxBinarySize)
+	}
+	if !bytes.Equal(h.Sum(nil), want) {
+		return "", xerrors.Errorf("checksum mismatch, got %x but the release lists %x", h.Sum(nil), want)
+	}
+	return f.Name(), nil
+}
+
+// replaceBinary moves the binary at tmp over exe, keeping exe's permissions.
+// A running binary can't be overwritten on windows but it can be renamed,
+// so there the old one is moved out of the way first.
+func replaceBinary(exe, tmp string) error {
+	info, err := os.Stat(exe)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	if runtime.GOOS != "windows" {
+		return os.Rename(tmp, exe)
+	}
+
+	old := exe + ".old"
+	_ = os.Remove(old)
+	if err := os.Rename(exe, old); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, exe); err != nil {
+		_ = os.Rename(old, exe)
+		return err
+	}
+	return nil
+}
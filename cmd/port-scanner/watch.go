@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/spf13/pflag"
+	"go.coder.com/cli"
+	"golang.org/x/xerrors"
+
+	"github.com/fuskovic/port-scanner/pkg/scanner"
+)
+
+// watch turns the scanner into a lightweight exposure monitor. It rescans a
+// host on an interval and logs every port that opened or closed since the last scan.
+// With --baseline the first scan outlives the watch, so a restarted one picks up
+// where it left off as a drift detector rather than starting from scratch.
+type watchCmd struct {
+	host        string
+	ports       string
+	all         bool
+	every       time.Duration
+	schedule    string
+	timeout     time.Duration
+	concurrency int
+	ipv4Only    bool
+	ipv6Only    bool
+	metricsAddr string
+	metrics     *metrics
+	webhook     webhook
+	email       email
+	notifySpecs []string
+	notifiers   notifiers
+	config      string
+	profile     string
+	out         outFile
+	baseline    string
+	record      bool
+	historyDB   string
+	retention   historyRetention
+}
+
+func (cmd *watchCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:    "watch",
+		Usage:   "[flags]",
+		Aliases: []string{"w"},
+		Desc:    "Rescan a host on an interval or cron schedule and log the ports that open or close.",
+	}
+}
+
+func (cmd *watchCmd) RegisterFlags(fl *pflag.FlagSet) {
+	fl.StringVar(&cmd.host, "host", "", "host to watch(ip address or hostname)")
+	fl.StringVarP(&cmd.ports, "ports", "p", "", "ports to scan as a list and/or ranges(e.g. 22,80,443,8000-9000)")
+	fl.BoolVarP(&cmd.all, "all", "a", false, "scan all ports(scans first 1024 if not enabled)")
+	fl.DurationVar(&cmd.every, "every", 5*time.Minute, "how long to wait between scans")
+	fl.StringVar(&cmd.schedule, "schedule", "", "scan whenever this cron expression matches instead of --every, in local time(e.g. \"0 2 * * *\" or @hourly)")
+	fl.DurationVar(&cmd.timeout, "timeout", scanner.DefaultTimeout, "how long to wait on each connection attempt(e.g. 500ms)")
+	fl.IntVar(&cmd.concurrency, "concurrency", scanner.DefaultConcurrency, "how many ports to scan at once")
+	fl.BoolVarP(&cmd.ipv4Only, "ipv4-only", "4", false, "only watch the host's ipv4 address(dials tcp4)")
+	fl.BoolVarP(&cmd.ipv6Only, "ipv6-only", "6", false, "only watch the host's ipv6 address(dials tcp6)")
+	registerOutFlags(fl, &cmd.out)
+	registerBaselineFlag(fl, &cmd.baseline, "the changes")
+	fl.BoolVar(&cmd.record, "record", false, "record every scan in the scan history(see the history subcommand)")
+	registerHistoryDBFlag(fl, &cmd.historyDB)
+	registerHistoryRetentionFlags(fl, &cmd.retention, " after every recorded scan")
+	registerWebhookFlags(fl, &cmd.webhook)
+	registerEmailFlags(fl, &cmd.email)
+	registerNotifyFlag(fl, &cmd.notifySpecs, "when ports open up")
+	registerConfigFlag(fl, &cmd.config)
+	registerProfileFlag(fl, &cmd.profile)
+	fl.StringVar(&cmd.metricsAddr, "metrics-addr", "", "address to serve prometheus metrics on at /metrics(e.g. :9100)")
+}
+
+func (cmd *watchCmd) Run(fl *pflag.FlagSet) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if _, err := applyConfig(fl, cmd.config, cmd.Spec().Name); err != nil {
+		log.Fatalf("failed to load config: %s", err)
+	}
+
+	if cmd.host == "" {
+		fl.Usage()
+		log.Fatal("host not provided")
+	}
+
+	if cmd.ipv4Only && cmd.ipv6Only {
+		fl.Usage()
+		log.Fatal("--ipv4-only and --ipv6-only are mutually exclusive")
+	}
+
+	if cmd.every <= 0 {
+		fl.Usage()
+		log.Fatalf("--every must be positive, got %s", cmd.every)
+	}
+
+	var sched *schedule
+	if cmd.schedule != "" {
+		if fl.Changed("every") {
+			fl.Usage()
+			log.Fatal("--every and --schedule are mutually exclusive")
+		}
+
+		var err error
+		if sched, err = parseSchedule(cmd.schedule); err != nil {
+			fl.Usage()
+			log.Fatalf("invalid --schedule: %s", err)
+		}
+		if sched.next(time.Now()).IsZero() {
+			fl.Usage()
+			log.Fatalf("invalid --schedule: %q never matches", cmd.schedule)
+		}
+	}
+
+	if cmd.ports != "" && cmd.all {
+		fl.Usage()
+		log.Fatal("--ports and --all are mutually exclusive")
+	}
+
+	ports := defaultPorts(cmd.all)
+	if cmd.ports != "" {
+		var err error
+		ports, err = scanner.ParsePorts(cmd.ports)
+		if err != nil {
+			fl.Usage()
+			log.Fatalf("invalid ports: %s", err)
+		}
+	}
+
+	if err := cmd.email.validate(); err != nil {
+		fl.Usage()
+		log.Fatal(err)
+	}
+
+	var err error
+	if cmd.notifiers, err = parseNotifiers(cmd.notifySpecs); err != nil {
+		fl.Usage()
+		log.Fatalf("invalid --notify: %s", err)
+	}
+
+	if cmd.out.append && cmd.out.path == "" {
+		fl.Usage()
+		log.Fatal("--append needs --out")
+	}
+
+	if err := cmd.retention.parse(); err != nil {
+		fl.Usage()
+		log.Fatal(err)
+	}
+	if cmd.retention.enabled() && !cmd.record {
+		fl.Usage()
+		log.Fatal("--keep and --max-size only apply along with --record")
+	}
+
+	// Every baseline and change gets logged, so that's what goes in the file.
+	// --append keeps the history of earlier watches instead of starting over.
+	out, err := cmd.out.open()
+	if err != nil {
+		log.Fatalf("invalid --out: %s", err)
+	}
+	if out != nil {
+		defer out.Close()
+		teeLog(out)
+	}
+
+	if cmd.metricsAddr != "" {
+		cmd.metrics = newMetrics()
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", cmd.metrics)
+		srv := &http.Server{Addr: cmd.metricsAddr, Handler: mux}
+		go func() {
+			<-ctx.Done()
+			_ = srv.Close()
+		}()
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("failed to serve metrics: %s", err)
+			}
+		}()
+		log.Printf("serving metrics on %s/metrics", cmd.metricsAddr)
+	}
+
+	// previous is nil until the first scan succeeds, which becomes the baseline.
+	// A --baseline from an earlier run takes its place, so whatever changed
+	// while we weren't watching is reported by the very first scan.
+	var previous map[int]bool
+	if cmd.baseline != "" {
+		baseline, err := loadBaseline(cmd.baseline)
+		if err != nil {
+			log.Fatalf("invalid --baseline: %s", err)
+		}
+		if baseline != nil {
+			if previous, err = baselineOpenPorts(baseline, cmd.host); err != nil {
+				log.Fatalf("invalid --baseline: %s", err)
+			}
+			log.Printf("baseline from %s: %d open ports %v", cmd.baseline, len(previous), portNames(sortedPorts(previous)))
+		}
+	}
+
+	// Without a schedule the first scan happens right away, with one it waits for its time.
+	next := time.Now()
+	if sched != nil {
+		next = sched.next(next)
+		log.Printf("watching %s on schedule %q, first scan at %s...", cmd.host, cmd.schedule, next.Format(time.RFC1123))
+	} else {
+		log.Printf("watching %s every %s...", cmd.host, cmd.every)
+	}
+
+	for {
+		select {
+		case <-time.After(time.Until(next)):
+		case <-ctx.Done():
+			log.Print("watch stopped")
+			return
+		}
+
+		open, ip, err := cmd.scan(ctx, ports)
+		// A watch's scans are what fills a history the quickest, so pruning it is up to --keep and --max-size.
+		if err == nil && ctx.Err() == nil && cmd.record {
+			rep := watchReport(invocation(new(root).Spec().Name+" "+cmd.Spec().Name, fl), cmd.host, ip, open, len(ports))
+			if err := recordScan(cmd.historyDB, rep, cmd.retention); err != nil {
+				log.Printf("failed to record scan: %s", err)
+			}
+		}
+
+		switch {
+		case ctx.Err() != nil:
+			log.Print("watch stopped")
+			return
+		case err != nil:
+			// A failed scan says nothing about the ports, so lets
+			// keep comparing against the last one that worked.
+			log.Printf("scan failed: %s", err)
+		case previous == nil:
+			log.Printf("baseline: %d open ports %v", len(open), portNames(sortedPorts(open)))
+			previous = open
+			if cmd.baseline != "" {
+				rep := watchReport(invocation(new(root).Spec().Name+" "+cmd.Spec().Name, fl), cmd.host, ip, open, len(ports))
+				if err := saveReport(cmd.baseline, rep); err != nil {
+					log.Fatalf("failed to save baseline: %s", err)
+				}
+				log.Printf("saved the baseline in %s", cmd.baseline)
+			}
+		default:
+			opened, closed := changes(previous, open)
+			logChanges(opened, closed, len(open))
+			if len(opened) > 0 || len(closed) > 0 {
+				cmd.notify(ctx, portChange{
+					Host:      cmd.host,
+					IP:        ip,
+					Timestamp: time.Now().UTC(),
+					Opened:    opened,
+					Closed:    closed,
+				}, len(open))
+			}
+			previous = open
+		}
+
+		// Scans that run past their next time skip it rather than piling up.
+		if sched != nil {
+			next = sched.next(time.Now())
+		} else {
+			next = time.Now().Add(cmd.every)
+		}
+	}
+}
+
+// notify sends change to the webhook, by email and to --notify, whichever are set up.
+// None of them being down should stop us watching.
+func (cmd *watchCmd) notify(ctx context.Context, change portChange, open int) {
+	if cmd.webhook.enabled() {
+		if err := cmd.webhook.send(ctx, "change", change); err != nil {
+			log.Printf("failed to send changes to webhook: %s", err)
+		}
+	}
+
+	if cmd.email.enabled() {
+		if err := cmd.email.sendChange(ctx, change, open); err != nil {
+			log.Printf("failed to email changes: %s", err)
+		}
+	}
+
+	// Ports closing isn't worth interrupting people over.
+	if len(cmd.notifiers) > 0 && len(change.Opened) > 0 {
+		n := notification{title: fmt.Sprintf("%d ports opened up on %s", len(change.Opened), hostKey(&hostResult{Host: change.Host, IP: change.IP}))}
+		n.lines = portNames(change.Opened)
+		cmd.notifiers.send(ctx, n)
+	}
+}
+
+// scan resolves the host again, in case its address moved, and returns its open ports
+// along with the address they were found on.
+func (cmd *watchCmd) scan(ctx context.Context, ports []int) (map[int]bool, string, error) {
+	ips, err := scanner.Resolve(ctx, cmd.host, cmd.network(), scanner.DefaultResolveTimeout)
+	if err != nil {
+		if ctx.Err() == nil {
+			cmd.metrics.observeFailure()
+		}
+		return nil, "", xerrors.Errorf("failed to resolve: %w", err)
+	}
+
+	addr := scanner.ZonedAddr(cmd.host, ips[0])
+	s, err := scanner.New(addr, scanner.Options{
+		Network:     cmd.network(),
+		Ports:       ports,
+		Timeout:     cmd.timeout,
+		Concurrency: cmd.concurrency,
+	})
+	if err != nil {
+		return nil, "", xerrors.Errorf("failed to initialize port scanner: %w", err)
+	}
+
+	res, err := s.Scan(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			cmd.metrics.observeFailure()
+		}
+		return nil, "", err
+	}
+
+	open := make(map[int]bool)
+	for _, p := range res.Open() {
+		open[p.Port] = true
+	}
+	cmd.metrics.observe(cmd.host, addr, sortedPorts(open), res.Duration, res.Failures)
+	return open, addr, nil
+}
+
+func (cmd *watchCmd) network() string {
+	switch {
+	case cmd.ipv4Only:
+		return "tcp4"
+	case cmd.ipv6Only:
+		return "tcp6"
+	}
+	return "tcp"
+}
+
+// changes returns the ports that are open in only one of before and after.
+func changes(before, after map[int]bool) (opened, closed []int) {
+	for port := range after {
+		if !before[port] {
+			opened = append(opened, port)
+		}
+	}
+	for port := range before {
+		if !after[port] {
+			closed = append(closed, port)
+		}
+	}
+	sort.Ints(opened)
+	sort.Ints(closed)
+	return opened, closed
+}
+
+// logChanges logs every port that opened or closed, open is how many are open now.
+func logChanges(opened, closed []int, open int) {
+	if len(opened) == 0 && len(closed) == 0 {
+		log.Printf("no changes(%d open ports)", open)
+		return
+	}
+
+	for _, name := range portNames(opened) {
+		log.Printf("%s opened", name)
+	}
+
+	for _, name := range portNames(closed) {
+		log.Printf("%s closed", name)
+	}
+}
+
+func sortedPorts(set map[int]bool) []int {
+	ports := make([]int, 0, len(set))
+	for port := range set {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	return ports
+}
+
+// portNames renders each tcp port along with its well-known service name.
+func portNames(ports []int) []string {
+	names := make([]string, len(ports))
+	for i, port := range ports {
+		names[i] = portResult{Port: port, Service: scanner.ServiceName(port, "tcp")}.name()
+	}
+	return names
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/pflag"
+	"go.coder.com/cli"
+	"golang.org/x/xerrors"
+)
+
+//go:embed web.html
+var webPage []byte
+
+// web is a dashboard over the scan history for those who'd rather click
+// through their scans than read them off a terminal. It browses what's been
+// recorded, compares any two scans and runs new ones, which get recorded in
+// turn. Everything the page does goes through a small JSON API:
+//
+//	GET  /api/history?host=&limit=    the recorded scans, newest first
+//	GET  /api/history/{id}            a recorded scan's result
+//	GET  /api/diff?from=&to=          what changed between two recorded scans
+//	POST /api/scans                   run a scan, like serve's POST /scans
+//	GET  /api/scans/{id}              a scan's status and progress
+//	POST /api/scans/{id}/pause        pause, resume or cancel a scan, like serve does
+//	POST /api/scans/{id}/resume
+//	POST /api/scans/{id}/cancel
+//
+// There's no authentication, so it only listens on localhost unless told otherwise.
+type webCmd struct {
+	addr      string
+	historyDB string
+	retention historyRetention
+	workers   int
+	stateFile string
+}
+
+func (cmd *webCmd) Spec() cli.CommandSpec {
+	return cli.CommandSpec{
+		Name:  "web",
+		Usage: "[flags]",
+		Desc:  "Browse the scan history, compare scans and run new ones from a web browser.",
+	}
+}
+
+func (cmd *webCmd) RegisterFlags(fl *pflag.FlagSet) {
+	fl.StringVar(&cmd.addr, "addr", "127.0.0.1:8090", "address to listen on(anyone who can reach it can run scans)")
+	registerHistoryDBFlag(fl, &cmd.historyDB)
+	registerHistoryRetentionFlags(fl, &cmd.retention, " after every scan run from the dashboard")
+	fl.IntVar(&cmd.workers, "workers", 1, "how many scans to run at once")
+	registerStateFileFlag(fl, &cmd.stateFile)
+}
+
+func (cmd *webCmd) Run(fl *pflag.FlagSet) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := cmd.retention.parse(); err != nil {
+		fl.Usage()
+		log.Fatal(err)
+	}
+
+	if cmd.workers < 1 {
+		fl.Usage()
+		log.Fatalf("--workers must be at least 1, got %d", cmd.workers)
+	}
+
+	db, err := openHistory(cmd.historyDB)
+	if err != nil {
+		log.Fatalf("failed to open history: %s", err)
+	}
+	defer db.Close()
+
+	// A dashboard usually has a single user, so scans run one at a time unless told otherwise.
+	q := newJobQueue(16)
+	q.historyDB, q.retention = cmd.historyDB, cmd.retention
+	if err := q.restore(cmd.stateFile); err != nil {
+		log.Fatalf("failed to restore scans: %s", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < cmd.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+	go q.expire(ctx, time.Hour)
+
+	lis, err := net.Listen("tcp", cmd.addr)
+	if err != nil {
+		log.Fatalf("failed to listen: %s", err)
+	}
+
+	srv := &http.Server{Handler: knownHost(sameOrigin(webHandler(db, q)))}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("serving the dashboard on http://%s...", lis.Addr())
+	if err := srv.Serve(lis); err != nil && err != http.ErrServerClosed {
+		log.Fatalf("failed to serve: %s", err)
+	}
+
+	wg.Wait()
+	log.Print("server stopped")
+}
+
+// scanDiff is what changed between two recorded scans, in diff's notation.
+type scanDiff struct {
+	From    scanSummary `json:"from"`
+	To      scanSummary `json:"to"`
+	Changes []string    `json:"changes"`
+}
+
+func webHandler(db *sql.DB, q *jobQueue) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write(webPage)
+	})
+
+	mux.HandleFunc("/api/history", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			respondError(w, http.StatusMethodNotAllowed, xerrors.Errorf("%s isn't supported", r.Method))
+			return
+		}
+
+		limit := 0
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 0 {
+				respondError(w, http.StatusBadRequest, xerrors.Errorf("%q is an invalid limit", raw))
+				return
+			}
+			limit = n
+		}
+
+		scans, err := listScans(db, r.URL.Query().Get("host"), limit)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if scans == nil {
+			scans = []scanSummary{}
+		}
+		respond(w, http.StatusOK, scans)
+	})
+
+	mux.HandleFunc("/api/history/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			respondError(w, http.StatusMethodNotAllowed, xerrors.Errorf("%s isn't supported", r.Method))
+			return
+		}
+
+		h, code, err := scanByID(db, strings.TrimPrefix(r.URL.Path, "/api/history/"))
+		if err != nil {
+			respondError(w, code, err)
+			return
+		}
+		respond(w, http.StatusOK, h)
+	})
+
+	mux.HandleFunc("/api/diff", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			respondError(w, http.StatusMethodNotAllowed, xerrors.Errorf("%s isn't supported", r.Method))
+			return
+		}
+
+		before, code, err := scanByID(db, r.URL.Query().Get("from"))
+		if err != nil {
+			respondError(w, code, err)
+			return
+		}
+		after, code, err := scanByID(db, r.URL.Query().Get("to"))
+		if err != nil {
+			respondError(w, code, err)
+			return
+		}
+
+		changes := diffReports(&report{Hosts: []*hostResult{before}}, &report{Hosts: []*hostResult{after}})
+		if changes == nil {
+			changes = []string{}
+		}
+		// Both ids parsed by now.
+		from, _ := strconv.ParseInt(r.URL.Query().Get("from"), 10, 64)
+		to, _ := strconv.ParseInt(r.URL.Query().Get("to"), 10, 64)
+		respond(w, http.StatusOK, scanDiff{From: summarize(from, before), To: summarize(to, after), Changes: changes})
+	})
+
+	// Scans are run by the same queue serve uses, only under /api.
+	scans := http.StripPrefix("/api", q.handler())
+	mux.Handle("/api/scans", scans)
+	mux.Handle("/api/scans/", scans)
+	return mux
+}
+
+// scanByID loads the recorded scan whose id is raw, along with the status to respond with when it can't.
+func scanByID(db *sql.DB, raw string) (*hostResult, int, error) {
+	id, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return nil, http.StatusBadRequest, xerrors.Errorf("%q is an invalid scan id", raw)
+	}
+
+	h, err := loadScan(db, id)
+	switch {
+	case err != nil:
+		return nil, http.StatusInternalServerError, err
+	case h == nil:
+		return nil, http.StatusNotFound, xerrors.Errorf("there's no scan with id %d", id)
+	}
+	return h, http.StatusOK, nil
+}
+
+// summarize lists h, recorded as the scan with id, like listScans does.
+func summarize(id int64, h *hostResult) scanSummary {
+	return scanSummary{
+		ID:           id,
+		StartedAt:    h.Timestamp,
+		Duration:     h.Duration,
+		Host:         h.Host,
+		IP:           h.IP,
+		Protocol:     h.Protocol,
+		ScannedPorts: h.ScannedPorts,
+		OpenPorts:    len(openPorts(h)),
+	}
+}
+
+// knownHost turns away requests for names other than localhost. A page elsewhere
+// can rebind its own name to 127.0.0.1 to read the server, but a rebound name
+// is still the attacker's name, so only ever ours or an address is trusted.
+func knownHost(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if host != "localhost" && net.ParseIP(strings.Trim(host, "[]")) == nil {
+			respondError(w, http.StatusForbidden, xerrors.Errorf("%q isn't a host the server answers to", r.Host))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sameOrigin turns away requests other sites make on behalf of whoever has the
+// dashboard open. Listening on localhost doesn't keep a page elsewhere from
+// submitting scans to it.
+func sameOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" && origin != "http://"+r.Host {
+			respondError(w, http.StatusForbidden, xerrors.Errorf("requests from %q aren't allowed", origin))
+			return
+		}
+
+		// Forms can be posted cross-site without an Origin by older browsers, but never as json.
+		if r.Method != http.MethodGet && r.Method != http.MethodHead &&
+			!strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			respondError(w, http.StatusUnsupportedMediaType, xerrors.New("requests must be sent as application/json"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
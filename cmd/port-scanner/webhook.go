@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/xerrors"
+)
+
+// webhookSignatureHeader carries the hex encoded HMAC-SHA256 of the request body,
+// keyed with --webhook-secret, so receivers can check the payload came from us.
+const webhookSignatureHeader = "X-Port-Scanner-Signature"
+
+// webhookEventHeader says what the payload is, "scan" for a finished scan
+// or "change" for ports that opened or closed under watch.
+const webhookEventHeader = "X-Port-Scanner-Event"
+
+// webhook POSTs results to a user provided endpoint.
+type webhook struct {
+	url     string
+	secret  string
+	retries int
+	timeout time.Duration
+}
+
+func registerWebhookFlags(fl *pflag.FlagSet, w *webhook) {
+	fl.StringVar(&w.url, "webhook-url", "", "POST the results as json to this url")
+	// Receivers commonly take their credentials in the url, as user:pass@ or a ?token=.
+	_ = fl.SetAnnotation("webhook-url", secretAnnotation, []string{"true"})
+	fl.StringVar(&w.secret, "webhook-secret", os.Getenv("PORT_SCANNER_WEBHOOK_SECRET"), "sign webhook payloads with this key(defaults to $PORT_SCANNER_WEBHOOK_SECRET)")
+	_ = fl.SetAnnotation("webhook-secret", secretAnnotation, []string{"true"})
+	fl.IntVar(&w.retries, "webhook-retries", 3, "how many times to retry a webhook delivery that failed")
+	fl.DurationVar(&w.timeout, "webhook-timeout", 10*time.Second, "how long to wait on each webhook delivery attempt")
+}
+
+// enabled reports whether a webhook url was set.
+func (w *webhook) enabled() bool {
+	return w.url != ""
+}
+
+// send POSTs payload as json, retrying with a growing delay when the endpoint can't be
+// reached or answers with a server error. Client errors won't fix themselves, so they aren't retried.
+func (w *webhook) send(ctx context.Context, event string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return xerrors.Errorf("failed to encode payload: %w", err)
+	}
+
+	delay := time.Second
+	for attempt := 0; ; attempt++ {
+		retry, err := w.post(ctx, event, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= w.retries {
+			return err
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		delay *= 2
+	}
+}
+
+// post makes a single delivery attempt and reports whether a failure is worth retrying.
+func (w *webhook) post(ctx context.Context, event string, body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, xerrors.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, event)
+	if w.secret != "" {
+		req.Header.Set(webhookSignatureHeader, "sha256="+signPayload(w.secret, body))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return true, xerrors.Errorf("failed to deliver webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, xerrors.Errorf("webhook endpoint answered %s", resp.Status)
+	}
+	return false, xerrors.Errorf("webhook endpoint answered %s", resp.Status)
+}
+
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// portChange is the payload watch sends when ports opened or closed since its last scan.
+type portChange struct {
+	Host      string    `json:"host"`
+	IP        string    `json:"ip"`
+	Timestamp time.Time `json:"timestamp"`
+	Opened    []int     `json:"opened"`
+	Closed    []int     `json:"closed"`
+}
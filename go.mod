@@ -3,7 +3,16 @@ module github.com/fuskovic/port-scanner
 go 1.16
 
 require (
+	github.com/mattn/go-sqlite3 v1.14.16
+	github.com/oschwald/maxminddb-golang v1.8.0
 	github.com/spf13/pflag v1.0.5
 	go.coder.com/cli v0.6.0
+	go.starlark.net v0.0.0-20220328144851-d1966c6b9fcd
+	go.uber.org/goleak v1.1.12
+	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
+	golang.org/x/mod v0.4.2
+	golang.org/x/term v0.0.0-20201126162022-7de9c90e9dd1
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1
+	google.golang.org/grpc v1.38.0
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,150 @@
+package scanner
+
+import (
+	"context"
+	"sync"
+)
+
+const (
+	// adaptiveStart is how many ports an adaptive scan starts out with at once.
+	adaptiveStart = 16
+	// adaptiveMinWindow is the fewest dials the limit is ever judged on.
+	adaptiveMinWindow = 8
+	// adaptiveTolerance is how much the share of timed out dials may grow
+	// from one window to the next before we take it as the path dropping probes.
+	adaptiveTolerance = 0.1
+)
+
+// adaptiveLimit decides how many ports to scan at once from how the dials fare.
+// The limit is judged once per window of about as many dials as it allows: it
+// grows by a quarter while the path keeps up, backs off by a quarter once a
+// bigger share of dials time out than in the window before, and halves as soon
+// as we run out of sockets. Once it has backed off it grows one port at a time,
+// so it settles on the fastest rate the path takes without dropping probes.
+type adaptiveLimit struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  int
+	max    int
+	active int
+	// backedOff is set once the limit had to come down at least once.
+	backedOff bool
+
+	// dials, timeouts and exhausted count the outcomes of the current window,
+	// lastTimeouts is the share of dials that timed out in the one before.
+	dials, timeouts, exhausted int
+	lastTimeouts               float64
+}
+
+func newAdaptiveLimit(max int) *adaptiveLimit {
+	a := &adaptiveLimit{limit: adaptiveStart, max: max, lastTimeouts: -1}
+	if a.limit > max {
+		a.limit = max
+	}
+	a.cond = sync.NewCond(&a.mu)
+	return a
+}
+
+// acquire blocks until another port may be scanned or ctx is done.
+func (a *adaptiveLimit) acquire(ctx context.Context) error {
+	if a == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for a.active >= a.limit && ctx.Err() == nil {
+		a.cond.Wait()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	a.active++
+	return nil
+}
+
+// release hands back what acquire took.
+func (a *adaptiveLimit) release() {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	a.active--
+	a.mu.Unlock()
+	a.cond.Signal()
+}
+
+// wake lets every acquire blocked on a cancelled ctx return.
+func (a *adaptiveLimit) wake() {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	a.mu.Unlock()
+	a.cond.Broadcast()
+}
+
+// observe feeds the outcome of a dial back into the limit.
+func (a *adaptiveLimit) observe(err error) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.dials++
+	switch {
+	case err == nil:
+	case isExhausted(err):
+		a.exhausted++
+	case dialOutcome(err) == "timeout":
+		a.timeouts++
+	}
+
+	window := a.limit
+	if window < adaptiveMinWindow {
+		window = adaptiveMinWindow
+	}
+	// Running out of sockets won't get better by waiting for the window to fill up.
+	if a.dials < window && a.exhausted == 0 {
+		return
+	}
+
+	timeouts := float64(a.timeouts) / float64(a.dials)
+	grew := false
+	switch {
+	case a.exhausted > 0:
+		a.limit /= 2
+		a.backedOff = true
+	case a.lastTimeouts >= 0 && timeouts > a.lastTimeouts+adaptiveTolerance:
+		a.limit -= a.limit / 4
+		a.backedOff = true
+	case a.backedOff:
+		a.limit++
+		grew = true
+	default:
+		a.limit += a.limit/4 + 1
+		grew = true
+	}
+
+	if a.limit < 1 {
+		a.limit = 1
+	}
+	if a.limit > a.max {
+		a.limit = a.max
+	}
+	a.lastTimeouts = timeouts
+	a.dials, a.timeouts, a.exhausted = 0, 0, 0
+	if grew {
+		a.cond.Broadcast()
+	}
+}
+
+// current returns the limit as it stands.
+func (a *adaptiveLimit) current() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.limit
+}
@@ -0,0 +1,56 @@
+package scanner
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+)
+
+const (
+	arpRequestOp = 1
+	arpReplyOp   = 2
+	etherTypeARP = 0x0806
+)
+
+var broadcastMAC = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// arpRequest builds an ethernet frame asking who has ip, to be answered to srcMAC at srcIP.
+func arpRequest(srcMAC net.HardwareAddr, srcIP, ip net.IP) []byte {
+	frame := make([]byte, 42)
+	copy(frame[0:6], broadcastMAC)
+	copy(frame[6:12], srcMAC)
+	binary.BigEndian.PutUint16(frame[12:14], etherTypeARP)
+
+	arp := frame[14:]
+	binary.BigEndian.PutUint16(arp[0:2], 1)      // ethernet
+	binary.BigEndian.PutUint16(arp[2:4], 0x0800) // ipv4
+	arp[4] = 6
+	arp[5] = 4
+	binary.BigEndian.PutUint16(arp[6:8], arpRequestOp)
+	copy(arp[8:14], srcMAC)
+	copy(arp[14:18], srcIP.To4())
+	// The target hardware address is what we're asking for, so it stays zeroed.
+	copy(arp[24:28], ip.To4())
+	return frame
+}
+
+// parseARPReply returns the sender of frame if it's an arp reply.
+func parseARPReply(frame []byte) (net.IP, net.HardwareAddr, bool) {
+	if len(frame) < 42 || binary.BigEndian.Uint16(frame[12:14]) != etherTypeARP {
+		return nil, nil, false
+	}
+
+	arp := frame[14:]
+	if binary.BigEndian.Uint16(arp[6:8]) != arpReplyOp || arp[4] != 6 || arp[5] != 4 {
+		return nil, nil, false
+	}
+
+	mac := make(net.HardwareAddr, 6)
+	copy(mac, arp[8:14])
+	ip := make(net.IP, 4)
+	copy(ip, arp[14:18])
+	if bytes.Equal(mac, broadcastMAC) {
+		return nil, nil, false
+	}
+	return ip, mac, true
+}
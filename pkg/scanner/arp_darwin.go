@@ -0,0 +1,214 @@
+package scanner
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// macOS has no packet sockets, arp requests go out and their replies come
+// back through a bpf device attached to the interface instead.
+
+// arpCache returns the MAC address of every neighbour the kernel has resolved,
+// which are the routes it flagged RTF_LLINFO.
+func arpCache() (map[string]net.HardwareAddr, error) {
+	rib, err := syscall.RouteRIB(syscall.NET_RT_FLAGS, syscall.RTF_LLINFO)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read the routing table: %w", err)
+	}
+	msgs, err := syscall.ParseRoutingMessage(rib)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to parse the routing table: %w", err)
+	}
+
+	cache := make(map[string]net.HardwareAddr)
+	for _, msg := range msgs {
+		addrs, err := syscall.ParseRoutingSockaddr(msg)
+		if err != nil || len(addrs) <= syscall.RTAX_GATEWAY {
+			continue
+		}
+		dst, ok := addrs[syscall.RTAX_DST].(*syscall.SockaddrInet4)
+		if !ok {
+			continue
+		}
+
+		// Addresses the kernel asked about and never got an answer for have no link layer address.
+		ll, ok := addrs[syscall.RTAX_GATEWAY].(*syscall.SockaddrDatalink)
+		if !ok || ll.Alen != 6 || int(ll.Nlen)+6 > len(ll.Data) {
+			continue
+		}
+		mac := make(net.HardwareAddr, 6)
+		for i := range mac {
+			mac[i] = byte(ll.Data[int(ll.Nlen)+i])
+		}
+		cache[net.IP(dst.Addr[:]).String()] = mac
+	}
+	return cache, nil
+}
+
+// CheckARP makes sure we're allowed to open the bpf device arp requests are sent over.
+// Without one arp discovery falls back to what's already in the kernel's arp cache.
+func CheckARP() error {
+	fd, err := openBPF()
+	if err != nil {
+		return xerrors.Errorf("arp requests need a bpf device(run as root): %w", err)
+	}
+	return syscall.Close(fd)
+}
+
+// openBPF opens the first bpf device no one else has open, every one of them
+// can only be attached to a single interface at a time.
+func openBPF() (int, error) {
+	for i := 0; i < 256; i++ {
+		fd, err := syscall.Open("/dev/bpf"+strconv.Itoa(i), syscall.O_RDWR, 0)
+		if err == syscall.EBUSY {
+			continue
+		}
+		if err != nil {
+			return -1, xerrors.Errorf("failed to open /dev/bpf%d: %w", i, err)
+		}
+		return fd, nil
+	}
+	return -1, xerrors.New("every bpf device is busy")
+}
+
+// arpFilter only lets arp frames through, so we don't get to copy
+// everything else the interface sees.
+var arpFilter = []syscall.BpfInsn{
+	*syscall.BpfStmt(syscall.BPF_LD|syscall.BPF_H|syscall.BPF_ABS, 12),
+	*syscall.BpfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, etherTypeARP, 0, 1),
+	*syscall.BpfStmt(syscall.BPF_RET|syscall.BPF_K, 1500),
+	*syscall.BpfStmt(syscall.BPF_RET|syscall.BPF_K, 0),
+}
+
+func (l *link) sweep(ctx context.Context, timeout time.Duration) ([]LiveHost, error) {
+	fd, err := openBPF()
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.Close(fd)
+
+	// The buffer has to be sized before the device is attached, reads
+	// have to be exactly that big.
+	if _, err := syscall.SetBpfBuflen(fd, 1<<16); err != nil {
+		return nil, xerrors.Errorf("failed to size the bpf buffer: %w", err)
+	}
+	if err := syscall.SetBpfInterface(fd, l.iface.Name); err != nil {
+		return nil, xerrors.Errorf("failed to attach to %s: %w", l.iface.Name, err)
+	}
+	// Hand replies over as they arrive rather than once the buffer fills up,
+	// and leave the frames we write alone since they have our address already.
+	if err := syscall.SetBpfImmediate(fd, 1); err != nil {
+		return nil, xerrors.Errorf("failed to enable immediate mode: %w", err)
+	}
+	if err := syscall.SetBpfHeadercmpl(fd, 1); err != nil {
+		return nil, xerrors.Errorf("failed to set header complete mode: %w", err)
+	}
+	if err := syscall.SetBpf(fd, arpFilter); err != nil {
+		return nil, xerrors.Errorf("failed to set the arp filter: %w", err)
+	}
+	// Same trick as the SYN scan, reads wake up regularly to check whether we're done.
+	tv := syscall.NsecToTimeval(int64(100 * time.Millisecond))
+	if err := syscall.SetBpfTimeout(fd, &tv); err != nil {
+		return nil, xerrors.Errorf("failed to set read timeout: %w", err)
+	}
+	bufLen, err := syscall.BpfBuflen(fd)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read the bpf buffer size: %w", err)
+	}
+
+	var (
+		mu    sync.Mutex
+		sent  = make(map[string]time.Time)
+		found = make(map[string]LiveHost)
+		done  = make(chan struct{})
+		wg    sync.WaitGroup
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		buf := make([]byte, bufLen)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			n, err := syscall.Read(fd, buf)
+			if err != nil {
+				continue
+			}
+
+			for _, frame := range bpfFrames(buf[:n]) {
+				ip, mac, ok := parseARPReply(frame)
+				if !ok {
+					continue
+				}
+
+				mu.Lock()
+				if start, ok := sent[ip.String()]; ok {
+					if _, dup := found[ip.String()]; !dup {
+						found[ip.String()] = LiveHost{IP: ip, Method: "arp", Latency: time.Since(start), MAC: mac}
+					}
+				}
+				mu.Unlock()
+			}
+		}
+	}()
+
+	for _, ip := range l.ips {
+		if ctx.Err() != nil {
+			break
+		}
+
+		mu.Lock()
+		sent[ip.String()] = time.Now()
+		mu.Unlock()
+		_, _ = syscall.Write(fd, arpRequest(l.iface.HardwareAddr, l.src, ip))
+	}
+
+	select {
+	case <-time.After(timeout):
+	case <-ctx.Done():
+	}
+	close(done)
+	wg.Wait()
+
+	hosts := make([]LiveHost, 0, len(found))
+	for _, host := range found {
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+// bpfHdrLen is the size of a bpf_hdr without its padding.
+const bpfHdrLen = 18
+
+// bpfFrames splits what a read of a bpf device returned into its frames. Each
+// one comes after a bpf_hdr, in host byte order which is little endian on
+// every mac, and the next starts at the following 4 byte boundary.
+func bpfFrames(buf []byte) [][]byte {
+	var frames [][]byte
+	for len(buf) >= bpfHdrLen {
+		caplen := int(binary.LittleEndian.Uint32(buf[8:12]))
+		hdrlen := int(binary.LittleEndian.Uint16(buf[16:18]))
+		if hdrlen+caplen > len(buf) {
+			break
+		}
+		frames = append(frames, buf[hdrlen:hdrlen+caplen])
+
+		next := (hdrlen + caplen + 3) &^ 3
+		if next > len(buf) {
+			break
+		}
+		buf = buf[next:]
+	}
+	return frames
+}
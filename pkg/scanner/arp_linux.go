@@ -0,0 +1,144 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// arpCache returns the MAC address of every neighbour the kernel has resolved.
+func arpCache() (map[string]net.HardwareAddr, error) {
+	f, err := os.Open("/proc/net/arp")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// IP address, HW type, Flags, HW address, Mask, Device
+	cache := make(map[string]net.HardwareAddr)
+	sc := bufio.NewScanner(f)
+	sc.Scan() // the header
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 4 {
+			continue
+		}
+
+		// Flags 0x0 is an address the kernel asked about and never got an answer for.
+		if fields[2] == "0x0" {
+			continue
+		}
+
+		mac, err := net.ParseMAC(fields[3])
+		if err != nil {
+			continue
+		}
+		cache[fields[0]] = mac
+	}
+	return cache, sc.Err()
+}
+
+// CheckARP makes sure we're allowed to open the packet socket arp requests are sent over.
+// Without one arp discovery falls back to what's already in the kernel's arp cache.
+func CheckARP() error {
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(syscall.ETH_P_ARP)))
+	if err != nil {
+		return xerrors.Errorf("arp requests need packet sockets(run as root or grant CAP_NET_RAW): %w", err)
+	}
+	return syscall.Close(fd)
+}
+
+func (l *link) sweep(ctx context.Context, timeout time.Duration) ([]LiveHost, error) {
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(syscall.ETH_P_ARP)))
+	if err != nil {
+		return nil, xerrors.Errorf("failed to open a packet socket: %w", err)
+	}
+	defer syscall.Close(fd)
+
+	sa := &syscall.SockaddrLinklayer{Protocol: htons(syscall.ETH_P_ARP), Ifindex: l.iface.Index}
+	if err := syscall.Bind(fd, sa); err != nil {
+		return nil, xerrors.Errorf("failed to bind to %s: %w", l.iface.Name, err)
+	}
+
+	// Same trick as the SYN scan, Recvfrom wakes up regularly to check whether we're done.
+	tv := syscall.NsecToTimeval(int64(100 * time.Millisecond))
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		return nil, xerrors.Errorf("failed to set receive timeout: %w", err)
+	}
+
+	var (
+		mu    sync.Mutex
+		sent  = make(map[string]time.Time)
+		found = make(map[string]LiveHost)
+		done  = make(chan struct{})
+		wg    sync.WaitGroup
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		buf := make([]byte, 1500)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			n, _, err := syscall.Recvfrom(fd, buf, 0)
+			if err != nil {
+				continue
+			}
+
+			ip, mac, ok := parseARPReply(buf[:n])
+			if !ok {
+				continue
+			}
+
+			mu.Lock()
+			if start, ok := sent[ip.String()]; ok {
+				if _, dup := found[ip.String()]; !dup {
+					found[ip.String()] = LiveHost{IP: ip, Method: "arp", Latency: time.Since(start), MAC: mac}
+				}
+			}
+			mu.Unlock()
+		}
+	}()
+
+	dst := &syscall.SockaddrLinklayer{Protocol: htons(syscall.ETH_P_ARP), Ifindex: l.iface.Index, Halen: 6}
+	copy(dst.Addr[:], broadcastMAC)
+	for _, ip := range l.ips {
+		if ctx.Err() != nil {
+			break
+		}
+
+		mu.Lock()
+		sent[ip.String()] = time.Now()
+		mu.Unlock()
+		_ = syscall.Sendto(fd, arpRequest(l.iface.HardwareAddr, l.src, ip), 0, dst)
+	}
+
+	select {
+	case <-time.After(timeout):
+	case <-ctx.Done():
+	}
+	close(done)
+	wg.Wait()
+
+	hosts := make([]LiveHost, 0, len(found))
+	for _, host := range found {
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+// htons converts a short to network byte order, which is what packet sockets take protocols in.
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
@@ -0,0 +1,26 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package scanner
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// Sending arp requests takes packet sockets or bpf devices, which only
+// linux and macos have, and npcap isn't something we can count on on windows.
+var errARPUnsupported = unsupported("arp discovery is only supported on linux and macos")
+
+func CheckARP() error {
+	return errARPUnsupported
+}
+
+func arpSweep(ctx context.Context, ips []net.IP, timeout time.Duration) ([]LiveHost, error) {
+	return nil, errARPUnsupported
+}
+
+func arpCache() (map[string]net.HardwareAddr, error) {
+	return nil, errARPUnsupported
+}
@@ -0,0 +1,87 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package scanner
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// arpSweep asks every host in ips that's on a directly attached ipv4 network for its MAC address.
+// Nothing filters arp on a lan, so unlike a ping every host that's up answers.
+// Hosts that aren't on-link are left for the other methods.
+func arpSweep(ctx context.Context, ips []net.IP, timeout time.Duration) ([]LiveHost, error) {
+	links, err := onLink(ips)
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []LiveHost
+	for _, l := range links {
+		found, err := l.sweep(ctx, timeout)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to send arp requests on %s: %w", l.iface.Name, err)
+		}
+		hosts = append(hosts, found...)
+	}
+	return hosts, nil
+}
+
+// link is a local interface along with the targets on its network.
+type link struct {
+	iface *net.Interface
+	src   net.IP
+	ips   []net.IP
+}
+
+// onLink groups the ipv4 addresses of ips by the interface whose network they're on.
+func onLink(ips []net.IP) ([]*link, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, xerrors.Errorf("failed to list interfaces: %w", err)
+	}
+
+	var links []*link
+	byIface := make(map[int]*link)
+	for _, ip := range ips {
+		if ip.To4() == nil || ip.IsLoopback() {
+			continue
+		}
+
+	ifaces:
+		for i := range ifaces {
+			iface := &ifaces[i]
+			if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || len(iface.HardwareAddr) != 6 {
+				continue
+			}
+
+			addrs, err := iface.Addrs()
+			if err != nil {
+				continue
+			}
+			for _, addr := range addrs {
+				ipNet, ok := addr.(*net.IPNet)
+				if !ok || ipNet.IP.To4() == nil || !ipNet.Contains(ip) {
+					continue
+				}
+
+				l := byIface[iface.Index]
+				if l == nil {
+					l = &link{iface: iface, src: ipNet.IP.To4()}
+					byIface[iface.Index] = l
+					links = append(links, l)
+				}
+				// Our own address won't answer, it's up as far as we're concerned anyway.
+				if !ip.Equal(l.src) {
+					l.ips = append(l.ips, ip.To4())
+				}
+				break ifaces
+			}
+		}
+	}
+	return links, nil
+}
@@ -0,0 +1,119 @@
+package scanner
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// AuditLog records every connection attempt the scanner makes, one JSON
+// object per line. Unlike the scan output it also covers closed and filtered
+// ports, which is what authorized-scanning audits usually ask for.
+type AuditLog struct {
+	sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+type auditEntry struct {
+	Time     time.Time `json:"time"`
+	Source   string    `json:"source,omitempty"`
+	Target   string    `json:"target"`
+	Protocol string    `json:"protocol"`
+	Outcome  string    `json:"outcome"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// OpenAuditLog opens path for appending, the log is never truncated.
+// An empty path returns a nil log, which records nothing.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to open %q: %w", path, err)
+	}
+	return &AuditLog{file: f, enc: json.NewEncoder(f)}, nil
+}
+
+// record logs the outcome of dialing target over network with d.
+func (a *AuditLog) record(start time.Time, d *net.Dialer, network, target string, conn net.Conn, err error) {
+	if a == nil {
+		return
+	}
+
+	entry := auditEntry{
+		Time:     start.UTC(),
+		Target:   target,
+		Protocol: network,
+		Outcome:  "connected",
+	}
+
+	switch {
+	case conn != nil:
+		entry.Source = conn.LocalAddr().String()
+	case d.LocalAddr != nil:
+		entry.Source = d.LocalAddr.String()
+	}
+
+	if err != nil {
+		entry.Outcome = dialOutcome(err)
+		entry.Error = err.Error()
+	}
+
+	a.write(entry)
+}
+
+// recordSYN logs the outcome of a SYN sent to target from source.
+func (a *AuditLog) recordSYN(start time.Time, source net.IP, target, outcome string) {
+	if a == nil {
+		return
+	}
+
+	a.write(auditEntry{
+		Time:     start.UTC(),
+		Source:   source.String(),
+		Target:   target,
+		Protocol: "tcp4-syn",
+		Outcome:  outcome,
+	})
+}
+
+func (a *AuditLog) write(entry auditEntry) {
+	a.Lock()
+	defer a.Unlock()
+	// There's nowhere sensible to report a failed audit write to from deep
+	// inside a scan, so lets not let it take the scan down with it.
+	_ = a.enc.Encode(entry)
+}
+
+func (a *AuditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	return a.file.Close()
+}
+
+// dialOutcome sorts a dial error into a coarse bucket.
+func dialOutcome(err error) string {
+	var netErr net.Error
+	switch {
+	case isExhausted(err):
+		return "exhausted"
+	case xerrors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case isRefused(err):
+		return "refused"
+	case isUnreachable(err):
+		return "unreachable"
+	case isPermission(err):
+		return "permission"
+	}
+	return "error"
+}
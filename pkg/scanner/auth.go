@@ -0,0 +1,99 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// Some services are dangerous to expose mostly because they ship without
+// authentication turned on. These probes ask such a service for something
+// harmless and report whether it answered without credentials.
+// They're strictly read-only, they never write keys or create indices.
+type authProbe struct {
+	service string
+	// requiresAuth reports whether the service refused to serve us anonymously.
+	requiresAuth func(conn net.Conn, host string) (bool, error)
+}
+
+var authProbes = map[int]authProbe{
+	6379: {service: "redis", requiresAuth: redisRequiresAuth},
+	9200: {service: "elasticsearch", requiresAuth: elasticsearchRequiresAuth},
+}
+
+// HasAuthProbe reports whether there's an auth probe for the service usually found on port.
+func HasAuthProbe(port int) bool {
+	_, ok := authProbes[port]
+	return ok
+}
+
+// CheckAuth runs the auth probe registered for port, if there is one.
+func (s *Scanner) CheckAuth(ctx context.Context, port int) (service string, requiresAuth bool, err error) {
+	probe, ok := authProbes[port]
+	if !ok {
+		return "", false, xerrors.Errorf("no auth probe for port %d", port)
+	}
+
+	conn, err := s.dial(ctx, port)
+	if err != nil {
+		return probe.service, false, xerrors.Errorf("failed to connect: %w", err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(s.opts.Timeout)); err != nil {
+		return probe.service, false, xerrors.Errorf("failed to set deadline: %w", err)
+	}
+	defer watchConn(ctx, conn)()
+
+	requiresAuth, err = probe.requiresAuth(conn, s.hostname())
+	return probe.service, requiresAuth, err
+}
+
+// redisRequiresAuth sends a PING, an open instance answers +PONG
+// while a protected one answers with a NOAUTH error.
+func redisRequiresAuth(conn net.Conn, _ string) (bool, error) {
+	if _, err := conn.Write([]byte("PING\r\n")); err != nil {
+		return false, xerrors.Errorf("failed to send PING: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return false, xerrors.Errorf("failed to read PING reply: %w", err)
+	}
+
+	switch {
+	case strings.HasPrefix(reply, "+PONG"):
+		return false, nil
+	case strings.HasPrefix(reply, "-NOAUTH"), strings.HasPrefix(reply, "-WRONGPASS"):
+		return true, nil
+	}
+	return false, xerrors.Errorf("unexpected PING reply %q", strings.TrimSpace(reply))
+}
+
+// elasticsearchRequiresAuth requests the cluster info document at "/",
+// which is only served anonymously when security is disabled.
+func elasticsearchRequiresAuth(conn net.Conn, host string) (bool, error) {
+	req := "GET / HTTP/1.0\r\nHost: " + host + "\r\n\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		return false, xerrors.Errorf("failed to send request: %w", err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return false, xerrors.Errorf("failed to read response: %w", err)
+	}
+	_ = resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return false, nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return true, nil
+	}
+	return false, xerrors.Errorf("unexpected status %q", resp.Status)
+}
@@ -0,0 +1,33 @@
+package scanner
+
+import (
+	"sync/atomic"
+
+	"golang.org/x/xerrors"
+)
+
+// ErrBudgetExhausted is returned once every connection attempt of a Budget has been made.
+var ErrBudgetExhausted = xerrors.New("connection budget exhausted")
+
+// Budget is a hard ceiling on the number of connection attempts a scan may make,
+// no matter how many ports, retries or follow-up probes it ends up with.
+type Budget struct {
+	used int64
+	max  int64
+}
+
+// NewBudget returns nil when max isn't positive, which means the scan is unbounded.
+func NewBudget(max int64) *Budget {
+	if max <= 0 {
+		return nil
+	}
+	return &Budget{max: max}
+}
+
+// take claims a single connection attempt, reporting false once the budget is spent.
+func (b *Budget) take() bool {
+	if b == nil {
+		return true
+	}
+	return atomic.AddInt64(&b.used, 1) <= b.max
+}
@@ -0,0 +1,125 @@
+package scanner
+
+import (
+	"net"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// maxCIDRHosts keeps a typo like /8 or an ipv6 /64 from expanding into
+// more addresses than anyone could reasonably mean to scan.
+const maxCIDRHosts = 1 << 16
+
+// ExpandHost returns every host that a target spec refers to.
+// CIDR ranges like 192.168.1.0/24 expand to each of their addresses,
+// anything else is passed through as a single host, with the brackets
+// of an ipv6 literal like [::1] taken off.
+func ExpandHost(host string) ([]string, error) {
+	host = trimBrackets(host)
+	if !strings.Contains(host, "/") {
+		return []string{host}, nil
+	}
+
+	ip, ipNet, err := net.ParseCIDR(host)
+	if err != nil {
+		return nil, xerrors.Errorf("%q is an invalid cidr range: %w", host, err)
+	}
+
+	ones, bits := ipNet.Mask.Size()
+	if bits-ones > 16 {
+		return nil, xerrors.Errorf("%q has more than %d addresses", host, maxCIDRHosts)
+	}
+
+	var hosts []string
+	for cur := ip.Mask(ipNet.Mask); ipNet.Contains(cur); cur = nextIP(cur) {
+		hosts = append(hosts, cur.String())
+	}
+
+	// The network and broadcast addresses of an ipv4 range aren't hosts,
+	// except in /31 and /32 ranges where every address is usable.
+	if ip.To4() != nil && bits-ones > 1 {
+		hosts = hosts[1 : len(hosts)-1]
+	}
+	return hosts, nil
+}
+
+// nextIP returns the address following ip.
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// trimBrackets takes the brackets off an ipv6 literal, as it would be written in a url.
+func trimBrackets(host string) string {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host[1 : len(host)-1]
+	}
+	return host
+}
+
+// Exclusions are the hosts, addresses and cidr ranges a scan must never touch.
+// A nil Exclusions doesn't exclude anything.
+type Exclusions struct {
+	names map[string]bool
+	nets  []*net.IPNet
+}
+
+// ParseExclusions parses specs written like targets, as hostnames, addresses or cidr ranges.
+// Unlike targets, cidr ranges aren't expanded so they can be as large as they need to be.
+func ParseExclusions(specs []string) (*Exclusions, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+
+	e := &Exclusions{names: make(map[string]bool)}
+	for _, spec := range specs {
+		spec = trimBrackets(strings.TrimSpace(spec))
+		switch {
+		case spec == "":
+			return nil, xerrors.New("empty host")
+		case strings.Contains(spec, "/"):
+			_, ipNet, err := net.ParseCIDR(spec)
+			if err != nil {
+				return nil, xerrors.Errorf("%q is an invalid cidr range: %w", spec, err)
+			}
+			e.nets = append(e.nets, ipNet)
+		case net.ParseIP(spec) != nil:
+			ip := net.ParseIP(spec)
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			e.nets = append(e.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		default:
+			e.names[strings.ToLower(strings.TrimSuffix(spec, "."))] = true
+		}
+	}
+	return e, nil
+}
+
+// Excludes reports whether host, which resolved to ip, is excluded, either by
+// name or because its address is. Excluding a name leaves its addresses alone
+// when they're reached some other way, excluding an address never does.
+func (e *Exclusions) Excludes(host string, ip net.IP) bool {
+	if e == nil {
+		return false
+	}
+
+	if e.names[strings.ToLower(strings.TrimSuffix(host, "."))] {
+		return true
+	}
+	for _, ipNet := range e.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
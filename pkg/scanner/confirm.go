@@ -0,0 +1,110 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// A successful TCP connect isn't always proof that something is listening.
+// Middleboxes and transparent proxies happily accept connections on ports
+// nobody is serving, so for some ports we want a stronger signal before
+// reporting them as open.
+type ConfirmLevel string
+
+const (
+	// ConfirmConnect treats a completed TCP handshake as open.
+	ConfirmConnect ConfirmLevel = "connect"
+	// ConfirmHTTP additionally requires a parseable HTTP response.
+	ConfirmHTTP ConfirmLevel = "http"
+	// ConfirmTLS additionally requires a completed TLS handshake.
+	ConfirmTLS ConfirmLevel = "tls"
+)
+
+// DefaultConfirmLevels is the mapping the CLI applies when the user doesn't override it.
+// Ports that aren't listed here fall back to ConfirmConnect.
+//
+//	80, 8000, 8080 -> http
+//	443, 8443      -> tls
+var DefaultConfirmLevels = map[int]ConfirmLevel{
+	80:   ConfirmHTTP,
+	8000: ConfirmHTTP,
+	8080: ConfirmHTTP,
+	443:  ConfirmTLS,
+	8443: ConfirmTLS,
+}
+
+// ParseConfirmLevels merges the user provided port=level pairs over the defaults.
+func ParseConfirmLevels(overrides map[string]string) (map[int]ConfirmLevel, error) {
+	levels := make(map[int]ConfirmLevel, len(DefaultConfirmLevels)+len(overrides))
+	for port, level := range DefaultConfirmLevels {
+		levels[port] = level
+	}
+
+	for rawPort, rawLevel := range overrides {
+		port, err := parsePort(rawPort)
+		if err != nil {
+			return nil, err
+		}
+
+		level := ConfirmLevel(strings.ToLower(strings.TrimSpace(rawLevel)))
+		switch level {
+		case ConfirmConnect, ConfirmHTTP, ConfirmTLS:
+		default:
+			return nil, xerrors.Errorf("%q is an invalid confirmation level for port %d", rawLevel, port)
+		}
+		levels[port] = level
+	}
+	return levels, nil
+}
+
+// levelFor returns the confirmation level that applies to port.
+func levelFor(levels map[int]ConfirmLevel, port int) ConfirmLevel {
+	if level, ok := levels[port]; ok {
+		return level
+	}
+	return ConfirmConnect
+}
+
+// confirm runs the check required by level over an already established connection.
+// A nil error means the port is confirmed open.
+func confirm(ctx context.Context, conn net.Conn, host string, level ConfirmLevel, timeout time.Duration) error {
+	if level == ConfirmConnect {
+		return nil
+	}
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return xerrors.Errorf("failed to set deadline: %w", err)
+	}
+	defer watchConn(ctx, conn)()
+
+	switch level {
+	case ConfirmHTTP:
+		req := "HEAD / HTTP/1.0\r\nHost: " + host + "\r\n\r\n"
+		if _, err := conn.Write([]byte(req)); err != nil {
+			return xerrors.Errorf("failed to send http request: %w", err)
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			return xerrors.Errorf("failed to read http response: %w", err)
+		}
+		_ = resp.Body.Close()
+	case ConfirmTLS:
+		// We only care that the handshake completes, not whether
+		// the certificate would be trusted by anyone.
+		tlsConn := tls.Client(conn, &tls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: true,
+		})
+		if err := tlsConn.Handshake(); err != nil {
+			return xerrors.Errorf("tls handshake failed: %w", err)
+		}
+	}
+	return nil
+}
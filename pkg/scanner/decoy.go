@@ -0,0 +1,60 @@
+package scanner
+
+import (
+	"math/rand"
+	"net"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// Decoys are addresses a raw scan sends a spoofed copy of every probe from,
+// after nmap's -D. Whoever is watching the target sees the scan come from all
+// of them at once and can't tell which one is real. The replies to the decoys
+// go to the decoys, so they have to be up for the scan to be believable, and
+// most networks drop spoofed packets on their way out. It's meant for testing
+// how an IDS copes in a lab. A nil entry is where our own probe goes.
+type Decoys []net.IP
+
+// ParseDecoys parses a list like "10.0.0.1,10.0.0.2,ME,10.0.0.3", where ME
+// stands for our own address. Without ME we take a random spot among them.
+func ParseDecoys(specs []string) (Decoys, error) {
+	var decoys Decoys
+	me := false
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if strings.EqualFold(spec, "ME") {
+			if me {
+				return nil, xerrors.New("ME can only be in the list once")
+			}
+			me = true
+			decoys = append(decoys, nil)
+			continue
+		}
+
+		ip := net.ParseIP(spec).To4()
+		if ip == nil {
+			return nil, xerrors.Errorf("%q is not an ipv4 address", spec)
+		}
+		decoys = append(decoys, ip)
+	}
+
+	if !me && len(decoys) > 0 {
+		i := rand.Intn(len(decoys) + 1)
+		decoys = append(decoys[:i], append(Decoys{nil}, decoys[i:]...)...)
+	}
+	return decoys, nil
+}
+
+// String lists the decoys the way ParseDecoys takes them.
+func (d Decoys) String() string {
+	names := make([]string, len(d))
+	for i, ip := range d {
+		if ip == nil {
+			names[i] = "ME"
+		} else {
+			names[i] = ip.String()
+		}
+	}
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,227 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// DefaultDiscoverPorts are what tcp discovery knocks on. Hosts that drop
+// pings still tend to run ssh, a web server or windows file sharing.
+var DefaultDiscoverPorts = []int{22, 80, 443, 445, 3389, 8080}
+
+// DefaultDiscoverTimeout is how long discovery waits on each probe when DiscoverOptions.Timeout isn't set.
+const DefaultDiscoverTimeout = time.Second
+
+// DiscoverOptions picks the methods used to tell which hosts are up.
+// Every method only probes the hosts the ones before it didn't find.
+type DiscoverOptions struct {
+	// ICMP sends an echo request to each ipv4 host, it needs raw sockets(see CheckICMP).
+	ICMP bool
+	// TCP connects to Ports, a refused connection gives the host away as much as an accepted one.
+	TCP bool
+	// ARP asks the hosts on directly attached networks for their MAC address before any
+	// other method runs, a host that's up always answers. Without packet sockets(see CheckARP)
+	// it falls back to the hosts the kernel resolved while the other methods probed them.
+	// Either way it catches hosts that firewall everything else.
+	ARP bool
+	// Ports tcp discovery connects to, defaults to DefaultDiscoverPorts.
+	Ports []int
+	// Timeout for each probe, defaults to DefaultDiscoverTimeout.
+	Timeout time.Duration
+	// Concurrency caps how many tcp probes are in flight, defaults to DefaultConcurrency.
+	Concurrency int
+}
+
+// LiveHost is a host discovery found to be up.
+type LiveHost struct {
+	IP net.IP
+	// Method is how the host gave itself away, "icmp", "tcp" or "arp".
+	Method string
+	// Latency is the round trip of the probe that found the host, arp finds have none.
+	Latency time.Duration
+	// MAC is set for hosts on a directly attached network when the kernel's arp cache knows it.
+	MAC net.HardwareAddr
+}
+
+// Discover probes ips with the methods opts enables and returns the hosts that are up, sorted by address.
+func Discover(ctx context.Context, ips []net.IP, opts DiscoverOptions) ([]LiveHost, error) {
+	if !opts.ICMP && !opts.TCP && !opts.ARP {
+		return nil, xerrors.New("no discovery method enabled")
+	}
+
+	if opts.Ports == nil {
+		opts.Ports = DefaultDiscoverPorts
+	}
+
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultDiscoverTimeout
+	}
+
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultConcurrency
+	}
+
+	live := make(map[string]*LiveHost)
+	remaining := func() []net.IP {
+		var left []net.IP
+		for _, ip := range ips {
+			if live[ip.String()] == nil {
+				left = append(left, ip)
+			}
+		}
+		return left
+	}
+
+	if opts.ARP && CheckARP() == nil {
+		found, err := arpSweep(ctx, remaining(), opts.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		for _, host := range found {
+			host := host
+			live[host.IP.String()] = &host
+		}
+	}
+
+	if opts.ICMP && ctx.Err() == nil {
+		found, err := pingSweep(ctx, remaining(), opts.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		for _, host := range found {
+			host := host
+			live[host.IP.String()] = &host
+		}
+	}
+
+	if opts.TCP && ctx.Err() == nil {
+		for _, host := range knockSweep(ctx, remaining(), opts) {
+			host := host
+			live[host.IP.String()] = &host
+		}
+	}
+
+	// The arp cache only fills in for addresses we've sent something to,
+	// so lets make sure every remaining host got at least one datagram.
+	if opts.ARP && ctx.Err() == nil {
+		if !opts.ICMP && !opts.TCP {
+			nudge(remaining())
+			select {
+			case <-time.After(opts.Timeout):
+			case <-ctx.Done():
+			}
+		}
+
+		cache, err := arpCache()
+		if err != nil {
+			return nil, xerrors.Errorf("failed to read the arp cache: %w", err)
+		}
+		for _, ip := range ips {
+			mac, ok := cache[ip.String()]
+			if !ok {
+				continue
+			}
+			host := live[ip.String()]
+			if host == nil {
+				host = &LiveHost{IP: ip, Method: "arp"}
+				live[ip.String()] = host
+			}
+			host.MAC = mac
+		}
+	}
+
+	hosts := make([]LiveHost, 0, len(live))
+	for _, host := range live {
+		hosts = append(hosts, *host)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		return bytes.Compare(hosts[i].IP.To16(), hosts[j].IP.To16()) < 0
+	})
+	return hosts, ctx.Err()
+}
+
+// knockSweep connects to the discovery ports of every host, a host is up
+// as soon as one of them accepts or refuses the connection.
+func knockSweep(ctx context.Context, ips []net.IP, opts DiscoverOptions) []LiveHost {
+	type probe struct {
+		ip   net.IP
+		port int
+	}
+
+	probes := make(chan probe)
+	go func() {
+		defer close(probes)
+		for _, port := range opts.Ports {
+			for _, ip := range ips {
+				select {
+				case probes <- probe{ip: ip, port: port}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	var (
+		mu    sync.Mutex
+		found = make(map[string]LiveHost)
+		wg    sync.WaitGroup
+	)
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range probes {
+				mu.Lock()
+				_, done := found[p.ip.String()]
+				mu.Unlock()
+				if done {
+					continue
+				}
+
+				d := net.Dialer{Timeout: opts.Timeout}
+				start := time.Now()
+				conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(p.ip.String(), strconv.Itoa(p.port)))
+				if err == nil {
+					conn.Close()
+				}
+				if err != nil && !isRefused(err) {
+					continue
+				}
+
+				mu.Lock()
+				if _, done := found[p.ip.String()]; !done {
+					found[p.ip.String()] = LiveHost{IP: p.ip, Method: "tcp", Latency: time.Since(start)}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	hosts := make([]LiveHost, 0, len(found))
+	for _, host := range found {
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// nudge sends a datagram to every host so the kernel resolves the on-link ones.
+// Nothing needs to answer, which port we send to doesn't matter.
+func nudge(ips []net.IP) {
+	for _, ip := range ips {
+		conn, err := net.Dial("udp", net.JoinHostPort(ip.String(), "9"))
+		if err != nil {
+			continue
+		}
+		_, _ = conn.Write(nil)
+		conn.Close()
+	}
+}
@@ -0,0 +1,211 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// How long we give a service to greet us before we try poking it.
+const greetingWait = time.Second
+
+// A signature recognizes a protocol from the first bytes a service sends.
+// Going by bytes rather than port numbers means a web server hiding on
+// 31337 still gets identified as http.
+type signature struct {
+	protocol string
+	// matched describes what we matched on so users can judge the guess.
+	matched string
+	match   func(b []byte) bool
+}
+
+func prefix(p string) func([]byte) bool {
+	return func(b []byte) bool { return bytes.HasPrefix(b, []byte(p)) }
+}
+
+func contains(p string) func([]byte) bool {
+	return func(b []byte) bool { return bytes.Contains(b, []byte(p)) }
+}
+
+// greetingSignatures match protocols where the server talks first.
+var greetingSignatures = []signature{
+	{protocol: "ssh", matched: `"SSH-" greeting`, match: prefix("SSH-")},
+	{protocol: "ftp", matched: `"220" greeting mentioning FTP`, match: func(b []byte) bool {
+		return bytes.HasPrefix(b, []byte("220")) && bytes.Contains(bytes.ToUpper(b), []byte("FTP"))
+	}},
+	{protocol: "smtp", matched: `"220" greeting`, match: prefix("220")},
+	{protocol: "pop3", matched: `"+OK" greeting`, match: prefix("+OK")},
+	{protocol: "imap", matched: `"* OK" greeting`, match: prefix("* OK")},
+	{protocol: "vnc", matched: `"RFB " greeting`, match: prefix("RFB ")},
+	{protocol: "mysql", matched: "protocol v10 handshake packet", match: func(b []byte) bool {
+		// 3 byte length, 1 byte sequence id and then the protocol version.
+		return len(b) > 5 && b[3] == 0 && b[4] == 0x0a
+	}},
+}
+
+// replySignatures match what a client-first service answers to our probe.
+var replySignatures = []signature{
+	{protocol: "http", matched: `"HTTP/" status line`, match: prefix("HTTP/")},
+	// TLS servers answer plaintext with an alert record.
+	{protocol: "tls", matched: "tls alert record", match: func(b []byte) bool {
+		return len(b) > 2 && b[0] == 0x15 && b[1] == 0x03
+	}},
+	{protocol: "redis", matched: `"+PONG" reply`, match: prefix("+PONG")},
+	{protocol: "redis", matched: `"-ERR" reply`, match: prefix("-ERR")},
+	{protocol: "redis", matched: `"-NOAUTH" reply`, match: prefix("-NOAUTH")},
+	{protocol: "rtsp", matched: `"RTSP/" status line`, match: prefix("RTSP/")},
+	{protocol: "sip", matched: `"SIP/" status line`, match: contains("SIP/2.0")},
+	{protocol: "memcached", matched: `"VERSION" reply`, match: prefix("VERSION ")},
+}
+
+// probe is sent to services that stay quiet. Plenty of protocols answer
+// something recognizable to an http request, even if it's an error.
+var probe = []byte("GET / HTTP/1.0\r\n\r\n")
+
+// portProbes are greetings for services that ignore or choke on an http
+// request but have a well-known home port we can pick them out by.
+var portProbes = map[int][]byte{
+	6379:  []byte("PING\r\n"),
+	11211: []byte("version\r\n"),
+}
+
+func probeFor(port int) []byte {
+	if p, ok := portProbes[port]; ok {
+		return p
+	}
+	return probe
+}
+
+// maxBannerLen caps how much of a banner we keep, some services open with a whole page.
+const maxBannerLen = 128
+
+// Guess is the protocol guessed for a port. An empty Protocol means nothing matched.
+type Guess struct {
+	Protocol string
+	// Matched describes the signature the guess is based on.
+	Matched string
+	// Banner is the first line the service sent us, with anything
+	// unprintable replaced by dots.
+	Banner string
+	// Version is the product and version the service gave away, if it's one we know.
+	Version *Version
+	Err     error
+}
+
+// GuessProtocols guesses the protocol on each port concurrently since most
+// of the time is spent waiting on quiet services. Guesses are returned in
+// the same order as ports.
+func (s *Scanner) GuessProtocols(ctx context.Context, ports []int) []Guess {
+	guesses := make([]Guess, len(ports))
+
+	var wg sync.WaitGroup
+	for i, port := range ports {
+		wg.Add(1)
+		go func(i, p int) {
+			defer wg.Done()
+			// Each goroutine writes to its own index so there's nothing to lock.
+			g := &guesses[i]
+			*g = s.guessProtocol(ctx, p)
+		}(i, port)
+	}
+	wg.Wait()
+	return guesses
+}
+
+// guessProtocol connects to port and guesses what's speaking on it from
+// the bytes it sends back. An empty protocol means nothing matched.
+func (s *Scanner) guessProtocol(ctx context.Context, port int) Guess {
+	conn, err := s.dial(ctx, port)
+	if err != nil {
+		return Guess{Err: xerrors.Errorf("failed to connect: %w", err)}
+	}
+	defer conn.Close()
+	defer watchConn(ctx, conn)()
+
+	greeting, err := readSome(ctx, conn, greetingWait)
+	if err != nil {
+		return Guess{Err: xerrors.Errorf("failed to read greeting: %w", err)}
+	}
+	if len(greeting) > 0 {
+		protocol, matched := matchSignatures(greetingSignatures, greeting)
+		return Guess{Protocol: protocol, Matched: matched, Banner: banner(greeting), Version: matchVersion(protocol, greeting)}
+	}
+
+	if err := conn.SetWriteDeadline(time.Now().Add(s.opts.Timeout)); err != nil {
+		return Guess{Err: xerrors.Errorf("failed to set deadline: %w", err)}
+	}
+	if _, err := conn.Write(probeFor(port)); err != nil {
+		return Guess{Err: xerrors.Errorf("failed to send probe: %w", err)}
+	}
+
+	reply, err := readSome(ctx, conn, s.opts.Timeout)
+	if err != nil {
+		return Guess{Err: xerrors.Errorf("failed to read reply: %w", err)}
+	}
+	protocol, matched := matchSignatures(replySignatures, reply)
+	return Guess{Protocol: protocol, Matched: matched, Banner: banner(reply), Version: matchVersion(protocol, reply)}
+}
+
+// banner trims b down to its first line and makes it safe to print.
+func banner(b []byte) string {
+	if i := bytes.IndexAny(b, "\r\n"); i >= 0 {
+		b = b[:i]
+	}
+	if len(b) > maxBannerLen {
+		b = b[:maxBannerLen]
+	}
+
+	out := make([]byte, len(b))
+	for i, c := range b {
+		if c < ' ' || c > '~' {
+			c = '.'
+		}
+		out[i] = c
+	}
+	return string(out)
+}
+
+// readSome reads whatever conn sends within wait, or until ctx is done.
+// Hitting the deadline without receiving anything isn't an error, the
+// service is just quiet.
+func readSome(ctx context.Context, conn net.Conn, wait time.Duration) ([]byte, error) {
+	deadline := time.Now().Add(wait)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+	// Our deadline replaces the one watchConn sets once ctx is done, so
+	// a scan cancelled before we set it mustn't wait the whole of it out.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 512)
+	n, err := conn.Read(buf)
+	if n > 0 {
+		return buf[:n], nil
+	}
+
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, nil
+	}
+	return nil, err
+}
+
+func matchSignatures(signatures []signature, b []byte) (protocol, matched string) {
+	for _, sig := range signatures {
+		if sig.match(b) {
+			return sig.protocol, sig.matched
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,124 @@
+package scanner
+
+import (
+	"context"
+	"crypto/tls"
+	"html"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// maxTitleBody caps how much of a page we read looking for its title.
+const maxTitleBody = 64 << 10
+
+// maxTitleLength, in characters, keeps a page that stuffs its title from flooding the output.
+const maxTitleLength = 128
+
+var titlePattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// HTTPInfo is what a GET of / on a web port told us.
+type HTTPInfo struct {
+	// Scheme is "http" or "https", whichever the port answered.
+	Scheme     string
+	StatusCode int
+	// Server is the Server header, which names the software more often than not.
+	Server string
+	// Location is where a redirect points to, redirects aren't followed.
+	Location string
+	Title    string
+}
+
+// ProbeHTTP GETs / from port and reports the status, Server header, redirect location and page title.
+// Ports that usually speak tls are tried over https first, everything else over plain http first,
+// falling back to the other scheme when the first one doesn't get an http response.
+// hostHeader is sent as the Host, and as SNI over https, leave it empty for ip targets.
+func (s *Scanner) ProbeHTTP(ctx context.Context, port int, hostHeader string) (HTTPInfo, error) {
+	schemes := []string{"http", "https"}
+	if levelFor(s.opts.ConfirmLevels, port) == ConfirmTLS || port == 443 || port == 8443 {
+		schemes = []string{"https", "http"}
+	}
+
+	var firstErr error
+	for _, scheme := range schemes {
+		info, err := s.getRoot(ctx, scheme, port, hostHeader)
+		if err == nil {
+			return info, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return HTTPInfo{}, firstErr
+}
+
+func (s *Scanner) getRoot(ctx context.Context, scheme string, port int, hostHeader string) (HTTPInfo, error) {
+	host := hostHeader
+	if host == "" {
+		host = s.hostname()
+	}
+
+	client := &http.Client{
+		Timeout: s.opts.Timeout,
+		Transport: &http.Transport{
+			// Every request goes through our own dial so budgets, rate limits,
+			// source addresses and the audit log apply like they do to the scan.
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return s.dial(ctx, port)
+			},
+			TLSClientConfig:   &tls.Config{ServerName: hostHeader, InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		},
+		// A redirect is worth reporting, not following.
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	url := scheme + "://" + net.JoinHostPort(host, strconv.Itoa(port)) + "/"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return HTTPInfo{}, xerrors.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("User-Agent", "port-scanner")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return HTTPInfo{}, xerrors.Errorf("failed to GET / over %s: %w", scheme, err)
+	}
+	defer resp.Body.Close()
+
+	info := HTTPInfo{
+		Scheme:     scheme,
+		StatusCode: resp.StatusCode,
+		Server:     resp.Header.Get("Server"),
+		Location:   resp.Header.Get("Location"),
+	}
+
+	if strings.Contains(resp.Header.Get("Content-Type"), "html") || resp.Header.Get("Content-Type") == "" {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxTitleBody))
+		info.Title = pageTitle(body)
+	}
+	return info, nil
+}
+
+// pageTitle returns the <title> of page with its whitespace collapsed, or "" if it has none.
+func pageTitle(page []byte) string {
+	match := titlePattern.FindSubmatch(page)
+	if match == nil {
+		return ""
+	}
+
+	title := strings.Join(strings.Fields(html.UnescapeString(string(match[1]))), " ")
+	if runes := []rune(title); len(runes) > maxTitleLength {
+		title = string(runes[:maxTitleLength])
+	}
+	return title
+}
@@ -0,0 +1,129 @@
+package scanner
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	icmpEchoReply   = 0
+	icmpEchoRequest = 8
+)
+
+// CheckICMP makes sure we're allowed to open the raw socket ICMP discovery needs.
+func CheckICMP() error {
+	conn, err := net.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return xerrors.Errorf("icmp discovery needs raw sockets(run as root or grant CAP_NET_RAW): %w", err)
+	}
+	return conn.Close()
+}
+
+// pingSweep sends an echo request to every ipv4 host in ips and returns the ones that replied.
+// Requests go out in one burst and replies are collected until timeout after the last one.
+func pingSweep(ctx context.Context, ips []net.IP, timeout time.Duration) ([]LiveHost, error) {
+	conn, err := net.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return nil, xerrors.Errorf("icmp discovery needs raw sockets(run as root or grant CAP_NET_RAW): %w", err)
+	}
+	defer conn.Close()
+
+	// Replies are told apart from everyone else's by the identifier we send.
+	id := uint16(os.Getpid())
+
+	var (
+		mu    sync.Mutex
+		sent  = make(map[string]time.Time)
+		found = make(map[string]LiveHost)
+		wg    sync.WaitGroup
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				// Closing the connection or its deadline passing is how we stop listening.
+				return
+			}
+
+			addr, ok := from.(*net.IPAddr)
+			if !ok || !isEchoReply(buf[:n], id) {
+				continue
+			}
+
+			mu.Lock()
+			key := addr.IP.String()
+			if start, ok := sent[key]; ok {
+				if _, done := found[key]; !done {
+					found[key] = LiveHost{IP: addr.IP, Method: "icmp", Latency: time.Since(start)}
+				}
+			}
+			mu.Unlock()
+		}
+	}()
+
+	for i, ip := range ips {
+		if ctx.Err() != nil {
+			break
+		}
+		if ip.To4() == nil {
+			continue
+		}
+
+		mu.Lock()
+		sent[ip.String()] = time.Now()
+		mu.Unlock()
+		_, _ = conn.WriteTo(echoRequest(id, uint16(i)), &net.IPAddr{IP: ip})
+	}
+
+	select {
+	case <-time.After(timeout):
+	case <-ctx.Done():
+	}
+	_ = conn.SetReadDeadline(time.Now())
+	wg.Wait()
+
+	hosts := make([]LiveHost, 0, len(found))
+	for _, host := range found {
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+// echoRequest builds an ICMP echo request with no payload.
+func echoRequest(id, seq uint16) []byte {
+	msg := make([]byte, 8)
+	msg[0] = icmpEchoRequest
+	binary.BigEndian.PutUint16(msg[4:6], id)
+	binary.BigEndian.PutUint16(msg[6:8], seq)
+	binary.BigEndian.PutUint16(msg[2:4], icmpChecksum(msg))
+	return msg
+}
+
+// isEchoReply reports whether msg is a reply to one of our echo requests.
+// Raw ip4:icmp sockets hand us the message without its ip header.
+func isEchoReply(msg []byte, id uint16) bool {
+	return len(msg) >= 8 && msg[0] == icmpEchoReply && msg[1] == 0 && binary.BigEndian.Uint16(msg[4:6]) == id
+}
+
+func icmpChecksum(msg []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(msg); i += 2 {
+		sum += uint32(msg[i])<<8 | uint32(msg[i+1])
+	}
+	if len(msg)%2 == 1 {
+		sum += uint32(msg[len(msg)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
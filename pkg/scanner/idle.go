@@ -0,0 +1,270 @@
+package scanner
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// An idle scan, after nmap's -sI, never sends the target anything from our
+// address. It needs a zombie: an idle host that stamps every packet it sends
+// with the next number of a single global ip id counter. For every group of
+// ports we
+//   - ask the zombie for its ip id by sending it a SYN-ACK it answers with a RST,
+//   - send the target a SYN for each port, spoofed to come from the zombie,
+//   - and ask the zombie for its ip id again.
+//
+// An open port answers its SYN with a SYN-ACK to the zombie, which resets it and
+// uses up an id. Closed ports send the zombie a RST, which it ignores, and
+// filtered ones nothing at all, so how far the counter moved on top of our own
+// probe is how many of the group's ports are open. Groups with some of both get
+// split until every port is accounted for. Closed and filtered ports look the
+// same from the zombie, so those get reported as closed|filtered.
+//
+// Anything else the zombie sends meanwhile moves the counter as well, which is
+// why it has to be idle, a busy one makes the scan retry groups until it gives up.
+
+// Zombie is the host an idle scan bounces its probes off.
+type Zombie struct {
+	IP net.IP
+	// Port is a port of the zombie we send SYN-ACKs to, it doesn't matter
+	// whether anything listens on it as long as a firewall doesn't drop them.
+	Port int
+}
+
+// DefaultZombiePort is the port of the zombie we probe when none is given.
+const DefaultZombiePort = 80
+
+// ParseZombie parses a zombie given as host or host:port.
+func ParseZombie(spec string) (*Zombie, error) {
+	host, port := spec, DefaultZombiePort
+	if h, p, err := net.SplitHostPort(spec); err == nil {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 1 || n > 65535 {
+			return nil, xerrors.Errorf("%q is an invalid port", p)
+		}
+		host, port = h, n
+	}
+
+	addr, err := net.ResolveIPAddr("ip4", host)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to resolve %s: %w", host, err)
+	}
+	return &Zombie{IP: addr.IP.To4(), Port: port}, nil
+}
+
+func (z *Zombie) String() string {
+	return net.JoinHostPort(z.IP.String(), strconv.Itoa(z.Port))
+}
+
+const (
+	// idleGroupSize is how many ports get probed through the zombie at once.
+	// Bigger groups need fewer probes on a quiet target and more on a busy one.
+	idleGroupSize = 16
+	// idleTries is how often a group goes again when the zombie's counter moved
+	// further than its ports can account for, before it's split up or given up on.
+	idleTries = 3
+	// idleSamples is how many ip ids are asked for to tell what kind of counter the zombie has.
+	idleSamples = 4
+)
+
+// zombieConn is how an idle scan talks to the zombie and the target.
+type zombieConn interface {
+	// ipID asks the zombie for the ip id of its next packet.
+	ipID(ctx context.Context) (uint16, error)
+	// spoof sends the target a SYN for port that seems to come from the zombie.
+	spoof(port int) error
+	close()
+}
+
+// idleScan runs an idle scan of s.opts.Ports through s.opts.Zombie.
+func (s *Scanner) idleScan(ctx context.Context) error {
+	conn, err := s.dialZombie()
+	if err != nil {
+		return err
+	}
+	defer conn.close()
+
+	z := &zombie{s: s, conn: conn}
+	if err := z.calibrate(ctx); err != nil {
+		return err
+	}
+
+	group := idleGroupSize
+	if s.opts.Concurrency < group {
+		group = s.opts.Concurrency
+	}
+
+	var ports []int
+	for _, port := range s.opts.Ports {
+		atomic.AddInt64(&s.scanned, 1)
+		if !s.opts.Budget.take() {
+			s.skip(port)
+			continue
+		}
+		ports = append(ports, port)
+	}
+
+	for len(ports) > 0 && ctx.Err() == nil {
+		n := group
+		if n > len(ports) {
+			n = len(ports)
+		}
+		if err := z.scan(ctx, ports[:n]); err != nil {
+			return err
+		}
+		ports = ports[n:]
+	}
+
+	// Ports left over when the scan was cancelled never got an answer.
+	for range ports {
+		s.fail("timeout")
+	}
+	return nil
+}
+
+// zombie tracks the ip id counter of the zombie host.
+type zombie struct {
+	s    *Scanner
+	conn zombieConn
+	// swapped is set for stacks that keep the counter in host byte order
+	// on a little endian machine, so it goes up by 256 a packet.
+	swapped bool
+}
+
+// calibrate makes sure the zombie's ip ids go up by one for every packet it
+// sends. Zeroes, random ids and per-destination counters are all useless to us.
+func (z *zombie) calibrate(ctx context.Context) error {
+	ids := make([]uint16, idleSamples)
+	for i := range ids {
+		id, err := z.conn.ipID(ctx)
+		if err != nil {
+			return err
+		}
+		ids[i] = id
+	}
+
+	incremental, swapped := true, true
+	for i := 1; i < len(ids); i++ {
+		diff := ids[i] - ids[i-1]
+		if diff == 0 {
+			return xerrors.Errorf("zombie %s always sends the same ip id(%d), it can't be used for an idle scan", z.s.opts.Zombie, ids[i])
+		}
+		incremental = incremental && diff < 16
+		swapped = swapped && diff%256 == 0 && diff/256 < 16
+	}
+	switch {
+	case incremental:
+	case swapped:
+		z.swapped = true
+	default:
+		return xerrors.Errorf("zombie %s doesn't send incremental ip ids(%v), they're random or it isn't idle", z.s.opts.Zombie, ids)
+	}
+	return nil
+}
+
+// id asks the zombie for its ip id, in the order it counts them in.
+func (z *zombie) id(ctx context.Context) (uint16, error) {
+	id, err := z.conn.ipID(ctx)
+	if z.swapped {
+		id = id<<8 | id>>8
+	}
+	return id, err
+}
+
+// opened returns how many of ports seem to be open, by how far the zombie's
+// counter moved while they were probed on top of our own probe of it.
+func (z *zombie) opened(ctx context.Context, ports []int) (int, error) {
+	before, err := z.id(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, port := range ports {
+		if err := z.s.opts.Rate.wait(ctx); err != nil {
+			return 0, err
+		}
+		if err := z.s.opts.Jitter.wait(ctx); err != nil {
+			return 0, err
+		}
+		z.s.sent(0)
+		if err := z.conn.spoof(port); err != nil && z.s.opts.RawErrors {
+			dumpRawError(port, err)
+		}
+	}
+
+	// The target's answers have to reach the zombie before we look again.
+	select {
+	case <-time.After(z.s.opts.Timeout / 2):
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+
+	after, err := z.id(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return int(after-before) - 1, nil
+}
+
+// scan finds the open ports of a group, splitting it up until every part is
+// either all open or all closed|filtered. A group of open ports gets probed
+// again before it's believed.
+func (z *zombie) scan(ctx context.Context, ports []int) error {
+	// The confirmation isn't a retry, only noise makes a group go again.
+	confirmed, retries := false, 0
+	for {
+		n, err := z.opened(ctx, ports)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case n < 0 || n > len(ports):
+			// Someone else got the zombie to send something.
+			if retries++; retries < idleTries {
+				continue
+			}
+			if len(ports) > 1 {
+				return z.split(ctx, ports)
+			}
+			z.s.finish(ports[0])
+			z.s.reject(PortResult{Port: ports[0], Attempts: retries + 1}, "zombie-noise")
+			if z.s.opts.RawErrors {
+				dumpRawError(ports[0], xerrors.Errorf("zombie %s isn't idle, its ip id moved by %d", z.s.opts.Zombie, n+1))
+			}
+		case n == 0:
+			for _, port := range ports {
+				z.s.finish(port)
+				z.s.reject(PortResult{Port: port, Attempts: retries + 1}, string(StateClosedFiltered))
+			}
+		case n == len(ports) && !confirmed:
+			// Noise can pass for open ports, so they have to turn up open twice in a row.
+			confirmed = true
+			continue
+		case n == len(ports):
+			for _, port := range ports {
+				z.s.finish(port)
+				z.s.add(PortResult{Port: port, State: StateOpen, Attempts: retries + 1})
+			}
+		default:
+			return z.split(ctx, ports)
+		}
+		return nil
+	}
+}
+
+func (z *zombie) split(ctx context.Context, ports []int) error {
+	half := len(ports) / 2
+	if err := z.scan(ctx, ports[:half]); err != nil {
+		return err
+	}
+	return z.scan(ctx, ports[half:])
+}
@@ -0,0 +1,146 @@
+package scanner
+
+import (
+	"context"
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// rawZombie talks to the zombie over a raw tcp socket and spoofs the probes
+// to the target over a raw socket we write the ip headers of ourselves.
+type rawZombie struct {
+	s       *Scanner
+	fd      int
+	hdrFD   int
+	src     net.IP
+	target  syscall.SockaddrInet4
+	zombie  syscall.SockaddrInet4
+	srcPort uint16
+	buf     []byte
+}
+
+func (s *Scanner) dialZombie() (zombieConn, error) {
+	z := s.opts.Zombie
+	// Our SYN-ACKs go to the zombie, which may well be routed differently than the target.
+	src, err := s.sourceFor(z.IP.String())
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &rawZombie{s: s, fd: -1, hdrFD: -1, src: src, buf: make([]byte, 65535)}
+	copy(conn.target.Addr[:], net.ParseIP(s.host).To4())
+	copy(conn.zombie.Addr[:], z.IP.To4())
+
+	if conn.fd, err = syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, ipProtoTCP); err != nil {
+		return nil, xerrors.Errorf("failed to open a raw socket: %w", err)
+	}
+	if conn.hdrFD, err = syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, syscall.IPPROTO_RAW); err != nil {
+		conn.close()
+		return nil, xerrors.Errorf("failed to open a raw socket for our own ip headers: %w", err)
+	}
+	for _, fd := range []int{conn.fd, conn.hdrFD} {
+		if iface := s.opts.Sources.device(); iface != "" {
+			if err := bindFDToDevice(fd, iface); err != nil {
+				conn.close()
+				return nil, err
+			}
+		}
+		if s.opts.Mark != 0 {
+			if err := markFD(fd, s.opts.Mark); err != nil {
+				conn.close()
+				return nil, err
+			}
+		}
+	}
+
+	// Recvfrom has no context, so lets have it wake up regularly to check on it.
+	tv := syscall.NsecToTimeval(int64(100 * time.Millisecond))
+	if err := syscall.SetsockoptTimeval(conn.fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		conn.close()
+		return nil, xerrors.Errorf("failed to set receive timeout: %w", err)
+	}
+
+	// Every probe of the zombie goes out from a port of its own, so a late
+	// RST to an earlier probe is never mistaken for the answer to this one.
+	conn.srcPort = uint16(32768 + rand.Intn(28232))
+	return conn, nil
+}
+
+func (z *rawZombie) ipID(ctx context.Context) (uint16, error) {
+	zombie := z.s.opts.Zombie
+	for try := 0; try <= z.s.opts.Retry.Retries; try++ {
+		z.srcPort++
+		if z.srcPort < 32768 {
+			z.srcPort = 32768
+		}
+
+		if err := z.s.opts.Rate.wait(ctx); err != nil {
+			return 0, err
+		}
+		to := z.zombie
+		pkt := probePacket(z.src, zombie.IP, z.srcPort, uint16(zombie.Port), rand.Uint32(), tcpFlagSYN|tcpFlagACK)
+		if err := syscall.Sendto(z.fd, pkt, 0, &to); err != nil {
+			return 0, xerrors.Errorf("failed to probe zombie %s: %w", zombie, err)
+		}
+
+		deadline := time.Now().Add(z.s.opts.Timeout)
+		for time.Now().Before(deadline) && ctx.Err() == nil {
+			n, _, err := syscall.Recvfrom(z.fd, z.buf, 0)
+			if err != nil {
+				continue
+			}
+			if id, ok := parseZombieReply(z.buf[:n], zombie, z.srcPort); ok {
+				return id, nil
+			}
+		}
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+	}
+	return 0, xerrors.Errorf("zombie %s didn't answer our SYN-ACKs with a RST", zombie)
+}
+
+func (z *rawZombie) spoof(port int) error {
+	zombie := z.s.opts.Zombie
+	dst := net.IP(z.target.Addr[:])
+	// The zombie's answer to the target's SYN-ACK is all we need, its port doesn't matter.
+	srcPort := uint16(1024 + rand.Intn(64511))
+	to := z.target
+	for _, pkt := range ipv4Packets(zombie.IP, dst, ipProtoTCP, probePacket(zombie.IP, dst, srcPort, uint16(port), rand.Uint32(), tcpFlagSYN), IPHeader{}) {
+		if err := syscall.Sendto(z.hdrFD, pkt, 0, &to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (z *rawZombie) close() {
+	for _, fd := range []int{z.fd, z.hdrFD} {
+		if fd >= 0 {
+			syscall.Close(fd)
+		}
+	}
+}
+
+// parseZombieReply picks the zombie's RST to our probe from ourPort out of a raw
+// ipv4 packet and returns the ip id it was sent with.
+func parseZombieReply(pkt []byte, zombie *Zombie, ourPort uint16) (uint16, bool) {
+	if len(pkt) < 20 || pkt[0]>>4 != 4 || pkt[9] != ipProtoTCP {
+		return 0, false
+	}
+	ihl := int(pkt[0]&0x0f) * 4
+	if len(pkt) < ihl+20 || !net.IP(pkt[12:16]).Equal(zombie.IP) {
+		return 0, false
+	}
+
+	tcp := pkt[ihl:]
+	if int(binary.BigEndian.Uint16(tcp[0:])) != zombie.Port || binary.BigEndian.Uint16(tcp[2:]) != ourPort || tcp[13]&tcpFlagRST == 0 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(pkt[4:]), true
+}
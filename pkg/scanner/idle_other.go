@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package scanner
+
+// Idle scans need the same raw sockets as SYN scans.
+func (s *Scanner) dialZombie() (zombieConn, error) {
+	return nil, unsupported("idle scans are only supported on linux")
+}
@@ -0,0 +1,39 @@
+package scanner
+
+import "context"
+
+// InFlight caps how many probes are outstanding at once across every scanner
+// sharing it. Scanning several hosts in parallel with one keeps the run as a
+// whole from flooding the network, however many hosts are under way.
+type InFlight struct {
+	slots chan struct{}
+}
+
+// NewInFlight returns nil when max isn't positive, which doesn't cap anything.
+func NewInFlight(max int) *InFlight {
+	if max <= 0 {
+		return nil
+	}
+	return &InFlight{slots: make(chan struct{}, max)}
+}
+
+// acquire blocks until a probe may go out or ctx is done.
+func (f *InFlight) acquire(ctx context.Context) error {
+	if f == nil {
+		return nil
+	}
+
+	select {
+	case f.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release hands back a slot taken by acquire.
+func (f *InFlight) release() {
+	if f != nil {
+		<-f.slots
+	}
+}
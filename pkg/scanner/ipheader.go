@@ -0,0 +1,85 @@
+package scanner
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"net"
+
+	"golang.org/x/xerrors"
+)
+
+// IPHeader shapes the ipv4 header of raw probes, which is handy for seeing how a
+// firewall treats edge case packets. The zero value leaves it all to the kernel.
+type IPHeader struct {
+	// TTL is the time to live of the probes, 0 uses the kernel's default.
+	TTL int
+	// TOS is the type of service byte, a DSCP shifted left by two along with the ECN bits.
+	TOS int
+	// FragmentSize splits every probe into ip fragments carrying this many bytes
+	// of it, which has to be a multiple of 8. 0 sends every probe in one piece.
+	FragmentSize int
+}
+
+func (h IPHeader) validate() error {
+	switch {
+	case h.TTL < 0 || h.TTL > 255:
+		return xerrors.Errorf("a ttl is between 1 and 255, got %d", h.TTL)
+	case h.TOS < 0 || h.TOS > 255:
+		return xerrors.Errorf("the type of service is a byte, got %d", h.TOS)
+	case h.FragmentSize < 0 || h.FragmentSize%8 != 0:
+		return xerrors.Errorf("fragments carry a multiple of 8 bytes, got %d", h.FragmentSize)
+	}
+	return nil
+}
+
+// ipv4Packets wraps payload in ipv4 headers of our own, fragmented when h says so.
+// That's what lets a raw socket with IP_HDRINCL send it from an address that
+// isn't ours, or in pieces the kernel would never split it into.
+func ipv4Packets(src, dst net.IP, proto int, payload []byte, h IPHeader) [][]byte {
+	ttl := h.TTL
+	if ttl == 0 {
+		ttl = 64
+	}
+	// Every fragment of a packet shares its id, that's how the target puts them back together.
+	id := uint16(rand.Intn(0x10000))
+
+	size := len(payload)
+	if h.FragmentSize > 0 {
+		size = h.FragmentSize
+	}
+
+	var pkts [][]byte
+	for off := 0; ; off += size {
+		end := off + size
+		if end > len(payload) {
+			end = len(payload)
+		}
+
+		pkt := make([]byte, 20+end-off)
+		pkt[0] = 0x45
+		pkt[1] = byte(h.TOS)
+		binary.BigEndian.PutUint16(pkt[2:], uint16(len(pkt)))
+		binary.BigEndian.PutUint16(pkt[4:], id)
+		// The offset counts 8 byte blocks, the flags are either more fragments to come
+		// or, for a probe in one piece, don't fragment like the kernel sets on its own.
+		frag := uint16(off / 8)
+		switch {
+		case end < len(payload):
+			frag |= 0x2000
+		case h.FragmentSize == 0:
+			frag |= 0x4000
+		}
+		binary.BigEndian.PutUint16(pkt[6:], frag)
+		pkt[8] = byte(ttl)
+		pkt[9] = byte(proto)
+		copy(pkt[12:16], src.To4())
+		copy(pkt[16:20], dst.To4())
+		binary.BigEndian.PutUint16(pkt[10:], icmpChecksum(pkt[:20]))
+		copy(pkt[20:], payload[off:end])
+		pkts = append(pkts, pkt)
+
+		if end == len(payload) {
+			return pkts
+		}
+	}
+}
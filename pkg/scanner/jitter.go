@@ -0,0 +1,85 @@
+package scanner
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// Jitter spaces probes out by a random gap between Min and Max. A rate limit
+// still sends them on a perfectly regular beat, which is easy to pick out of
+// the traffic, while jittered probes look a lot more like background noise.
+// Share one between scanners to space out the probes of a whole multi-host run.
+// Every probe waits its turn, so it also caps the rate at one probe per gap.
+type Jitter struct {
+	Min, Max time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// ParseJitter parses a range of gaps like "50ms-300ms", a single
+// duration like "200ms" is short for anything up to it.
+func ParseJitter(s string) (*Jitter, error) {
+	lo, hi := "0s", s
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		lo, hi = s[:i], s[i+1:]
+	}
+
+	min, err := time.ParseDuration(strings.TrimSpace(lo))
+	if err != nil {
+		return nil, xerrors.Errorf("invalid minimum: %w", err)
+	}
+	max, err := time.ParseDuration(strings.TrimSpace(hi))
+	if err != nil {
+		return nil, xerrors.Errorf("invalid maximum: %w", err)
+	}
+	if min < 0 || max <= 0 || min > max {
+		return nil, xerrors.Errorf("%q should be a range of positive durations like 50ms-300ms", s)
+	}
+	return &Jitter{Min: min, Max: max}, nil
+}
+
+// wait blocks until it's the next probe's turn or ctx is done, a nil j never waits.
+func (j *Jitter) wait(ctx context.Context) error {
+	if j == nil {
+		return nil
+	}
+
+	delay := time.Until(j.reserve())
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reserve returns when the caller gets to send its probe, and pushes the
+// turn after it a random gap further out.
+func (j *Jitter) reserve() time.Time {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	at := time.Now()
+	if j.next.After(at) {
+		at = j.next
+	}
+
+	gap := j.Min
+	if j.Max > j.Min {
+		gap += time.Duration(rand.Int63n(int64(j.Max - j.Min + 1)))
+	}
+	j.next = at.Add(gap)
+	return at
+}
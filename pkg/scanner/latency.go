@@ -0,0 +1,89 @@
+package scanner
+
+import (
+	"sort"
+	"time"
+)
+
+// Fastest returns the n open ports of r that took the least time to connect to.
+// Only open ports have a latency, so closed and filtered ones are never considered.
+func (r Result) Fastest(n int) []PortResult {
+	ports := r.Open()
+	sort.Slice(ports, func(i, j int) bool {
+		if ports[i].Latency == ports[j].Latency {
+			return ports[i].Port < ports[j].Port
+		}
+		return ports[i].Latency < ports[j].Latency
+	})
+
+	if n < len(ports) {
+		ports = ports[:n]
+	}
+	return ports
+}
+
+// Suspicious reports whether p connected faster than min.
+// Middleboxes that accept every connection tend to answer near-instantly,
+// even for ports nothing behind them is listening on.
+func (p PortResult) Suspicious(min time.Duration) bool {
+	return p.State == StateOpen && p.Latency < min
+}
+
+// LatencyBuckets are the upper bounds of the buckets SummarizeLatency counts
+// ports into. Services on the same host or lan land in the first few, ones
+// behind a proxy or on an overloaded host in the last few.
+var LatencyBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// LatencySummary describes how long the open ports of a host took to connect to.
+type LatencySummary struct {
+	Min, Median, P90, Max time.Duration
+	// Counts holds how many ports fell in each of LatencyBuckets, a port counts
+	// towards the first bucket it fits in. The extra last count is for the
+	// ports slower than every bucket.
+	Counts []int
+}
+
+// Latency summarizes the connect latencies of the open ports of r,
+// it's nil when none of them has one.
+func (r Result) Latency() *LatencySummary {
+	var latencies []time.Duration
+	for _, p := range r.Open() {
+		if p.Latency > 0 {
+			latencies = append(latencies, p.Latency)
+		}
+	}
+	if len(latencies) == 0 {
+		return nil
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	s := &LatencySummary{
+		Min:    latencies[0],
+		Median: percentile(latencies, 50),
+		P90:    percentile(latencies, 90),
+		Max:    latencies[len(latencies)-1],
+		Counts: make([]int, len(LatencyBuckets)+1),
+	}
+	for _, l := range latencies {
+		i := sort.Search(len(LatencyBuckets), func(i int) bool { return l <= LatencyBuckets[i] })
+		s.Counts[i]++
+	}
+	return s
+}
+
+// percentile returns the p'th percentile of sorted using the nearest rank.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
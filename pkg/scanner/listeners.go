@@ -0,0 +1,43 @@
+package scanner
+
+import (
+	"net"
+	"sort"
+)
+
+// Listener is a socket of the local machine waiting on connections, or on
+// datagrams for udp.
+type Listener struct {
+	// Network is "tcp", "tcp6", "udp" or "udp6".
+	Network string
+	IP      net.IP
+	Port    int
+	// PID and Process are the process holding the socket. We can only tell for
+	// the processes we're allowed to look into, it's 0 and "" for the others.
+	PID     int
+	Process string
+}
+
+// Wildcard reports whether l accepts on every address of the machine.
+func (l Listener) Wildcard() bool {
+	return l.IP.IsUnspecified()
+}
+
+// Listeners lists the machine's listening tcp and udp sockets, sorted by port.
+func Listeners() ([]Listener, error) {
+	ls, err := listeners()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(ls, func(i, j int) bool {
+		if ls[i].Port != ls[j].Port {
+			return ls[i].Port < ls[j].Port
+		}
+		if ls[i].Network != ls[j].Network {
+			return ls[i].Network < ls[j].Network
+		}
+		return ls[i].IP.String() < ls[j].IP.String()
+	})
+	return ls, nil
+}
@@ -0,0 +1,165 @@
+package scanner
+
+import (
+	"bufio"
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	// tcpListen is the LISTEN state in /proc/net/tcp.
+	tcpListen = "0A"
+	// udpUnconnected is the state of a udp socket nobody connected, which
+	// is the closest udp gets to listening.
+	udpUnconnected = "07"
+)
+
+func listeners() ([]Listener, error) {
+	owners := socketOwners()
+
+	var ls []Listener
+	for _, table := range []struct {
+		network, path, state string
+	}{
+		{"tcp", "/proc/net/tcp", tcpListen},
+		{"tcp6", "/proc/net/tcp6", tcpListen},
+		{"udp", "/proc/net/udp", udpUnconnected},
+		{"udp6", "/proc/net/udp6", udpUnconnected},
+	} {
+		found, err := readSocketTable(table.path, table.network, table.state, owners)
+		if err != nil {
+			// A kernel without ipv6 has no tables for it.
+			if os.IsNotExist(err) && strings.HasSuffix(table.network, "6") {
+				continue
+			}
+			return nil, xerrors.Errorf("failed to read %s: %w", table.path, err)
+		}
+		ls = append(ls, found...)
+	}
+	return ls, nil
+}
+
+// readSocketTable returns the sockets of a /proc/net table that are in state.
+func readSocketTable(path, network, state string, owners map[string]owner) ([]Listener, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// sl, local_address, rem_address, st, tx_queue:rx_queue, tr:tm->when, retrnsmt, uid, timeout, inode
+	var ls []Listener
+	sc := bufio.NewScanner(f)
+	sc.Scan() // the header
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 10 || fields[3] != state {
+			continue
+		}
+
+		ip, port, ok := parseSocketAddr(fields[1])
+		if !ok {
+			continue
+		}
+		if state == udpUnconnected {
+			// Unconnected udp sockets that were only ever used to send, like a
+			// resolver's, are bound to an ephemeral port but listen all the same.
+			if _, remote, ok := parseSocketAddr(fields[2]); !ok || remote != 0 {
+				continue
+			}
+		}
+
+		l := Listener{Network: network, IP: ip, Port: port}
+		if o, ok := owners[fields[9]]; ok {
+			l.PID, l.Process = o.pid, o.process
+		}
+		ls = append(ls, l)
+	}
+	return ls, sc.Err()
+}
+
+// parseSocketAddr parses the ADDR:PORT of a /proc/net table, the address is
+// hex in 32-bit words of host byte order and the port is hex in network order.
+func parseSocketAddr(s string) (net.IP, int, bool) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return nil, 0, false
+	}
+
+	raw, err := hex.DecodeString(s[:i])
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return nil, 0, false
+	}
+	port, err := strconv.ParseUint(s[i+1:], 16, 16)
+	if err != nil {
+		return nil, 0, false
+	}
+
+	// Every platform linux runs a network stack on these days is little-endian.
+	ip := make(net.IP, len(raw))
+	for w := 0; w < len(raw); w += 4 {
+		ip[w], ip[w+1], ip[w+2], ip[w+3] = raw[w+3], raw[w+2], raw[w+1], raw[w]
+	}
+	if v4 := ip.To4(); v4 != nil && len(raw) == net.IPv6len && !ip.IsUnspecified() {
+		// An ipv4-mapped address on a tcp6 socket.
+		ip = v4
+	}
+	return ip, int(port), true
+}
+
+// owner is the process holding a socket.
+type owner struct {
+	pid     int
+	process string
+}
+
+// socketOwners maps socket inodes to the process holding them, by going
+// through the file descriptors of every process we're allowed to look into.
+// When several processes share a socket, like a server's forked workers,
+// the one with the lowest pid, usually the parent, gets it.
+func socketOwners() map[string]owner {
+	owners := make(map[string]owner)
+	procs, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return owners
+	}
+
+	for _, proc := range procs {
+		pid, err := strconv.Atoi(proc.Name())
+		if err != nil {
+			continue
+		}
+
+		fdDir := filepath.Join("/proc", proc.Name(), "fd")
+		fds, err := ioutil.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+
+		var process string
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+
+			inode := strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")
+			if o, ok := owners[inode]; ok && o.pid < pid {
+				continue
+			}
+			if process == "" {
+				comm, _ := ioutil.ReadFile(filepath.Join("/proc", proc.Name(), "comm"))
+				process = strings.TrimSpace(string(comm))
+			}
+			owners[inode] = owner{pid: pid, process: process}
+		}
+	}
+	return owners
+}
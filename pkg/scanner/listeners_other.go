@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package scanner
+
+// Listing sockets and their owners means reading /proc, which only linux has.
+func listeners() ([]Listener, error) {
+	return nil, unsupported("listing local sockets is only supported on linux")
+}
@@ -0,0 +1,172 @@
+package scanner
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// Operating systems build their SYN-ACKs a little differently. The initial TTL,
+// the window they advertise and the order they put TCP options in are rarely
+// configured by anyone, so together they give away the OS family behind an open port.
+// Scrubbing firewalls and load balancers rewrite some of these, which is why
+// guesses come with a confidence rather than as a fact.
+
+// TCPFingerprint is what a SYN-ACK says about the stack that sent it.
+type TCPFingerprint struct {
+	// TTL is the ttl the reply arrived with, InitialTTL our guess at what it was sent with.
+	TTL        int
+	InitialTTL int
+	Window     int
+	// Options lists the kinds of TCP options in the order they were sent,
+	// M for mss, N for nop, W for window scale, S for sack permitted, T for timestamps and E for end of list.
+	Options string
+}
+
+// OSGuess is the operating system family a fingerprint most looks like.
+type OSGuess struct {
+	// Family is like "Linux" or "Windows", "unknown" when nothing matched well enough.
+	Family string
+	// Confidence is how much of the fingerprint matched, between 0 and 1.
+	Confidence  float64
+	Fingerprint TCPFingerprint
+}
+
+type osSignature struct {
+	family  string
+	ttl     int
+	options string
+	windows []int
+}
+
+// osSignatures are the SYN-ACKs of common stacks answering fingerprintSYN.
+var osSignatures = []osSignature{
+	{family: "Linux", ttl: 64, options: "M,S,T,N,W", windows: []int{5792, 14480, 28960, 29200, 43440, 43690, 64240, 65160, 65483}},
+	{family: "Windows", ttl: 128, options: "M,N,W,N,N,S", windows: []int{8192, 64240, 65535}},
+	{family: "macOS", ttl: 64, options: "M,N,W,N,N,T,S", windows: []int{65535}},
+	{family: "FreeBSD", ttl: 64, options: "M,N,W,S,T", windows: []int{65535, 65228}},
+	{family: "OpenBSD", ttl: 64, options: "M,N,N,S,N,W,N,N,T", windows: []int{16384}},
+	{family: "network device", ttl: 255, options: "M", windows: []int{4128, 8192, 16384}},
+}
+
+// minOSConfidence is the least a signature has to match before we name a family.
+const minOSConfidence = 0.5
+
+// CheckOSDetect makes sure we're allowed to open the raw socket OS detection needs.
+func CheckOSDetect() error {
+	if err := CheckSYN(); err != nil {
+		return xerrors.Errorf("os detection needs to send its own SYN: %w", err)
+	}
+	return nil
+}
+
+// GuessOS scores fp against the signatures of common stacks, the option order
+// says the most, followed by the initial ttl and then the window.
+func GuessOS(fp TCPFingerprint) OSGuess {
+	best := OSGuess{Family: "unknown", Fingerprint: fp}
+	for _, sig := range osSignatures {
+		var score float64
+		if sig.options == fp.Options {
+			score += 0.5
+		}
+		if sig.ttl == fp.InitialTTL {
+			score += 0.3
+		}
+		for _, window := range sig.windows {
+			if window == fp.Window {
+				score += 0.2
+				break
+			}
+		}
+
+		if score > best.Confidence {
+			best.Family, best.Confidence = sig.family, score
+		}
+	}
+
+	if best.Confidence < minOSConfidence {
+		best.Family = "unknown"
+	}
+	return best
+}
+
+// fingerprintSYN builds a SYN offering every common option, the way a modern stack would,
+// so the reply shows which of them the other end supports and in what order it puts them.
+func fingerprintSYN(src, dst net.IP, srcPort, dstPort uint16, seq uint32) []byte {
+	pkt := make([]byte, 40)
+	binary.BigEndian.PutUint16(pkt[0:], srcPort)
+	binary.BigEndian.PutUint16(pkt[2:], dstPort)
+	binary.BigEndian.PutUint32(pkt[4:], seq)
+	// 40 byte header(10 words) with no ack number.
+	pkt[12] = 10 << 4
+	pkt[13] = tcpFlagSYN
+	binary.BigEndian.PutUint16(pkt[14:], 64240)
+	copy(pkt[20:], []byte{
+		2, 4, 0x05, 0xb4, // mss 1460
+		4, 2, // sack permitted
+		8, 10, 0, 0, 0, 1, 0, 0, 0, 0, // timestamps
+		1,       // nop
+		3, 3, 7, // window scale
+	})
+	binary.BigEndian.PutUint16(pkt[16:], tcpChecksum(src, dst, pkt))
+	return pkt
+}
+
+// parseFingerprint reads the fingerprint off a raw ipv4 SYN-ACK.
+func parseFingerprint(pkt []byte) TCPFingerprint {
+	ihl := int(pkt[0]&0x0f) * 4
+	tcp := pkt[ihl:]
+	fp := TCPFingerprint{
+		TTL:    int(pkt[8]),
+		Window: int(binary.BigEndian.Uint16(tcp[14:])),
+	}
+	fp.InitialTTL = initialTTL(fp.TTL)
+
+	offset := int(tcp[12]>>4) * 4
+	if offset > len(tcp) {
+		offset = len(tcp)
+	}
+	var kinds []string
+	for opts := tcp[20:offset]; len(opts) > 0; {
+		kind := opts[0]
+		switch kind {
+		case 0:
+			kinds = append(kinds, "E")
+			opts = opts[1:]
+			continue
+		case 1:
+			kinds = append(kinds, "N")
+			opts = opts[1:]
+			continue
+		case 2:
+			kinds = append(kinds, "M")
+		case 3:
+			kinds = append(kinds, "W")
+		case 4:
+			kinds = append(kinds, "S")
+		case 8:
+			kinds = append(kinds, "T")
+		default:
+			kinds = append(kinds, "?")
+		}
+		if len(opts) < 2 || opts[1] < 2 || int(opts[1]) > len(opts) {
+			break
+		}
+		opts = opts[opts[1]:]
+	}
+	// Padding after the last option says nothing about the stack.
+	fp.Options = strings.TrimRight(strings.Join(kinds, ","), ",E")
+	return fp
+}
+
+// initialTTL rounds ttl up to the initial ttl stacks commonly start from.
+func initialTTL(ttl int) int {
+	for _, initial := range []int{32, 64, 128} {
+		if ttl <= initial {
+			return initial
+		}
+	}
+	return 255
+}
@@ -0,0 +1,86 @@
+package scanner
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// DetectOS sends a SYN of our own to an open port and guesses the OS from the SYN-ACK.
+// Like a SYN scan it never completes the handshake, our kernel resets it for us.
+func (s *Scanner) DetectOS(ctx context.Context, port int) (OSGuess, error) {
+	dst := net.ParseIP(s.host).To4()
+	if dst == nil {
+		return OSGuess{}, xerrors.New("os detection only supports ipv4 targets")
+	}
+
+	src, err := s.synSource()
+	if err != nil {
+		return OSGuess{}, err
+	}
+
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, syscall.IPPROTO_TCP)
+	if err != nil {
+		return OSGuess{}, xerrors.Errorf("failed to open a raw socket: %w", err)
+	}
+	defer syscall.Close(fd)
+
+	if iface := s.opts.Sources.device(); iface != "" {
+		if err := bindFDToDevice(fd, iface); err != nil {
+			return OSGuess{}, err
+		}
+	}
+	if s.opts.Mark != 0 {
+		if err := markFD(fd, s.opts.Mark); err != nil {
+			return OSGuess{}, err
+		}
+	}
+
+	tv := syscall.NsecToTimeval(int64(100 * time.Millisecond))
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		return OSGuess{}, xerrors.Errorf("failed to set receive timeout: %w", err)
+	}
+
+	var sa syscall.SockaddrInet4
+	copy(sa.Addr[:], dst)
+	srcPort := uint16(32768 + rand.Intn(28232))
+
+	if err := s.opts.Rate.wait(ctx); err != nil {
+		return OSGuess{}, err
+	}
+	if err := s.opts.Jitter.wait(ctx); err != nil {
+		return OSGuess{}, err
+	}
+	sent := time.Now()
+	if err := syscall.Sendto(fd, fingerprintSYN(src, dst, srcPort, uint16(port), rand.Uint32()), 0, &sa); err != nil {
+		return OSGuess{}, xerrors.Errorf("failed to send SYN: %w", err)
+	}
+
+	buf := make([]byte, 65535)
+	for time.Since(sent) < s.opts.Timeout && ctx.Err() == nil {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			continue
+		}
+
+		replyPort, flags, ok := parseSYNReply(buf[:n], dst, srcPort)
+		if !ok || replyPort != port {
+			continue
+		}
+		if flags&tcpFlagSYN == 0 {
+			return OSGuess{}, xerrors.Errorf("port %d refused our SYN", port)
+		}
+		s.opts.Audit.recordSYN(sent, src, net.JoinHostPort(s.host, strconv.Itoa(port)), synOutcome(flags))
+		return GuessOS(parseFingerprint(buf[:n])), nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return OSGuess{}, err
+	}
+	return OSGuess{}, xerrors.Errorf("no reply to our SYN within %s", s.opts.Timeout)
+}
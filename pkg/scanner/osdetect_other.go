@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package scanner
+
+import "context"
+
+// DetectOS needs the same raw tcp sockets as a SYN scan, which only linux gives us.
+func (s *Scanner) DetectOS(ctx context.Context, port int) (OSGuess, error) {
+	return OSGuess{}, unsupported("os detection is only supported on linux")
+}
@@ -0,0 +1,50 @@
+package scanner
+
+import (
+	"bufio"
+	_ "embed"
+	"net"
+	"strings"
+	"sync"
+)
+
+//go:embed oui.txt
+var ouiFile string
+
+var (
+	ouiOnce sync.Once
+	// vendors maps the first three bytes of a MAC address like "b8:27:eb" to who it's assigned to.
+	vendors map[string]string
+)
+
+// Vendor returns the organization the prefix of mac is assigned to, like
+// Raspberry Pi Foundation for b8:27:eb, or "" if it isn't in the registry.
+// Locally administered addresses, which virtual machines and containers
+// often make up, never have a vendor.
+func Vendor(mac net.HardwareAddr) string {
+	if len(mac) < 3 || mac[0]&0x02 != 0 {
+		return ""
+	}
+
+	ouiOnce.Do(func() { vendors = parseOUIs(ouiFile) })
+	return vendors[mac[:3].String()]
+}
+
+// parseOUIs reads a table of tab separated prefixes and organizations, comment lines are ignored.
+func parseOUIs(table string) map[string]string {
+	names := make(map[string]string)
+	lines := bufio.NewScanner(strings.NewReader(table))
+	for lines.Scan() {
+		line := lines.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		i := strings.IndexByte(line, '\t')
+		if i < 0 {
+			continue
+		}
+		names[line[:i]] = line[i+1:]
+	}
+	return names
+}
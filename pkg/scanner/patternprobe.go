@@ -0,0 +1,73 @@
+package scanner
+
+import (
+	"net"
+	"regexp"
+
+	"golang.org/x/xerrors"
+)
+
+// PatternProbe is a probe defined by data instead of code, after the probes of
+// nmap-service-probes: it sends a payload and matches the reply against a
+// regular expression. It's how in-house protocols get fingerprinted without
+// writing a Probe of their own.
+type PatternProbe struct {
+	ProbeName string
+	// Ports the probe applies to. It also applies to any port whose banner Pattern matches.
+	Ports []int
+	// Payload is sent once connected, nothing is sent for services that greet us first.
+	Payload []byte
+	// Pattern has to match the reply. Its named groups end up in the details.
+	Pattern *regexp.Regexp
+	// Summary is expanded with the groups of the match like regexp.Expand,
+	// e.g. "acme rpc $version". The probe's name is used when it's empty.
+	Summary string
+}
+
+func (p *PatternProbe) Name() string { return p.ProbeName }
+
+func (p *PatternProbe) Match(port int, banner string) bool {
+	for _, candidate := range p.Ports {
+		if candidate == port {
+			return true
+		}
+	}
+	return banner != "" && p.Pattern.MatchString(banner)
+}
+
+func (p *PatternProbe) Run(conn net.Conn, _ string) (ProbeResult, error) {
+	if len(p.Payload) > 0 {
+		if _, err := conn.Write(p.Payload); err != nil {
+			return ProbeResult{}, xerrors.Errorf("failed to send payload: %w", err)
+		}
+	}
+
+	// The deadline the connection came with bounds the wait.
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if n == 0 && err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return ProbeResult{}, xerrors.New("no reply")
+		}
+		return ProbeResult{}, xerrors.Errorf("failed to read reply: %w", err)
+	}
+	reply := buf[:n]
+
+	match := p.Pattern.FindSubmatchIndex(reply)
+	if match == nil {
+		return ProbeResult{}, xerrors.Errorf("reply %q didn't match", banner(reply))
+	}
+
+	details := make(map[string]string)
+	for i, name := range p.Pattern.SubexpNames() {
+		if name != "" && match[2*i] >= 0 {
+			details[name] = string(reply[match[2*i]:match[2*i+1]])
+		}
+	}
+
+	summary := p.ProbeName
+	if p.Summary != "" {
+		summary = string(p.Pattern.Expand(nil, []byte(p.Summary), reply, match))
+	}
+	return ProbeResult{Summary: summary, Details: details}, nil
+}
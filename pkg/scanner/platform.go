@@ -0,0 +1,18 @@
+package scanner
+
+import "golang.org/x/xerrors"
+
+// ErrUnsupported is what features that need something this platform doesn't
+// have fail with, like the raw sockets of a SYN scan outside of linux. Check
+// for it with xerrors.Is to fall back to what works everywhere, like a connect
+// scan, rather than give up. It's never the error for missing privileges.
+var ErrUnsupported = xerrors.New("unsupported on this platform")
+
+// unsupportedError says what isn't supported here, and is ErrUnsupported.
+type unsupportedError string
+
+func unsupported(msg string) error { return unsupportedError(msg) }
+
+func (e unsupportedError) Error() string { return string(e) }
+
+func (e unsupportedError) Is(target error) bool { return target == ErrUnsupported }
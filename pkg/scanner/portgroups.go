@@ -0,0 +1,62 @@
+package scanner
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/xerrors"
+)
+
+// Port groups are named sets of ports, written as "@name" wherever ParsePorts
+// takes a port, so a scan of the usual web ports doesn't need to spell them out.
+var (
+	portGroupsMu sync.Mutex
+	portGroups   = map[string][]int{
+		"web":           {80, 443, 591, 3000, 5000, 8000, 8008, 8080, 8081, 8443, 8888, 9000, 9443},
+		"db":            {1433, 1521, 3306, 5432, 5984, 6379, 7474, 8086, 9042, 9200, 11211, 27017},
+		"mail":          {25, 110, 143, 465, 587, 993, 995, 2525},
+		"remote-access": {22, 23, 512, 513, 514, 2222, 3389, 5900, 5901, 5902, 5903, 5985, 5986},
+		"windows":       {88, 135, 137, 138, 139, 389, 445, 464, 636, 3268, 3269, 3389, 5985, 5986},
+	}
+)
+
+// DefinePortGroup makes spec, written like the ports ParsePorts takes, available as "@name".
+// It replaces a group of the same name, built in or not, and may refer to groups defined before it.
+func DefinePortGroup(name, spec string) error {
+	if name == "" || strings.ContainsAny(name, "@,- \t") {
+		return xerrors.Errorf("%q is an invalid port group name", name)
+	}
+
+	ports, err := ParsePorts(spec)
+	if err != nil {
+		return xerrors.Errorf("invalid port group %q: %w", name, err)
+	}
+
+	portGroupsMu.Lock()
+	defer portGroupsMu.Unlock()
+	portGroups[name] = ports
+	return nil
+}
+
+// PortGroupNames returns the names of every port group, sorted.
+func PortGroupNames() []string {
+	portGroupsMu.Lock()
+	defer portGroupsMu.Unlock()
+	names := make([]string, 0, len(portGroups))
+	for name := range portGroups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupPortGroup(name string) ([]int, error) {
+	portGroupsMu.Lock()
+	defer portGroupsMu.Unlock()
+	ports, ok := portGroups[name]
+	if !ok {
+		return nil, xerrors.Errorf("%q is an unknown port group", "@"+name)
+	}
+	return ports, nil
+}
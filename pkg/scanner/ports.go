@@ -0,0 +1,90 @@
+package scanner
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	WellKnownPorts = 1024
+	AllPorts       = 65535
+)
+
+// PortRange returns every port from first to last inclusive.
+func PortRange(first, last int) []int {
+	ports := make([]int, 0, last-first+1)
+	for port := first; port <= last; port++ {
+		ports = append(ports, port)
+	}
+	return ports
+}
+
+// ParsePorts parses a comma separated list of ports and port ranges
+// like "22,80,443" or "8000-9000" or any mix of the two, along with
+// port groups like "@web"(see DefinePortGroup).
+// The result is sorted and free of duplicates.
+func ParsePorts(spec string) ([]int, error) {
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		if strings.HasPrefix(part, "@") {
+			group, err := lookupPortGroup(part[1:])
+			if err != nil {
+				return nil, err
+			}
+			for _, port := range group {
+				seen[port] = true
+			}
+			continue
+		}
+
+		first, last := part, part
+		if i := strings.Index(part, "-"); i >= 0 {
+			first, last = part[:i], part[i+1:]
+		}
+
+		from, err := parsePort(first)
+		if err != nil {
+			return nil, err
+		}
+
+		to, err := parsePort(last)
+		if err != nil {
+			return nil, err
+		}
+
+		if from > to {
+			return nil, xerrors.Errorf("%q is an invalid port range", part)
+		}
+
+		for port := from; port <= to; port++ {
+			seen[port] = true
+		}
+	}
+
+	if len(seen) == 0 {
+		return nil, xerrors.Errorf("%q doesn't contain any ports", spec)
+	}
+
+	ports := make([]int, 0, len(seen))
+	for port := range seen {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	return ports, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || port < 1 || port > AllPorts {
+		return 0, xerrors.Errorf("%q is an invalid port", s)
+	}
+	return port, nil
+}
@@ -0,0 +1,150 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// Probe is a service specific check run against open ports, like asking
+// redis for its INFO or reading the handshake mysql greets clients with.
+// Probes register themselves with RegisterProbe so new ones can be
+// added without touching the scan itself.
+type Probe interface {
+	// Name is what the probe is selected by and reported as, e.g. "redis".
+	Name() string
+	// Match reports whether the probe applies to an open port. banner is the first
+	// line the service sent us(see Guess), it's empty when we don't have one.
+	Match(port int, banner string) bool
+	// Run performs the check over a fresh connection to the port. The connection
+	// has the scan's timeout as its deadline and gets closed for the probe.
+	Run(conn net.Conn, host string) (ProbeResult, error)
+}
+
+// ProbeResult is what a probe found out about a service.
+type ProbeResult struct {
+	// Summary is a short human readable line, e.g. "redis 7.0.5 standalone".
+	Summary string
+	// Details are the individual facts the summary is made of.
+	Details map[string]string
+}
+
+// ProbeOutcome is the result of running a single probe against a port.
+type ProbeOutcome struct {
+	Probe  string
+	Result ProbeResult
+	Err    error
+}
+
+var (
+	probesMu sync.Mutex
+	probes   = make(map[string]Probe)
+)
+
+// RegisterProbe makes p available under its name. Like database/sql drivers,
+// probes are meant to register from an init func, so registering the same
+// name twice is a programming error and panics.
+func RegisterProbe(p Probe) {
+	probesMu.Lock()
+	defer probesMu.Unlock()
+	if _, ok := probes[p.Name()]; ok {
+		panic(fmt.Sprintf("scanner: probe %q registered twice", p.Name()))
+	}
+	probes[p.Name()] = p
+}
+
+// ProbeNames returns the names of every registered probe, sorted.
+func ProbeNames() []string {
+	probesMu.Lock()
+	defer probesMu.Unlock()
+	names := make([]string, 0, len(probes))
+	for name := range probes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupProbes returns the registered probes called names, in the same order.
+func LookupProbes(names []string) ([]Probe, error) {
+	probesMu.Lock()
+	defer probesMu.Unlock()
+	var found []Probe
+	for _, name := range names {
+		p, ok := probes[name]
+		if !ok {
+			return nil, xerrors.Errorf("%q is an unknown probe", name)
+		}
+		found = append(found, p)
+	}
+	return found, nil
+}
+
+// RunProbes runs every one of probes that matches port against it, one after
+// the other. Probes that don't match are left out of the outcomes.
+func (s *Scanner) RunProbes(ctx context.Context, port int, banner string, probes []Probe) []ProbeOutcome {
+	var outcomes []ProbeOutcome
+	for _, p := range probes {
+		if ctx.Err() != nil {
+			break
+		}
+
+		if p.Match(port, banner) {
+			outcomes = append(outcomes, s.RunProbe(ctx, port, p))
+		}
+	}
+	return outcomes
+}
+
+// RunProbe runs p against port whether or not it matches it.
+func (s *Scanner) RunProbe(ctx context.Context, port int, p Probe) ProbeOutcome {
+	result, err := s.runProbe(ctx, port, p)
+	return ProbeOutcome{Probe: p.Name(), Result: result, Err: err}
+}
+
+func (s *Scanner) runProbe(ctx context.Context, port int, p Probe) (ProbeResult, error) {
+	conn, err := s.dial(ctx, port)
+	if err != nil {
+		return ProbeResult{}, xerrors.Errorf("failed to connect: %w", err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(s.opts.Timeout)); err != nil {
+		return ProbeResult{}, xerrors.Errorf("failed to set deadline: %w", err)
+	}
+	defer watchConn(ctx, conn)()
+
+	return p.Run(conn, s.hostname())
+}
+
+// Exchange sends payload to port over a fresh connection and returns whatever
+// the service replies with first. An empty payload sends nothing and returns
+// what the service greets us with, if anything.
+func (s *Scanner) Exchange(ctx context.Context, port int, payload []byte) ([]byte, error) {
+	conn, err := s.dial(ctx, port)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to connect: %w", err)
+	}
+	defer conn.Close()
+	defer watchConn(ctx, conn)()
+
+	if len(payload) > 0 {
+		if err := conn.SetWriteDeadline(time.Now().Add(s.opts.Timeout)); err != nil {
+			return nil, xerrors.Errorf("failed to set deadline: %w", err)
+		}
+		if _, err := conn.Write(payload); err != nil {
+			return nil, xerrors.Errorf("failed to send: %w", err)
+		}
+	}
+
+	reply, err := readSome(ctx, conn, s.opts.Timeout)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read reply: %w", err)
+	}
+	return reply, nil
+}
@@ -0,0 +1,125 @@
+package scanner
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket capping how many probes per second a scan sends.
+// Share one between scanners to cap a whole multi-host run, bursts are held to
+// a tenth of a second's worth of probes so an IDS never sees a spike.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a limiter allowing perSecond probes a second.
+// A perSecond that isn't positive returns nil, which doesn't limit anything.
+func NewRateLimiter(perSecond float64) *RateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+
+	burst := perSecond / 10
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{rate: perSecond, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// wait blocks until a probe may be sent or ctx is done.
+func (r *RateLimiter) wait(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+
+	for {
+		delay := r.reserve()
+		if delay == 0 {
+			return nil
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// reserve takes a token if there is one, otherwise it returns how long
+// until the next one is due.
+func (r *RateLimiter) reserve() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.tokens += now.Sub(r.last).Seconds() * r.rate
+	if r.tokens > r.burst {
+		r.tokens = r.burst
+	}
+	r.last = now
+
+	if r.tokens >= 1 {
+		r.tokens--
+		return 0
+	}
+	return time.Duration((1 - r.tokens) / r.rate * float64(time.Second))
+}
+
+// Pacer keeps a scan starting at least a minimum number of ports a second,
+// so a scan of a known size fits a predictable window. A port the workers
+// don't get to in time is started by a worker of its own, past Concurrency,
+// one gap after the last one rather than in a burst to catch up.
+// Share one between scanners to pace a whole multi-host run.
+type Pacer struct {
+	gap time.Duration
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+// NewPacer returns a pacer starting at least perSecond ports a second.
+// A perSecond that isn't positive returns nil, which doesn't pace anything.
+func NewPacer(perSecond float64) *Pacer {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &Pacer{gap: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// deadline returns when the next port has to be started by.
+func (p *Pacer) deadline() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.last.IsZero() {
+		p.last = time.Now()
+	}
+	return p.last.Add(p.gap)
+}
+
+// started records that a port was just started.
+func (p *Pacer) started() {
+	p.mu.Lock()
+	p.last = time.Now()
+	p.mu.Unlock()
+}
+
+// claim reports whether the port due by deadline is still the caller's to
+// start, another scanner sharing p may have started one since, and records
+// it as started if it is.
+func (p *Pacer) claim(deadline time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.last.Add(p.gap).After(deadline) {
+		return false
+	}
+	p.last = time.Now()
+	return true
+}
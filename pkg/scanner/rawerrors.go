@@ -0,0 +1,67 @@
+package scanner
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"syscall"
+
+	"golang.org/x/xerrors"
+)
+
+// dumpRawError logs everything we know about why port wasn't reported open.
+// When the closed/filtered call looks wrong on some platform, the concrete
+// error types and errno are usually what explains it.
+func dumpRawError(port int, err error) {
+	line := fmt.Sprintf("raw-error port=%d type=%T err=%q", port, err, err)
+
+	var opErr *net.OpError
+	if xerrors.As(err, &opErr) {
+		line += fmt.Sprintf(" op=%s net=%s inner-type=%T", opErr.Op, opErr.Net, opErr.Err)
+	}
+
+	var errno syscall.Errno
+	if xerrors.As(err, &errno) {
+		line += fmt.Sprintf(" errno=%d(%s)", int(errno), errno)
+	}
+
+	var netErr net.Error
+	if xerrors.As(err, &netErr) {
+		line += fmt.Sprintf(" timeout=%t", netErr.Timeout())
+	}
+	log.Print(line)
+}
+
+// isRefused reports whether err is the target actively refusing the connection.
+func isRefused(err error) bool {
+	return xerrors.Is(err, syscall.ECONNREFUSED)
+}
+
+// isUnreachable reports whether err is a router telling us there's no
+// route to the host or its network, rather than the host answering itself.
+func isUnreachable(err error) bool {
+	return xerrors.Is(err, syscall.EHOSTUNREACH) || xerrors.Is(err, syscall.ENETUNREACH)
+}
+
+// isPermission reports whether err is this machine refusing to send the probe,
+// usually a local firewall rule or a missing privilege.
+func isPermission(err error) bool {
+	return xerrors.Is(err, syscall.EACCES) || xerrors.Is(err, syscall.EPERM)
+}
+
+// isReset reports whether err is the other end resetting an established connection.
+func isReset(err error) bool {
+	return xerrors.Is(err, syscall.ECONNRESET)
+}
+
+// isExhausted reports whether err is us running out of sockets or local
+// ports rather than anything the target did, which means we're dialing
+// faster than this machine can keep up with.
+func isExhausted(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.EMFILE, syscall.ENFILE, syscall.ENOBUFS, syscall.EADDRNOTAVAIL} {
+		if xerrors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
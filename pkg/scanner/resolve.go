@@ -0,0 +1,155 @@
+package scanner
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+const DefaultResolveTimeout = 5 * time.Second
+
+// ErrResolveTimeout is returned when the resolver doesn't answer in time.
+// We keep it distinct so a slow DNS server can be told apart from a host
+// that simply doesn't exist.
+var ErrResolveTimeout = xerrors.New("resolution timed out")
+
+// Resolve turns host into the addresses that can be dialed over network.
+// IP literals are returned as-is, hostnames are looked up and their A/AAAA
+// records are filtered down to the requested address family.
+//
+// The zone of an ipv6 literal like fe80::1%eth0 doesn't fit in a net.IP,
+// so it's left out of the returned address and ZonedAddr puts it back.
+func Resolve(ctx context.Context, host, network string, timeout time.Duration) ([]net.IP, error) {
+	host = trimBrackets(host)
+	addr, zone := SplitZone(host)
+	if ip := net.ParseIP(addr); ip != nil {
+		if !InFamily(ip, network) {
+			return nil, xerrors.Errorf("%q is not usable over %s", host, network)
+		}
+		if err := checkZone(ip, zone); err != nil {
+			return nil, err
+		}
+		return []net.IP{ip}, nil
+	}
+	if zone != "" {
+		return nil, xerrors.Errorf("%q: only ipv6 addresses take a zone", host)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		if xerrors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, xerrors.Errorf("%q: %w", host, ErrResolveTimeout)
+		}
+		return nil, xerrors.Errorf("failed to lookup %q: %w", host, err)
+	}
+
+	var ips []net.IP
+	for _, addr := range addrs {
+		if InFamily(addr.IP, network) {
+			ips = append(ips, addr.IP)
+		}
+	}
+
+	if len(ips) == 0 {
+		return nil, xerrors.Errorf("%q has no address usable over %s", host, network)
+	}
+	return ips, nil
+}
+
+// SplitZone splits the zone off an ipv6 literal like fe80::1%eth0, which is
+// the interface a link-local address is reached over. The zone is "" when
+// host doesn't have one.
+func SplitZone(host string) (addr, zone string) {
+	host = trimBrackets(host)
+	if i := strings.LastIndexByte(host, '%'); i >= 0 {
+		return host[:i], host[i+1:]
+	}
+	return host, ""
+}
+
+// ZonedAddr returns ip the way New takes it, along with the zone host was
+// given with if it was a zoned ipv6 literal.
+func ZonedAddr(host string, ip net.IP) string {
+	if _, zone := SplitZone(host); zone != "" && ip.To4() == nil {
+		return ip.String() + "%" + zone
+	}
+	return ip.String()
+}
+
+// checkZone makes sure zone, if there is one, names an interface of ours that ip can be reached over.
+func checkZone(ip net.IP, zone string) error {
+	if zone == "" {
+		return nil
+	}
+	if ip.To4() != nil {
+		return xerrors.Errorf("%s%%%s: only ipv6 addresses take a zone", ip, zone)
+	}
+
+	// Zones are interface names, or their indexes like on windows.
+	var err error
+	if index, convErr := strconv.Atoi(zone); convErr == nil {
+		_, err = net.InterfaceByIndex(index)
+	} else {
+		_, err = net.InterfaceByName(zone)
+	}
+	if err != nil {
+		return xerrors.Errorf("%s%%%s: %q is an unknown interface", ip, zone, zone)
+	}
+	return nil
+}
+
+// PickAddresses narrows the resolved addresses of host down to the ones the user asked for.
+// choice is either "first", "dual", "all" or one of the resolved addresses. dual picks the
+// first address of each family, a dual-stack host can serve other ports over each of them.
+func PickAddresses(host string, ips []net.IP, choice string) ([]net.IP, error) {
+	switch choice {
+	case "", "first":
+		return ips[:1], nil
+	case "dual":
+		var v4, v6 net.IP
+		for _, ip := range ips {
+			if ip.To4() != nil && v4 == nil {
+				v4 = ip
+			} else if ip.To4() == nil && v6 == nil {
+				v6 = ip
+			}
+		}
+		var picked []net.IP
+		for _, ip := range ips {
+			if ip.Equal(v4) || ip.Equal(v6) {
+				picked = append(picked, ip)
+			}
+		}
+		return picked, nil
+	case "all":
+		return ips, nil
+	}
+
+	picked := net.ParseIP(choice)
+	if picked == nil {
+		return nil, xerrors.Errorf("%q is neither first, dual, all or an ip address", choice)
+	}
+
+	if !ContainsIP(ips, picked) {
+		return nil, xerrors.Errorf("%q doesn't resolve to %s(resolved to %v)", host, picked, ips)
+	}
+	return []net.IP{picked}, nil
+}
+
+// HasRoute reports whether there's a route to ip. Connecting a udp socket
+// picks a route without sending anything.
+func HasRoute(ip net.IP) bool {
+	conn, err := net.Dial("udp", net.JoinHostPort(ip.String(), "9"))
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
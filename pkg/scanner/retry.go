@@ -0,0 +1,52 @@
+package scanner
+
+import (
+	"math"
+	"math/rand"
+	"net"
+	"time"
+)
+
+const (
+	DefaultRetryDelay   = 250 * time.Millisecond
+	DefaultRetryJitter  = 0.5
+	DefaultRetryBackoff = 2
+)
+
+// RetryPolicy decides how many times and how far apart timed out dials are retried.
+// The zero value doesn't retry.
+type RetryPolicy struct {
+	Retries int
+	// Delay is how long to wait before the first retry.
+	Delay time.Duration
+	// Backoff multiplies the delay after every retry so a rate limited target
+	// gets more and more room to recover. Anything below 1 keeps it constant.
+	Backoff float64
+	// Jitter is the fraction of Delay that each wait is randomly spread by.
+	// When a whole batch of ports times out together, retrying them all after
+	// exactly the same delay would just recreate the burst that timed out.
+	Jitter float64
+}
+
+// wait returns how long to wait before retry number retry, counting from 0.
+// The delay is randomized within [delay-delay*Jitter, delay+delay*Jitter).
+func (p RetryPolicy) wait(retry int) time.Duration {
+	delay := p.Delay
+	if p.Backoff > 1 {
+		delay = time.Duration(float64(delay) * math.Pow(p.Backoff, float64(retry)))
+	}
+
+	spread := int64(float64(delay) * p.Jitter)
+	if spread <= 0 {
+		return delay
+	}
+	// math/rand's top-level functions are safe for concurrent use.
+	return delay - time.Duration(spread) + time.Duration(rand.Int63n(2*spread))
+}
+
+// shouldRetry reports whether a failed dial is worth another attempt.
+// A refused connection is a definitive answer, a timeout might just be a dropped packet.
+func shouldRetry(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
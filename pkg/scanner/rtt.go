@@ -0,0 +1,96 @@
+package scanner
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// AutoTimeoutFactor is how many round trips an Options.AutoTimeout scan
+	// waits on a probe, enough for a path that gets a little busier mid scan.
+	AutoTimeoutFactor = 4
+	// MinAutoTimeout is the shortest timeout an Options.AutoTimeout scan
+	// derives, hosts on the same lan answer faster than a busy one can be relied on to.
+	MinAutoTimeout = 100 * time.Millisecond
+	// rttProbes is how many ports the round trip is measured on.
+	rttProbes = 4
+)
+
+// rttPorts are dialed to measure the round trip of udp and sctp scans, whose
+// own probes don't get an answer we can time. One of them is usually open
+// and the rest get refused, either way the answer is all we need.
+var rttPorts = []int{80, 443, 22, 25}
+
+// autoTimeout measures the round trip time to the host and returns the timeout
+// probes should get, AutoTimeoutFactor round trips between MinAutoTimeout and
+// ceiling. Without an answer to any of the measurements it's ceiling itself.
+func (s *Scanner) autoTimeout(ctx context.Context, ceiling time.Duration) (time.Duration, time.Duration) {
+	rtt := s.measureRTT(ctx, ceiling)
+	if rtt == 0 {
+		return 0, ceiling
+	}
+
+	timeout := AutoTimeoutFactor * rtt
+	switch {
+	case timeout < MinAutoTimeout:
+		timeout = MinAutoTimeout
+	case timeout > ceiling:
+		timeout = ceiling
+	}
+	return rtt, timeout
+}
+
+// measureRTT connects to a few ports at once and returns how long the slowest
+// answer took, a connect being refused is as good an answer as one accepted.
+// Tcp scans measure on the first of their own ports, since those are the ones
+// the timeout has to suit. It's 0 when nothing answered within timeout.
+func (s *Scanner) measureRTT(ctx context.Context, timeout time.Duration) time.Duration {
+	ports, network := rttPorts, "tcp4"
+	if strings.HasPrefix(s.network, "tcp") {
+		ports, network = s.opts.Ports, s.network
+	} else if ip := net.ParseIP(s.hostname()); ip.To4() == nil {
+		network = "tcp6"
+	}
+	if len(ports) > rttProbes {
+		ports = ports[:rttProbes]
+	}
+
+	// Udp scans' dialers bind udp source addresses, these need tcp ones.
+	dialers := s.opts.Sources.dialers(network, timeout, probeControl(s.opts.Mark))
+	var (
+		mu      sync.Mutex
+		slowest time.Duration
+		wg      sync.WaitGroup
+	)
+	for _, port := range ports {
+		if err := s.opts.Rate.wait(ctx); err != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(port int) {
+			defer wg.Done()
+			d := s.opts.Sources.pick(dialers)
+			start := time.Now()
+			conn, err := d.DialContext(ctx, network, net.JoinHostPort(s.host, strconv.Itoa(port)))
+			rtt := time.Since(start)
+			if err == nil {
+				conn.Close()
+			} else if dialOutcome(err) != "refused" {
+				return
+			}
+
+			mu.Lock()
+			if rtt > slowest {
+				slowest = rtt
+			}
+			mu.Unlock()
+		}(port)
+	}
+	wg.Wait()
+	return slowest
+}
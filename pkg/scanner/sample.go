@@ -0,0 +1,39 @@
+package scanner
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// SamplePorts returns n randomly chosen ports from ports.
+// The selection only depends on seed, so reusing a seed reproduces the sample.
+func SamplePorts(ports []int, n int, seed int64) []int {
+	if n <= 0 || n >= len(ports) {
+		return ports
+	}
+
+	sample := make([]int, len(ports))
+	copy(sample, ports)
+
+	rng := rand.New(rand.NewSource(seed))
+	rng.Shuffle(len(sample), func(i, j int) {
+		sample[i], sample[j] = sample[j], sample[i]
+	})
+
+	sample = sample[:n]
+	sort.Ints(sample)
+	return sample
+}
+
+// ShufflePorts returns ports in a random order, reusing a seed reproduces the order.
+// Probes arriving in strictly ascending order are the first thing an IDS looks for.
+func ShufflePorts(ports []int, seed int64) []int {
+	shuffled := make([]int, len(ports))
+	copy(shuffled, ports)
+
+	rng := rand.New(rand.NewSource(seed))
+	rng.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	return shuffled
+}
@@ -0,0 +1,903 @@
+// Package scanner implements the port scanner behind the port-scanner CLI
+// so other Go programs can embed it without shelling out.
+package scanner
+
+import (
+	"context"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	// DefaultTimeout is how long a single dial may take when Options.Timeout isn't set.
+	DefaultTimeout = 3 * time.Second
+	// DefaultConcurrency is how many ports are scanned at once when Options.Concurrency isn't set.
+	DefaultConcurrency = 512
+)
+
+// Options holds everything that shapes a scan besides the host itself.
+// The zero value scans the well-known tcp ports with the default timeout.
+type Options struct {
+	// Network is "tcp" or "udp", optionally pinned to an address family
+	// with a "4" or "6" suffix like the networks accepted by net.Dial.
+	// "sctp" or "sctp4" runs an SCTP INIT scan, which like a SYN scan
+	// only supports ipv4 targets on linux and needs raw sockets.
+	Network string
+	// Ports to scan, defaults to the well-known ports.
+	Ports []int
+	// Timeout for each dial, defaults to DefaultTimeout.
+	Timeout time.Duration
+	// AutoTimeout measures the round trip time to the host before every scan
+	// and times probes out after AutoTimeoutFactor of them instead, no sooner
+	// than MinAutoTimeout and no later than Timeout. It costs a few connects
+	// on top of the scan, and a host that answers none of them within
+	// Timeout is scanned with Timeout. Proxies, ssh bastions and zombies sit
+	// between us and the host, so it doesn't apply to them.
+	AutoTimeout bool
+	// HostTimeout gives up on the host once its scan has taken this long, so one
+	// that drops every probe doesn't hold up the rest of a sweep. The ports it
+	// didn't get to end up in Result.Unscanned and Result.Incomplete is set,
+	// Scan itself doesn't fail over it. Zero never gives up.
+	HostTimeout time.Duration
+	// Concurrency caps how many ports are scanned at once, defaults to DefaultConcurrency.
+	// Every worker holds a socket, so keep it well below the file descriptor limit.
+	Concurrency int
+	// ConfirmLevels maps ports to the check required before they're reported open.
+	// Ports without an entry only need a successful connect.
+	ConfirmLevels map[int]ConfirmLevel
+	Retry         RetryPolicy
+	// Sources is the pool of local addresses to dial from, nil lets the kernel pick.
+	Sources *SourcePool
+	// Mark sets the fwmark(SO_MARK) of every socket the scan sends probes over,
+	// so policy routing and firewall rules can single them out. It's linux
+	// only and needs CAP_NET_ADMIN.
+	Mark int
+	// Budget caps the connection attempts made, share it between
+	// scanners to cap a whole multi-host run.
+	Budget *Budget
+	// Rate caps how many probes go out per second when set,
+	// share it between scanners the same way as Budget.
+	Rate *RateLimiter
+	// MinRate starts at least so many ports a second when set, by starting
+	// extra workers past Concurrency whenever the ones there are fall behind,
+	// share it between scanners the same way as Budget. The extra workers can
+	// hold up to a second's worth of ports times Timeout in sockets between
+	// them. It only applies to connect and udp scans, raw scans don't wait on dials.
+	MinRate *Pacer
+	// Jitter spaces probes out by random gaps when set, on top of Rate,
+	// share it between scanners the same way.
+	Jitter *Jitter
+	// InFlight caps how many probes are outstanding at once on top of
+	// Concurrency, share it between scanners scanning hosts in parallel.
+	InFlight *InFlight
+	// Audit records every connection attempt when set.
+	Audit *AuditLog
+	// Found receives every port as soon as it turns out to be reachable,
+	// which beats waiting on Scan to return for long scans. Sends block,
+	// so keep draining it until Scan returns. It's never closed for you.
+	Found chan<- PortResult
+	// SYN scans with half-open raw SYN packets instead of full connects.
+	// It only supports ipv4 tcp targets on linux and needs raw socket privileges,
+	// ConfirmLevels don't apply since no connection is ever established.
+	// Like the kernel does for a connect, a SYN still unanswered half way
+	// through Timeout is sent again as part of the same try.
+	SYN bool
+	// FlagScan scans with raw FIN, NULL, Xmas or ACK probes instead(see FlagScan).
+	// It has the same restrictions as SYN and the two are mutually exclusive.
+	FlagScan FlagScan
+	// Decoys sends a spoofed copy of every raw probe from each of them(see Decoys).
+	// It only applies to SYN, flag and SCTP scans.
+	Decoys Decoys
+	// IPHeader sets the ttl, type of service and fragmentation of raw probes,
+	// with the same restrictions as Decoys.
+	IPHeader IPHeader
+	// Zombie runs an idle scan through it instead(see Zombie), which only reports
+	// ports as open or closed|filtered. It has the same restrictions as SYN,
+	// takes neither decoys, ip header options, an audit log nor an Engine and
+	// Concurrency caps how many ports are probed through the zombie at once.
+	Zombie *Zombie
+	// Engine sends the SYNs of a SYN scan through a stateless engine shared by
+	// every scanner of a run instead of a raw socket of their own(see Engine).
+	// Concurrency doesn't apply, Rate is what paces it. It takes neither decoys,
+	// ip header options nor an audit log, and since nothing is kept per probe
+	// the latency of open ports isn't measured.
+	Engine *Engine
+	// Proxy routes every connect through a SOCKS5 proxy when set, it only
+	// supports tcp connect scans since the proxy makes the connections for us.
+	Proxy *Proxy
+	// SSHJump tunnels every connect through an ssh bastion when set,
+	// with the same restrictions as Proxy.
+	SSHJump *SSHJump
+	// RawErrors logs the underlying error for every port that isn't open.
+	RawErrors bool
+	// AllStates keeps the tcp ports that aren't open in the result as closed
+	// or filtered, instead of only counting them in Failures.
+	AllStates bool
+	// Adaptive starts out scanning a handful of ports at once and scales up to
+	// Concurrency while the dials keep succeeding or getting refused, backing
+	// off when more of them time out or we run out of sockets. It only applies
+	// to connect and udp scans, raw scans don't wait on dials.
+	Adaptive bool
+	// Verify probes every port found open a second time once the scan is
+	// done, and drops the ones that get refused, time out or get reset right
+	// after accepting, which is what connection tracking middleboxes with
+	// nothing behind them do. Ports sent on Found or to OnPortOpen before
+	// then can still get dropped. It only applies to tcp connect scans.
+	Verify bool
+	// VerifySample is how many of the refused ports Verify probes again too,
+	// to catch a first round that got refused by something other than the host.
+	VerifySample int
+	// OnPortOpen is called with every port found open as soon as it is, from
+	// whichever goroutine found it, so it has to be safe to call concurrently
+	// and shouldn't block for long since the port's worker waits on it.
+	OnPortOpen func(PortResult)
+	// OnHostDone is called with what Scan is about to return once it's done.
+	OnHostDone func(Result, error)
+	// Sink gets the result of every Scan written to it once it's done, see
+	// OutputSink. A failed write is what Scan returns unless it failed itself.
+	Sink OutputSink
+}
+
+// PortResult is what we learned about a single port.
+type PortResult struct {
+	Port  int
+	State State
+	// Latency is how long the successful connect took, it's only set for open ports.
+	Latency time.Duration
+	// Attempts is how many tries it took to get an answer. Anything above 1
+	// means the first tries got dropped, which can point at rate limiting.
+	Attempts int
+	// Failure is how the last probe of a port that isn't open failed, one of
+	// the outcomes counted in Result.Failures. A filtered port that timed
+	// out is firewalled, one that's unreachable has no route to it at all.
+	Failure string
+}
+
+// Result is the outcome of a scan. Closed and filtered ports are left out
+// unless Options.AllStates is set, and the rest are sorted by port number.
+type Result struct {
+	Host     string
+	Network  string
+	Start    time.Time
+	Duration time.Duration
+	Ports    []PortResult
+	// Unscanned holds the ports we never got to because the budget or Options.HostTimeout ran out.
+	Unscanned []int
+	// Failures counts the ports that weren't reported by how their last probe
+	// failed: "timeout", "refused", "unreachable" when a router had no route
+	// to the host, "permission" when this machine wouldn't send it, or "error"
+	// for anything else.
+	Failures map[string]int
+	// Probes counts the probes sent, Retries the ones among them that went
+	// out again to a port the first one got no answer from.
+	Probes  int
+	Retries int
+	// RTT is the round trip time Options.AutoTimeout measured, Timeout what
+	// the probes timed out after, which is Options.Timeout without it.
+	RTT     time.Duration
+	Timeout time.Duration
+	// Incomplete means Options.HostTimeout ran out before every port was scanned.
+	Incomplete bool
+	// Verify is what the second round of an Options.Verify scan made of the first.
+	Verify *VerifyResult
+}
+
+// Open returns the open ports of r.
+func (r Result) Open() []PortResult {
+	return r.InState(StateOpen)
+}
+
+// InState returns the ports of r that are in state.
+func (r Result) InState(state State) []PortResult {
+	var ports []PortResult
+	for _, p := range r.Ports {
+		if p.State == state {
+			ports = append(ports, p)
+		}
+	}
+	return ports
+}
+
+// Scanner scans the ports of a single host. It is created with New and
+// is good for one run of Scan or Stream.
+type Scanner struct {
+	// scanned counts the ports we're done with, it's only touched through
+	// sync/atomic and comes first to keep it 64-bit aligned on 32-bit platforms.
+	scanned int64
+	// we're going to wan't to scan each port concurrently
+	// so let's use a mutex lock to help us make sure we
+	// do this in a thread-safe way.
+	mu        sync.Mutex
+	opts      Options
+	host      string
+	network   string
+	ports     []PortResult
+	unscanned []int
+	failures  map[string]int
+	probes    int
+	retries   int
+	// refused holds the refused ports an Options.Verify scan samples from.
+	refused []int
+	// maxTimeout is Options.Timeout as given, which AutoTimeout replaces for
+	// every scan with what it derived from the round trip time rtt.
+	maxTimeout time.Duration
+	rtt        time.Duration
+	// done holds the ports we're finished with for good, see Snapshot.
+	done map[int]bool
+	// adaptive is what scales the workers of an Options.Adaptive connect scan.
+	adaptive *adaptiveLimit
+	// engineTry is the round of SYNs an Options.Engine scan is on.
+	engineTry int32
+	// dialers are shared by every probe, so the sockets they open are only
+	// tuned once instead of building a dialer per dial.
+	dialers []*net.Dialer
+	// stream receives the ports of a Stream until streamDone is closed.
+	stream     chan<- PortResult
+	streamDone <-chan struct{}
+}
+
+// New returns a scanner for host, which has to be an ip address. Link-local
+// ipv6 addresses can carry the zone of the interface they're on, like fe80::1%eth0.
+func New(host string, opts Options) (*Scanner, error) {
+	addr, zone := SplitZone(host)
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, xerrors.Errorf("%q is an invalid ip address", host)
+	}
+	if err := checkZone(ip, zone); err != nil {
+		return nil, err
+	}
+
+	if opts.Network == "" {
+		opts.Network = "tcp"
+	}
+
+	if !InFamily(ip, opts.Network) {
+		return nil, xerrors.Errorf("%q has no address usable over %s", host, opts.Network)
+	}
+
+	if strings.HasPrefix(opts.Network, "sctp") {
+		if ip.To4() == nil || strings.HasSuffix(opts.Network, "6") {
+			return nil, xerrors.Errorf("SCTP scans only support ipv4 targets, got %s over %s", host, opts.Network)
+		}
+
+		if err := CheckSCTP(); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.SYN && opts.FlagScan != "" {
+		return nil, xerrors.Errorf("can't run a SYN and a %s scan at once", opts.FlagScan.name())
+	}
+
+	if opts.SYN || opts.FlagScan != "" {
+		if ip.To4() == nil || !strings.HasPrefix(opts.Network, "tcp") || strings.HasSuffix(opts.Network, "6") {
+			return nil, xerrors.Errorf("%s scans only support ipv4 tcp targets, got %s over %s", opts.FlagScan.name(), host, opts.Network)
+		}
+
+		if err := CheckSYN(); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Zombie != nil {
+		switch {
+		case opts.SYN || opts.FlagScan != "":
+			return nil, xerrors.Errorf("can't run an idle and a %s scan at once", opts.FlagScan.name())
+		case ip.To4() == nil || !strings.HasPrefix(opts.Network, "tcp") || strings.HasSuffix(opts.Network, "6"):
+			return nil, xerrors.Errorf("idle scans only support ipv4 tcp targets, got %s over %s", host, opts.Network)
+		case opts.Zombie.IP.To4() == nil:
+			return nil, xerrors.Errorf("zombie %s isn't an ipv4 address", opts.Zombie.IP)
+		case opts.Zombie.IP.Equal(ip):
+			return nil, xerrors.New("the zombie can't be the target itself")
+		case len(opts.Decoys) > 0 || opts.IPHeader != (IPHeader{}) || opts.Audit != nil || opts.Engine != nil:
+			return nil, xerrors.New("decoys, ip header options, audit logs and the stateless engine don't apply to idle scans")
+		}
+		if err := CheckSYN(); err != nil {
+			return nil, err
+		}
+	}
+
+	raw := opts.SYN || opts.FlagScan != "" || strings.HasPrefix(opts.Network, "sctp")
+	if len(opts.Decoys) > 0 && !raw {
+		return nil, xerrors.New("decoys only apply to raw SYN, flag and SCTP scans")
+	}
+
+	if opts.IPHeader != (IPHeader{}) && !raw {
+		return nil, xerrors.New("ip header options only apply to raw SYN, flag and SCTP scans")
+	}
+	if err := opts.IPHeader.validate(); err != nil {
+		return nil, err
+	}
+
+	if opts.Engine != nil {
+		if !opts.SYN {
+			return nil, xerrors.New("the stateless engine only runs SYN scans")
+		}
+		if len(opts.Decoys) > 0 || opts.IPHeader != (IPHeader{}) || opts.Audit != nil {
+			return nil, xerrors.New("decoys, ip header options and audit logs don't apply to stateless scans")
+		}
+	}
+
+	if opts.Mark < 0 || int64(opts.Mark) > math.MaxUint32 {
+		return nil, xerrors.Errorf("%d is an invalid fwmark", opts.Mark)
+	}
+	if opts.Mark != 0 {
+		if err := checkMark(opts.Mark); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Proxy != nil && opts.SSHJump != nil {
+		return nil, xerrors.New("can't route through both a proxy and an ssh bastion")
+	}
+
+	if (opts.Proxy != nil || opts.SSHJump != nil) && (opts.SYN || opts.FlagScan != "" || opts.Zombie != nil || !strings.HasPrefix(opts.Network, "tcp")) {
+		return nil, xerrors.Errorf("proxies and ssh bastions only support tcp connect scans, got %s", opts.Network)
+	}
+
+	if opts.Adaptive && (opts.SYN || opts.FlagScan != "" || opts.Zombie != nil || strings.HasPrefix(opts.Network, "sctp")) {
+		return nil, xerrors.New("adaptive concurrency only applies to connect and udp scans")
+	}
+
+	if opts.AutoTimeout && (opts.Proxy != nil || opts.SSHJump != nil || opts.Zombie != nil) {
+		return nil, xerrors.New("the round trip to the host can't be measured through a proxy, ssh bastion or zombie")
+	}
+
+	if opts.Verify && (opts.SYN || opts.FlagScan != "" || opts.Zombie != nil || !strings.HasPrefix(opts.Network, "tcp")) {
+		return nil, xerrors.New("verifying open ports only applies to tcp connect scans")
+	}
+
+	if opts.MinRate != nil && (opts.SYN || opts.FlagScan != "" || opts.Zombie != nil || strings.HasPrefix(opts.Network, "sctp")) {
+		return nil, xerrors.New("a minimum rate only applies to connect and udp scans")
+	}
+
+	if opts.Ports == nil {
+		opts.Ports = PortRange(1, WellKnownPorts)
+	}
+
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultConcurrency
+	}
+
+	network := dialNetwork(ip, opts.Network)
+	return &Scanner{
+		opts:       opts,
+		maxTimeout: opts.Timeout,
+		host:       host,
+		network:    network,
+		dialers:    opts.Sources.dialers(network, opts.Timeout, probeControl(opts.Mark)),
+	}, nil
+}
+
+// InFamily reports whether ip can be dialed over network.
+func InFamily(ip net.IP, network string) bool {
+	switch {
+	case strings.HasSuffix(network, "4"):
+		return ip.To4() != nil
+	case strings.HasSuffix(network, "6"):
+		return ip.To4() == nil
+	}
+	return true
+}
+
+// dialNetwork pins a plain "tcp" or "udp" network down to the family of ip.
+// That way every target is dialed over its own family, no matter
+// what mix of ipv4 and ipv6 addresses we were handed.
+func dialNetwork(ip net.IP, network string) string {
+	if network != "tcp" && network != "udp" {
+		return network
+	}
+
+	if ip.To4() != nil {
+		return network + "4"
+	}
+	return network + "6"
+}
+
+// hostname is the host without the zone of a link-local address, for Host
+// headers and the like where the zone only matters to us.
+func (s *Scanner) hostname() string {
+	addr, _ := SplitZone(s.host)
+	return addr
+}
+
+func (s *Scanner) add(p PortResult) {
+	// Since we'll be appending to the same slice from different goroutines,
+	// lets make sure we're locking and unlocking between writes.
+	s.mu.Lock()
+	s.ports = append(s.ports, p)
+	s.mu.Unlock()
+
+	if s.opts.Found != nil {
+		s.opts.Found <- p
+	}
+	if s.opts.OnPortOpen != nil && p.State == StateOpen {
+		s.opts.OnPortOpen(p)
+	}
+	s.emit(p)
+}
+
+// emit sends p to the consumer of a Stream, if there is one.
+func (s *Scanner) emit(p PortResult) {
+	s.mu.Lock()
+	stream, done := s.stream, s.streamDone
+	s.mu.Unlock()
+	if stream == nil {
+		return
+	}
+
+	select {
+	case stream <- p:
+	case <-done:
+	}
+}
+
+// sent counts a probe going out, try is how many went out to its port before it.
+func (s *Scanner) sent(try int) {
+	s.mu.Lock()
+	s.probes++
+	if try > 0 {
+		s.retries++
+	}
+	s.mu.Unlock()
+}
+
+func (s *Scanner) skip(port int) {
+	s.mu.Lock()
+	s.unscanned = append(s.unscanned, port)
+	s.mu.Unlock()
+}
+
+// finish marks port as done, so it's left out of the remaining ports of a Snapshot.
+func (s *Scanner) finish(port int) {
+	s.mu.Lock()
+	s.done[port] = true
+	s.mu.Unlock()
+}
+
+func (s *Scanner) fail(outcome string) {
+	s.mu.Lock()
+	s.failures[outcome]++
+	s.mu.Unlock()
+}
+
+// reject counts a port that isn't open by how its last probe failed,
+// and keeps it as closed or filtered when Options.AllStates is set.
+// Unlike add it isn't sent on Found, which is only for reachable ports.
+func (s *Scanner) reject(p PortResult, outcome string) {
+	s.fail(outcome)
+	if s.opts.Verify && outcome == "refused" {
+		s.mu.Lock()
+		s.refused = append(s.refused, p.Port)
+		s.mu.Unlock()
+	}
+	if !s.opts.AllStates {
+		return
+	}
+
+	p.State, p.Failure = stateFor(outcome), outcome
+	s.mu.Lock()
+	s.ports = append(s.ports, p)
+	s.mu.Unlock()
+	s.emit(p)
+}
+
+// Scan scans every port of the host. If ctx is cancelled part way through,
+// whatever was found so far is returned along with the context's error.
+func (s *Scanner) Scan(ctx context.Context) (Result, error) {
+	s.mu.Lock()
+	s.ports, s.unscanned, s.refused = nil, nil, nil
+	s.failures = make(map[string]int)
+	s.probes, s.retries = 0, 0
+	s.done = make(map[int]bool)
+	s.mu.Unlock()
+	atomic.StoreInt64(&s.scanned, 0)
+
+	start := time.Now()
+	// The host timeout covers the whole scan, measuring the round trip included.
+	hostCtx := ctx
+	if s.opts.HostTimeout > 0 {
+		var cancel context.CancelFunc
+		hostCtx, cancel = context.WithTimeout(ctx, s.opts.HostTimeout)
+		defer cancel()
+	}
+
+	// The path may have changed since the last scan, so it's measured again every time.
+	if s.opts.AutoTimeout {
+		s.rtt, s.opts.Timeout = s.autoTimeout(hostCtx, s.maxTimeout)
+		s.dialers = s.opts.Sources.dialers(s.network, s.opts.Timeout, probeControl(s.opts.Mark))
+	}
+
+	var err error
+	switch {
+	case s.opts.Engine != nil:
+		err = s.statelessScan(hostCtx)
+	case s.opts.Zombie != nil:
+		err = s.idleScan(hostCtx)
+	case s.opts.SYN || s.opts.FlagScan != "":
+		err = s.synScan(hostCtx)
+	case strings.HasPrefix(s.network, "sctp"):
+		err = s.sctpScan(hostCtx)
+	default:
+		s.connectScan(hostCtx)
+	}
+
+	// Verifying a scan cut short would only hold up reporting what it found.
+	var verified *VerifyResult
+	if s.opts.Verify && err == nil && hostCtx.Err() == nil {
+		verified = s.verify(hostCtx)
+	}
+
+	// Running out of time on the host is its own outcome, only ctx
+	// being done is an error.
+	timedOut := hostCtx.Err() != nil && ctx.Err() == nil
+	if timedOut && xerrors.Is(err, context.DeadlineExceeded) {
+		err = nil
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+
+	s.mu.Lock()
+	// A scan that timed out as its last port finished is complete all the same.
+	var incomplete bool
+	if timedOut {
+		skipped := make(map[int]bool, len(s.unscanned))
+		for _, port := range s.unscanned {
+			skipped[port] = true
+		}
+		for _, port := range s.opts.Ports {
+			if !s.done[port] && !skipped[port] {
+				s.unscanned = append(s.unscanned, port)
+				incomplete = true
+			}
+		}
+	}
+	// Ports get added in whatever order the dials finish,
+	// sorting them keeps results diffable between runs.
+	sort.Slice(s.ports, func(i, j int) bool { return s.ports[i].Port < s.ports[j].Port })
+	sort.Ints(s.unscanned)
+	res := Result{
+		Host:       s.host,
+		Network:    s.network,
+		Start:      start,
+		Duration:   time.Since(start),
+		Ports:      s.ports,
+		Unscanned:  s.unscanned,
+		Failures:   s.failures,
+		Probes:     s.probes,
+		Retries:    s.retries,
+		RTT:        s.rtt,
+		Timeout:    s.opts.Timeout,
+		Incomplete: incomplete,
+		Verify:     verified,
+	}
+	s.mu.Unlock()
+
+	if s.opts.Sink != nil {
+		if werr := s.opts.Sink.Write(res); werr != nil && err == nil {
+			err = xerrors.Errorf("failed to write result: %w", werr)
+		}
+	}
+
+	if s.opts.OnHostDone != nil {
+		s.opts.OnHostDone(res, err)
+	}
+	return res, err
+}
+
+// Stream scans every port of the host like Scan, but sends every port that
+// makes it into the result on the first channel as soon as it's scanned
+// instead of making us wait on the whole scan, which matters for sweeps that
+// take hours. Ports come in the order they finish rather than sorted. Both
+// channels are closed once the scan is done, the second after receiving the
+// error Scan would have returned if there was one. Cancel ctx to stop reading
+// early, otherwise keep draining the ports or the scan blocks on them.
+func (s *Scanner) Stream(ctx context.Context) (<-chan PortResult, <-chan error) {
+	ports := make(chan PortResult)
+	errc := make(chan error, 1)
+
+	s.mu.Lock()
+	s.stream, s.streamDone = ports, ctx.Done()
+	s.mu.Unlock()
+
+	go func() {
+		defer close(errc)
+		_, err := s.Scan(ctx)
+
+		s.mu.Lock()
+		s.stream, s.streamDone = nil, nil
+		s.mu.Unlock()
+		close(ports)
+
+		if err != nil {
+			errc <- err
+		}
+	}()
+	return ports, errc
+}
+
+// Progress reports how many of the ports of the current scan are done.
+// It's safe to call from another goroutine while Scan is running.
+func (s *Scanner) Progress() (scanned, total int) {
+	return int(atomic.LoadInt64(&s.scanned)), len(s.opts.Ports)
+}
+
+// Snapshot returns the ports found reachable so far along with the ports that
+// are still to be scanned, in the order they'd be scanned. Ports cut short by
+// cancelling the scan or skipped for lack of budget count as still to be scanned,
+// so a later scan of just the remaining ports picks up where this one left off.
+// It's safe to call from another goroutine while Scan is running.
+func (s *Scanner) Snapshot() (found []PortResult, remaining []int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found = append(found, s.ports...)
+	sort.Slice(found, func(i, j int) bool { return found[i].Port < found[j].Port })
+
+	skipped := make(map[int]bool, len(s.unscanned))
+	for _, port := range s.unscanned {
+		skipped[port] = true
+	}
+
+	remaining = []int{}
+	for _, port := range s.opts.Ports {
+		if !s.done[port] || skipped[port] {
+			remaining = append(remaining, port)
+		}
+	}
+	return found, remaining
+}
+
+// connectScan scans every port with a full connect.
+func (s *Scanner) connectScan(ctx context.Context) {
+	// Lets use a wait group so we can wait for all of our workers, and any
+	// extra ones a MinRate starts, to exit before returning our result.
+	var wg sync.WaitGroup
+
+	// Spawning a goroutine per port would hold a socket for every one of them,
+	// so lets hand the ports out to a fixed number of workers instead.
+	ports := make(chan int)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(ports)
+		for _, port := range s.opts.Ports {
+			if !s.handOut(ctx, ports, port, &wg) {
+				return
+			}
+		}
+	}()
+
+	workers := s.opts.Concurrency
+	if workers > len(s.opts.Ports) {
+		workers = len(s.opts.Ports)
+	}
+
+	// Adaptive scans start every worker up front and let the limit decide
+	// how many of them get to dial at once.
+	s.adaptive = nil
+	if s.opts.Adaptive {
+		s.adaptive = newAdaptiveLimit(workers)
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				s.adaptive.wake()
+			case <-stop:
+			}
+		}()
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range ports {
+				if s.adaptive.acquire(ctx) != nil {
+					continue
+				}
+				if s.opts.InFlight.acquire(ctx) != nil {
+					s.adaptive.release()
+					continue
+				}
+				s.scanPort(ctx, p)
+				s.opts.InFlight.release()
+				s.adaptive.release()
+				s.portDone(ctx, p)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// handOut passes port on to the workers and reports whether the scan goes on.
+// With a MinRate a port the workers don't take before it's due gets a worker
+// of its own, which the limits on how many ports are scanned at once don't apply to.
+func (s *Scanner) handOut(ctx context.Context, ports chan<- int, port int, wg *sync.WaitGroup) bool {
+	if s.opts.MinRate == nil {
+		select {
+		case ports <- port:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for {
+		deadline := s.opts.MinRate.deadline()
+		timer := time.NewTimer(time.Until(deadline))
+		select {
+		case ports <- port:
+			timer.Stop()
+			s.opts.MinRate.started()
+			return true
+		case <-timer.C:
+			if !s.opts.MinRate.claim(deadline) {
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.scanPort(ctx, port)
+				s.portDone(ctx, port)
+			}()
+			return true
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		}
+	}
+}
+
+// portDone records that a worker is done with port.
+func (s *Scanner) portDone(ctx context.Context, port int) {
+	atomic.AddInt64(&s.scanned, 1)
+	// A cancelled dial tells us nothing, so the port is still to be scanned.
+	if ctx.Err() == nil {
+		s.finish(port)
+	}
+}
+
+// scanPort scans a single port and records it if it turned out to be reachable.
+func (s *Scanner) scanPort(ctx context.Context, p int) {
+	if strings.HasPrefix(s.network, "udp") {
+		if result := s.probeUDP(ctx, p); result.State != StateClosed {
+			s.add(result)
+		}
+		return
+	}
+
+	if result, open := s.isOpen(ctx, p); open {
+		s.add(result)
+	}
+}
+
+func (s *Scanner) dial(ctx context.Context, port int) (net.Conn, error) {
+	// Once the scan is cancelled there's no point in spending budget on it.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if !s.opts.Budget.take() {
+		return nil, ErrBudgetExhausted
+	}
+
+	if err := s.opts.Rate.wait(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.opts.Jitter.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	addr := net.JoinHostPort(s.host, strconv.Itoa(port))
+	d := s.opts.Sources.pick(s.dialers)
+	start := time.Now()
+	var conn net.Conn
+	var err error
+	switch {
+	case s.opts.Proxy != nil:
+		conn, err = s.opts.Proxy.dial(ctx, d, addr)
+	case s.opts.SSHJump != nil:
+		conn, err = s.opts.SSHJump.dial(ctx, addr, s.opts.Timeout)
+	default:
+		conn, err = d.DialContext(ctx, s.network, addr)
+	}
+	s.opts.Audit.record(start, d, s.network, addr, conn, err)
+	if ctx.Err() == nil {
+		s.adaptive.observe(err)
+	}
+	return conn, err
+}
+
+// Concurrency returns how many ports the last scan ended up scanning at once,
+// which only differs from Options.Concurrency for adaptive scans.
+func (s *Scanner) Concurrency() int {
+	if s.adaptive == nil {
+		return s.opts.Concurrency
+	}
+	return s.adaptive.current()
+}
+
+// isOpen reports whether port is open along with how long the successful connect took.
+func (s *Scanner) isOpen(ctx context.Context, port int) (PortResult, bool) {
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		conn, err := s.dial(ctx, port)
+		latency := time.Since(start)
+		// Probes the budget or cancelling the scan stopped never went out.
+		if err == nil || !xerrors.Is(err, ErrBudgetExhausted) && ctx.Err() == nil {
+			s.sent(attempt)
+		}
+		if err == nil {
+			defer conn.Close()
+			err = confirm(ctx, conn, s.hostname(), levelFor(s.opts.ConfirmLevels, port), s.opts.Timeout)
+			if err != nil {
+				s.fail(dialOutcome(err))
+				if s.opts.RawErrors {
+					dumpRawError(port, err)
+				}
+			}
+			return PortResult{Port: port, State: StateOpen, Latency: latency, Attempts: attempt + 1}, err == nil
+		}
+
+		// Running out of budget before the port answered means we never
+		// learned anything about it, even if earlier attempts went out.
+		if xerrors.Is(err, ErrBudgetExhausted) {
+			s.skip(port)
+			return PortResult{}, false
+		}
+
+		if ctx.Err() != nil {
+			return PortResult{}, false
+		}
+
+		if attempt >= s.opts.Retry.Retries || !shouldRetry(err) {
+			// Running out of sockets says nothing about the port,
+			// so it's left for another scan instead of called filtered.
+			if outcome := dialOutcome(err); outcome == "exhausted" {
+				s.fail(outcome)
+				s.skip(port)
+			} else {
+				s.reject(PortResult{Port: port, Attempts: attempt + 1}, outcome)
+			}
+			if s.opts.RawErrors {
+				dumpRawError(port, err)
+			}
+			return PortResult{}, false
+		}
+
+		select {
+		case <-time.After(s.opts.Retry.wait(attempt)):
+		case <-ctx.Done():
+			return PortResult{}, false
+		}
+	}
+}
+
+// watchConn interrupts any blocked reads and writes on conn once ctx is done.
+// Call the returned func when done with conn so the watcher can exit.
+func watchConn(ctx context.Context, conn net.Conn) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,185 @@
+package scanner
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/goleak"
+)
+
+// TestScanCancelLeak cancels a scan while a probe is waiting on a port and
+// makes sure none of the scan's goroutines outlive it.
+func TestScanCancelLeak(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The listener takes connections and never answers, so the http probe
+	// of its port keeps waiting until the scan gets cancelled.
+	accepted := make(chan struct{}, 1)
+	var (
+		conns []net.Conn
+		wg    sync.WaitGroup
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+			select {
+			case accepted <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	defer func() {
+		lis.Close()
+		wg.Wait()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	port := lis.Addr().(*net.TCPAddr).Port
+	ports := []int{port}
+	for p := 1; p <= 1024; p++ {
+		if p != port {
+			ports = append(ports, p)
+		}
+	}
+
+	s, err := New("127.0.0.1", Options{
+		Ports:         ports,
+		Timeout:       time.Minute,
+		Concurrency:   16,
+		ConfirmLevels: map[int]ConfirmLevel{port: ConfirmHTTP},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = s.Scan(ctx)
+	}()
+
+	select {
+	case <-accepted:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the scan never connected to the listener")
+	}
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the scan didn't return after being cancelled")
+	}
+
+	// The listener's goroutine has to be gone before checking for the scan's.
+	lis.Close()
+	wg.Wait()
+	goleak.VerifyNone(t)
+}
+
+// TestFamilyFilter runs a mixed list of addresses through the filter -4 and -6
+// apply, and checks every address left gets dialed over its own family.
+func TestFamilyFilter(t *testing.T) {
+	ips := []net.IP{
+		net.ParseIP("192.0.2.1"),
+		net.ParseIP("2001:db8::1"),
+		// A v4-mapped address is an ipv4 one as far as dialing goes.
+		net.ParseIP("::ffff:192.0.2.2"),
+		net.ParseIP("::1"),
+		net.ParseIP("127.0.0.1"),
+	}
+
+	tests := []struct {
+		name    string
+		network string
+		// want maps the addresses kept to the network they're dialed over.
+		want map[string]string
+	}{
+		{
+			name:    "both families",
+			network: "tcp",
+			want: map[string]string{
+				"192.0.2.1":   "tcp4",
+				"2001:db8::1": "tcp6",
+				"192.0.2.2":   "tcp4",
+				"::1":         "tcp6",
+				"127.0.0.1":   "tcp4",
+			},
+		},
+		{
+			name:    "-4",
+			network: "tcp4",
+			want: map[string]string{
+				"192.0.2.1": "tcp4",
+				"192.0.2.2": "tcp4",
+				"127.0.0.1": "tcp4",
+			},
+		},
+		{
+			name:    "-6",
+			network: "tcp6",
+			want: map[string]string{
+				"2001:db8::1": "tcp6",
+				"::1":         "tcp6",
+			},
+		},
+		{
+			name:    "udp both families",
+			network: "udp",
+			want: map[string]string{
+				"192.0.2.1":   "udp4",
+				"2001:db8::1": "udp6",
+				"192.0.2.2":   "udp4",
+				"::1":         "udp6",
+				"127.0.0.1":   "udp4",
+			},
+		},
+		{
+			name:    "udp -4",
+			network: "udp4",
+			want: map[string]string{
+				"192.0.2.1": "udp4",
+				"192.0.2.2": "udp4",
+				"127.0.0.1": "udp4",
+			},
+		},
+		{
+			name:    "udp -6",
+			network: "udp6",
+			want: map[string]string{
+				"2001:db8::1": "udp6",
+				"::1":         "udp6",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(map[string]string)
+			for _, ip := range ips {
+				if InFamily(ip, tt.network) {
+					got[ip.String()] = dialNetwork(ip, tt.network)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package scanner
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"math/rand"
+	"net"
+)
+
+// ipProtoSCTP is the ip protocol number of SCTP.
+const ipProtoSCTP = 132
+
+// SCTP chunk types we send or care about in replies.
+const (
+	sctpChunkInit    = 1
+	sctpChunkInitAck = 2
+	sctpChunkAbort   = 6
+)
+
+// An SCTP INIT scan is the SYN scan of SCTP. We send the INIT that opens an
+// association and wait:
+//   - an INIT-ACK means the port is open, we never follow up with the COOKIE-ECHO
+//   - an ABORT means the port is closed
+//   - silence means something dropped the INIT, so we retry and then give up
+//
+// Go has no SCTP support of its own, so this is the only way we scan SCTP
+// and it needs the same raw sockets as a SYN scan.
+
+// crc32c is the table of the checksum SCTP uses instead of the internet checksum.
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+func (s *Scanner) sctpScan(ctx context.Context) error {
+	return s.rawScan(ctx, rawProbe{
+		proto:   ipProtoSCTP,
+		name:    "SCTP",
+		silence: StateFiltered,
+		packet: func(_, _ net.IP, srcPort, dstPort uint16) []byte {
+			return sctpInit(srcPort, dstPort, rand.Uint32()|1, rand.Uint32())
+		},
+		reply: func(pkt []byte, from net.IP, ourPort uint16) (int, State, string, bool) {
+			port, chunk, ok := parseSCTPReply(pkt, from, ourPort)
+			switch {
+			case !ok:
+				return 0, "", "", false
+			case chunk == sctpChunkInitAck:
+				return port, StateOpen, "init-ack", true
+			case chunk == sctpChunkAbort:
+				return port, StateClosed, "refused", true
+			}
+			return 0, "", "", false
+		},
+	})
+}
+
+// sctpInit builds an SCTP packet holding a single INIT chunk from srcPort to dstPort.
+// tag is the verification tag we ask the peer to use and must not be 0.
+func sctpInit(srcPort, dstPort uint16, tag, tsn uint32) []byte {
+	pkt := make([]byte, 32)
+	binary.BigEndian.PutUint16(pkt[0:], srcPort)
+	binary.BigEndian.PutUint16(pkt[2:], dstPort)
+	// The packet's own verification tag stays 0 since the peer hasn't given us one yet.
+
+	chunk := pkt[12:]
+	chunk[0] = sctpChunkInit
+	binary.BigEndian.PutUint16(chunk[2:], 20)
+	binary.BigEndian.PutUint32(chunk[4:], tag)
+	// Receive window, outbound streams, inbound streams and the first TSN.
+	binary.BigEndian.PutUint32(chunk[8:], 65535)
+	binary.BigEndian.PutUint16(chunk[12:], 10)
+	binary.BigEndian.PutUint16(chunk[14:], 2048)
+	binary.BigEndian.PutUint32(chunk[16:], tsn)
+
+	// Unlike the rest of the header the checksum goes out little endian.
+	binary.LittleEndian.PutUint32(pkt[8:], crc32.Checksum(pkt, crc32c))
+	return pkt
+}
+
+// parseSCTPReply picks the reply to one of our INITs out of a raw ipv4 packet.
+// It returns the port that replied along with the type of the first chunk it replied with.
+func parseSCTPReply(pkt []byte, from net.IP, ourPort uint16) (port int, chunk byte, ok bool) {
+	if len(pkt) < 20 || pkt[0]>>4 != 4 || pkt[9] != ipProtoSCTP {
+		return 0, 0, false
+	}
+
+	ihl := int(pkt[0]&0x0f) * 4
+	// The common header and at least one chunk header.
+	if len(pkt) < ihl+16 || !net.IP(pkt[12:16]).Equal(from) {
+		return 0, 0, false
+	}
+
+	sctp := pkt[ihl:]
+	// Scanning ourselves means we also see our own INITs go by.
+	if binary.BigEndian.Uint16(sctp[2:]) != ourPort {
+		return 0, 0, false
+	}
+	return int(binary.BigEndian.Uint16(sctp[0:])), sctp[12], true
+}
@@ -0,0 +1,230 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// The probes that ship with the scanner. Like the auth probes they only
+// ever ask for information, they never change anything on the service.
+func init() {
+	RegisterProbe(redisProbe{})
+	RegisterProbe(mysqlProbe{})
+	RegisterProbe(smtpProbe{})
+	RegisterProbe(smbProbe{})
+}
+
+// redisProbe asks redis for the server section of INFO.
+type redisProbe struct{}
+
+func (redisProbe) Name() string { return "redis" }
+
+func (redisProbe) Match(port int, banner string) bool {
+	return port == 6379 || strings.HasPrefix(banner, "+PONG") || strings.HasPrefix(banner, "-NOAUTH")
+}
+
+func (redisProbe) Run(conn net.Conn, _ string) (ProbeResult, error) {
+	if _, err := conn.Write([]byte("INFO server\r\n")); err != nil {
+		return ProbeResult{}, xerrors.Errorf("failed to send INFO: %w", err)
+	}
+
+	r := bufio.NewReader(conn)
+	header, err := r.ReadString('\n')
+	if err != nil {
+		return ProbeResult{}, xerrors.Errorf("failed to read INFO reply: %w", err)
+	}
+	header = strings.TrimSpace(header)
+
+	switch {
+	case strings.HasPrefix(header, "-NOAUTH"), strings.HasPrefix(header, "-WRONGPASS"):
+		return ProbeResult{
+			Summary: "redis, authentication required",
+			Details: map[string]string{"requires_auth": "true"},
+		}, nil
+	case !strings.HasPrefix(header, "$"):
+		return ProbeResult{}, xerrors.Errorf("unexpected INFO reply %q", header)
+	}
+
+	// The info is sent as a bulk string of "key:value" lines.
+	n, err := strconv.Atoi(header[1:])
+	if err != nil || n < 0 {
+		return ProbeResult{}, xerrors.Errorf("unexpected INFO reply %q", header)
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return ProbeResult{}, xerrors.Errorf("failed to read INFO reply: %w", err)
+	}
+
+	details := map[string]string{"requires_auth": "false"}
+	for _, line := range strings.Split(string(payload), "\r\n") {
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		switch key := line[:i]; key {
+		case "redis_version", "redis_mode", "os", "uptime_in_days":
+			details[key] = line[i+1:]
+		}
+	}
+
+	summary := "redis " + details["redis_version"]
+	if mode := details["redis_mode"]; mode != "" {
+		summary += " " + mode
+	}
+	return ProbeResult{Summary: summary + ", no authentication", Details: details}, nil
+}
+
+// mysqlProbe reads the handshake mysql and mariadb greet every client with,
+// which gives away the server version without logging in.
+type mysqlProbe struct{}
+
+func (mysqlProbe) Name() string { return "mysql" }
+
+func (mysqlProbe) Match(port int, banner string) bool {
+	return port == 3306 || strings.Contains(banner, "mysql_native_password") || strings.Contains(banner, "caching_sha2_password")
+}
+
+func (mysqlProbe) Run(conn net.Conn, _ string) (ProbeResult, error) {
+	// Packets start with a 3 byte little endian length and a sequence id.
+	var header [4]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return ProbeResult{}, xerrors.Errorf("failed to read handshake: %w", err)
+	}
+	length := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return ProbeResult{}, xerrors.Errorf("failed to read handshake: %w", err)
+	}
+
+	if len(payload) == 0 {
+		return ProbeResult{}, xerrors.New("empty handshake")
+	}
+
+	// Servers that won't talk to us at all, e.g. because our host isn't
+	// allowed to connect, send an error packet instead.
+	if payload[0] == 0xff && len(payload) >= 3 {
+		code := binary.LittleEndian.Uint16(payload[1:3])
+		msg := string(payload[3:])
+		// Servers past 4.1 put a "#" and a 5 character sql state in front of the message.
+		if strings.HasPrefix(msg, "#") && len(msg) >= 6 {
+			msg = msg[6:]
+		}
+		return ProbeResult{
+			Summary: fmt.Sprintf("mysql, refused us with error %d: %s", code, msg),
+			Details: map[string]string{"error_code": strconv.Itoa(int(code)), "error": msg},
+		}, nil
+	}
+
+	if payload[0] != 0x0a {
+		return ProbeResult{}, xerrors.Errorf("unsupported handshake protocol version %d", payload[0])
+	}
+
+	rest := payload[1:]
+	end := bytes.IndexByte(rest, 0)
+	if end < 0 {
+		return ProbeResult{}, xerrors.New("truncated handshake")
+	}
+	details := map[string]string{"version": string(rest[:end])}
+	rest = rest[end+1:]
+
+	// connection id(4) auth data(8) filler(1) capabilities(2) charset(1) status(2)
+	// capabilities(2) auth data length(1) reserved(10) and then the rest of the auth data.
+	if len(rest) >= 4 {
+		details["connection_id"] = strconv.FormatUint(uint64(binary.LittleEndian.Uint32(rest)), 10)
+	}
+	if len(rest) >= 31 {
+		authLen := int(rest[20])
+		part2 := authLen - 8
+		if part2 < 13 {
+			part2 = 13
+		}
+		if plugin := rest[31:]; len(plugin) > part2 {
+			plugin = plugin[part2:]
+			if end := bytes.IndexByte(plugin, 0); end >= 0 {
+				plugin = plugin[:end]
+			}
+			details["auth_plugin"] = string(plugin)
+		}
+	}
+
+	summary := "mysql " + details["version"]
+	if plugin := details["auth_plugin"]; plugin != "" {
+		summary += " using " + plugin
+	}
+	return ProbeResult{Summary: summary, Details: details}, nil
+}
+
+// smtpProbe greets an smtp server with EHLO to learn the extensions it
+// supports, like whether it offers STARTTLS and which ways to AUTH.
+type smtpProbe struct{}
+
+func (smtpProbe) Name() string { return "smtp" }
+
+func (smtpProbe) Match(port int, banner string) bool {
+	switch port {
+	case 25, 587, 2525:
+		return true
+	}
+	return strings.HasPrefix(banner, "220") && strings.Contains(strings.ToUpper(banner), "SMTP")
+}
+
+func (smtpProbe) Run(conn net.Conn, _ string) (ProbeResult, error) {
+	tp := textproto.NewConn(conn)
+	_, greeting, err := tp.ReadResponse(220)
+	if err != nil {
+		return ProbeResult{}, xerrors.Errorf("failed to read greeting: %w", err)
+	}
+
+	if err := tp.PrintfLine("EHLO port-scanner"); err != nil {
+		return ProbeResult{}, xerrors.Errorf("failed to send EHLO: %w", err)
+	}
+	_, reply, err := tp.ReadResponse(250)
+	if err != nil {
+		return ProbeResult{}, xerrors.Errorf("EHLO failed: %w", err)
+	}
+	// Lets be polite, the reply doesn't matter.
+	_ = tp.PrintfLine("QUIT")
+
+	details := map[string]string{"greeting": strings.Replace(greeting, "\n", " ", -1)}
+	// The first line of the reply is the server greeting us back, the rest are extensions.
+	lines := strings.Split(reply, "\n")
+	var extensions []string
+	for _, ext := range lines[1:] {
+		fields := strings.Fields(ext)
+		if len(fields) == 0 {
+			continue
+		}
+		extensions = append(extensions, ext)
+		switch strings.ToUpper(fields[0]) {
+		case "STARTTLS":
+			details["starttls"] = "true"
+		case "AUTH":
+			details["auth"] = strings.Join(fields[1:], " ")
+		case "SIZE":
+			if len(fields) > 1 {
+				details["size"] = fields[1]
+			}
+		}
+	}
+	details["extensions"] = strings.Join(extensions, ", ")
+
+	summary := "smtp"
+	if details["starttls"] == "true" {
+		summary += ", STARTTLS"
+	} else {
+		summary += ", no STARTTLS"
+	}
+	if auth := details["auth"]; auth != "" {
+		summary += ", AUTH " + auth
+	}
+	return ProbeResult{Summary: summary, Details: details}, nil
+}
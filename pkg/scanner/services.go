@@ -0,0 +1,66 @@
+package scanner
+
+import (
+	"bufio"
+	_ "embed"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//go:embed services.txt
+var servicesFile string
+
+var (
+	servicesOnce sync.Once
+	// services maps "port/protocol" to the name of the service registered there.
+	services map[string]string
+)
+
+// ServiceName returns the well-known name of the service on port, like ssh for 22,
+// or "" if there isn't one. network is a dial network like "tcp" or "udp6".
+func ServiceName(port int, network string) string {
+	servicesOnce.Do(func() { services = parseServices(servicesFile) })
+	return services[strconv.Itoa(port)+"/"+strings.TrimRight(network, "46")]
+}
+
+// ServicePorts returns every port with a well-known service over network, sorted.
+func ServicePorts(network string) []int {
+	servicesOnce.Do(func() { services = parseServices(servicesFile) })
+	suffix := "/" + strings.TrimRight(network, "46")
+	var ports []int
+	for key := range services {
+		if strings.HasSuffix(key, suffix) {
+			port, err := strconv.Atoi(strings.TrimSuffix(key, suffix))
+			if err == nil {
+				ports = append(ports, port)
+			}
+		}
+	}
+	sort.Ints(ports)
+	return ports
+}
+
+// parseServices reads a table in /etc/services format, the first name listed
+// for a port wins and aliases and comments are ignored.
+func parseServices(table string) map[string]string {
+	names := make(map[string]string)
+	lines := bufio.NewScanner(strings.NewReader(table))
+	for lines.Scan() {
+		line := lines.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		if _, ok := names[fields[1]]; !ok {
+			names[fields[1]] = fields[0]
+		}
+	}
+	return names
+}
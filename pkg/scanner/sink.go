@@ -0,0 +1,233 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// OutputSink is somewhere the results of a run end up. Set it as Options.Sink
+// and every Scan writes its result to it once it's done, share one between
+// scanners to collect a whole multi-host run. Writes come from whichever
+// goroutine ran the scan, so sinks have to be safe for concurrent use.
+// Flushing is up to whoever runs the scans, once they're all done.
+type OutputSink interface {
+	Write(Result) error
+	// Flush delivers whatever the sink held back, some only send in batches.
+	Flush() error
+}
+
+// Sinks writes to and flushes every one of its sinks, so a run can deliver
+// its results to several places at once. A sink failing doesn't keep the
+// rest from getting the result, the first error is what's returned.
+type Sinks []OutputSink
+
+func (ss Sinks) Write(r Result) error {
+	var first error
+	for _, s := range ss {
+		if err := s.Write(r); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (ss Sinks) Flush() error {
+	var first error
+	for _, s := range ss {
+		if err := s.Flush(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// WriterSink writes every result to an io.Writer as a line of json, buffered until it's flushed.
+type WriterSink struct {
+	mu sync.Mutex
+	w  *bufio.Writer
+}
+
+// NewWriterSink returns a sink writing to w, e.g. os.Stdout.
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: bufio.NewWriter(w)}
+}
+
+func (s *WriterSink) Write(r Result) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return xerrors.Errorf("failed to encode result: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.w.Write(append(line, '\n')); err != nil {
+		return xerrors.Errorf("failed to write result: %w", err)
+	}
+	return nil
+}
+
+func (s *WriterSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.w.Flush(); err != nil {
+		return xerrors.Errorf("failed to write results: %w", err)
+	}
+	return nil
+}
+
+// FileSink is a WriterSink writing to a file, close it once it's flushed.
+type FileSink struct {
+	*WriterSink
+	file *os.File
+}
+
+// OpenFileSink creates path, or appends to it when append is set.
+func OpenFileSink(path string, append bool) (*FileSink, error) {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if append {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to open %q: %w", path, err)
+	}
+	return &FileSink{WriterSink: NewWriterSink(f), file: f}, nil
+}
+
+// Close flushes whatever's left and closes the file.
+func (s *FileSink) Close() error {
+	if err := s.Flush(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
+
+// WebhookSignatureHeader carries the hex encoded HMAC-SHA256 of a WebhookSink's
+// request body keyed with its Secret, so receivers can check it came from us.
+const WebhookSignatureHeader = "X-Port-Scanner-Signature"
+
+// WebhookSink collects results and POSTs them to URL as a json array when
+// flushed, a run makes a single request rather than one per host.
+type WebhookSink struct {
+	URL string
+	// Secret signs every request when set, see WebhookSignatureHeader.
+	Secret string
+	// Client defaults to an http.Client timing requests out after 10 seconds.
+	Client *http.Client
+
+	mu      sync.Mutex
+	results []Result
+}
+
+func (s *WebhookSink) Write(r Result) error {
+	s.mu.Lock()
+	s.results = append(s.results, r)
+	s.mu.Unlock()
+	return nil
+}
+
+// Flush POSTs the results written since the last flush, if there are any.
+// They're dropped either way, a failed delivery isn't tried again.
+func (s *WebhookSink) Flush() error {
+	s.mu.Lock()
+	results := s.results
+	s.results = nil
+	s.mu.Unlock()
+	if len(results) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(results)
+	if err != nil {
+		return xerrors.Errorf("failed to encode results: %w", err)
+	}
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return xerrors.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(s.Secret))
+		_, _ = mac.Write(body)
+		req.Header.Set(WebhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return xerrors.Errorf("failed to deliver webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return xerrors.Errorf("webhook endpoint answered %s", resp.Status)
+	}
+	return nil
+}
+
+// SyslogSink sends an RFC 5424 message for every open port of a result to a
+// syslog endpoint over udp or tcp, from the local0 facility with the notice severity.
+type SyslogSink struct {
+	mu       sync.Mutex
+	network  string
+	conn     net.Conn
+	hostname string
+}
+
+// DialSyslog connects to the syslog endpoint at addr over network, "udp" or "tcp".
+func DialSyslog(network, addr string) (*SyslogSink, error) {
+	if network != "udp" && network != "tcp" {
+		return nil, xerrors.Errorf("%q is an unsupported syslog transport(udp or tcp)", network)
+	}
+	conn, err := net.DialTimeout(network, addr, 10*time.Second)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to connect to syslog endpoint: %w", err)
+	}
+
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+	return &SyslogSink{network: network, conn: conn, hostname: hostname}, nil
+}
+
+func (s *SyslogSink) Write(r Result) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range r.Open() {
+		msg := fmt.Sprintf("<133>1 %s %s port-scanner %d - - %s port %d/%s is open", r.Start.UTC().Format(time.RFC3339), s.hostname, os.Getpid(), r.Host, p.Port, r.Network)
+		// A datagram is a message of its own, over tcp receivers expect one per line.
+		if s.network == "tcp" {
+			msg += "\n"
+		}
+		if _, err := s.conn.Write([]byte(msg)); err != nil {
+			return xerrors.Errorf("failed to send event: %w", err)
+		}
+	}
+	return nil
+}
+
+// Flush does nothing, every message goes out as soon as it's written.
+func (s *SyslogSink) Flush() error { return nil }
+
+func (s *SyslogSink) Close() error { return s.conn.Close() }
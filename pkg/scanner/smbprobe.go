@@ -0,0 +1,332 @@
+package scanner
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/xerrors"
+)
+
+// smbProbe finds out what a Windows or Samba host calls itself and how it
+// speaks SMB, without logging in. It negotiates SMB2 for the dialect and signing
+// requirements, then starts an anonymous NTLM session setup, whose challenge
+// carries the host's NetBIOS and DNS names, its domain or workgroup and the
+// OS version. The session is never completed.
+type smbProbe struct{}
+
+func (smbProbe) Name() string { return "smb" }
+
+func (smbProbe) Match(port int, _ string) bool {
+	return port == 139 || port == 445
+}
+
+// SMB2 commands and the status a session setup needs another round for.
+const (
+	smb2Negotiate              = 0x0000
+	smb2SessionSetup           = 0x0001
+	smbStatusMoreProcessing    = 0xc0000016
+	smbSecurityModeSigning     = 0x0001
+	smbSecurityModeSigningReqd = 0x0002
+)
+
+// smbDialects are the SMB2 dialects we offer, in the order of the revisions they stand for.
+var smbDialects = []uint16{0x0202, 0x0210, 0x0300, 0x0302, 0x0311}
+
+func (smbProbe) Run(conn net.Conn, _ string) (ProbeResult, error) {
+	// On 139 SMB rides a NetBIOS session, which has to be asked for by the
+	// server's name first. Most servers take the generic *SMBSERVER.
+	if _, port, _ := net.SplitHostPort(conn.RemoteAddr().String()); port == "139" {
+		if err := netbiosSession(conn); err != nil {
+			return ProbeResult{}, err
+		}
+	}
+
+	details := make(map[string]string)
+	reply, err := smbRoundTrip(conn, smbNegotiateRequest())
+	if err != nil {
+		return ProbeResult{}, xerrors.Errorf("failed to negotiate: %w", err)
+	}
+	if err := parseSMBNegotiate(reply, details); err != nil {
+		return ProbeResult{}, err
+	}
+
+	// Not getting the names shouldn't throw away the dialect we already have,
+	// plenty of hardened hosts turn away anonymous session setups.
+	if reply, err := smbRoundTrip(conn, smbSessionSetupRequest()); err != nil {
+		details["ntlm_error"] = err.Error()
+	} else if err := parseSMBSessionSetup(reply, details); err != nil {
+		details["ntlm_error"] = err.Error()
+	}
+
+	summary := "smb " + details["dialect"]
+	if name := details["netbios_name"]; name != "" {
+		summary += " " + name
+		if domain := details["netbios_domain"]; domain != "" && domain != name {
+			summary += " in " + domain
+		}
+	}
+	if details["signing"] == "required" {
+		summary += "(signing required)"
+	} else {
+		summary += "(signing not required)"
+	}
+	return ProbeResult{Summary: summary, Details: details}, nil
+}
+
+// netbiosSession asks for a NetBIOS session with *SMBSERVER, RFC 1002 4.3.2.
+func netbiosSession(conn net.Conn) error {
+	called, calling := netbiosName("*SMBSERVER", 0x20), netbiosName("PORT-SCANNER", 0x00)
+	req := []byte{0x81, 0, 0, byte(len(called) + len(calling))}
+	req = append(append(req, called...), calling...)
+	if _, err := conn.Write(req); err != nil {
+		return xerrors.Errorf("failed to request a netbios session: %w", err)
+	}
+
+	var resp [4]byte
+	if _, err := io.ReadFull(conn, resp[:]); err != nil {
+		return xerrors.Errorf("failed to read netbios session response: %w", err)
+	}
+	if resp[0] != 0x82 {
+		return xerrors.Errorf("netbios session refused(response type %#x)", resp[0])
+	}
+	return nil
+}
+
+// netbiosName first-level encodes name with suffix as its 16th byte, RFC 1001 14.1.
+func netbiosName(name string, suffix byte) []byte {
+	raw := []byte(fmt.Sprintf("%-15.15s", strings.ToUpper(name)))
+	raw = append(raw, suffix)
+
+	encoded := []byte{32}
+	for _, b := range raw {
+		encoded = append(encoded, 'A'+b>>4, 'A'+b&0x0f)
+	}
+	return append(encoded, 0)
+}
+
+// smbRoundTrip sends an SMB2 message framed for direct tcp, which is the same framing
+// a NetBIOS session message has, and reads the message that comes back.
+func smbRoundTrip(conn net.Conn, msg []byte) ([]byte, error) {
+	frame := make([]byte, 4, 4+len(msg))
+	binary.BigEndian.PutUint32(frame, uint32(len(msg)))
+	if _, err := conn.Write(append(frame, msg...)); err != nil {
+		return nil, xerrors.Errorf("failed to send: %w", err)
+	}
+
+	var header [4]byte
+	for {
+		if _, err := io.ReadFull(conn, header[:]); err != nil {
+			return nil, xerrors.Errorf("failed to read reply: %w", err)
+		}
+		// NetBIOS keepalives can come in between.
+		if header[0] != 0x85 {
+			break
+		}
+	}
+	n := binary.BigEndian.Uint32(header[:]) & 0x00ffffff
+	if n > 1<<16 {
+		return nil, xerrors.Errorf("%d byte reply is too long", n)
+	}
+
+	reply := make([]byte, n)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return nil, xerrors.Errorf("failed to read reply: %w", err)
+	}
+	if bytes.HasPrefix(reply, []byte("\xffSMB")) {
+		return nil, xerrors.New("the server only speaks SMB1")
+	}
+	if len(reply) < 64 || !bytes.HasPrefix(reply, []byte("\xfeSMB")) {
+		return nil, xerrors.New("reply isn't SMB2")
+	}
+	return reply, nil
+}
+
+// smb2Header builds the 64 byte header of a request, MS-SMB2 2.2.1.2.
+func smb2Header(command uint16, messageID uint64) []byte {
+	h := make([]byte, 64)
+	copy(h, "\xfeSMB")
+	binary.LittleEndian.PutUint16(h[4:], 64)
+	binary.LittleEndian.PutUint16(h[12:], command)
+	binary.LittleEndian.PutUint16(h[14:], 1) // credits requested
+	binary.LittleEndian.PutUint64(h[24:], messageID)
+	return h
+}
+
+// smbNegotiateRequest offers every SMB2 dialect, along with the negotiate
+// contexts 3.1.1 can't be negotiated without, MS-SMB2 2.2.3.
+func smbNegotiateRequest() []byte {
+	msg := smb2Header(smb2Negotiate, 0)
+
+	body := make([]byte, 36)
+	binary.LittleEndian.PutUint16(body[0:], 36)
+	binary.LittleEndian.PutUint16(body[2:], uint16(len(smbDialects)))
+	binary.LittleEndian.PutUint16(body[4:], smbSecurityModeSigning)
+	rand.Read(body[12:28]) // client guid
+	for _, d := range smbDialects {
+		body = append(body, byte(d), byte(d>>8))
+	}
+	msg = append(msg, body...)
+	for len(msg)%8 != 0 {
+		msg = append(msg, 0)
+	}
+	binary.LittleEndian.PutUint32(msg[64+28:], uint32(len(msg)))
+	binary.LittleEndian.PutUint16(msg[64+32:], 2)
+
+	// SHA-512 preauth integrity with a random salt.
+	preauth := make([]byte, 6+32)
+	binary.LittleEndian.PutUint16(preauth[0:], 1)
+	binary.LittleEndian.PutUint16(preauth[2:], 32)
+	binary.LittleEndian.PutUint16(preauth[4:], 1)
+	rand.Read(preauth[6:])
+	msg = appendNegotiateContext(msg, 1, preauth)
+	for len(msg)%8 != 0 {
+		msg = append(msg, 0)
+	}
+
+	// AES-128-GCM and AES-128-CCM encryption.
+	msg = appendNegotiateContext(msg, 2, []byte{2, 0, 2, 0, 1, 0})
+	return msg
+}
+
+func appendNegotiateContext(msg []byte, kind uint16, data []byte) []byte {
+	header := make([]byte, 8)
+	binary.LittleEndian.PutUint16(header[0:], kind)
+	binary.LittleEndian.PutUint16(header[2:], uint16(len(data)))
+	return append(append(msg, header...), data...)
+}
+
+// parseSMBNegotiate reads the dialect and signing requirements out of a negotiate response, MS-SMB2 2.2.4.
+func parseSMBNegotiate(reply []byte, details map[string]string) error {
+	if status := binary.LittleEndian.Uint32(reply[8:]); status != 0 {
+		return xerrors.Errorf("negotiate failed with status %#x", status)
+	}
+
+	body := reply[64:]
+	if len(body) < 64 {
+		return xerrors.New("negotiate response is too short")
+	}
+
+	mode := binary.LittleEndian.Uint16(body[2:])
+	switch dialect := binary.LittleEndian.Uint16(body[4:]); dialect {
+	case 0x0202:
+		details["dialect"] = "2.0.2"
+	case 0x0210:
+		details["dialect"] = "2.1"
+	case 0x0300:
+		details["dialect"] = "3.0"
+	case 0x0302:
+		details["dialect"] = "3.0.2"
+	case 0x0311:
+		details["dialect"] = "3.1.1"
+	default:
+		details["dialect"] = fmt.Sprintf("%#04x", dialect)
+	}
+
+	switch {
+	case mode&smbSecurityModeSigningReqd != 0:
+		details["signing"] = "required"
+	case mode&smbSecurityModeSigning != 0:
+		details["signing"] = "enabled"
+	default:
+		details["signing"] = "disabled"
+	}
+	return nil
+}
+
+// ntlmNegotiateFlags ask for the target's name and info along with the OS version,
+// the rest are what every client asks for so servers don't turn us away.
+const ntlmNegotiateFlags = 0x00000001 | // unicode
+	0x00000002 | // oem
+	0x00000004 | // request target
+	0x00000200 | // ntlm
+	0x00008000 | // always sign
+	0x00080000 | // extended session security
+	0x00800000 | // target info
+	0x02000000 | // version
+	0x20000000 | // 128 bit
+	0x80000000 // 56 bit
+
+// smbSessionSetupRequest starts a session setup with an NTLM negotiate
+// message, MS-SMB2 2.2.5 and MS-NLMP 2.2.1.1.
+func smbSessionSetupRequest() []byte {
+	token := make([]byte, 40)
+	copy(token, "NTLMSSP\x00")
+	binary.LittleEndian.PutUint32(token[8:], 1)
+	binary.LittleEndian.PutUint32(token[12:], ntlmNegotiateFlags)
+	// Windows 10, NTLM revision 15.
+	copy(token[32:], []byte{10, 0, 0x61, 0x4a, 0, 0, 0, 15})
+
+	msg := smb2Header(smb2SessionSetup, 1)
+	body := make([]byte, 24)
+	binary.LittleEndian.PutUint16(body[0:], 25)
+	body[3] = smbSecurityModeSigning
+	binary.LittleEndian.PutUint16(body[12:], uint16(64+len(body)))
+	binary.LittleEndian.PutUint16(body[14:], uint16(len(token)))
+	return append(append(msg, body...), token...)
+}
+
+// The attributes of an NTLM challenge's target info we report, MS-NLMP 2.2.2.1.
+var ntlmTargetInfo = map[uint16]string{
+	1: "netbios_name",
+	2: "netbios_domain",
+	3: "dns_name",
+	4: "dns_domain",
+	5: "dns_forest",
+}
+
+// parseSMBSessionSetup reads the host's names and OS version out of the NTLM
+// challenge a session setup response carries, MS-NLMP 2.2.1.2.
+func parseSMBSessionSetup(reply []byte, details map[string]string) error {
+	if status := binary.LittleEndian.Uint32(reply[8:]); status != smbStatusMoreProcessing {
+		return xerrors.Errorf("session setup failed with status %#x", status)
+	}
+
+	// The challenge tends to be wrapped in SPNEGO, going by its signature saves us decoding that.
+	i := bytes.Index(reply[64:], []byte("NTLMSSP\x00\x02\x00\x00\x00"))
+	if i < 0 {
+		return xerrors.New("no NTLM challenge in the session setup response")
+	}
+	challenge := reply[64+i:]
+	if len(challenge) < 48 {
+		return xerrors.New("NTLM challenge is too short")
+	}
+
+	flags := binary.LittleEndian.Uint32(challenge[20:])
+	if flags&0x02000000 != 0 && len(challenge) >= 56 {
+		v := challenge[48:]
+		details["os_version"] = fmt.Sprintf("%d.%d build %d", v[0], v[1], binary.LittleEndian.Uint16(v[2:]))
+	}
+
+	n := int(binary.LittleEndian.Uint16(challenge[40:]))
+	offset := int(binary.LittleEndian.Uint32(challenge[44:]))
+	if offset+n > len(challenge) {
+		return xerrors.New("NTLM target info is out of bounds")
+	}
+	info := challenge[offset : offset+n]
+	for len(info) >= 4 {
+		id := binary.LittleEndian.Uint16(info)
+		size := int(binary.LittleEndian.Uint16(info[2:]))
+		if id == 0 || len(info) < 4+size {
+			break
+		}
+		if key, ok := ntlmTargetInfo[id]; ok {
+			details[key] = decodeUTF16(info[4 : 4+size])
+		}
+		info = info[4+size:]
+	}
+	return nil
+}
+
+func decodeUTF16(b []byte) string {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return banner([]byte(string(utf16.Decode(units))))
+}
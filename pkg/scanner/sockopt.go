@@ -0,0 +1,21 @@
+package scanner
+
+import "syscall"
+
+// controlFunc is what a net.Dialer runs on every socket before it connects.
+type controlFunc func(network, address string, c syscall.RawConn) error
+
+// chainControl runs every fn that isn't nil in order, stopping at the first error.
+func chainControl(fns ...controlFunc) controlFunc {
+	return func(network, address string, c syscall.RawConn) error {
+		for _, fn := range fns {
+			if fn == nil {
+				continue
+			}
+			if err := fn(network, address, c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
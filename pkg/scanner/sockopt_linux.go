@@ -0,0 +1,67 @@
+package scanner
+
+import (
+	"strings"
+	"syscall"
+
+	"golang.org/x/xerrors"
+)
+
+// probeControl returns the control func tuning every socket a connect or udp
+// scan dials a probe over, marking them with mark when it's set.
+func probeControl(mark int) controlFunc {
+	return func(network, _ string, c syscall.RawConn) error {
+		var tuneErr error
+		err := c.Control(func(fd uintptr) {
+			tuneErr = tuneProbeFD(int(fd), network, mark)
+		})
+		if err != nil {
+			return err
+		}
+		return tuneErr
+	}
+}
+
+func tuneProbeFD(fd int, network string, mark int) error {
+	if mark != 0 {
+		if err := markFD(fd, mark); err != nil {
+			return err
+		}
+	}
+
+	if !strings.HasPrefix(network, "tcp") {
+		return nil
+	}
+
+	// Probes are a handful of bytes that shouldn't sit waiting on Nagle.
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_NODELAY, 1); err != nil {
+		return xerrors.Errorf("failed to disable nagle: %w", err)
+	}
+
+	// A zero linger resets the connection on close instead of leaving it in
+	// TIME_WAIT, which on big scans ties up the ephemeral ports long after
+	// we're done with them.
+	if err := syscall.SetsockoptLinger(fd, syscall.SOL_SOCKET, syscall.SO_LINGER, &syscall.Linger{Onoff: 1}); err != nil {
+		return xerrors.Errorf("failed to set linger: %w", err)
+	}
+	return nil
+}
+
+// checkMark makes sure we're allowed to set mark on our sockets, which
+// takes CAP_NET_ADMIN, so we don't find out one failed probe at a time.
+func checkMark(mark int) error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		return xerrors.Errorf("failed to open a socket: %w", err)
+	}
+	defer syscall.Close(fd)
+	return markFD(fd, mark)
+}
+
+// markFD sets the fwmark policy routing and firewall rules can match fd's packets on.
+func markFD(fd, mark int) error {
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_MARK, mark); err != nil {
+		return xerrors.Errorf("failed to set fwmark %d(run as root or grant CAP_NET_ADMIN): %w", mark, err)
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package scanner
+
+// Elsewhere probes go out over Go's default sockets, which
+// disable Nagle too but leave closed connections in TIME_WAIT.
+func probeControl(int) controlFunc { return nil }
+
+// SO_MARK is linux only.
+func checkMark(int) error {
+	return unsupported("fwmarks are only supported on linux")
+}
@@ -0,0 +1,172 @@
+package scanner
+
+import (
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// SourcePool hands out local addresses to dial from in round-robin order.
+// Spreading connections across every public address of a multi-homed
+// host gets around per-source rate limits on the other end.
+// It can also pin every connection to one interface, like a VPN tunnel.
+type SourcePool struct {
+	addrs []net.IP
+	next  uint64
+	iface string
+}
+
+// NewSourcePool validates that each address is assigned to a local
+// interface and keeps the ones that can reach target. When iface is set
+// connections are bound to it and the addresses have to be assigned to it.
+// No addresses and no interface returns a nil pool, which lets the kernel pick.
+func NewSourcePool(rawAddrs []string, iface string, target net.IP) (*SourcePool, error) {
+	if len(rawAddrs) == 0 && iface == "" {
+		return nil, nil
+	}
+
+	pool := &SourcePool{iface: iface}
+	var local []net.IP
+	var err error
+	if iface != "" {
+		if err := checkBindToDevice(); err != nil {
+			return nil, err
+		}
+		local, err = InterfaceAddrs(iface)
+	} else {
+		local, err = LocalAddrs()
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("failed to list interface addresses: %w", err)
+	}
+
+	// Binding to the interface is enough on its own, the kernel
+	// picks the source from the addresses assigned to it.
+	if len(rawAddrs) == 0 {
+		return pool, nil
+	}
+
+	for _, raw := range rawAddrs {
+		ip := net.ParseIP(raw)
+		if ip == nil {
+			return nil, xerrors.Errorf("%q is an invalid ip address", raw)
+		}
+
+		if !ContainsIP(local, ip) {
+			if iface != "" {
+				return nil, xerrors.Errorf("%s is not assigned to %s", ip, iface)
+			}
+			return nil, xerrors.Errorf("%s is not assigned to any interface", ip)
+		}
+
+		// You can't dial an ipv6 target from an ipv4 address or vice versa.
+		if (ip.To4() == nil) != (target.To4() == nil) {
+			continue
+		}
+		pool.addrs = append(pool.addrs, ip)
+	}
+
+	if len(pool.addrs) == 0 {
+		return nil, xerrors.Errorf("none of the source addresses are in the same family as %s", target)
+	}
+	return pool, nil
+}
+
+// dialers returns the dialers for network, one per source address, with control
+// run on every socket they open once it's bound to the interface. Build them once
+// and take turns with pick. A nil pool returns a single dialer that lets the kernel pick.
+func (p *SourcePool) dialers(network string, timeout time.Duration, control controlFunc) []*net.Dialer {
+	base := net.Dialer{Timeout: timeout, Control: control}
+	if p == nil {
+		return []*net.Dialer{&base}
+	}
+
+	if p.iface != "" {
+		base.Control = chainControl(bindToDevice(p.iface), control)
+	}
+
+	if len(p.addrs) == 0 {
+		return []*net.Dialer{&base}
+	}
+
+	dialers := make([]*net.Dialer, len(p.addrs))
+	for i, ip := range p.addrs {
+		d := base
+		if strings.HasPrefix(network, "udp") {
+			d.LocalAddr = &net.UDPAddr{IP: ip}
+		} else {
+			d.LocalAddr = &net.TCPAddr{IP: ip}
+		}
+		dialers[i] = &d
+	}
+	return dialers
+}
+
+// pick returns the next of dialers in round-robin order, the rotation
+// is shared by every scanner using the pool.
+func (p *SourcePool) pick(dialers []*net.Dialer) *net.Dialer {
+	if p == nil || len(dialers) == 1 {
+		return dialers[0]
+	}
+
+	i := atomic.AddUint64(&p.next, 1) - 1
+	return dialers[i%uint64(len(dialers))]
+}
+
+// device returns the interface connections are bound to, if any.
+func (p *SourcePool) device() string {
+	if p == nil {
+		return ""
+	}
+	return p.iface
+}
+
+// LocalAddrs returns the addresses assigned to this machine's interfaces.
+func LocalAddrs() ([]net.IP, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []net.IP
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+	return ips, nil
+}
+
+// InterfaceAddrs returns the addresses assigned to the interface called name.
+func InterfaceAddrs(name string) ([]net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []net.IP
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+	return ips, nil
+}
+
+// ContainsIP reports whether ip is in ips.
+func ContainsIP(ips []net.IP, ip net.IP) bool {
+	for _, candidate := range ips {
+		if candidate.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+package scanner
+
+import (
+	"syscall"
+
+	"golang.org/x/xerrors"
+)
+
+func checkBindToDevice() error { return nil }
+
+// bindToDevice returns a dialer control func that pins the socket to the interface called name,
+// so traffic leaves through it whatever the routing table would have picked.
+func bindToDevice(name string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var bindErr error
+		err := c.Control(func(fd uintptr) {
+			bindErr = bindFDToDevice(int(fd), name)
+		})
+		if err != nil {
+			return err
+		}
+		return bindErr
+	}
+}
+
+func bindFDToDevice(fd int, name string) error {
+	if err := syscall.SetsockoptString(fd, syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, name); err != nil {
+		return xerrors.Errorf("failed to bind to interface %s: %w", name, err)
+	}
+	return nil
+}
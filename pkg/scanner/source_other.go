@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package scanner
+
+import "syscall"
+
+// SO_BINDTODEVICE is linux only, elsewhere you'd have to
+// bind to the interface's address with --source-ips instead.
+func checkBindToDevice() error {
+	return unsupported("binding to an interface is only supported on linux")
+}
+
+func bindToDevice(string) func(network, address string, c syscall.RawConn) error {
+	return func(string, string, syscall.RawConn) error { return checkBindToDevice() }
+}
+
+func bindFDToDevice(int, string) error { return checkBindToDevice() }
@@ -0,0 +1,118 @@
+package scanner
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// SRVServices are the services LookupSRV looks for when it isn't given any:
+// the ones that map out an Active Directory or VoIP deployment, and the mail,
+// chat and calendar servers that tend to be published the same way.
+var SRVServices = []string{
+	"_ldap._tcp", "_ldap._tcp.dc._msdcs", "_gc._tcp", "_kerberos._tcp", "_kerberos._udp", "_kpasswd._tcp", "_kpasswd._udp",
+	"_sip._tcp", "_sip._udp", "_sips._tcp", "_sipfederationtls._tcp", "_stun._udp", "_turn._udp", "_h323cs._tcp",
+	"_xmpp-client._tcp", "_xmpp-server._tcp",
+	"_submission._tcp", "_imap._tcp", "_imaps._tcp", "_pop3s._tcp", "_autodiscover._tcp",
+	"_caldavs._tcp", "_carddavs._tcp",
+}
+
+// SRVTarget is a host and port an SRV record points at.
+type SRVTarget struct {
+	// Service is the record's name without the domain, e.g. "_ldap._tcp".
+	Service string
+	// Protocol is the protocol the service is offered over, e.g. "tcp".
+	Protocol string
+	Host     string
+	Port     int
+}
+
+// LookupSRV looks up the SRV records of every one of services under domain,
+// each lookup taking at most timeout, and returns what they point at in the
+// order of services. Services without records are skipped, as are records
+// saying a service isn't offered. It only fails when none of the lookups
+// got an answer.
+func LookupSRV(ctx context.Context, domain string, services []string, timeout time.Duration) ([]SRVTarget, error) {
+	domain = strings.TrimSuffix(domain, ".")
+	if domain == "" {
+		return nil, xerrors.New("no domain to look up srv records under")
+	}
+	if len(services) == 0 {
+		services = SRVServices
+	}
+
+	found := make([][]SRVTarget, len(services))
+	errs := make([]error, len(services))
+	var wg sync.WaitGroup
+	for i, service := range services {
+		wg.Add(1)
+		go func(i int, service string) {
+			defer wg.Done()
+			found[i], errs[i] = lookupService(ctx, domain, service, timeout)
+		}(i, service)
+	}
+	wg.Wait()
+
+	var targets []SRVTarget
+	var lastErr error
+	answered := false
+	for i := range services {
+		if errs[i] != nil {
+			lastErr = errs[i]
+			continue
+		}
+		answered = true
+		targets = append(targets, found[i]...)
+	}
+	if !answered {
+		return nil, lastErr
+	}
+	return targets, nil
+}
+
+// lookupService looks up the SRV records of a single service. A name without
+// any records isn't an error, there's just nothing there.
+func lookupService(ctx context.Context, domain, service string, timeout time.Duration) ([]SRVTarget, error) {
+	service = strings.Trim(service, ".")
+	labels := strings.Split(service, ".")
+	if len(labels) < 2 || !strings.HasPrefix(labels[0], "_") || !strings.HasPrefix(labels[1], "_") {
+		return nil, xerrors.Errorf("%q should look like _service._proto, e.g. _ldap._tcp", service)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// With an empty service and proto the name is looked up as is,
+	// which keeps names like _ldap._tcp.dc._msdcs intact.
+	_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", service+"."+domain)
+	var dnsErr *net.DNSError
+	if xerrors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		if xerrors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, xerrors.Errorf("%s.%s: %w", service, domain, ErrResolveTimeout)
+		}
+		return nil, xerrors.Errorf("failed to look up %s.%s: %w", service, domain, err)
+	}
+
+	var targets []SRVTarget
+	for _, r := range records {
+		host := strings.TrimSuffix(r.Target, ".")
+		// A target of "." is how a domain says it doesn't offer the service.
+		if host == "" || r.Port == 0 {
+			continue
+		}
+		targets = append(targets, SRVTarget{
+			Service:  service,
+			Protocol: strings.TrimPrefix(labels[1], "_"),
+			Host:     host,
+			Port:     int(r.Port),
+		})
+	}
+	return targets, nil
+}
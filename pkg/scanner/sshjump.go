@@ -0,0 +1,223 @@
+package scanner
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/xerrors"
+)
+
+// SSHJump is an ssh connection to a bastion that connect scans are tunneled
+// through, every dial becomes a direct-tcpip channel the bastion opens for us.
+// That lets us scan a network we can only ssh into without a VPN.
+type SSHJump struct {
+	addr   string
+	client *ssh.Client
+}
+
+// DialSSHJump connects and authenticates to the bastion at addr.
+// It's safe to share between scanners, call Close once they're all done.
+func DialSSHJump(ctx context.Context, addr string, config *ssh.ClientConfig) (*SSHJump, error) {
+	d := net.Dialer{Timeout: config.Timeout}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to connect to %s: %w", addr, err)
+	}
+
+	// The handshake has no context of its own.
+	defer watchConn(ctx, conn)()
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, xerrors.Errorf("failed to establish ssh connection to %s: %w", addr, err)
+	}
+	return &SSHJump{addr: addr, client: ssh.NewClient(c, chans, reqs)}, nil
+}
+
+// String returns the address of the bastion.
+func (j *SSHJump) String() string { return j.addr }
+
+// Close closes the connection to the bastion along with every tunnel still open through it.
+func (j *SSHJump) Close() error { return j.client.Close() }
+
+// dial has the bastion connect to addr. The bastion gives up on a filtered
+// port in its own time, so lets stop waiting once our timeout is up and close
+// the tunnel if it comes through after all.
+func (j *SSHJump) dial(ctx context.Context, addr string, timeout time.Duration) (net.Conn, error) {
+	type dialed struct {
+		conn net.Conn
+		err  error
+	}
+
+	done := make(chan dialed, 1)
+	go func() {
+		conn, err := j.client.Dial("tcp", addr)
+		done <- dialed{conn, err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	abandon := func() {
+		go func() {
+			if d := <-done; d.conn != nil {
+				d.conn.Close()
+			}
+		}()
+	}
+
+	select {
+	case d := <-done:
+		if d.err != nil {
+			return nil, tunnelError(d.err)
+		}
+		return newTunnelConn(d.conn), nil
+	case <-timer.C:
+		abandon()
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: tunnelTimeout("ssh: bastion didn't connect in time")}
+	case <-ctx.Done():
+		abandon()
+		return nil, ctx.Err()
+	}
+}
+
+// tunnelError maps the bastion failing to reach a port onto the errors
+// a direct dial would have returned.
+func tunnelError(err error) error {
+	var openErr *ssh.OpenChannelError
+	if !xerrors.As(err, &openErr) || openErr.Reason != ssh.ConnectionFailed {
+		return err
+	}
+
+	// OpenSSH passes on why the connect failed in the message.
+	switch msg := strings.ToLower(openErr.Message); {
+	case strings.Contains(msg, "refused"):
+		return xerrors.Errorf("ssh: %s: %w", openErr.Message, syscall.ECONNREFUSED)
+	case strings.Contains(msg, "timed out"), strings.Contains(msg, "unreachable"):
+		return &net.OpError{Op: "dial", Net: "tcp", Err: tunnelTimeout("ssh: " + openErr.Message)}
+	}
+	return err
+}
+
+// tunnelConn gives ssh channels the deadlines everything past the dial relies on,
+// which they don't support themselves. Reads happen in the background so a
+// deadline passing can give up on one without closing the channel, since
+// probes like guessing the protocol carry on after a read times out.
+// Writes only check the deadline up front, ssh channels rarely block on them.
+type tunnelConn struct {
+	net.Conn
+	once    sync.Once
+	reads   chan tunnelRead
+	closed  chan struct{}
+	pending []byte
+	readErr error
+
+	mu       sync.Mutex
+	deadline time.Time
+	// changed is closed whenever the deadline moves, to wake up a blocked Read.
+	changed chan struct{}
+}
+
+type tunnelRead struct {
+	data []byte
+	err  error
+}
+
+func newTunnelConn(conn net.Conn) *tunnelConn {
+	return &tunnelConn{
+		Conn:    conn,
+		reads:   make(chan tunnelRead),
+		closed:  make(chan struct{}),
+		changed: make(chan struct{}),
+	}
+}
+
+func (c *tunnelConn) pump() {
+	for {
+		buf := make([]byte, 32<<10)
+		n, err := c.Conn.Read(buf)
+		select {
+		case c.reads <- tunnelRead{buf[:n], err}:
+		case <-c.closed:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (c *tunnelConn) Read(b []byte) (int, error) {
+	c.once.Do(func() { go c.pump() })
+	for len(c.pending) == 0 && c.readErr == nil {
+		c.mu.Lock()
+		deadline, changed := c.deadline, c.changed
+		c.mu.Unlock()
+
+		var expired <-chan time.Time
+		if !deadline.IsZero() {
+			wait := time.Until(deadline)
+			if wait <= 0 {
+				return 0, errTunnelTimeout
+			}
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			expired = timer.C
+		}
+
+		select {
+		case r := <-c.reads:
+			c.pending, c.readErr = r.data, r.err
+		case <-expired:
+			return 0, errTunnelTimeout
+		case <-changed:
+		}
+	}
+
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	if n > 0 {
+		return n, nil
+	}
+	return 0, c.readErr
+}
+
+func (c *tunnelConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	deadline := c.deadline
+	c.mu.Unlock()
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return 0, errTunnelTimeout
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *tunnelConn) Close() error {
+	c.mu.Lock()
+	select {
+	case <-c.closed:
+	default:
+		close(c.closed)
+	}
+	c.mu.Unlock()
+	return c.Conn.Close()
+}
+
+func (c *tunnelConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	close(c.changed)
+	c.changed = make(chan struct{})
+	return nil
+}
+
+func (c *tunnelConn) SetReadDeadline(t time.Time) error  { return c.SetDeadline(t) }
+func (c *tunnelConn) SetWriteDeadline(t time.Time) error { return c.SetDeadline(t) }
+
+var errTunnelTimeout = &net.OpError{Op: "read", Net: "tcp", Err: tunnelTimeout("ssh: i/o timeout")}
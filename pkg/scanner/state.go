@@ -0,0 +1,36 @@
+package scanner
+
+// State is what we learned about a port.
+type State string
+
+const (
+	StateOpen State = "open"
+	// StateOpenFiltered is a port that never answered. Over UDP that's what
+	// both an open port with a quiet service and a firewalled port look like.
+	StateOpenFiltered State = "open|filtered"
+	// StateClosed is a port that refused the connection, the host is
+	// reachable but nothing is listening on it.
+	StateClosed State = "closed"
+	// StateFiltered is a tcp port that never answered or was rejected by
+	// something other than the host's own stack, usually a firewall.
+	StateFiltered State = "filtered"
+	// StateUnfiltered is a port an ACK scan got a RST back from, nothing
+	// dropped the probe but it doesn't say whether anything is listening.
+	StateUnfiltered State = "unfiltered"
+	// StateClosedFiltered is a port an idle scan didn't find open, from the
+	// zombie a closed port and a filtered one look the same.
+	StateClosedFiltered State = "closed|filtered"
+)
+
+// stateFor is the state of a tcp port whose last probe failed with outcome.
+// Only a refused connection, i.e. an RST, means nothing is listening, any
+// other failure means something dropped or rejected the probe on the way.
+func stateFor(outcome string) State {
+	switch outcome {
+	case "refused":
+		return StateClosed
+	case string(StateClosedFiltered):
+		return StateClosedFiltered
+	}
+	return StateFiltered
+}
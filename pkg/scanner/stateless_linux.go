@@ -0,0 +1,330 @@
+package scanner
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// Engine is a masscan style SYN engine shared by every scanner of a run. One
+// goroutine sends the SYNs of every host while another reads the replies, and
+// neither waits on the other. The sequence number of every SYN is a cookie,
+// a keyed hash of the target's address and port, so a reply acknowledging
+// the cookie is the reply to our probe without us having kept anything per
+// probe. That's what lets a /16 be scanned at whatever rate the network takes,
+// where a connect scan would be stuck waiting on a socket per port.
+type Engine struct {
+	fd      int
+	secret  uint64
+	srcPort uint16
+	probes  chan engineProbe
+
+	// hosts are the scanners waiting on replies by target address,
+	// a target listed twice under different names gets two.
+	mu    sync.RWMutex
+	hosts map[[4]byte][]*Scanner
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+type engineProbe struct {
+	to   syscall.SockaddrInet4
+	pkt  []byte
+	port int
+	// raw is set when the scanner sending it wants to hear about send errors.
+	raw bool
+}
+
+// NewEngine opens the raw socket the engine sends and receives over, bound to
+// iface and the packets marked with mark when they're set, and starts it up.
+// Close it once every scan using it returned.
+func NewEngine(iface string, mark int) (*Engine, error) {
+	if err := CheckSYN(); err != nil {
+		return nil, err
+	}
+
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, syscall.IPPROTO_TCP)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to open a raw socket: %w", err)
+	}
+
+	if err := tuneEngineFD(fd, iface, mark); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	e := &Engine{
+		fd:      fd,
+		secret:  rand.Uint64(),
+		srcPort: uint16(32768 + rand.Intn(28232)),
+		probes:  make(chan engineProbe, 4096),
+		hosts:   make(map[[4]byte][]*Scanner),
+		done:    make(chan struct{}),
+	}
+	e.wg.Add(2)
+	go e.send()
+	go e.receive()
+	return e, nil
+}
+
+func tuneEngineFD(fd int, iface string, mark int) error {
+	if iface != "" {
+		if err := bindFDToDevice(fd, iface); err != nil {
+			return err
+		}
+	}
+	if mark != 0 {
+		if err := markFD(fd, mark); err != nil {
+			return err
+		}
+	}
+
+	tv := syscall.NsecToTimeval(int64(100 * time.Millisecond))
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		return xerrors.Errorf("failed to set receive timeout: %w", err)
+	}
+
+	// Replies come in from every host at once, so lets ask for a lot more room than
+	// a single host's SYN scan does, past the system's cap when we're allowed to.
+	// Again not getting it only costs accuracy.
+	if syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUFFORCE, 64<<20) != nil {
+		_ = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, 64<<20)
+	}
+	return nil
+}
+
+// Close stops the engine once the probes queued up are sent.
+func (e *Engine) Close() error {
+	close(e.probes)
+	close(e.done)
+	e.wg.Wait()
+	return syscall.Close(e.fd)
+}
+
+func (e *Engine) send() {
+	defer e.wg.Done()
+	for p := range e.probes {
+		if err := syscall.Sendto(e.fd, p.pkt, 0, &p.to); err != nil && p.raw {
+			dumpRawError(p.port, err)
+		}
+	}
+}
+
+func (e *Engine) receive() {
+	defer e.wg.Done()
+	buf := make([]byte, 65535)
+	for {
+		select {
+		case <-e.done:
+			return
+		default:
+		}
+
+		n, _, err := syscall.Recvfrom(e.fd, buf, 0)
+		if err != nil {
+			continue
+		}
+
+		host, port, flags, ok := e.parseReply(buf[:n])
+		if !ok {
+			continue
+		}
+
+		// Holding the lock while the scanners take the reply means
+		// none of them gets one after it unregistered.
+		e.mu.RLock()
+		for _, s := range e.hosts[host] {
+			s.engineReply(port, flags)
+		}
+		e.mu.RUnlock()
+	}
+}
+
+// cookie is the sequence number of our SYN to port on dst.
+func (e *Engine) cookie(dst net.IP, port uint16) uint32 {
+	var b [14]byte
+	binary.BigEndian.PutUint64(b[:], e.secret)
+	copy(b[8:], dst.To4())
+	binary.BigEndian.PutUint16(b[12:], port)
+	h := fnv.New32a()
+	h.Write(b[:])
+	return h.Sum32()
+}
+
+// parseReply picks a SYN-ACK or RST acknowledging one of our cookies out of a
+// raw ipv4 packet, returning who sent it, from what port and with what flags.
+func (e *Engine) parseReply(pkt []byte) (host [4]byte, port int, flags byte, ok bool) {
+	if len(pkt) < 20 || pkt[0]>>4 != 4 || pkt[9] != ipProtoTCP {
+		return host, 0, 0, false
+	}
+
+	ihl := int(pkt[0]&0x0f) * 4
+	if len(pkt) < ihl+20 {
+		return host, 0, 0, false
+	}
+
+	tcp := pkt[ihl:]
+	flags = tcp[13]
+	if binary.BigEndian.Uint16(tcp[2:]) != e.srcPort || flags&tcpFlagACK == 0 {
+		return host, 0, 0, false
+	}
+
+	from := net.IP(pkt[12:16])
+	srcPort := binary.BigEndian.Uint16(tcp[0:])
+	if binary.BigEndian.Uint32(tcp[8:]) != e.cookie(from, srcPort)+1 {
+		return host, 0, 0, false
+	}
+	copy(host[:], from)
+	return host, int(srcPort), flags, true
+}
+
+func (e *Engine) register(host [4]byte, s *Scanner) {
+	e.mu.Lock()
+	e.hosts[host] = append(e.hosts[host], s)
+	e.mu.Unlock()
+}
+
+func (e *Engine) unregister(host [4]byte, s *Scanner) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	scanners := e.hosts[host]
+	for i, candidate := range scanners {
+		if candidate == s {
+			scanners = append(scanners[:i], scanners[i+1:]...)
+			break
+		}
+	}
+	if len(scanners) == 0 {
+		delete(e.hosts, host)
+	} else {
+		e.hosts[host] = scanners
+	}
+}
+
+// statelessScan queues a SYN for every port with s.opts.Engine and waits on
+// the replies, round after round of retries for the ports that never answered.
+func (s *Scanner) statelessScan(ctx context.Context) error {
+	e := s.opts.Engine
+	src, err := s.synSource()
+	if err != nil {
+		return err
+	}
+
+	dst := net.ParseIP(s.host).To4()
+	var host [4]byte
+	copy(host[:], dst)
+	var to syscall.SockaddrInet4
+	to.Addr = host
+
+	e.register(host, s)
+	pending := s.opts.Ports
+	for try := 0; try <= s.opts.Retry.Retries && len(pending) > 0 && ctx.Err() == nil; try++ {
+		if try > 0 {
+			select {
+			case <-time.After(s.opts.Retry.wait(try - 1)):
+			case <-ctx.Done():
+			}
+		}
+		atomic.StoreInt32(&s.engineTry, int32(try))
+
+		// Only the ports a SYN went out to this round can be retried,
+		// those the budget ran out on are left unscanned.
+		var sent []int
+		for _, port := range pending {
+			if ctx.Err() != nil {
+				break
+			}
+
+			if try == 0 {
+				atomic.AddInt64(&s.scanned, 1)
+			}
+
+			if !s.opts.Budget.take() {
+				s.skip(port)
+				continue
+			}
+
+			if err := s.opts.Rate.wait(ctx); err != nil {
+				continue
+			}
+			if err := s.opts.Jitter.wait(ctx); err != nil {
+				continue
+			}
+
+			dstPort := uint16(port)
+			probe := engineProbe{
+				to:   to,
+				pkt:  probePacket(src, dst, e.srcPort, dstPort, e.cookie(dst, dstPort), tcpFlagSYN),
+				port: port,
+				raw:  s.opts.RawErrors,
+			}
+			select {
+			case e.probes <- probe:
+				sent = append(sent, port)
+				s.sent(try)
+			case <-ctx.Done():
+			}
+		}
+
+		// Give the replies to our last probes a chance to arrive.
+		select {
+		case <-time.After(s.opts.Timeout):
+		case <-ctx.Done():
+		}
+
+		s.mu.Lock()
+		var unanswered []int
+		for _, port := range sent {
+			if !s.done[port] {
+				unanswered = append(unanswered, port)
+			}
+		}
+		s.mu.Unlock()
+		pending = unanswered
+	}
+	e.unregister(host, s)
+
+	// A scan that was cut short leaves the ports that didn't answer to be scanned again.
+	if ctx.Err() != nil {
+		return nil
+	}
+	for _, port := range pending {
+		s.finish(port)
+		s.reject(PortResult{Port: port, Attempts: s.opts.Retry.Retries + 1}, "timeout")
+		if s.opts.RawErrors {
+			dumpRawError(port, xerrors.New("no reply to SYN"))
+		}
+	}
+	return nil
+}
+
+// engineReply records the SYN-ACK or RST port answered our SYN with.
+// Ports keep answering retries and retransmitting SYN-ACKs, only the first counts.
+func (s *Scanner) engineReply(port int, flags byte) {
+	s.mu.Lock()
+	if s.done[port] {
+		s.mu.Unlock()
+		return
+	}
+	s.done[port] = true
+	s.mu.Unlock()
+
+	// Nothing is kept about when the SYN went out, so there's no latency to report.
+	result := PortResult{Port: port, Attempts: int(atomic.LoadInt32(&s.engineTry)) + 1}
+	if flags&tcpFlagSYN != 0 {
+		result.State = StateOpen
+		s.add(result)
+		return
+	}
+	s.reject(result, "refused")
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+package scanner
+
+import "context"
+
+// Engine is the stateless SYN engine, which like every raw scan is linux only.
+type Engine struct{}
+
+// NewEngine always fails outside of linux.
+func NewEngine(string, int) (*Engine, error) {
+	return nil, unsupported("stateless scans are only supported on linux")
+}
+
+// Close does nothing, there's never an engine to close.
+func (e *Engine) Close() error { return nil }
+
+func (s *Scanner) statelessScan(context.Context) error {
+	return unsupported("stateless scans are only supported on linux")
+}
@@ -0,0 +1,236 @@
+package scanner
+
+import (
+	"context"
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// ipProtoTCP is the ip protocol number raw tcp sockets are opened with.
+const ipProtoTCP = 6
+
+// TCP flags we care about when crafting probes and reading the replies.
+const (
+	tcpFlagFIN = 0x01
+	tcpFlagSYN = 0x02
+	tcpFlagRST = 0x04
+	tcpFlagPSH = 0x08
+	tcpFlagACK = 0x10
+	tcpFlagURG = 0x20
+)
+
+// A SYN scan never completes the handshake. We send a bare SYN and wait:
+//   - a SYN-ACK means the port is open, our kernel answers it with a RST for us
+//   - a RST means the port is closed
+//   - silence means something dropped the SYN, so we retry and then give up
+//
+// Since nothing ever reaches the listening application, it's faster and
+// far less disruptive than a full connect, but it needs raw sockets.
+
+// FlagScan is a raw scan probing ports with a segment that can't start a
+// handshake instead of a SYN. RFC 793 has closed ports answer those with a
+// RST and open ports drop them, so they get past filters and logging that
+// only look out for SYNs:
+//   - a RST means the port is closed
+//   - silence means the port is open, or that something dropped the probe
+//
+// Windows and a few other stacks don't follow the RFC and answer
+// every one of them with a RST, which makes every port look closed.
+type FlagScan string
+
+const (
+	// FlagScanFIN sends a bare FIN.
+	FlagScanFIN FlagScan = "fin"
+	// FlagScanNull sends a segment without any flags.
+	FlagScanNull FlagScan = "null"
+	// FlagScanXmas lights the segment up like a christmas tree with FIN, PSH and URG.
+	FlagScanXmas FlagScan = "xmas"
+	// FlagScanACK sends a bare ACK, which maps firewall rules rather than
+	// listeners. Open and closed ports alike answer it with a RST, so:
+	//   - a RST means the port is unfiltered
+	//   - silence means a stateful firewall dropped the ACK for not
+	//     belonging to a connection it knows of, so the port is filtered
+	FlagScanACK FlagScan = "ack"
+)
+
+// tcpFlags returns the flags of the probes a raw scan sends, SYN when f isn't set.
+func (f FlagScan) tcpFlags() byte {
+	switch f {
+	case FlagScanFIN:
+		return tcpFlagFIN
+	case FlagScanNull:
+		return 0
+	case FlagScanXmas:
+		return tcpFlagFIN | tcpFlagPSH | tcpFlagURG
+	case FlagScanACK:
+		return tcpFlagACK
+	}
+	return tcpFlagSYN
+}
+
+// name is how errors refer to the scan.
+func (f FlagScan) name() string {
+	if f == "" {
+		return "SYN"
+	}
+	return strings.ToUpper(string(f))
+}
+
+// rawProbe is how a raw scan probes ports and reads the replies. The loop
+// sending the probes and matching up the replies is the same for all of them.
+type rawProbe struct {
+	// proto is the ip protocol number of the raw socket.
+	proto int
+	// name is what errors call the scan, e.g. "SYN".
+	name string
+	// packet builds the probe sent to dstPort.
+	packet func(src, dst net.IP, srcPort, dstPort uint16) []byte
+	// reply picks a reply to one of our probes out of a raw ipv4 packet. It returns the
+	// port that replied, the state the reply puts it in and the outcome to audit it as.
+	reply func(pkt []byte, from net.IP, ourPort uint16) (port int, state State, outcome string, ok bool)
+	// silence is the state of a port that never replied,
+	// either StateFiltered or StateOpenFiltered.
+	silence State
+}
+
+// synScan runs a SYN scan, or the flag scan s.opts.FlagScan picked.
+func (s *Scanner) synScan(ctx context.Context) error {
+	scan := s.opts.FlagScan
+	p := rawProbe{
+		proto:   ipProtoTCP,
+		name:    scan.name(),
+		silence: StateFiltered,
+		packet: func(src, dst net.IP, srcPort, dstPort uint16) []byte {
+			return probePacket(src, dst, srcPort, dstPort, rand.Uint32(), scan.tcpFlags())
+		},
+		reply: func(pkt []byte, from net.IP, ourPort uint16) (int, State, string, bool) {
+			port, flags, ok := parseSYNReply(pkt, from, ourPort)
+			switch {
+			case !ok:
+				return 0, "", "", false
+			case flags&tcpFlagSYN != 0:
+				return port, StateOpen, synOutcome(flags), true
+			case scan == FlagScanACK:
+				// Getting an answer at all is what an ACK scan is after.
+				return port, StateUnfiltered, "reset", true
+			}
+			return port, StateClosed, synOutcome(flags), true
+		},
+	}
+
+	// Open ports are meant to drop the FIN, NULL and Xmas probes,
+	// so silence is all an open port gives us.
+	if scan != "" && scan != FlagScanACK {
+		p.silence = StateOpenFiltered
+	}
+	return s.rawScan(ctx, p)
+}
+
+// synSource returns the address the kernel would send our SYNs to s.host from.
+func (s *Scanner) synSource() (net.IP, error) { return s.sourceFor(s.host) }
+
+// sourceFor returns the address the kernel would send raw probes to host from.
+// Connecting a udp socket picks a route without sending anything.
+func (s *Scanner) sourceFor(host string) (net.IP, error) {
+	// The mark goes on too, since policy routing can send marked packets another way.
+	d := s.opts.Sources.pick(s.opts.Sources.dialers("udp4", s.opts.Timeout, probeControl(s.opts.Mark)))
+	conn, err := d.Dial("udp4", net.JoinHostPort(host, "9"))
+	if err != nil {
+		return nil, xerrors.Errorf("failed to find a route to %s: %w", host, err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.To4(), nil
+}
+
+// probePacket builds a TCP segment with flags from src:srcPort to dst:dstPort.
+// The kernel fills in the IP header for us.
+func probePacket(src, dst net.IP, srcPort, dstPort uint16, seq uint32, flags byte) []byte {
+	size := 20
+	if flags&tcpFlagSYN != 0 {
+		size = 24
+	}
+
+	pkt := make([]byte, size)
+	binary.BigEndian.PutUint16(pkt[0:], srcPort)
+	binary.BigEndian.PutUint16(pkt[2:], dstPort)
+	binary.BigEndian.PutUint32(pkt[4:], seq)
+	// No ack number, and a header of 5 words or 6 with the MSS option.
+	pkt[12] = byte(size/4) << 4
+	pkt[13] = flags
+	binary.BigEndian.PutUint16(pkt[14:], 1024)
+	// A SYN without an MSS option is a dead giveaway, and some stacks drop it.
+	if flags&tcpFlagSYN != 0 {
+		copy(pkt[20:], []byte{2, 4, 0x05, 0xb4})
+	}
+	binary.BigEndian.PutUint16(pkt[16:], tcpChecksum(src, dst, pkt))
+	return pkt
+}
+
+// tcpChecksum computes the checksum of segment over the ipv4 pseudo-header.
+func tcpChecksum(src, dst net.IP, segment []byte) uint16 {
+	var sum uint32
+	add := func(b []byte) {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(b[i])<<8 | uint32(b[i+1])
+		}
+		if len(b)%2 == 1 {
+			sum += uint32(b[len(b)-1]) << 8
+		}
+	}
+
+	add(src.To4())
+	add(dst.To4())
+	sum += 6 + uint32(len(segment))
+	add(segment)
+
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+// parseSYNReply picks the reply to one of our SYNs out of a raw ipv4 packet.
+// It returns the port that replied along with the TCP flags it replied with.
+func parseSYNReply(pkt []byte, from net.IP, ourPort uint16) (port int, flags byte, ok bool) {
+	if len(pkt) < 20 || pkt[0]>>4 != 4 || pkt[9] != 6 {
+		return 0, 0, false
+	}
+
+	ihl := int(pkt[0]&0x0f) * 4
+	if len(pkt) < ihl+20 || !net.IP(pkt[12:16]).Equal(from) {
+		return 0, 0, false
+	}
+
+	tcp := pkt[ihl:]
+	flags = tcp[13]
+	// Scanning ourselves means we also see our own probes and RSTs go by, only
+	// segments acknowledging ours or resetting them are replies. A RST answering
+	// an ACK doesn't acknowledge anything.
+	if binary.BigEndian.Uint16(tcp[2:]) != ourPort || flags&(tcpFlagACK|tcpFlagRST) == 0 {
+		return 0, 0, false
+	}
+	return int(binary.BigEndian.Uint16(tcp[0:])), flags, true
+}
+
+// synOutcome names the reply to a SYN for the audit log.
+func synOutcome(flags byte) string {
+	if flags&tcpFlagSYN != 0 {
+		return "syn-ack"
+	}
+	return "refused"
+}
+
+// synAttempt is a SYN we're waiting on a reply for.
+type synAttempt struct {
+	sent time.Time
+	// try counts the SYNs sent to this port before this one,
+	// not counting retransmits.
+	try int
+	// released is set once the attempt gave up its in-flight slot.
+	released bool
+}
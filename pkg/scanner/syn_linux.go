@@ -0,0 +1,337 @@
+package scanner
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// CheckSYN makes sure we're allowed to open the raw socket SYN, flag and idle scans need.
+func CheckSYN() error { return checkRaw(ipProtoTCP, "SYN") }
+
+// CheckSCTP makes sure we're allowed to open the raw socket an SCTP scan needs.
+func CheckSCTP() error { return checkRaw(ipProtoSCTP, "SCTP") }
+
+func checkRaw(proto int, name string) error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, proto)
+	if err != nil {
+		return xerrors.Errorf("%s scans need raw sockets(run as root or grant CAP_NET_RAW): %w", name, err)
+	}
+	return syscall.Close(fd)
+}
+
+// rawScan probes every port with raw packets built and read by p.
+func (s *Scanner) rawScan(ctx context.Context, p rawProbe) error {
+	src, err := s.synSource()
+	if err != nil {
+		return err
+	}
+
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, p.proto)
+	if err != nil {
+		return xerrors.Errorf("failed to open a raw socket: %w", err)
+	}
+	defer syscall.Close(fd)
+
+	if iface := s.opts.Sources.device(); iface != "" {
+		if err := bindFDToDevice(fd, iface); err != nil {
+			return err
+		}
+	}
+	if s.opts.Mark != 0 {
+		if err := markFD(fd, s.opts.Mark); err != nil {
+			return err
+		}
+	}
+
+	// Recvfrom has no context, so lets have it wake up regularly
+	// to check whether we're done listening.
+	tv := syscall.NsecToTimeval(int64(100 * time.Millisecond))
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		return xerrors.Errorf("failed to set receive timeout: %w", err)
+	}
+
+	// Replies to a fast burst of probes pile up far quicker than the default
+	// receive buffer holds, and every dropped reply looks like a filtered port.
+	// Not getting the bigger buffer only costs accuracy, so carry on regardless.
+	_ = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, 8<<20)
+
+	dst := net.ParseIP(s.host).To4()
+	var sa syscall.SockaddrInet4
+	copy(sa.Addr[:], dst)
+
+	// The kernel can set the ttl and type of service of the probes it sends for us.
+	hdr := s.opts.IPHeader
+	if hdr.TTL > 0 {
+		if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_TTL, hdr.TTL); err != nil {
+			return xerrors.Errorf("failed to set the ttl: %w", err)
+		}
+	}
+	if hdr.TOS > 0 {
+		if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_TOS, hdr.TOS); err != nil {
+			return xerrors.Errorf("failed to set the type of service: %w", err)
+		}
+	}
+
+	// senders are who every probe goes out as, just us without decoys. The decoys'
+	// probes, and fragments of any probe, carry an ip header of our own so they can
+	// claim to come from someone else or come in pieces. The replies to the decoys'
+	// probes never come back to us.
+	senders, hdrFD := Decoys{nil}, -1
+	if len(s.opts.Decoys) > 0 {
+		senders = s.opts.Decoys
+	}
+	if len(s.opts.Decoys) > 0 || hdr.FragmentSize > 0 {
+		if hdrFD, err = syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, syscall.IPPROTO_RAW); err != nil {
+			return xerrors.Errorf("failed to open a raw socket for our own ip headers: %w", err)
+		}
+		defer syscall.Close(hdrFD)
+		if iface := s.opts.Sources.device(); iface != "" {
+			if err := bindFDToDevice(hdrFD, iface); err != nil {
+				return err
+			}
+		}
+		if s.opts.Mark != 0 {
+			if err := markFD(hdrFD, s.opts.Mark); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Replies are told apart from everything else the raw socket sees by
+	// the port we sent from, so lets pick one out of the ephemeral range.
+	srcPort := uint16(32768 + rand.Intn(28232))
+
+	// Firing probes as fast as we can write them floods the receive buffer with
+	// replies faster than we can read them, so lets cap how many are in flight.
+	// A slot frees up once the probe is answered or times out.
+	inFlight := make(chan struct{}, s.opts.Concurrency)
+	var mu sync.Mutex
+	attempts := make(map[int]*synAttempt)
+	// answered is kept by port rather than by attempt, both the SYN and its
+	// retransmit get a SYN-ACK, open ports resend theirs until we reset them
+	// and a reply to an earlier try can turn up after a retry went out.
+	answered := make(map[int]bool)
+	release := func(attempt *synAttempt) {
+		if !attempt.released {
+			attempt.released = true
+			<-inFlight
+			s.opts.InFlight.release()
+		}
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		buf := make([]byte, 65535)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			n, _, err := syscall.Recvfrom(fd, buf, 0)
+			if err != nil {
+				continue
+			}
+
+			port, state, outcome, ok := p.reply(buf[:n], dst, srcPort)
+			if !ok {
+				continue
+			}
+
+			mu.Lock()
+			attempt := attempts[port]
+			if attempt != nil && !answered[port] {
+				answered[port] = true
+				release(attempt)
+				s.opts.Audit.recordSYN(attempt.sent, src, net.JoinHostPort(s.host, strconv.Itoa(port)), outcome)
+				if state == StateClosed {
+					s.reject(PortResult{Port: port, Attempts: attempt.try + 1}, "refused")
+				} else {
+					s.add(PortResult{Port: port, State: state, Latency: time.Since(attempt.sent), Attempts: attempt.try + 1})
+				}
+				s.finish(port)
+			}
+			mu.Unlock()
+		}
+	}()
+
+	send := func(port int) {
+		// Sendto writes into the sockaddr it's given, and retransmits go out
+		// alongside new probes, so every send gets a copy of its own.
+		to := sa
+		for _, from := range senders {
+			if from == nil && hdr.FragmentSize == 0 {
+				if err := syscall.Sendto(fd, p.packet(src, dst, srcPort, uint16(port)), 0, &to); err != nil && s.opts.RawErrors {
+					dumpRawError(port, err)
+				}
+				continue
+			}
+
+			if from == nil {
+				from = src
+			}
+			for _, pkt := range ipv4Packets(from, dst, p.proto, p.packet(from, dst, srcPort, uint16(port)), hdr) {
+				if err := syscall.Sendto(hdrFD, pkt, 0, &to); err != nil && s.opts.RawErrors {
+					dumpRawError(port, err)
+				}
+			}
+		}
+	}
+
+	// A connect gets its SYN retransmitted by the kernel, ours are on their
+	// own, so a single lost packet would have the port reported filtered.
+	// Lets resend every probe still unanswered half way through its timeout,
+	// as part of the same try. Once stopped is set the sockets are about to
+	// be closed, so nothing is resent anymore.
+	var (
+		stopped bool
+		sending sync.WaitGroup
+	)
+	retransmit := func(port int, attempt *synAttempt) {
+		mu.Lock()
+		if stopped || answered[port] || attempts[port] != attempt {
+			mu.Unlock()
+			return
+		}
+		sending.Add(1)
+		mu.Unlock()
+		defer sending.Done()
+
+		if s.opts.Rate.wait(ctx) != nil || s.opts.Jitter.wait(ctx) != nil {
+			return
+		}
+		s.sent(attempt.try + 1)
+		send(port)
+	}
+
+	pending := s.opts.Ports
+	outOfBudget := make(map[int]bool)
+	for try := 0; try <= s.opts.Retry.Retries && len(pending) > 0 && ctx.Err() == nil; try++ {
+		if try > 0 {
+			select {
+			case <-time.After(s.opts.Retry.wait(try - 1)):
+			case <-ctx.Done():
+			}
+		}
+
+		for _, port := range pending {
+			if ctx.Err() != nil {
+				break
+			}
+
+			// The port may have answered an earlier try since the round ended.
+			mu.Lock()
+			skip := answered[port]
+			mu.Unlock()
+			if skip {
+				continue
+			}
+
+			if try == 0 {
+				// Probes are fire and forget, so the first one
+				// going out is as done as a port gets here.
+				atomic.AddInt64(&s.scanned, 1)
+			}
+
+			// A port the budget ran out on before it answered is left unscanned,
+			// not called filtered for the tries that did go out.
+			if !s.opts.Budget.take() {
+				s.skip(port)
+				outOfBudget[port] = true
+				continue
+			}
+
+			if err := s.opts.Rate.wait(ctx); err != nil {
+				continue
+			}
+			if err := s.opts.Jitter.wait(ctx); err != nil {
+				continue
+			}
+
+			select {
+			case inFlight <- struct{}{}:
+			case <-ctx.Done():
+				continue
+			}
+			if s.opts.InFlight.acquire(ctx) != nil {
+				<-inFlight
+				continue
+			}
+
+			attempt := &synAttempt{sent: time.Now(), try: try}
+			s.sent(try)
+			mu.Lock()
+			attempts[port] = attempt
+			mu.Unlock()
+			port := port
+			time.AfterFunc(s.opts.Timeout/2, func() { retransmit(port, attempt) })
+			time.AfterFunc(s.opts.Timeout, func() {
+				mu.Lock()
+				release(attempt)
+				mu.Unlock()
+			})
+			send(port)
+		}
+
+		// Give the replies to our last probes a chance to arrive.
+		select {
+		case <-time.After(s.opts.Timeout):
+		case <-ctx.Done():
+		}
+
+		mu.Lock()
+		var unanswered []int
+		for _, port := range pending {
+			if attempts[port] != nil && !answered[port] && !outOfBudget[port] {
+				unanswered = append(unanswered, port)
+			}
+		}
+		mu.Unlock()
+		pending = unanswered
+	}
+
+	mu.Lock()
+	stopped = true
+	mu.Unlock()
+	sending.Wait()
+	close(done)
+	wg.Wait()
+
+	for _, port := range pending {
+		// Replies keep coming in until the receiver stops, so a port
+		// may have been answered since the last round ended.
+		attempt := attempts[port]
+		if attempt == nil || answered[port] {
+			continue
+		}
+
+		// Ports we stopped waiting on early could still have answered.
+		switch {
+		case ctx.Err() != nil:
+			s.fail("timeout")
+		case p.silence == StateOpenFiltered:
+			s.finish(port)
+			s.add(PortResult{Port: port, State: StateOpenFiltered, Attempts: attempt.try + 1})
+		default:
+			s.finish(port)
+			s.reject(PortResult{Port: port, Attempts: attempt.try + 1}, "timeout")
+		}
+		s.opts.Audit.recordSYN(attempt.sent, src, net.JoinHostPort(s.host, strconv.Itoa(port)), "timeout")
+		if s.opts.RawErrors && p.silence != StateOpenFiltered {
+			dumpRawError(port, xerrors.Errorf("no reply to %s", p.name))
+		}
+	}
+	return nil
+}
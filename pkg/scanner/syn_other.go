@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+package scanner
+
+import "context"
+
+// Outside of linux raw sockets either don't exist or never see the
+// replies to our probes, so raw scans aren't supported there.
+func CheckSYN() error {
+	return unsupported("SYN scans are only supported on linux")
+}
+
+func CheckSCTP() error {
+	return unsupported("SCTP scans are only supported on linux")
+}
+
+func (s *Scanner) rawScan(_ context.Context, p rawProbe) error {
+	return unsupported(p.name + " scans are only supported on linux")
+}
@@ -0,0 +1,108 @@
+package scanner
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// TLSInfo is what the handshake with a port and the certificate it served tell us.
+type TLSInfo struct {
+	// Version is the negotiated protocol version, like "TLS 1.3".
+	Version string
+	// CipherSuite is the name of the negotiated cipher suite.
+	CipherSuite string
+	Subject     string
+	Issuer      string
+	// SANs are the dns names and ip addresses the certificate is valid for.
+	SANs      []string
+	NotBefore time.Time
+	NotAfter  time.Time
+	// SelfSigned is set when the certificate is signed by its own key.
+	SelfSigned bool
+	// VerifyError says why the certificate wouldn't be trusted for serverName,
+	// empty when it chains up to a system root and covers the name.
+	VerifyError string
+}
+
+// InspectTLS hand-shakes TLS with port and reports the negotiated parameters and the leaf certificate.
+// serverName is sent as SNI so virtual hosts serve the right certificate, leave it empty for ip targets.
+// The certificate is accepted whatever it is, VerifyError says whether it would have been trusted.
+func (s *Scanner) InspectTLS(ctx context.Context, port int, serverName string) (TLSInfo, error) {
+	conn, err := s.dial(ctx, port)
+	if err != nil {
+		return TLSInfo{}, xerrors.Errorf("failed to connect: %w", err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(s.opts.Timeout)); err != nil {
+		return TLSInfo{}, xerrors.Errorf("failed to set deadline: %w", err)
+	}
+	defer watchConn(ctx, conn)()
+
+	tlsConn := tls.Client(conn, &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+	})
+	if err := tlsConn.Handshake(); err != nil {
+		return TLSInfo{}, xerrors.Errorf("tls handshake failed: %w", err)
+	}
+
+	state := tlsConn.ConnectionState()
+	info := TLSInfo{
+		Version:     tlsVersionName(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+	}
+	if len(state.PeerCertificates) == 0 {
+		return info, xerrors.New("no certificate was served")
+	}
+
+	leaf := state.PeerCertificates[0]
+	info.Subject = leaf.Subject.String()
+	info.Issuer = leaf.Issuer.String()
+	info.NotBefore = leaf.NotBefore
+	info.NotAfter = leaf.NotAfter
+	info.SANs = append(info.SANs, leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		info.SANs = append(info.SANs, ip.String())
+	}
+	info.SelfSigned = leaf.CheckSignatureFrom(leaf) == nil
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	name := serverName
+	if name == "" {
+		name = s.hostname()
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{DNSName: name, Intermediates: intermediates}); err != nil {
+		info.VerifyError = err.Error()
+	}
+	return info, nil
+}
+
+// IsHostname reports whether host is a name rather than an ip literal, only names are sent as SNI.
+func IsHostname(host string) bool {
+	addr, _ := SplitZone(host)
+	return net.ParseIP(addr) == nil
+}
+
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
@@ -0,0 +1,75 @@
+package scanner
+
+import (
+	"bufio"
+	_ "embed"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/xerrors"
+)
+
+//go:embed topports.txt
+var topPortsFile string
+
+var (
+	rankingOnce sync.Once
+	// ranking is every port, most likely to be open first.
+	ranking []int
+)
+
+// TopPorts returns the n tcp ports most likely to be open, sorted by port number.
+// Scanning the top 100 finds far more than the first 100 sequential ports would,
+// since popular services like mysql(3306) and redis(6379) live well past 1024.
+func TopPorts(n int) ([]int, error) {
+	if n < 1 || n > AllPorts {
+		return nil, xerrors.Errorf("%d is not between 1 and %d", n, AllPorts)
+	}
+
+	rankingOnce.Do(func() { ranking = rankPorts(topPortsFile, servicesFile) })
+	ports := append([]int(nil), ranking[:n]...)
+	sort.Ints(ports)
+	return ports, nil
+}
+
+// rankPorts ranks every port, starting with the ones listed in top.
+// After those come the tcp ports with a name in the services table
+// and after those everything else, both in numeric order.
+func rankPorts(top, services string) []int {
+	ranked := make([]int, 0, AllPorts)
+	seen := make(map[int]bool)
+	add := func(port int) {
+		if !seen[port] {
+			seen[port] = true
+			ranked = append(ranked, port)
+		}
+	}
+
+	lines := bufio.NewScanner(strings.NewReader(top))
+	for lines.Scan() {
+		line := strings.TrimSpace(lines.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if port, err := parsePort(line); err == nil {
+			add(port)
+		}
+	}
+
+	var named []int
+	for portProto := range parseServices(services) {
+		if port, err := parsePort(strings.TrimSuffix(portProto, "/tcp")); err == nil {
+			named = append(named, port)
+		}
+	}
+	sort.Ints(named)
+	for _, port := range named {
+		add(port)
+	}
+
+	for port := 1; port <= AllPorts; port++ {
+		add(port)
+	}
+	return ranked
+}
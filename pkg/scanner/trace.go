@@ -0,0 +1,175 @@
+package scanner
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// A trace sends probes to a port with the ttl going up one hop at a time.
+// Every router a probe runs out of ttl at tells us so with an ICMP time
+// exceeded quoting the probe back at us, which is how we know who it came
+// from. Once a probe makes it all the way the target answers it itself:
+//   - tcp probes are SYNs, answered with a SYN-ACK or a RST
+//   - udp probes are answered with a port unreachable by closed ports
+//   - icmp probes are echo requests, answered with an echo reply
+//
+// A firewall dropping the probes shows up as the hops going silent past it,
+// one rejecting them as a hop answering with an unreachable.
+
+// Defaults for the TraceOptions that aren't set.
+const (
+	DefaultTraceMaxHops = 30
+	DefaultTraceQueries = 3
+	DefaultTraceTimeout = time.Second
+)
+
+// traceSilentHops is how many hops in a row can leave every probe unanswered
+// before we give up on the rest, past a firewall dropping them they all would.
+const traceSilentHops = 5
+
+const (
+	ipProtoICMP = 1
+	ipProtoUDP  = 17
+
+	icmpUnreachable  = 3
+	icmpTimeExceeded = 11
+)
+
+// TraceOptions configures a trace.
+type TraceOptions struct {
+	// Protocol is what the probes are sent over, "tcp", "udp" or "icmp".
+	Protocol string
+	// Port the tcp and udp probes are sent to.
+	Port int
+	// MaxHops is the highest ttl probed, defaults to DefaultTraceMaxHops.
+	MaxHops int
+	// Queries is how many probes are sent per hop, defaults to DefaultTraceQueries.
+	Queries int
+	// Timeout for the reply to each probe, defaults to DefaultTraceTimeout.
+	Timeout time.Duration
+	// Hop is called with every hop as soon as its probes are done, if set.
+	Hop func(Hop)
+}
+
+// Hop is what the probes sent with a single ttl came back with.
+type Hop struct {
+	TTL int
+	// Addr is who answered the probes, nil when nobody did.
+	Addr net.IP
+	// RTTs holds the round trip of every answered probe.
+	RTTs []time.Duration
+	// Reply is how the probes were answered, e.g. "ttl-exceeded", "syn-ack",
+	// "reset", "echo-reply", "port-unreachable" or "admin-prohibited".
+	Reply string
+	// Reached is set when the target itself answered.
+	Reached bool
+}
+
+func (o *TraceOptions) setDefaults() {
+	if o.MaxHops <= 0 {
+		o.MaxHops = DefaultTraceMaxHops
+	}
+	if o.Queries <= 0 {
+		o.Queries = DefaultTraceQueries
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultTraceTimeout
+	}
+}
+
+// traceSource returns the address the kernel would send probes to dst from.
+func traceSource(dst net.IP) (net.IP, error) {
+	conn, err := net.Dial("udp4", net.JoinHostPort(dst.String(), "9"))
+	if err != nil {
+		return nil, xerrors.Errorf("failed to find a route to %s: %w", dst, err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.To4(), nil
+}
+
+// udpProbePacket builds an empty UDP datagram from srcPort to dstPort. The checksum
+// is optional over ipv4, so it's left out and the kernel fills in the IP header.
+func udpProbePacket(srcPort, dstPort uint16) []byte {
+	pkt := make([]byte, 8)
+	binary.BigEndian.PutUint16(pkt[0:], srcPort)
+	binary.BigEndian.PutUint16(pkt[2:], dstPort)
+	binary.BigEndian.PutUint16(pkt[4:], 8)
+	return pkt
+}
+
+// quotedProbe is the start of one of our probes as an ICMP error quotes it back.
+type quotedProbe struct {
+	proto byte
+	dst   net.IP
+	// srcPort is the echo identifier of icmp probes and dstPort their sequence number.
+	srcPort, dstPort uint16
+}
+
+// parseICMPError picks a time exceeded or an unreachable out of a raw ipv4 packet.
+// It returns who sent it, its type and code and the probe it quotes.
+func parseICMPError(pkt []byte) (from net.IP, typ, code byte, probe quotedProbe, ok bool) {
+	if len(pkt) < 20 || pkt[0]>>4 != 4 || pkt[9] != ipProtoICMP {
+		return nil, 0, 0, quotedProbe{}, false
+	}
+	ihl := int(pkt[0]&0x0f) * 4
+	msg := pkt[ihl:]
+	// The error is 8 bytes followed by the ip header and first 8 bytes of the probe.
+	if len(msg) < 8+20 || (msg[0] != icmpTimeExceeded && msg[0] != icmpUnreachable) {
+		return nil, 0, 0, quotedProbe{}, false
+	}
+
+	quoted := msg[8:]
+	qihl := int(quoted[0]&0x0f) * 4
+	if quoted[0]>>4 != 4 || len(quoted) < qihl+8 {
+		return nil, 0, 0, quotedProbe{}, false
+	}
+	probe = quotedProbe{
+		proto: quoted[9],
+		dst:   net.IP(quoted[16:20]),
+	}
+	inner := quoted[qihl:]
+	if probe.proto == ipProtoICMP {
+		probe.srcPort = binary.BigEndian.Uint16(inner[4:])
+		probe.dstPort = binary.BigEndian.Uint16(inner[6:])
+	} else {
+		probe.srcPort = binary.BigEndian.Uint16(inner[0:])
+		probe.dstPort = binary.BigEndian.Uint16(inner[2:])
+	}
+	return net.IP(pkt[12:16]), msg[0], msg[1], probe, true
+}
+
+// parseEchoReply picks the reply to one of our echo requests out of a raw ipv4
+// packet and returns its sequence number.
+func parseEchoReply(pkt []byte, from net.IP, id uint16) (seq uint16, ok bool) {
+	if len(pkt) < 20 || pkt[0]>>4 != 4 || pkt[9] != ipProtoICMP {
+		return 0, false
+	}
+	ihl := int(pkt[0]&0x0f) * 4
+	if len(pkt) < ihl+8 || !net.IP(pkt[12:16]).Equal(from) || !isEchoReply(pkt[ihl:], id) {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(pkt[ihl+6:]), true
+}
+
+// icmpErrorReply names an ICMP error the way Hop.Reply reports it.
+func icmpErrorReply(typ, code byte) string {
+	if typ == icmpTimeExceeded {
+		return "ttl-exceeded"
+	}
+	switch code {
+	case 0:
+		return "net-unreachable"
+	case 1:
+		return "host-unreachable"
+	case 2:
+		return "protocol-unreachable"
+	case 3:
+		return "port-unreachable"
+	case 9, 10, 13:
+		return "admin-prohibited"
+	}
+	return "unreachable"
+}
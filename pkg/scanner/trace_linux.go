@@ -0,0 +1,301 @@
+package scanner
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// CheckTrace makes sure we're allowed to open the raw sockets a trace over protocol needs.
+func CheckTrace(protocol string) error {
+	protos := []int{ipProtoICMP}
+	switch protocol {
+	case "tcp":
+		protos = append(protos, ipProtoTCP)
+	case "udp":
+		protos = append(protos, ipProtoUDP)
+	case "icmp":
+	default:
+		return xerrors.Errorf("%q is an unsupported trace protocol", protocol)
+	}
+
+	for _, proto := range protos {
+		fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, proto)
+		if err != nil {
+			return xerrors.Errorf("traceroute needs raw sockets(run as root or grant CAP_NET_RAW): %w", err)
+		}
+		_ = syscall.Close(fd)
+	}
+	return nil
+}
+
+// traceReply is the answer to the n'th probe of a trace.
+type traceReply struct {
+	n       int
+	from    net.IP
+	reply   string
+	reached bool
+	at      time.Time
+}
+
+// Trace probes the path to ip one ttl at a time and returns every hop along it,
+// up to the one the target answered at. It gives up early once the probes stop
+// getting anywhere, see traceSilentHops. The probes are raw packets like the
+// ones a SYN scan sends, so it needs the same privileges.
+func Trace(ctx context.Context, ip net.IP, opts TraceOptions) ([]Hop, error) {
+	dst := ip.To4()
+	if dst == nil {
+		return nil, xerrors.Errorf("traceroute only supports ipv4 targets, got %s", ip)
+	}
+	opts.setDefaults()
+	if opts.MaxHops > 255 {
+		return nil, xerrors.Errorf("a ttl only goes up to 255, got %d max hops", opts.MaxHops)
+	}
+
+	src, err := traceSource(dst)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every router answers on icmp, whatever the probes are sent over.
+	icmpFD, err := openTraceSocket(ipProtoICMP)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.Close(icmpFD)
+
+	probeFD := icmpFD
+	switch opts.Protocol {
+	case "tcp", "udp":
+		proto := ipProtoTCP
+		if opts.Protocol == "udp" {
+			proto = ipProtoUDP
+		}
+		if probeFD, err = openTraceSocket(proto); err != nil {
+			return nil, err
+		}
+		defer syscall.Close(probeFD)
+	case "icmp":
+	default:
+		return nil, xerrors.Errorf("%q is an unsupported trace protocol", opts.Protocol)
+	}
+
+	// Probes are told apart by their source port, or the sequence number of the
+	// echo requests, so the reply to one that took too long isn't mistaken for the next.
+	total := opts.MaxHops * opts.Queries
+	if total > 20000 {
+		return nil, xerrors.Errorf("can't send more than 20000 probes, got %d hops of %d", opts.MaxHops, opts.Queries)
+	}
+	basePort := 32768 + rand.Intn(28232-total)
+	id := uint16(os.Getpid())
+	packet := func(n int) []byte {
+		srcPort := uint16(basePort + n)
+		switch opts.Protocol {
+		case "tcp":
+			return probePacket(src, dst, srcPort, uint16(opts.Port), rand.Uint32(), tcpFlagSYN)
+		case "udp":
+			return udpProbePacket(srcPort, uint16(opts.Port))
+		}
+		return echoRequest(id, uint16(n))
+	}
+
+	// probe returns which probe an ICMP error quotes, if it's one of ours.
+	probe := func(q quotedProbe) (int, bool) {
+		if !q.dst.Equal(dst) {
+			return 0, false
+		}
+		n := int(q.dstPort)
+		switch opts.Protocol {
+		case "tcp", "udp":
+			if (opts.Protocol == "tcp") != (q.proto == ipProtoTCP) || int(q.dstPort) != opts.Port {
+				return 0, false
+			}
+			n = int(q.srcPort) - basePort
+		default:
+			if q.proto != ipProtoICMP || q.srcPort != id {
+				return 0, false
+			}
+		}
+		return n, n >= 0 && n < total
+	}
+
+	var (
+		current int32
+		replies = make(chan traceReply, 16)
+		done    = make(chan struct{})
+		wg      sync.WaitGroup
+		deliver = func(r traceReply) {
+			select {
+			case replies <- r:
+			case <-done:
+			}
+		}
+	)
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		readRaw(icmpFD, done, func(pkt []byte) {
+			if opts.Protocol == "icmp" {
+				if seq, ok := parseEchoReply(pkt, dst, id); ok {
+					deliver(traceReply{n: int(seq), from: dst, reply: "echo-reply", reached: true, at: time.Now()})
+					return
+				}
+			}
+
+			from, typ, code, q, ok := parseICMPError(pkt)
+			if !ok {
+				return
+			}
+			n, ok := probe(q)
+			if !ok {
+				return
+			}
+			// The target telling us the port's closed is as good as an answer.
+			deliver(traceReply{n: n, from: from, reply: icmpErrorReply(typ, code), reached: from.Equal(dst), at: time.Now()})
+		})
+	}()
+
+	if opts.Protocol == "tcp" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			readRaw(probeFD, done, func(pkt []byte) {
+				n := int(atomic.LoadInt32(&current))
+				port, flags, ok := parseSYNReply(pkt, dst, uint16(basePort+n))
+				if !ok || port != opts.Port {
+					return
+				}
+				reply := "reset"
+				if flags&tcpFlagSYN != 0 {
+					reply = "syn-ack"
+				}
+				deliver(traceReply{n: n, from: dst, reply: reply, reached: true, at: time.Now()})
+			})
+		}()
+	}
+
+	var sa syscall.SockaddrInet4
+	copy(sa.Addr[:], dst)
+
+	var hops []Hop
+	silent := 0
+	n := 0
+	for ttl := 1; ttl <= opts.MaxHops; ttl++ {
+		if err := syscall.SetsockoptInt(probeFD, syscall.IPPROTO_IP, syscall.IP_TTL, ttl); err != nil {
+			return hops, xerrors.Errorf("failed to set the ttl: %w", err)
+		}
+
+		hop := Hop{TTL: ttl}
+		for q := 0; q < opts.Queries; q, n = q+1, n+1 {
+			if err := ctx.Err(); err != nil {
+				return hops, err
+			}
+
+			atomic.StoreInt32(&current, int32(n))
+			sent := time.Now()
+			if err := syscall.Sendto(probeFD, packet(n), 0, &sa); err != nil {
+				return hops, xerrors.Errorf("failed to send probe: %w", err)
+			}
+
+			r, ok, err := awaitTraceReply(ctx, replies, n, opts.Timeout)
+			if err != nil {
+				return hops, err
+			}
+			if !ok {
+				continue
+			}
+			if hop.Addr == nil {
+				hop.Addr = r.from
+				hop.Reply = r.reply
+			}
+			hop.RTTs = append(hop.RTTs, r.at.Sub(sent))
+			hop.Reached = hop.Reached || r.reached
+		}
+
+		hops = append(hops, hop)
+		if opts.Hop != nil {
+			opts.Hop(hop)
+		}
+
+		switch {
+		case hop.Reached:
+			return hops, nil
+		case hop.Addr == nil:
+			silent++
+			if silent >= traceSilentHops {
+				return hops, nil
+			}
+		case hop.Reply != "ttl-exceeded":
+			// A router rejecting the probes means they won't get any further.
+			return hops, nil
+		default:
+			silent = 0
+		}
+	}
+	return hops, nil
+}
+
+// awaitTraceReply waits up to timeout for the reply to the n'th probe,
+// late replies to the probes before it are dropped.
+func awaitTraceReply(ctx context.Context, replies <-chan traceReply, n int, timeout time.Duration) (traceReply, bool, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case r := <-replies:
+			if r.n == n {
+				return r, true, nil
+			}
+		case <-timer.C:
+			return traceReply{}, false, nil
+		case <-ctx.Done():
+			return traceReply{}, false, ctx.Err()
+		}
+	}
+}
+
+func openTraceSocket(proto int) (int, error) {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, proto)
+	if err != nil {
+		return 0, xerrors.Errorf("failed to open a raw socket: %w", err)
+	}
+
+	// Recvfrom has no context, so lets have it wake up regularly
+	// to check whether we're done listening.
+	tv := syscall.NsecToTimeval(int64(100 * time.Millisecond))
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		_ = syscall.Close(fd)
+		return 0, xerrors.Errorf("failed to set receive timeout: %w", err)
+	}
+	return fd, nil
+}
+
+// readRaw hands every packet read from fd to handle until done is closed.
+func readRaw(fd int, done <-chan struct{}, handle func(pkt []byte)) {
+	buf := make([]byte, 65535)
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			continue
+		}
+		handle(buf[:n])
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package scanner
+
+import (
+	"context"
+	"net"
+)
+
+// A trace needs the same raw sockets as a SYN scan, which only linux gives us.
+func CheckTrace(string) error {
+	return unsupported("traceroute is only supported on linux")
+}
+
+func Trace(context.Context, net.IP, TraceOptions) ([]Hop, error) {
+	return nil, unsupported("traceroute is only supported on linux")
+}
@@ -0,0 +1,92 @@
+package scanner
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// UDP has no handshake so there's no equivalent of a successful connect.
+// Instead we send a datagram, a request the service would answer where
+// we have one(see udpPayloads), and see what comes back:
+//   - a reply means something is listening
+//   - an ICMP port-unreachable surfaces as a refused read on a connected socket
+//   - silence could mean either a quiet service or a firewall eating our datagram
+func (s *Scanner) probeUDP(ctx context.Context, port int) PortResult {
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		state, err := s.sendUDP(ctx, port)
+		result := PortResult{Port: port, State: state, Attempts: attempt + 1}
+		// Only a reply gives us a round trip to measure.
+		if state == StateOpen {
+			result.Latency = time.Since(start)
+		} else if err != nil {
+			result.Failure = dialOutcome(err)
+		}
+
+		// Silence before the budget ran out isn't an answer either.
+		if xerrors.Is(err, ErrBudgetExhausted) {
+			s.skip(port)
+			return PortResult{Port: port, State: StateClosed}
+		}
+		if ctx.Err() == nil {
+			s.sent(attempt)
+		}
+
+		if err != nil && s.opts.RawErrors && ctx.Err() == nil {
+			dumpRawError(port, err)
+		}
+
+		// Datagrams get dropped all the time, so silence is worth retrying.
+		if state != StateOpenFiltered || attempt >= s.opts.Retry.Retries || ctx.Err() != nil {
+			if state == StateClosed && err != nil && ctx.Err() == nil {
+				outcome := dialOutcome(err)
+				s.fail(outcome)
+				// Same as over tcp, we never got to ask the port.
+				if outcome == "exhausted" {
+					s.skip(port)
+				}
+			}
+			return result
+		}
+
+		select {
+		case <-time.After(s.opts.Retry.wait(attempt)):
+		case <-ctx.Done():
+			// The retries that were still due never went out either.
+			return PortResult{Port: port, State: StateClosed}
+		}
+	}
+}
+
+func (s *Scanner) sendUDP(ctx context.Context, port int) (State, error) {
+	conn, err := s.dial(ctx, port)
+	if err != nil {
+		return StateClosed, err
+	}
+	defer conn.Close()
+	defer watchConn(ctx, conn)()
+
+	if err := conn.SetDeadline(time.Now().Add(s.opts.Timeout)); err != nil {
+		return StateClosed, xerrors.Errorf("failed to set deadline: %w", err)
+	}
+
+	if _, err := conn.Write(udpPayload(port)); err != nil {
+		return StateClosed, xerrors.Errorf("failed to send datagram: %w", err)
+	}
+
+	buf := make([]byte, 1)
+	_, err = conn.Read(buf)
+	switch {
+	case err == nil:
+		return StateOpen, nil
+	case isRefused(err):
+		return StateClosed, err
+	// Cancelling the scan cut the wait short, the port is left to be scanned
+	// again rather than called open|filtered.
+	case ctx.Err() != nil:
+		return StateClosed, ctx.Err()
+	}
+	return StateOpenFiltered, err
+}
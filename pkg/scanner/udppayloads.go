@@ -0,0 +1,78 @@
+package scanner
+
+import "strings"
+
+// An empty datagram is something almost no UDP service answers, which leaves
+// every port open|filtered. These payloads are well formed requests for what's
+// usually listening on the port, so a live service has something to reply to.
+// Like the auth probes they only ever ask, they never change anything.
+var udpPayloads = map[int][]byte{
+	// A query for the root's name servers, even a resolver refusing us answers.
+	53: dnsQuery(".", 2),
+	// A tftp read request for a file nobody has, answered with a file-not-found error.
+	69: append([]byte{0, 1}, "port-scanner\x00octet\x00"...),
+	// An ntp v3 client request.
+	123: append([]byte{0x1b}, make([]byte, 47)...),
+	// A netbios node status request for the wildcard name.
+	137: netbiosStatusQuery(),
+	// An snmp v1 get of sysDescr.0 with the public community.
+	161: {
+		0x30, 0x29, // sequence
+		0x02, 0x01, 0x00, // version 1
+		0x04, 0x06, 'p', 'u', 'b', 'l', 'i', 'c', // community
+		0xa0, 0x1c, // get-request
+		0x02, 0x04, 0x00, 0x00, 0x00, 0x01, // request id
+		0x02, 0x01, 0x00, // error status
+		0x02, 0x01, 0x00, // error index
+		0x30, 0x0e, 0x30, 0x0c, // varbind list with a single varbind
+		0x06, 0x08, 0x2b, 0x06, 0x01, 0x02, 0x01, 0x01, 0x01, 0x00, // 1.3.6.1.2.1.1.1.0
+		0x05, 0x00, // null
+	},
+	// An ssdp search, answered by upnp devices.
+	1900: []byte("M-SEARCH * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nMAN: \"ssdp:discover\"\r\nMX: 1\r\nST: ssdp:all\r\n\r\n"),
+	// A unicast mdns query for the services a responder advertises.
+	5353: dnsQuery("_services._dns-sd._udp.local", 12),
+	// A memcached stats command behind the udp frame header.
+	11211: append([]byte{0, 0, 0, 0, 0, 1, 0, 0}, "stats\r\n"...),
+}
+
+// udpPayload returns what to send a udp port, nil for ports we don't have a payload for.
+func udpPayload(port int) []byte {
+	return udpPayloads[port]
+}
+
+// dnsQuery builds a recursive dns query for name of type qtype.
+func dnsQuery(name string, qtype uint16) []byte {
+	msg := []byte{
+		0x13, 0x37, // id
+		0x01, 0x00, // recursion desired
+		0x00, 0x01, // one question
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	for _, label := range strings.Split(strings.Trim(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	return append(msg, byte(qtype>>8), byte(qtype), 0x00, 0x01) // IN
+}
+
+// netbiosStatusQuery builds a netbios NBSTAT query for "*".
+func netbiosStatusQuery() []byte {
+	msg := []byte{
+		0x13, 0x37, // id
+		0x00, 0x00, // flags
+		0x00, 0x01, // one question
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x20, // the encoded name is always 32 bytes
+	}
+	// Names are padded to 16 bytes and every nibble is encoded as a letter from A.
+	name := append([]byte{'*'}, make([]byte, 15)...)
+	for _, b := range name {
+		msg = append(msg, 'A'+b>>4, 'A'+b&0x0f)
+	}
+	return append(msg, 0x00, 0x00, 0x21, 0x00, 0x01) // NBSTAT, IN
+}
@@ -0,0 +1,157 @@
+package scanner
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// MaxVerifyHold is the longest an Options.Verify scan holds a connection
+// waiting on a reset. Middleboxes that accept for a backend that isn't
+// there reset within a round trip or two of hearing back from it.
+const MaxVerifyHold = 500 * time.Millisecond
+
+// VerifyResult sums up the second round of probes of an Options.Verify scan.
+type VerifyResult struct {
+	// Dropped are the ports found open that didn't hold up the second time,
+	// they're left out of Result.Ports or kept as closed or filtered with
+	// Options.AllStates.
+	Dropped []int
+	// Sampled are the refused ports probed again, Reopened the ones among
+	// them that accepted the second time. Those stay closed, but any at all
+	// mean the first round was refused by something other than the host.
+	Sampled  []int
+	Reopened []int
+}
+
+// verify probes every open port again along with a sample of the refused
+// ones, and drops the open ports that don't hold up.
+func (s *Scanner) verify(ctx context.Context) *VerifyResult {
+	s.mu.Lock()
+	var open []int
+	for _, p := range s.ports {
+		if p.State == StateOpen {
+			open = append(open, p.Port)
+		}
+	}
+	var sampled []int
+	if s.opts.VerifySample > 0 {
+		sampled = SamplePorts(s.refused, s.opts.VerifySample, time.Now().UnixNano())
+	}
+	s.mu.Unlock()
+
+	res := &VerifyResult{Sampled: sampled}
+	held := make(map[int]string, len(open)+len(sampled))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	ports := make(chan int)
+	workers := s.opts.Concurrency
+	if n := len(open) + len(sampled); workers > n {
+		workers = n
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for port := range ports {
+				outcome := s.holds(ctx, port)
+				mu.Lock()
+				held[port] = outcome
+				mu.Unlock()
+			}
+		}()
+	}
+feed:
+	for _, port := range append(open, sampled...) {
+		select {
+		case ports <- port:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(ports)
+	wg.Wait()
+
+	for _, port := range sampled {
+		if outcome, ok := held[port]; ok && outcome == "" {
+			res.Reopened = append(res.Reopened, port)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.ports[:0]
+	for _, p := range s.ports {
+		// Ports we didn't get to again keep what the first round made of them.
+		outcome, ok := held[p.Port]
+		if p.State != StateOpen || !ok || outcome == "" {
+			kept = append(kept, p)
+			continue
+		}
+
+		res.Dropped = append(res.Dropped, p.Port)
+		s.failures[outcome]++
+		if s.opts.AllStates {
+			p.State, p.Latency, p.Failure = stateFor(outcome), 0, outcome
+			kept = append(kept, p)
+		}
+	}
+	s.ports = kept
+	sort.Ints(res.Dropped)
+	return res
+}
+
+// holds connects to port again and returns how that failed, or "" if it
+// didn't. A connection that gets reset right after being accepted fails as
+// refused, since whatever accepted it had nothing behind it. Verifying is
+// inconclusive once ctx is done or the budget runs out, which counts as held.
+func (s *Scanner) holds(ctx context.Context, port int) string {
+	conn, err := s.dial(ctx, port)
+	if xerrors.Is(err, ErrBudgetExhausted) || ctx.Err() != nil {
+		if conn != nil {
+			conn.Close()
+		}
+		return ""
+	}
+	s.sent(0)
+	if err != nil {
+		if s.opts.RawErrors {
+			dumpRawError(port, err)
+		}
+		return dialOutcome(err)
+	}
+	defer conn.Close()
+
+	// Confirming already made the other end answer over the connection.
+	level := levelFor(s.opts.ConfirmLevels, port)
+	if level != ConfirmConnect {
+		if err := confirm(ctx, conn, s.hostname(), level, s.opts.Timeout); err != nil && ctx.Err() == nil {
+			if s.opts.RawErrors {
+				dumpRawError(port, err)
+			}
+			return dialOutcome(err)
+		}
+		return ""
+	}
+
+	hold := s.opts.Timeout
+	if hold > MaxVerifyHold {
+		hold = MaxVerifyHold
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(hold)); err != nil {
+		return ""
+	}
+	defer watchConn(ctx, conn)()
+	// Anything but a reset, like the service greeting us or waiting on us
+	// to speak first, means something is there.
+	if _, err := conn.Read(make([]byte, 1)); err != nil && isReset(err) && ctx.Err() == nil {
+		if s.opts.RawErrors {
+			dumpRawError(port, err)
+		}
+		return "refused"
+	}
+	return ""
+}
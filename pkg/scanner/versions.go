@@ -0,0 +1,166 @@
+package scanner
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"golang.org/x/xerrors"
+)
+
+//go:embed versions.txt
+var versionsFile string
+
+// Version is the product a service identified itself as, and its version when it gave that away too.
+type Version struct {
+	Product string
+	Version string
+	// Info is anything else the service let on, like the distro that packaged it.
+	Info string
+}
+
+// versionMatch is a line of versions.txt.
+type versionMatch struct {
+	protocol string
+	pattern  *regexp.Regexp
+	product  string
+	version  string
+	info     string
+}
+
+var (
+	versionsOnce sync.Once
+	// versionMatches are the lines of versions.txt by protocol, in the order they're listed.
+	versionMatches map[string][]versionMatch
+)
+
+// matchVersion identifies the product that sent b from the fingerprints of protocol, nil if none match.
+func matchVersion(protocol string, b []byte) *Version {
+	versionsOnce.Do(func() { versionMatches = parseVersions(versionsFile) })
+	for _, m := range versionMatches[protocol] {
+		groups := m.pattern.FindSubmatch(b)
+		if groups == nil {
+			continue
+		}
+
+		v := &Version{
+			Product: expandVersion(m.product, groups),
+			Version: expandVersion(m.version, groups),
+			Info:    expandVersion(m.info, groups),
+		}
+		if v.Product == "" {
+			continue
+		}
+		return v
+	}
+	return nil
+}
+
+// expandVersion substitutes $1 to $9 in field with the groups of a match, made safe to print.
+func expandVersion(field string, groups [][]byte) string {
+	var b strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] == '$' && i+1 < len(field) && field[i+1] >= '1' && field[i+1] <= '9' {
+			if n := int(field[i+1] - '0'); n < len(groups) {
+				b.WriteString(banner(groups[n]))
+			}
+			i++
+			continue
+		}
+		b.WriteByte(field[i])
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// parseVersions parses the match lines of versions.txt. It's embedded, so a
+// line that doesn't parse is a bug and panics like a bad regexp.MustCompile would.
+func parseVersions(data string) map[string][]versionMatch {
+	matches := make(map[string][]versionMatch)
+	sc := bufio.NewScanner(strings.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		m, err := parseVersionMatch(line)
+		if err != nil {
+			panic(fmt.Sprintf("versions.txt:%d: %s", n, err))
+		}
+		matches[m.protocol] = append(matches[m.protocol], m)
+	}
+	return matches
+}
+
+func parseVersionMatch(line string) (versionMatch, error) {
+	var m versionMatch
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) != 3 || fields[0] != "match" {
+		return m, xerrors.Errorf("expected match <protocol> m|<regexp>|, got %q", line)
+	}
+	m.protocol = fields[1]
+
+	pattern, rest, ok := cutDelimited(fields[2], 'm')
+	if !ok {
+		return m, xerrors.Errorf("expected m|<regexp>|, got %q", fields[2])
+	}
+
+	flags := rest
+	if i := strings.IndexByte(rest, ' '); i >= 0 {
+		flags, rest = rest[:i], rest[i:]
+	} else {
+		rest = ""
+	}
+	if strings.Trim(flags, "ims") != "" {
+		return m, xerrors.Errorf("%q are unsupported flags(i, m and s)", flags)
+	}
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+
+	var err error
+	if m.pattern, err = regexp.Compile(pattern); err != nil {
+		return m, err
+	}
+
+	for rest = strings.TrimSpace(rest); rest != ""; rest = strings.TrimSpace(rest) {
+		field := rest[0]
+		value, next, ok := cutDelimited(rest, field)
+		if !ok {
+			return m, xerrors.Errorf("expected <field>/<value>/, got %q", rest)
+		}
+		rest = next
+
+		switch field {
+		case 'p':
+			m.product = value
+		case 'v':
+			m.version = value
+		case 'i':
+			m.info = value
+		default:
+			return m, xerrors.Errorf("%q is an unsupported field(p, v or i)", field)
+		}
+	}
+	if m.product == "" {
+		return m, xerrors.Errorf("no p/<product>/ in %q", line)
+	}
+	return m, nil
+}
+
+// cutDelimited splits s, which has to look like <kind><delim><value><delim><rest>,
+// into the value and what comes after it.
+func cutDelimited(s string, kind byte) (value, rest string, ok bool) {
+	if len(s) < 3 || s[0] != kind {
+		return "", "", false
+	}
+	delim := s[1]
+	end := strings.IndexByte(s[2:], delim)
+	if end < 0 {
+		return "", "", false
+	}
+	return s[2 : 2+end], s[3+end:], true
+}
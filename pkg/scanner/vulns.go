@@ -0,0 +1,268 @@
+package scanner
+
+import (
+	"bufio"
+	_ "embed"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/xerrors"
+)
+
+//go:embed vulns.txt
+var vulnsFile string
+
+// Advisory is a known vulnerability of a product.
+type Advisory struct {
+	// ID is the advisory's identifier, e.g. "CVE-2024-6387".
+	ID       string
+	Severity string
+	Summary  string
+}
+
+// AdvisoryDB is a set of advisories in the syntax of vulns.txt, looked up by product and version.
+type AdvisoryDB struct {
+	// entries are the advisories by lowercased product, in the order they're listed.
+	entries map[string][]advisoryEntry
+}
+
+type advisoryEntry struct {
+	Advisory
+	// affected are alternatives, a version is affected when all the constraints of one of them hold.
+	affected [][]versionConstraint
+}
+
+// versionConstraint is a single comparison like ">=8.5p1".
+type versionConstraint struct {
+	op      string
+	version string
+}
+
+// severities are the severities an advisory can have.
+var severities = map[string]bool{"low": true, "medium": true, "high": true, "critical": true}
+
+var (
+	advisoriesOnce sync.Once
+	advisories     *AdvisoryDB
+)
+
+// Advisories returns the advisories bundled with the scanner.
+func Advisories() *AdvisoryDB {
+	advisoriesOnce.Do(func() {
+		// It's embedded, so a line that doesn't parse is a bug.
+		db, err := ParseAdvisories("vulns.txt", vulnsFile)
+		if err != nil {
+			panic(err.Error())
+		}
+		advisories = db
+	})
+	return advisories
+}
+
+// ParseAdvisories parses advisories in the syntax of vulns.txt, the name is what errors point at.
+func ParseAdvisories(name, data string) (*AdvisoryDB, error) {
+	db := &AdvisoryDB{entries: make(map[string][]advisoryEntry)}
+	sc := bufio.NewScanner(strings.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		product, e, err := parseAdvisory(line)
+		if err != nil {
+			return nil, xerrors.Errorf("%s:%d: %w", name, n, err)
+		}
+		db.entries[product] = append(db.entries[product], e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, xerrors.Errorf("failed to read %s: %w", name, err)
+	}
+	return db, nil
+}
+
+func parseAdvisory(line string) (string, advisoryEntry, error) {
+	var e advisoryEntry
+	fields := strings.SplitN(line, " ", 4)
+	if len(fields) != 4 || fields[0] != "vuln" {
+		return "", e, xerrors.Errorf("expected vuln <id> <severity> p/<product>/ v/<versions>/ s/<summary>/, got %q", line)
+	}
+	e.ID, e.Severity = fields[1], strings.ToLower(fields[2])
+	if !severities[e.Severity] {
+		return "", e, xerrors.Errorf("%q isn't a severity(low, medium, high or critical)", fields[2])
+	}
+
+	var product, versions string
+	for rest := strings.TrimSpace(fields[3]); rest != ""; rest = strings.TrimSpace(rest) {
+		field := rest[0]
+		value, next, ok := cutDelimited(rest, field)
+		if !ok {
+			return "", e, xerrors.Errorf("expected <field>/<value>/, got %q", rest)
+		}
+		rest = next
+
+		switch field {
+		case 'p':
+			product = strings.ToLower(value)
+		case 'v':
+			versions = value
+		case 's':
+			e.Summary = value
+		default:
+			return "", e, xerrors.Errorf("%q is an unsupported field(p, v or s)", field)
+		}
+	}
+	if product == "" || versions == "" {
+		return "", e, xerrors.Errorf("no p/<product>/ or v/<versions>/ in %q", line)
+	}
+
+	for _, alternative := range strings.Split(versions, "||") {
+		var constraints []versionConstraint
+		for _, c := range strings.Fields(alternative) {
+			version := strings.TrimLeft(c, "<>=")
+			op := c[:len(c)-len(version)]
+			switch op {
+			case "":
+				op = "="
+			case "<", "<=", ">", ">=", "=":
+			default:
+				return "", e, xerrors.Errorf("%q isn't a comparison(<, <=, >, >= or =)", op)
+			}
+			if version == "" {
+				return "", e, xerrors.Errorf("no version after %q", op)
+			}
+			constraints = append(constraints, versionConstraint{op: op, version: version})
+		}
+		if len(constraints) == 0 {
+			return "", e, xerrors.Errorf("empty alternative in v/%s/", versions)
+		}
+		e.affected = append(e.affected, constraints)
+	}
+	return product, e, nil
+}
+
+// Merge returns the advisories of db and then those of other, leaving both as they are.
+func (db *AdvisoryDB) Merge(other *AdvisoryDB) *AdvisoryDB {
+	merged := &AdvisoryDB{entries: make(map[string][]advisoryEntry)}
+	for _, d := range []*AdvisoryDB{db, other} {
+		for product, entries := range d.entries {
+			merged.entries[product] = append(merged.entries[product], entries...)
+		}
+	}
+	return merged
+}
+
+// Lookup returns the advisories affecting v, each listed once. A version
+// without a version number can't be matched against anything.
+func (db *AdvisoryDB) Lookup(v Version) []Advisory {
+	if v.Version == "" {
+		return nil
+	}
+
+	var found []Advisory
+	seen := make(map[string]bool)
+	for _, e := range db.entries[strings.ToLower(v.Product)] {
+		if seen[e.ID] || !e.affects(v.Version) {
+			continue
+		}
+		seen[e.ID] = true
+		found = append(found, e.Advisory)
+	}
+	return found
+}
+
+func (e advisoryEntry) affects(version string) bool {
+	for _, constraints := range e.affected {
+		all := true
+		for _, c := range constraints {
+			if !c.holds(version) {
+				all = false
+				break
+			}
+		}
+		if all {
+			return true
+		}
+	}
+	return false
+}
+
+func (c versionConstraint) holds(version string) bool {
+	n := compareVersions(version, c.version)
+	switch c.op {
+	case "<":
+		return n < 0
+	case "<=":
+		return n <= 0
+	case ">":
+		return n > 0
+	case ">=":
+		return n >= 0
+	default:
+		return n == 0
+	}
+}
+
+// compareVersions compares versions part by part, runs of digits as numbers
+// and runs of letters as text, which sort before numbers so 1.0rc1 comes
+// before 1.0.1. A version that runs out of parts first is the lower one,
+// 8.5 comes before 8.5p1.
+func compareVersions(a, b string) int {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		na, errA := strconv.Atoi(pa[i])
+		nb, errB := strconv.Atoi(pb[i])
+		switch {
+		case errA == nil && errB == nil:
+			if na != nb {
+				if na < nb {
+					return -1
+				}
+				return 1
+			}
+		case errA == nil:
+			return 1
+		case errB == nil:
+			return -1
+		default:
+			if n := strings.Compare(strings.ToLower(pa[i]), strings.ToLower(pb[i])); n != 0 {
+				return n
+			}
+		}
+	}
+	switch {
+	case len(pa) < len(pb):
+		return -1
+	case len(pa) > len(pb):
+		return 1
+	}
+	return 0
+}
+
+// versionParts splits a version into its runs of digits and letters, dropping the separators.
+func versionParts(v string) []string {
+	var parts []string
+	start := -1
+	for i := 0; i <= len(v); i++ {
+		if i < len(v) && start >= 0 && isDigit(v[i]) == isDigit(v[start]) && isVersionChar(v[i]) {
+			continue
+		}
+		if start >= 0 {
+			parts = append(parts, v[start:i])
+			start = -1
+		}
+		if i < len(v) && isVersionChar(v[i]) {
+			start = i
+		}
+	}
+	return parts
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isVersionChar(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}